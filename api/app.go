@@ -3,6 +3,7 @@ package api
 import (
 	v1 "crynux_bridge/api/v1"
 	responseV1 "crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
 	"crynux_bridge/config"
 	"reflect"
 	"regexp"
@@ -25,10 +26,11 @@ func GetHttpApplication(appConfig *config.AppConfig) *gin.Engine {
 	engine := gin.New()
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
-	corsConfig.AddAllowHeaders("Authorization")
+	corsConfig.AddAllowHeaders("Authorization", tools.IdempotencyKeyHeader)
 	engine.Use(cors.New(corsConfig))
 	engine.Use(ginlogrus.Logger(log.StandardLogger()), gin.Recovery())
 	engine.Use(APIVersion())
+	engine.Use(tools.HashIdempotentRequest())
 
 	// Serve static files under static folder
 	// for OpenAPI documentations
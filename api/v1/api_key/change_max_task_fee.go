@@ -0,0 +1,58 @@
+package apikey
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type ChangeMaxTaskFeeInput struct {
+	APIKey     string `path:"api_key" json:"api_key" description:"API key" validate:"required"`
+	MaxTaskFee uint64 `json:"max_task_fee" description:"Max task fee of a task in GWei. 0 uses the max task fee of the fee policy"`
+}
+
+type ChangeMaxTaskFeeInputWithSignature struct {
+	ChangeMaxTaskFeeInput
+	Timestamp int64  `form:"timestamp" json:"timestamp" description:"Signature timestamp" validate:"required"`
+	Signature string `form:"signature" json:"signature" description:"Signature" validate:"required"`
+}
+
+func ChangeMaxTaskFee(c *gin.Context, in *ChangeMaxTaskFeeInputWithSignature) (*response.Response, error) {
+	match, address, err := tools.ValidateSignature(in.ChangeMaxTaskFeeInput, in.Timestamp, in.Signature)
+
+	if err != nil || !match {
+
+		if err != nil {
+			log.Debugln("error in sig validate: " + err.Error())
+		}
+
+		validationErr := response.NewValidationErrorResponse("signature", "Invalid signature")
+		return nil, validationErr
+	}
+	appConfig := config.GetConfig()
+	if address != appConfig.Blockchain.Account.Address {
+		validationErr := response.NewValidationErrorResponse("client_id", "Invalid signer")
+		return nil, validationErr
+	}
+	apiKey, err := tools.ValidateAPIKey(c.Request.Context(), config.GetDB(), in.APIKey)
+	if err != nil {
+		if errors.Is(err, tools.ErrAPIKeyExpired) {
+			return nil, response.NewValidationErrorResponse("api_key", "expired")
+		}
+		if errors.Is(err, tools.ErrAPIKeyInvalid) {
+			return nil, response.NewValidationErrorResponse("api_key", "invalid")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	if err := tools.ChangeMaxTaskFee(c.Request.Context(), config.GetDB(), apiKey, in.MaxTaskFee); err != nil {
+		log.Debugln("error in change max task fee: " + err.Error())
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return &response.Response{}, nil
+}
@@ -0,0 +1,59 @@
+package apikey
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type ChangePriorityInput struct {
+	APIKey   string          `path:"api_key" json:"api_key" description:"API key" validate:"required"`
+	Priority models.Priority `json:"priority" description:"Priority class of the tasks. realtime, standard, batch or auto" validate:"required,oneof=realtime standard batch auto"`
+}
+
+type ChangePriorityInputWithSignature struct {
+	ChangePriorityInput
+	Timestamp int64  `form:"timestamp" json:"timestamp" description:"Signature timestamp" validate:"required"`
+	Signature string `form:"signature" json:"signature" description:"Signature" validate:"required"`
+}
+
+func ChangePriority(c *gin.Context, in *ChangePriorityInputWithSignature) (*response.Response, error) {
+	match, address, err := tools.ValidateSignature(in.ChangePriorityInput, in.Timestamp, in.Signature)
+
+	if err != nil || !match {
+
+		if err != nil {
+			log.Debugln("error in sig validate: " + err.Error())
+		}
+
+		validationErr := response.NewValidationErrorResponse("signature", "Invalid signature")
+		return nil, validationErr
+	}
+	appConfig := config.GetConfig()
+	if address != appConfig.Blockchain.Account.Address {
+		validationErr := response.NewValidationErrorResponse("client_id", "Invalid signer")
+		return nil, validationErr
+	}
+	apiKey, err := tools.ValidateAPIKey(c.Request.Context(), config.GetDB(), in.APIKey)
+	if err != nil {
+		if errors.Is(err, tools.ErrAPIKeyExpired) {
+			return nil, response.NewValidationErrorResponse("api_key", "expired")
+		}
+		if errors.Is(err, tools.ErrAPIKeyInvalid) {
+			return nil, response.NewValidationErrorResponse("api_key", "invalid")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	if err := tools.ChangePriority(c.Request.Context(), config.GetDB(), apiKey, in.Priority); err != nil {
+		log.Debugln("error in change priority: " + err.Error())
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return &response.Response{}, nil
+}
@@ -0,0 +1,63 @@
+package apikey
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+type ChangeVerificationReplicasInput struct {
+	APIKey               string `path:"api_key" json:"api_key" description:"API key" validate:"required"`
+	VerificationReplicas int    `json:"verification_replicas" description:"Verification replicas of the tasks of the API key, unless set by the request. 0 means no replica" validate:"min=0"`
+}
+
+type ChangeVerificationReplicasInputWithSignature struct {
+	ChangeVerificationReplicasInput
+	Timestamp int64  `form:"timestamp" json:"timestamp" description:"Signature timestamp" validate:"required"`
+	Signature string `form:"signature" json:"signature" description:"Signature" validate:"required"`
+}
+
+func ChangeVerificationReplicas(c *gin.Context, in *ChangeVerificationReplicasInputWithSignature) (*response.Response, error) {
+	match, address, err := tools.ValidateSignature(in.ChangeVerificationReplicasInput, in.Timestamp, in.Signature)
+
+	if err != nil || !match {
+
+		if err != nil {
+			log.Debugln("error in sig validate: " + err.Error())
+		}
+
+		validationErr := response.NewValidationErrorResponse("signature", "Invalid signature")
+		return nil, validationErr
+	}
+	appConfig := config.GetConfig()
+	if address != appConfig.Blockchain.Account.Address {
+		validationErr := response.NewValidationErrorResponse("client_id", "Invalid signer")
+		return nil, validationErr
+	}
+	apiKey, err := tools.ValidateAPIKey(c.Request.Context(), config.GetDB(), in.APIKey)
+	if err != nil {
+		if errors.Is(err, tools.ErrAPIKeyExpired) {
+			return nil, response.NewValidationErrorResponse("api_key", "expired")
+		}
+		if errors.Is(err, tools.ErrAPIKeyInvalid) {
+			return nil, response.NewValidationErrorResponse("api_key", "invalid")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	if in.VerificationReplicas > tools.MaxVerificationReplicas() {
+		return nil, response.NewValidationErrorResponse("verification_replicas", fmt.Sprintf("Verification replicas should be at most %d", tools.MaxVerificationReplicas()))
+	}
+
+	if err := tools.ChangeVerificationReplicas(c.Request.Context(), config.GetDB(), apiKey, in.VerificationReplicas); err != nil {
+		log.Debugln("error in change verification replicas: " + err.Error())
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return &response.Response{}, nil
+}
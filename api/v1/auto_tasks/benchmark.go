@@ -0,0 +1,38 @@
+package auto_tasks
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BenchmarkInput struct {
+	Duration      int    `query:"duration" description:"recent time duration in hours to report" validate:"min=1"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key with the admin role"`
+}
+
+type BenchmarkResponse struct {
+	response.Response
+	Data []models.AutoTaskBenchmark `json:"data"`
+}
+
+// GetBenchmark reports the outcomes of the ended auto tasks by their templates and by the gpus of the nodes
+func GetBenchmark(c *gin.Context, in *BenchmarkInput) (*BenchmarkResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	if _, err := tools.ValidateAdminAuthorization(ctx, db, in.Authorization); err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-time.Duration(in.Duration) * time.Hour)
+	benchmarks, err := models.GetAutoTaskBenchmarks(ctx, db, since)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &BenchmarkResponse{Data: benchmarks}, nil
+}
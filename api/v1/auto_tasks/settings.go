@@ -0,0 +1,116 @@
+package auto_tasks
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminInput struct {
+	Authorization string `header:"Authorization" validate:"required" description:"API key with the admin role"`
+}
+
+type StateResponse struct {
+	response.Response
+	Data *tasks.AutoTaskState `json:"data"`
+}
+
+func getState(c *gin.Context) (*StateResponse, error) {
+	state, err := tasks.GetAutoTaskState(c.Request.Context())
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &StateResponse{Data: state}, nil
+}
+
+// GetState returns the settings in effect of the auto task generator, the pending and queued task counts and the time of the last batch
+func GetState(c *gin.Context, in *AdminInput) (*StateResponse, error) {
+	if _, err := tools.ValidateAdminAuthorization(c.Request.Context(), config.GetDB(), in.Authorization); err != nil {
+		return nil, err
+	}
+	return getState(c)
+}
+
+func setPaused(c *gin.Context, in *AdminInput, paused bool) (*StateResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	if _, err := tools.ValidateAdminAuthorization(ctx, db, in.Authorization); err != nil {
+		return nil, err
+	}
+	if err := models.UpdateAutoTaskSettings(ctx, db, map[string]interface{}{"paused": paused}); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return getState(c)
+}
+
+// Pause stops the auto task generator from creating new batches, the pending auto tasks are not affected
+func Pause(c *gin.Context, in *AdminInput) (*StateResponse, error) {
+	return setPaused(c, in, true)
+}
+
+func Resume(c *gin.Context, in *AdminInput) (*StateResponse, error) {
+	return setPaused(c, in, false)
+}
+
+type SettingsInput struct {
+	Authorization                 string    `header:"Authorization" validate:"required" description:"API key with the admin role"`
+	AutoTasksBatchSize            *uint64   `json:"auto_tasks_batch_size" description:"Auto tasks created in a batch, 0 creates none"`
+	PendingAutoTasksLimit         *uint64   `json:"pending_auto_tasks_limit" description:"No batch is created when the pending auto tasks or the tasks queued on the relay exceed the limit"`
+	PendingLargeVramLLMTasksLimit *uint64   `json:"pending_large_vram_llm_tasks_limit" description:"Limit of the pending llm auto tasks requiring more than 24GB vram"`
+	AutoTaskVersionRatio          []float64 `json:"auto_task_version_ratio" description:"Weights of the task versions of the auto tasks"`
+	AutoTaskTypeRatio             []float64 `json:"auto_task_type_ratio" description:"Weights of the sdxl, sd and llm auto tasks, unused with an auto task template file"`
+	Reset                         bool      `json:"reset" description:"Restore the task config for all the settings before applying the others in the request"`
+}
+
+// ChangeSettings overrides the task config of the auto task generator, the settings not in the request are kept
+func ChangeSettings(c *gin.Context, in *SettingsInput) (*StateResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	if _, err := tools.ValidateAdminAuthorization(ctx, db, in.Authorization); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	if in.Reset {
+		values["auto_tasks_batch_size"] = nil
+		values["pending_auto_tasks_limit"] = nil
+		values["pending_large_vram_llm_tasks_limit"] = nil
+		values["auto_task_version_ratio"] = models.Float64Array(nil)
+		values["auto_task_type_ratio"] = models.Float64Array(nil)
+	}
+	if in.AutoTasksBatchSize != nil {
+		values["auto_tasks_batch_size"] = *in.AutoTasksBatchSize
+	}
+	if in.PendingAutoTasksLimit != nil {
+		values["pending_auto_tasks_limit"] = *in.PendingAutoTasksLimit
+	}
+	if in.PendingLargeVramLLMTasksLimit != nil {
+		values["pending_large_vram_llm_tasks_limit"] = *in.PendingLargeVramLLMTasksLimit
+	}
+	if in.AutoTaskVersionRatio != nil {
+		if err := tasks.ValidateAutoTaskVersionRatio(in.AutoTaskVersionRatio); err != nil {
+			return nil, response.NewValidationErrorResponse("auto_task_version_ratio", err.Error())
+		}
+		values["auto_task_version_ratio"] = models.Float64Array(in.AutoTaskVersionRatio)
+	}
+	if in.AutoTaskTypeRatio != nil {
+		if err := tasks.ValidateAutoTaskTypeRatio(in.AutoTaskTypeRatio); err != nil {
+			return nil, response.NewValidationErrorResponse("auto_task_type_ratio", err.Error())
+		}
+		values["auto_task_type_ratio"] = models.Float64Array(in.AutoTaskTypeRatio)
+	}
+	if len(values) == 0 {
+		return nil, response.NewValidationErrorResponse("settings", "No setting to change")
+	}
+
+	if err := models.UpdateAutoTaskSettings(ctx, db, values); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return getState(c)
+}
@@ -26,7 +26,7 @@ type CountResponse struct {
 	Data *CountOutput `json:"data"`
 }
 
-func CountTask(_ *gin.Context, input *CountInput) (*CountResponse, error) {
+func CountTask(c *gin.Context, input *CountInput) (*CountResponse, error) {
 	duration := time.Duration(input.Duration) * time.Hour
 
 	startTime := time.Now().Add(-duration)
@@ -35,12 +35,31 @@ func CountTask(_ *gin.Context, input *CountInput) (*CountResponse, error) {
 	offset := 0
 	limit := 100
 
+	// the downloaded times are read page by page, so that the ids in the query are bounded by the page size
+	downloadedTimes := make(map[uint]time.Time)
 	for {
 		var clientTasks []models.ClientTask
 		if err := config.GetDB().Model(&models.ClientTask{}).Preload("InferenceTasks").Where("created_at >= ?", startTime).Order("id").Offset(offset).Limit(limit).Find(&clientTasks).Error; err != nil {
 			return nil, response.NewExceptionResponse(err)
 		}
 		allClientTasks = append(allClientTasks, clientTasks...)
+
+		var downloadedTaskIDs []uint
+		for _, clientTask := range clientTasks {
+			for _, task := range clientTask.InferenceTasks {
+				if task.Status == models.InferenceTaskResultDownloaded {
+					downloadedTaskIDs = append(downloadedTaskIDs, task.ID)
+				}
+			}
+		}
+		times, err := models.GetTaskStatusTimes(c.Request.Context(), config.GetDB(), downloadedTaskIDs, models.InferenceTaskResultDownloaded)
+		if err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		for id, t := range times {
+			downloadedTimes[id] = t
+		}
+
 		if len(clientTasks) < limit {
 			break
 		}
@@ -59,7 +78,12 @@ func CountTask(_ *gin.Context, input *CountInput) (*CountResponse, error) {
 			for _, task := range clientTask.InferenceTasks {
 				if task.Status == models.InferenceTaskResultDownloaded {
 					successCount += 1
-					t := task.UpdatedAt.Sub(task.CreatedAt)
+					// tasks downloaded before the status events are recorded fall back to UpdatedAt
+					finishTime, ok := downloadedTimes[task.ID]
+					if !ok {
+						finishTime = task.UpdatedAt
+					}
+					t := finishTime.Sub(task.CreatedAt)
 					if successTaskTime == 0 || t < successTaskTime {
 						successTaskTime = t
 					}
@@ -0,0 +1,38 @@
+package count
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LatencyInput struct {
+	Duration int  `query:"duration" description:"recent time duration in hours to count"`
+	TaskType *int `query:"task_type" description:"the task type to count, all types if omitted" validate:"omitempty,min=0,max=2"`
+}
+
+type LatencyResponse struct {
+	response.Response
+	Data *models.LatencyBreakdown `json:"data"`
+}
+
+// CountLatency returns the latency breakdown of the successful tasks, in milliseconds
+func CountLatency(c *gin.Context, input *LatencyInput) (*LatencyResponse, error) {
+	duration := time.Duration(input.Duration) * time.Hour
+	startTime := time.Now().Add(-duration)
+
+	var taskType *models.ChainTaskType
+	if input.TaskType != nil {
+		t := models.ChainTaskType(*input.TaskType)
+		taskType = &t
+	}
+
+	result, err := models.GetLatencyBreakdown(c.Request.Context(), config.GetDB(), startTime, taskType)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &LatencyResponse{Data: result}, nil
+}
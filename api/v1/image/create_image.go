@@ -3,17 +3,18 @@ package image
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crynux_bridge/api/ratelimit"
 	"crynux_bridge/api/v1/inference_tasks"
 	"crynux_bridge/api/v1/response"
 	"crynux_bridge/api/v1/tools"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
+	"crynux_bridge/storage"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math/rand"
-	"os"
 	"regexp"
 	"strconv"
 	"sync"
@@ -37,6 +38,7 @@ type CreateImageRequest struct {
 	Style             string  `json:"style,omitempty" enum:"vivid,natural" description:"No use for now. For compatibility with Openai."`
 	User              string  `json:"user,omitempty" description:"No use for now. For compatibility with Openai."`
 	Timeout           *uint64 `json:"timeout,omitempty" description:"Task timeout" validate:"omitempty"`
+	IdempotencyKey    string  `header:"Idempotency-Key" json:"-" description:"Retries of the request with the same key return the images of the first request" validate:"omitempty"`
 }
 
 func (in *CreateImageRequest) SetDefaultValues() {
@@ -95,8 +97,8 @@ type CreateImageResponse struct {
 
 var sizePattern = regexp.MustCompile(`^(\d+)(x|X)(\d+)$`)
 
-func streamBase64Encode(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+func streamBase64Encode(ctx context.Context, key string) (string, error) {
+	file, _, err := storage.GetResultStore().Get(ctx, key)
 	if err != nil {
 		return "", err
 	}
@@ -218,33 +220,35 @@ func CreateImage(c *gin.Context, in *CreateImageRequest) (*CreateImageResponse,
 		TaskType: &taskType,
 	}
 
-	resultFiles, _, err := inference_tasks.ProcessSDTask(ctx, db, task)
-	if err != nil {
-		return nil, err
-	}
-
-	b64results := make([]CreateImageData, len(resultFiles))
-	var wg sync.WaitGroup
+	return inference_tasks.RunIdempotent(c, apiKey.ID, task, 3*time.Minute, func(ctx context.Context, clientTask *models.ClientTask) (*CreateImageResponse, error) {
+		resultFiles, _, err := inference_tasks.WaitSDTask(ctx, db, clientTask)
+		if err != nil {
+			return nil, err
+		}
 
-	for i, resultFile := range resultFiles {
-		wg.Add(1)
-		go func(i int, resultFile string) {
-			defer wg.Done()
-			b64result, err := streamBase64Encode(resultFile)
-			if err != nil {
-				return
-			}
-			b64results[i] = CreateImageData{
-				B64Json: b64result,
-			}
-		}(i, resultFile)
-	}
+		b64results := make([]CreateImageData, len(resultFiles))
+		var wg sync.WaitGroup
+
+		for i, resultFile := range resultFiles {
+			wg.Add(1)
+			go func(i int, resultFile string) {
+				defer wg.Done()
+				b64result, err := streamBase64Encode(ctx, resultFile)
+				if err != nil {
+					return
+				}
+				b64results[i] = CreateImageData{
+					B64Json: b64result,
+				}
+			}(i, resultFile)
+		}
 
-	wg.Wait()
+		wg.Wait()
 
-	return &CreateImageResponse{
-		Created: time.Now().Unix(),
-		Data:    b64results,
-		Usage:   CreateImageUsage{},
-	}, nil
-}
+		return &CreateImageResponse{
+			Created: time.Now().Unix(),
+			Data:    b64results,
+			Usage:   CreateImageUsage{},
+		}, nil
+	})
+}
\ No newline at end of file
@@ -7,21 +7,25 @@ import (
 	"crynux_bridge/api/v1/tools"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
+	"crynux_bridge/storage"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"net/http"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type SDFinetuneLoraRequest struct {
 	SDFinetuneLoraTaskParams
 	Authorization string `header:"Authorization" validate:"required" description:"API key"`
 	Timeout       *uint64 `json:"timeout,omitempty" description:"Task timeout" validate:"omitempty"`
+	RetryPolicy   *inference_tasks.RetryPolicyInput `json:"retry_policy,omitempty" description:"Override the retry policy of the finetune segments" validate:"omitempty"`
+	MaxTotalFee   *uint64 `json:"max_total_fee,omitempty" description:"Max total task fee of all the finetune segments in GWei" validate:"omitempty"`
 }
 
 type SDFinetuneLoraTaskResponse struct {
@@ -47,6 +51,10 @@ func CreateSDFinetuneLoraTask(c *gin.Context, in *SDFinetuneLoraRequest) (*SDFin
 		return nil, response.NewValidationErrorResponse("rate_limit", fmt.Sprintf("rate limit exceeded, please wait %.2f seconds", waitTime))
 	}
 
+	if config.IsChainTaskBackend() {
+		return nil, response.NewValidationErrorResponse("task_backend", "SD finetune tasks are not supported by the chain task backend")
+	}
+
 	in.SetDefaultValues()
 
 	taskArgs := &models.FinetuneLoraTaskArgs{
@@ -116,14 +124,18 @@ func CreateSDFinetuneLoraTask(c *gin.Context, in *SDFinetuneLoraRequest) (*SDFin
 			}
 	
 			checkpoint := files[0]
-			appConfig := config.GetConfig()
+			checkpointFile, err := checkpoint.Open()
+			if err != nil {
+				return nil, response.NewExceptionResponse(err)
+			}
+			defer checkpointFile.Close()
 	
 			uuid := uuid.New().String()
-			checkpointFilename := filepath.Join(appConfig.DataDir.InferenceTasks, fmt.Sprintf("%s_checkpoint.zip", uuid))
-			if err = c.SaveUploadedFile(checkpoint, checkpointFilename); err != nil {
+			checkpointKey := fmt.Sprintf("%s_checkpoint.zip", uuid)
+			if err = storage.GetResultStore().Put(ctx, checkpointKey, checkpointFile, checkpoint.Size); err != nil {
 				return nil, response.NewExceptionResponse(err)
 			}
-			taskArgs.Checkpoint = checkpointFilename
+			taskArgs.Checkpoint = checkpointKey
 		}
 	}
 
@@ -135,7 +147,7 @@ func CreateSDFinetuneLoraTask(c *gin.Context, in *SDFinetuneLoraRequest) (*SDFin
 
 	taskType := models.TaskTypeSDFTLora
 	minVram := uint64(24)
-	taskFee := uint64(15000000000)
+	taskFee := getSDFinetuneTaskFee()
 	repeatNum := 1
 	task := &inference_tasks.TaskInput{
 		ClientID:  apiKey.ClientID,
@@ -145,6 +157,8 @@ func CreateSDFinetuneLoraTask(c *gin.Context, in *SDFinetuneLoraRequest) (*SDFin
 		TaskFee:   &taskFee,
 		RepeatNum: &repeatNum,
 		Timeout:   in.Timeout,
+		RetryPolicy: in.RetryPolicy,
+		MaxTotalFee: in.MaxTotalFee,
 	}
 
 	taskResponse, err := inference_tasks.DoCreateTask(ctx, task)
@@ -221,18 +235,100 @@ func DownloadSDFinetuneLoraTaskResult(c *gin.Context, in *DownloadSDFinetuneLora
 		return response.NewValidationErrorResponse("api_key", "invalid api key")
 	}
 
-	appConfig := config.GetConfig()
+	if task.ResultExpired() {
+		return response.NewValidationErrorResponse("id", "Task result expired")
+	}
 
-	taskResultFilePath := filepath.Join(appConfig.DataDir.InferenceTasks, task.TaskIDCommitment, "result.zip")
-	if _, err := os.Stat(taskResultFilePath); os.IsNotExist(err) {
+	taskResultFile, size, err := storage.GetResultStore().Get(ctx, storage.TaskKey(task.TaskIDCommitment, "result.zip"))
+	if errors.Is(err, storage.ErrNotFound) {
 		return response.NewValidationErrorResponse("id", "Task result not found")
+	} else if err != nil {
+		return response.NewExceptionResponse(err)
 	}
+	defer taskResultFile.Close()
 
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", "attachment; filename=result.zip")
-	c.Header("Content-Type", "application/zip")
-	c.File(taskResultFilePath)
+	c.DataFromReader(http.StatusOK, size, "application/zip", taskResultFile, nil)
 
 	return nil
-}
\ No newline at end of file
+}
+
+type GetSDFinetuneLoraTaskAttemptsRequest struct {
+	ID            uint   `path:"id" json:"id" description:"Task id" validate:"required"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+type GetSDFinetuneLoraTaskAttemptsResponse struct {
+	response.Response
+	Data []models.TaskAttempt `json:"data"`
+}
+
+func GetSDFinetuneLoraTaskAttempts(c *gin.Context, in *GetSDFinetuneLoraTaskAttemptsRequest) (*GetSDFinetuneLoraTaskAttemptsResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	// validate request (apiKey)
+	apiKey, err := tools.ValidateAuthorization(ctx, db, in.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	client, err := tools.GetClient(ctx, db, apiKey.ClientID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	clientTask, err := models.GetClientTaskByID(ctx, db, in.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("id", "Task not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	if client.ID != clientTask.ClientID {
+		return nil, response.NewValidationErrorResponse("api_key", "invalid api key")
+	}
+
+	attempts, err := models.GetTaskAttempts(ctx, db, clientTask.ID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return &GetSDFinetuneLoraTaskAttemptsResponse{
+		Data: attempts,
+	}, nil
+}
+
+type CancelSDFinetuneLoraTaskRequest struct {
+	ID            uint   `path:"id" json:"id" description:"Task id" validate:"required"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+func CancelSDFinetuneLoraTask(c *gin.Context, in *CancelSDFinetuneLoraTaskRequest) (*inference_tasks.CancelTaskResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	// validate request (apiKey)
+	apiKey, err := tools.ValidateAuthorization(ctx, db, in.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	client, err := tools.GetClient(ctx, db, apiKey.ClientID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	clientTask, err := models.GetClientTaskByID(ctx, db, in.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("id", "Task not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	if client.ID != clientTask.ClientID {
+		return nil, response.NewValidationErrorResponse("api_key", "invalid api key")
+	}
+
+	return inference_tasks.DoCancelTask(c, clientTask)
+}
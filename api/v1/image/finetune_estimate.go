@@ -0,0 +1,104 @@
+package image
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/config"
+	"math"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getSDFinetuneTaskFee() uint64 {
+	taskFee := config.GetConfig().Task.SDFinetuneTaskFee
+	if taskFee == 0 {
+		taskFee = 15000000000
+	}
+	return taskFee
+}
+
+type EstimateSDFinetuneLoraRequest struct {
+	SDFinetuneLoraTaskParams
+	DatasetSize int     `json:"dataset_size" description:"Number of images in the dataset" validate:"required,min=1"`
+	Timeout     *uint64 `json:"timeout,omitempty" description:"Task timeout of each segment in seconds" validate:"omitempty"`
+}
+
+type SDFinetuneLoraEstimate struct {
+	TotalSteps        uint64 `json:"total_steps"`
+	EstimatedSeconds  uint64 `json:"estimated_seconds"`
+	SegmentTimeout    uint64 `json:"segment_timeout"`
+	EstimatedSegments uint64 `json:"estimated_segments"`
+	SegmentFee        uint64 `json:"segment_fee"`
+	EstimatedTotalFee uint64 `json:"estimated_total_fee"`
+}
+
+type EstimateSDFinetuneLoraResponse struct {
+	response.Response
+	Data *SDFinetuneLoraEstimate `json:"data"`
+}
+
+// estimateTrainSteps follows the way the finetune task computes its train steps:
+// num_train_steps wins over num_train_epochs, and max_train_steps caps the result
+func estimateTrainSteps(in *EstimateSDFinetuneLoraRequest) uint64 {
+	var steps uint64
+	if in.NumTrainSteps != nil && *in.NumTrainSteps > 0 {
+		steps = uint64(*in.NumTrainSteps)
+	} else {
+		batch := in.BatchSize * in.GradientAccumulationSteps
+		stepsPerEpoch := uint64(math.Ceil(float64(in.DatasetSize) / float64(batch)))
+		epochs := in.NumTrainEpochs
+		if in.MaxTrainEpochs > 0 && epochs > in.MaxTrainEpochs {
+			epochs = in.MaxTrainEpochs
+		}
+		steps = stepsPerEpoch * uint64(epochs)
+	}
+	if in.MaxTrainSteps != nil && *in.MaxTrainSteps > 0 && steps > uint64(*in.MaxTrainSteps) {
+		steps = uint64(*in.MaxTrainSteps)
+	}
+	return steps
+}
+
+// estimateSDFinetuneLora estimates the train time of the request by secondsPerStep at 512x512 resolution
+// and batch size 1, and the segments of segmentTimeout seconds it takes at segmentFee each
+func estimateSDFinetuneLora(in *EstimateSDFinetuneLoraRequest, secondsPerStep float64, segmentTimeout, segmentFee uint64) *SDFinetuneLoraEstimate {
+	// the time of each step grows with the pixels and the images of a step
+	scale := math.Pow(float64(in.Resolution)/512, 2) * float64(in.BatchSize*in.GradientAccumulationSteps)
+	totalSteps := estimateTrainSteps(in)
+	estimatedSeconds := uint64(math.Ceil(float64(totalSteps) * secondsPerStep * scale))
+
+	segments := (estimatedSeconds + segmentTimeout - 1) / segmentTimeout
+	if segments == 0 {
+		segments = 1
+	}
+	return &SDFinetuneLoraEstimate{
+		TotalSteps:        totalSteps,
+		EstimatedSeconds:  estimatedSeconds,
+		SegmentTimeout:    segmentTimeout,
+		EstimatedSegments: segments,
+		SegmentFee:        segmentFee,
+		EstimatedTotalFee: segments * segmentFee,
+	}
+}
+
+func EstimateSDFinetuneLoraTask(_ *gin.Context, in *EstimateSDFinetuneLoraRequest) (*EstimateSDFinetuneLoraResponse, error) {
+	appConfig := config.GetConfig()
+	in.SetDefaultValues()
+
+	secondsPerStep := appConfig.Task.SDFinetuneSecondsPerStep
+	if secondsPerStep <= 0 {
+		secondsPerStep = 0.25
+	}
+
+	var segmentTimeout uint64
+	if in.Timeout != nil {
+		segmentTimeout = *in.Timeout
+	} else {
+		segmentTimeout = appConfig.Task.SDFinetuneTimeout * 60
+	}
+	if segmentTimeout == 0 {
+		return nil, response.NewValidationErrorResponse("timeout", "Segment timeout is not configured")
+	}
+
+	return &EstimateSDFinetuneLoraResponse{
+		Data: estimateSDFinetuneLora(in, secondsPerStep, segmentTimeout, getSDFinetuneTaskFee()),
+	}, nil
+}
@@ -0,0 +1,110 @@
+package image
+
+import "testing"
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func newEstimateRequest(datasetSize int, params SDFinetuneLoraTaskParams) *EstimateSDFinetuneLoraRequest {
+	in := &EstimateSDFinetuneLoraRequest{SDFinetuneLoraTaskParams: params, DatasetSize: datasetSize}
+	in.SetDefaultValues()
+	return in
+}
+
+func TestEstimateTrainSteps(t *testing.T) {
+	cases := []struct {
+		name        string
+		datasetSize int
+		params      SDFinetuneLoraTaskParams
+		steps       uint64
+	}{
+		{
+			name:        "default batch size",
+			datasetSize: 100,
+			steps:       7,
+		},
+		{
+			name:        "batch of gradient accumulation",
+			datasetSize: 100,
+			params:      SDFinetuneLoraTaskParams{BatchSize: 10, GradientAccumulationSteps: 2, NumTrainEpochs: 3, MaxTrainEpochs: 5},
+			steps:       15,
+		},
+		{
+			name:        "epochs capped by max epochs",
+			datasetSize: 100,
+			params:      SDFinetuneLoraTaskParams{BatchSize: 10, GradientAccumulationSteps: 2, NumTrainEpochs: 3, MaxTrainEpochs: 2},
+			steps:       10,
+		},
+		{
+			name:        "steps over epochs",
+			datasetSize: 100,
+			params:      SDFinetuneLoraTaskParams{NumTrainSteps: intPtr(50), NumTrainEpochs: 3, MaxTrainEpochs: 5},
+			steps:       50,
+		},
+		{
+			name:        "epochs capped by max steps",
+			datasetSize: 100,
+			params:      SDFinetuneLoraTaskParams{BatchSize: 10, GradientAccumulationSteps: 2, NumTrainEpochs: 3, MaxTrainEpochs: 5, MaxTrainSteps: intPtr(8)},
+			steps:       8,
+		},
+		{
+			name:        "steps capped by max steps",
+			datasetSize: 100,
+			params:      SDFinetuneLoraTaskParams{NumTrainSteps: intPtr(50), MaxTrainSteps: intPtr(20)},
+			steps:       20,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if steps := estimateTrainSteps(newEstimateRequest(c.datasetSize, c.params)); steps != c.steps {
+				t.Errorf("%d train steps, want %d", steps, c.steps)
+			}
+		})
+	}
+}
+
+func TestEstimateSDFinetuneLora(t *testing.T) {
+	cases := []struct {
+		name     string
+		params   SDFinetuneLoraTaskParams
+		seconds  uint64
+		segments uint64
+	}{
+		{
+			// 1 step of 16 images at 512x512
+			name:     "default resolution",
+			seconds:  4,
+			segments: 1,
+		},
+		{
+			name:     "double resolution",
+			params:   SDFinetuneLoraTaskParams{Resolution: 1024},
+			seconds:  16,
+			segments: 1,
+		},
+		{
+			name:     "several segments",
+			params:   SDFinetuneLoraTaskParams{NumTrainSteps: intPtr(1000), BatchSize: 1, Resolution: 1024},
+			seconds:  1000,
+			segments: 2,
+		},
+		{
+			name:     "segments of exact timeouts",
+			params:   SDFinetuneLoraTaskParams{NumTrainSteps: intPtr(2400), BatchSize: 1},
+			seconds:  600,
+			segments: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			estimate := estimateSDFinetuneLora(newEstimateRequest(16, c.params), 0.25, 600, 15)
+			if estimate.EstimatedSeconds != c.seconds || estimate.EstimatedSegments != c.segments {
+				t.Errorf("estimated %d seconds in %d segments, want %d in %d", estimate.EstimatedSeconds, estimate.EstimatedSegments, c.seconds, c.segments)
+			}
+			if estimate.SegmentTimeout != 600 || estimate.SegmentFee != 15 || estimate.EstimatedTotalFee != c.segments*15 {
+				t.Errorf("segment timeout and fees mismatch: %+v", estimate)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package inference_tasks
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/tasks"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CancelTaskInput struct {
+	ClientID      string `path:"client_id" json:"client_id" description:"Client id" validate:"required"`
+	ClientTaskID  uint   `path:"client_task_id" json:"client_task_id" description:"Client task id" validate:"required"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+type CancelTaskResult struct {
+	ClientTaskID uint                    `json:"client_task_id"`
+	Status       models.ClientTaskStatus `json:"status"`
+	Tasks        []tasks.CancelResult    `json:"tasks"`
+}
+
+type CancelTaskResponse struct {
+	response.Response
+	Data *CancelTaskResult `json:"data"`
+}
+
+// DoCancelTask cancels the client task and reports the refunds of its inference tasks
+func DoCancelTask(c *gin.Context, clientTask *models.ClientTask) (*CancelTaskResponse, error) {
+	results, err := tasks.CancelClientTask(c.Request.Context(), clientTask)
+	if err != nil {
+		if errors.Is(err, models.ErrClientTaskNotRunning) {
+			return nil, response.NewValidationErrorResponse("client_task_id", "Client task has finished")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &CancelTaskResponse{
+		Data: &CancelTaskResult{
+			ClientTaskID: clientTask.ID,
+			Status:       models.ClientTaskStatusCancelled,
+			Tasks:        results,
+		},
+	}, nil
+}
+
+func CancelTask(c *gin.Context, in *CancelTaskInput) (*CancelTaskResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	apiKey, err := tools.ValidateAuthorization(ctx, db, in.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.ClientID != in.ClientID {
+		return nil, response.NewValidationErrorResponse("Authorization", "unauthorized")
+	}
+	client, err := tools.GetClient(ctx, db, in.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_id", "Client not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	clientTask, err := tools.GetClientTask(ctx, db, client.ID, in.ClientTaskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_task_id", "Client task not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return DoCancelTask(c, clientTask)
+}
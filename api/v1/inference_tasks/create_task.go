@@ -8,6 +8,7 @@ import (
 	"crynux_bridge/config"
 	"crynux_bridge/models"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -19,16 +20,42 @@ import (
 )
 
 type TaskInput struct {
-	ClientID        string                `json:"client_id" description:"Client id" validate:"required"`
-	TaskArgs        string                `json:"task_args" description:"Task args" validate:"required"`
-	TaskType        *models.ChainTaskType `json:"task_type" description:"Task type. 0 - SD task, 1 - LLM task, 2 - SD Finetune task" validate:"required"`
-	TaskVersion     *string               `json:"task_version,omitempty" description:"Task version. Default is 2.5.0" validate:"omitempty"`
-	MinVram         *uint64               `json:"min_vram,omitempty" description:"Task minimal vram requirement" validate:"omitempty"`
-	RequiredGPU     string                `json:"required_gpu,omitempty" description:"Task required GPU name" validate:"omitempty"`
-	RequiredGPUVram uint64                `json:"required_gpu_vram,omitempty" description:"Task required GPU Vram" validate:"omitempty"`
-	RepeatNum       *int                  `json:"repeat_num,omitempty" description:"Task repeat number" validate:"omitempty"`
-	TaskFee         *uint64               `json:"task_fee,omitempty" description:"Task fee" validate:"omitempty"`
-	Timeout         *uint64               `json:"timeout,omitempty" description:"Task timeout" validate:"omitempty"`
+	ClientID             string                `json:"client_id" description:"Client id" validate:"required"`
+	TaskArgs             string                `json:"task_args" description:"Task args" validate:"required"`
+	TaskType             *models.ChainTaskType `json:"task_type" description:"Task type. 0 - SD task, 1 - LLM task, 2 - SD Finetune task" validate:"required"`
+	TaskVersion          *string               `json:"task_version,omitempty" description:"Task version. Default is 2.5.0" validate:"omitempty"`
+	MinVram              *uint64               `json:"min_vram,omitempty" description:"Task minimal vram requirement" validate:"omitempty"`
+	RequiredGPU          string                `json:"required_gpu,omitempty" description:"Task required GPU name" validate:"omitempty"`
+	RequiredGPUVram      uint64                `json:"required_gpu_vram,omitempty" description:"Task required GPU Vram" validate:"omitempty"`
+	RepeatNum            *int                  `json:"repeat_num,omitempty" description:"Task repeat number" validate:"omitempty"`
+	TaskFee              *uint64               `json:"task_fee,omitempty" description:"Task fee" validate:"omitempty"`
+	Timeout              *uint64               `json:"timeout,omitempty" description:"Task timeout" validate:"omitempty"`
+	RetryPolicy          *RetryPolicyInput     `json:"retry_policy,omitempty" description:"Override the retry policy of the task type" validate:"omitempty"`
+	MaxTotalFee          *uint64               `json:"max_total_fee,omitempty" description:"Max total task fee of all the submitted tasks in GWei. The task stops with status budget_exceeded if reached" validate:"omitempty"`
+	VerificationReplicas *int                  `json:"verification_replicas,omitempty" description:"Replicas of the task run besides it, whose results are compared with its result. The task fails if most of the results disagree. Replicas are charged as separate tasks. Default is the verification replicas of the API key" validate:"omitempty,min=0"`
+	IdempotencyKey       string                `header:"Idempotency-Key" json:"-" description:"Retries of the request with the same key return the task created by the first request" validate:"omitempty"`
+}
+
+type RetryPolicyInput struct {
+	MaxAttempts       int               `json:"max_attempts,omitempty" description:"Max failed attempts before the task fails" validate:"omitempty,min=1"`
+	InitialBackoff    uint64            `json:"initial_backoff,omitempty" description:"Seconds to wait before the first retry" validate:"omitempty"`
+	MaxBackoff        uint64            `json:"max_backoff,omitempty" description:"Max seconds to wait before a retry" validate:"omitempty"`
+	BackoffMultiplier float64           `json:"backoff_multiplier,omitempty" description:"Backoff multiplier between retries" validate:"omitempty,min=1"`
+	FeeIncreaseRatio  float64           `json:"fee_increase_ratio,omitempty" description:"Task fee multiplier when the task fee is too low" validate:"omitempty,gt=1"`
+	MaxTaskFee        uint64            `json:"max_task_fee,omitempty" description:"Max task fee in GWei when raising the task fee" validate:"omitempty"`
+	Actions           map[string]string `json:"actions,omitempty" description:"Action for each failure reason. Reasons: timeout, model_download_failed, incorrect_result, task_fee_too_low, task_error, invalidated, group_refund, result_mismatch. Actions: retry, raise_fee, fail" validate:"omitempty,dive,oneof=retry raise_fee fail"`
+}
+
+func (in *RetryPolicyInput) toRetryPolicy() config.RetryPolicy {
+	return config.RetryPolicy{
+		MaxAttempts:       in.MaxAttempts,
+		InitialBackoff:    in.InitialBackoff,
+		MaxBackoff:        in.MaxBackoff,
+		BackoffMultiplier: in.BackoffMultiplier,
+		FeeIncreaseRatio:  in.FeeIncreaseRatio,
+		MaxTaskFee:        in.MaxTaskFee,
+		Actions:           in.Actions,
+	}
 }
 
 type TaskResponse struct {
@@ -75,7 +102,9 @@ func getTaskFee(taskType models.ChainTaskType, baseTaskFee, cap uint64) uint64 {
 	}
 }
 
-func buildTasks(in *TaskInput, client *models.Client, clientTask *models.ClientTask, appConfig *config.AppConfig) ([]*models.InferenceTask, error) {
+// buildTasks validates the request and builds its inference tasks, with the settings of the client task to create.
+// The tasks are linked to the client task when they are saved.
+func buildTasks(in *TaskInput, settings *models.ClientTask, appConfig *config.AppConfig, feeDecision *models.FeeDecision) ([]*models.InferenceTask, error) {
 	taskType := *in.TaskType
 
 	var taskVersion = appConfig.Task.TaskVersions[0]
@@ -112,13 +141,13 @@ func buildTasks(in *TaskInput, client *models.Client, clientTask *models.ClientT
 
 	// task args has been validated, so there should be no error
 	taskSize, _ := getTaskSize(taskType, in.TaskArgs)
-	var baseTaskFee uint64
-	if in.TaskFee != nil {
-		baseTaskFee = *in.TaskFee
-	} else {
-		baseTaskFee = 5000000000
+	taskFee := getTaskFee(taskType, feeDecision.BaseFee, taskSize) // unit: GWei
+	if feeDecision.MaxTaskFee > 0 && taskFee > feeDecision.MaxTaskFee {
+		if feeDecision.Reason == models.FeeDecisionRequested {
+			return nil, response.NewValidationErrorResponse("task_fee", "Task fee exceeds the max task fee of the client")
+		}
+		taskFee = feeDecision.MaxTaskFee
 	}
-	taskFee := getTaskFee(taskType, baseTaskFee, taskSize) // unit: GWei
 
 	repeatNum := appConfig.Task.RepeatNum
 	if in.RepeatNum != nil {
@@ -130,10 +159,6 @@ func buildTasks(in *TaskInput, client *models.Client, clientTask *models.ClientT
 		return nil, response.NewExceptionResponse(err)
 	}
 
-	taskIDBytes := make([]byte, 32)
-	rand.Read(taskIDBytes)
-	taskID := hexutil.Encode(taskIDBytes)
-
 	var timeout uint64
 	if in.Timeout != nil {
 		timeout = *in.Timeout
@@ -143,33 +168,83 @@ func buildTasks(in *TaskInput, client *models.Client, clientTask *models.ClientT
 		timeout = appConfig.Task.DefaultTimeout * 60
 	}
 
+	// the verification replicas run the same task under their own task ids, so that each of them
+	// is sampled for validation by the relay with the vrf proof of its own sampling seed
 	tasks := make([]*models.InferenceTask, 0)
-	for i := 0; i < repeatNum; i++ {
-		task := &models.InferenceTask{
-			Client:          *client,
-			ClientTask:      *clientTask,
-			TaskArgs:        in.TaskArgs,
-			TaskType:        taskType,
-			TaskModelIDs:    modelIDs,
-			TaskVersion:     taskVersion,
-			TaskFee:         taskFee,
-			MinVram:         minVram,
-			RequiredGPU:     in.RequiredGPU,
-			RequiredGPUVram: in.RequiredGPUVram,
-			TaskSize:        taskSize,
-			TaskID:          taskID,
-			Timeout:         timeout,
+	for replica := 0; replica <= settings.VerificationReplicas; replica++ {
+		taskIDBytes := make([]byte, 32)
+		rand.Read(taskIDBytes)
+		taskID := hexutil.Encode(taskIDBytes)
+
+		for i := 0; i < repeatNum; i++ {
+			task := &models.InferenceTask{
+				TaskArgs:        in.TaskArgs,
+				TaskType:        taskType,
+				TaskModelIDs:    modelIDs,
+				TaskVersion:     taskVersion,
+				TaskFee:         taskFee,
+				MinVram:         minVram,
+				RequiredGPU:     in.RequiredGPU,
+				RequiredGPUVram: in.RequiredGPUVram,
+				TaskSize:        taskSize,
+				TaskID:          taskID,
+				Timeout:         timeout,
+				Replica:         replica,
+			}
+			tasks = append(tasks, task)
 		}
-		tasks = append(tasks, task)
 	}
 
 	return tasks, nil
 }
 
+// checkMaxTotalFee rejects the request whose first tasks already cost more than the max total fee of the client task.
+// It is checked before the client task is created, otherwise the rejected request leaves a running client task.
+func checkMaxTotalFee(settings *models.ClientTask, tasks []*models.InferenceTask) error {
+	if settings.MaxTotalFee == 0 {
+		return nil
+	}
+	var totalFee uint64
+	for _, task := range tasks {
+		totalFee += task.TaskFee
+	}
+	if totalFee > settings.MaxTotalFee {
+		return response.NewValidationErrorResponse("max_total_fee", "Max total fee is less than the task fee")
+	}
+	return nil
+}
+
+// getVerificationReplicas returns the verification replicas of the task set by the request,
+// or by the API key of the client. The results of the sd finetune tasks are not compared.
+func getVerificationReplicas(ctx context.Context, db *gorm.DB, in *TaskInput) (int, error) {
+	if in.VerificationReplicas != nil {
+		if *in.VerificationReplicas > 0 && *in.TaskType == models.TaskTypeSDFTLora {
+			return 0, response.NewValidationErrorResponse("verification_replicas", "Verification replicas are not supported by SD finetune tasks")
+		}
+		if *in.VerificationReplicas > tools.MaxVerificationReplicas() {
+			return 0, response.NewValidationErrorResponse("verification_replicas", fmt.Sprintf("Verification replicas should be at most %d", tools.MaxVerificationReplicas()))
+		}
+		return *in.VerificationReplicas, nil
+	}
+	if *in.TaskType == models.TaskTypeSDFTLora {
+		return 0, nil
+	}
+	replicas, err := models.GetClientVerificationReplicas(ctx, db, in.ClientID)
+	if err != nil {
+		return 0, response.NewExceptionResponse(err)
+	}
+	return min(replicas, tools.MaxVerificationReplicas()), nil
+}
+
 func DoCreateTask(ctx context.Context, in *TaskInput) (*TaskResponse, error) {
 	appConfig := config.GetConfig()
 	db := config.GetDB()
 
+	// the later segments of the finetune tasks carry checkpoints, which cannot be uploaded for the tasks created on chain
+	if *in.TaskType == models.TaskTypeSDFTLora && config.IsChainTaskBackend() {
+		return nil, response.NewValidationErrorResponse("task_type", "SD finetune tasks are not supported by the chain task backend")
+	}
+
 	// get Client
 	client, err := tools.GetClient(ctx, db, in.ClientID)
 	if err != nil {
@@ -178,20 +253,73 @@ func DoCreateTask(ctx context.Context, in *TaskInput) (*TaskResponse, error) {
 		}
 	}
 
-	// create ClientTask for client
-	clientTask, err := tools.CreateClientTask(ctx, db, client)
+	// the retry policy is stored with the client task, so that later config changes
+	// do not affect running tasks
+	var retryPolicy string
+	if in.RetryPolicy != nil {
+		policy := mergeRetryPolicy(*in.TaskType, in.RetryPolicy)
+		b, err := json.Marshal(policy)
+		if err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		retryPolicy = string(b)
+	}
+
+	settings := &models.ClientTask{RetryPolicy: retryPolicy}
+	if in.MaxTotalFee != nil {
+		settings.MaxTotalFee = *in.MaxTotalFee
+	}
+
+	verificationReplicas, err := getVerificationReplicas(ctx, db, in)
+	if err != nil {
+		return nil, err
+	}
+	settings.VerificationReplicas = verificationReplicas
+
+	// the request is validated and its fee is quoted before anything is saved,
+	// so that a rejected request leaves no running client task behind
+	feeDecision, err := quoteTaskFee(ctx, in, client.ID)
 	if err != nil {
 		return nil, response.NewExceptionResponse(err)
 	}
 
 	// build interface tasks
-	tasks, err := buildTasks(in, client, clientTask, appConfig)
+	tasks, err := buildTasks(in, settings, appConfig, feeDecision)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tasks are submitted to the relay in the priority class of the client
+	priority, err := models.GetClientPriority(ctx, db, in.ClientID)
 	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	for _, task := range tasks {
+		task.Priority = priority
+	}
+
+	if err := checkMaxTotalFee(settings, tasks); err != nil {
 		return nil, err
 	}
 
-	// save tasks to local db
-	err = models.SaveTasks(ctx, config.GetDB(), tasks)
+	// create ClientTask for client and save its tasks to local db in one transaction,
+	// together with the fee decisions for the audit of the fee policy
+	var clientTask *models.ClientTask
+	err = config.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		clientTask, err = tools.CreateClientTask(ctx, tx, client, settings)
+		if err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			task.Client = clientTask.Client
+			task.ClientTask = *clientTask
+		}
+		if err := models.SaveTasks(ctx, tx, tasks); err != nil {
+			return err
+		}
+		return models.SaveFeeDecisions(ctx, tx, newFeeDecisions(feeDecision, tasks))
+	})
 	if err != nil {
 		return nil, response.NewExceptionResponse(err)
 	}
@@ -214,6 +342,8 @@ func CreateTask(c *gin.Context, in *TaskInput) (*TaskResponse, error) {
 	if !allowed {
 		return nil, response.NewValidationErrorResponse("rate_limit", fmt.Sprintf("rate limit exceeded, please wait %.2f seconds", waitTime))
 	}
-	
-	return DoCreateTask(ctx, in)
+
+	return RunIdempotent(c, 0, in, 0, func(ctx context.Context, clientTask *models.ClientTask) (*TaskResponse, error) {
+		return &TaskResponse{Data: clientTask}, nil
+	})
 }
@@ -0,0 +1,28 @@
+package inference_tasks
+
+import (
+	"crynux_bridge/models"
+	"testing"
+)
+
+func TestCheckMaxTotalFee(t *testing.T) {
+	// a task and its verification replica
+	tasks := []*models.InferenceTask{{TaskFee: 40}, {TaskFee: 40}}
+	cases := []struct {
+		name        string
+		maxTotalFee uint64
+		rejected    bool
+	}{
+		{"no limit", 0, false},
+		{"within budget", 80, false},
+		{"replicas over budget", 79, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkMaxTotalFee(&models.ClientTask{MaxTotalFee: c.maxTotalFee}, tasks)
+			if (err != nil) != c.rejected {
+				t.Errorf("request rejected %v, want %v: %v", err != nil, c.rejected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package inference_tasks
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type GetTaskAttemptsResponse struct {
+	response.Response
+	Data []models.TaskAttempt `json:"data"`
+}
+
+// GetTaskAttempts returns the failed attempts of the client task and the retry decision taken for each
+func GetTaskAttempts(c *gin.Context, in *GetTaskInput) (*GetTaskAttemptsResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	client, err := tools.GetClient(ctx, db, in.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_id", "Client not found")
+		} else {
+			return nil, response.NewExceptionResponse(err)
+		}
+	}
+
+	clientTask, err := tools.GetClientTask(ctx, db, client.ID, in.ClientTaskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_task_id", "Client task not found")
+		} else {
+			return nil, response.NewExceptionResponse(err)
+		}
+	}
+
+	attempts, err := models.GetTaskAttempts(ctx, db, clientTask.ID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &GetTaskAttemptsResponse{Data: attempts}, nil
+}
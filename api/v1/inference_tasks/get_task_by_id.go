@@ -18,7 +18,10 @@ type GetTaskInput struct {
 
 type GetTaskResponse struct {
 	response.Response
-	Data *models.InferenceTask `json:"data"`
+	Data     *models.InferenceTask `json:"data"`
+	Attempts int                   `json:"attempts"` // tasks submitted for the client task, including the retries
+	// task fee in GWei of the verification replicas of the client task, including their retries
+	VerificationFee uint64 `json:"verification_fee"`
 }
 
 func GetTaskById(c *gin.Context, in *GetTaskInput) (*GetTaskResponse, error) {
@@ -48,10 +51,53 @@ func GetTaskById(c *gin.Context, in *GetTaskInput) (*GetTaskResponse, error) {
 		return nil, response.NewValidationErrorResponse("client_task_id", "Client task has no associated tasks")
 	}
 
-	task := clientTask.InferenceTasks[0]
-	for _, t := range clientTask.InferenceTasks[1:] {
-		if t.Status == models.InferenceTaskResultDownloaded {
-			if task.Status != models.InferenceTaskResultDownloaded {
+	task := resultTask(clientTask.InferenceTasks)
+
+	attempts, err := models.GetTaskAttempts(ctx, db, clientTask.ID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return &GetTaskResponse{
+		Data:            &task,
+		Attempts:        countSubmittedAttempts(attempts),
+		VerificationFee: verificationFee(clientTask.InferenceTasks),
+	}, nil
+}
+
+// verificationFee returns the total task fee of the verification replicas among the tasks of a client task
+func verificationFee(tasks []models.InferenceTask) uint64 {
+	var fee uint64
+	for _, task := range tasks {
+		if task.Replica > 0 {
+			fee += task.TaskFee
+		}
+	}
+	return fee
+}
+
+// countSubmittedAttempts returns the number of tasks submitted for the client task,
+// which is the first task and one more for each attempt followed by a new task
+func countSubmittedAttempts(attempts []models.TaskAttempt) int {
+	count := 1
+	for _, attempt := range attempts {
+		if attempt.NextTaskID != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// resultTask returns the task whose result is returned to the client among the tasks of a client task:
+// the earliest finished successful task whose result agrees with the other replicas, or a task not aborted if none succeeded
+func resultTask(tasks []models.InferenceTask) models.InferenceTask {
+	downloaded := func(t models.InferenceTask) bool {
+		return t.Status == models.InferenceTaskResultDownloaded && t.ResultVerification != models.ResultVerificationMismatch
+	}
+	task := tasks[0]
+	for _, t := range tasks[1:] {
+		if downloaded(t) {
+			if !downloaded(task) {
 				task = t
 			} else if task.UpdatedAt.Sub(t.UpdatedAt) > 0 {
 				task = t
@@ -60,8 +106,5 @@ func GetTaskById(c *gin.Context, in *GetTaskInput) (*GetTaskResponse, error) {
 			task = t
 		}
 	}
-
-	return &GetTaskResponse{
-		Data: &task,
-	}, nil
+	return task
 }
@@ -0,0 +1,47 @@
+package inference_tasks
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type GetTaskFeesResponse struct {
+	response.Response
+	Data []models.FeeDecision `json:"data"`
+}
+
+// GetTaskFees returns how the task fee of every inference task of the client task was chosen, and its outcome
+func GetTaskFees(c *gin.Context, in *GetTaskInput) (*GetTaskFeesResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	client, err := tools.GetClient(ctx, db, in.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_id", "Client not found")
+		} else {
+			return nil, response.NewExceptionResponse(err)
+		}
+	}
+
+	clientTask, err := tools.GetClientTask(ctx, db, client.ID, in.ClientTaskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_task_id", "Client task not found")
+		} else {
+			return nil, response.NewExceptionResponse(err)
+		}
+	}
+
+	decisions, err := models.GetFeeDecisions(ctx, db, clientTask.ID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &GetTaskFeesResponse{Data: decisions}, nil
+}
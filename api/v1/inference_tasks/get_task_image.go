@@ -5,10 +5,10 @@ import (
 	"crynux_bridge/api/v1/response"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
+	"crynux_bridge/storage"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -57,22 +57,14 @@ func GetTaskImage(c *gin.Context, in *GetTaskImageInput) error {
 		return response.NewValidationErrorResponse("client_task_id", "Client task has no associated tasks")
 	}
 
-	task := clientTask.InferenceTasks[0]
-	for _, t := range clientTask.InferenceTasks[1:] {
-		if t.Status == models.InferenceTaskResultDownloaded {
-			if task.Status != models.InferenceTaskResultDownloaded {
-				task = t
-			} else if task.UpdatedAt.Sub(t.UpdatedAt) > 0 {
-				task = t
-			}
-		} else if task.Status == models.InferenceTaskEndAborted && t.Status != models.InferenceTaskEndAborted {
-			task = t
-		}
-	}
+	task := resultTask(clientTask.InferenceTasks)
 
 	if task.Status != models.InferenceTaskResultDownloaded {
 		return response.NewValidationErrorResponse("client_task_id", "Client task was not successful")
 	}
+	if task.ResultExpired() {
+		return response.NewValidationErrorResponse("client_task_id", "Client task result expired")
+	}
 
 	ext := "png"
 	if task.TaskType == models.TaskTypeLLM {
@@ -80,22 +72,18 @@ func GetTaskImage(c *gin.Context, in *GetTaskImageInput) error {
 	}
 	filename := fmt.Sprintf("%d.%s", *in.Index, ext)
 
-	appConfig := config.GetConfig()
-	imageFile := filepath.Join(
-		appConfig.DataDir.InferenceTasks,
-		task.TaskIDCommitment,
-		filename,
-	)
-
-	if _, err := os.Stat(imageFile); err != nil {
+	imageFile, size, err := storage.GetResultStore().Get(c.Request.Context(), storage.TaskKey(task.TaskIDCommitment, filename))
+	if errors.Is(err, storage.ErrNotFound) {
 		return response.NewValidationErrorResponse("image_num", "Image not found")
+	} else if err != nil {
+		return response.NewExceptionResponse(err)
 	}
+	defer imageFile.Close()
 
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.File(imageFile)
+	c.DataFromReader(http.StatusOK, size, "application/octet-stream", imageFile, nil)
 
 	return nil
-}
+}
\ No newline at end of file
@@ -0,0 +1,90 @@
+package inference_tasks
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TaskTimeline struct {
+	InferenceTaskID  uint                     `json:"inference_task_id"`
+	TaskIDCommitment string                   `json:"task_id_commitment"`
+	Status           models.TaskStatus        `json:"status"`
+	Events           []models.TaskStatusEvent `json:"events"`
+	Latency          models.TaskLatency       `json:"latency"`
+}
+
+type GetTaskTimelineResponse struct {
+	response.Response
+	Data []TaskTimeline `json:"data"`
+}
+
+// GetTaskTimeline returns the status transitions of every inference task of the client task
+func GetTaskTimeline(c *gin.Context, in *GetTaskInput) (*GetTaskTimelineResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	client, err := tools.GetClient(ctx, db, in.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_id", "Client not found")
+		} else {
+			return nil, response.NewExceptionResponse(err)
+		}
+	}
+
+	clientTask, err := tools.GetClientTask(ctx, db, client.ID, in.ClientTaskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("client_task_id", "Client task not found")
+		} else {
+			return nil, response.NewExceptionResponse(err)
+		}
+	}
+
+	ids := make([]uint, len(clientTask.InferenceTasks))
+	for i, task := range clientTask.InferenceTasks {
+		ids[i] = task.ID
+	}
+	events, err := models.GetTaskStatusEvents(ctx, db, ids)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	taskEvents := make(map[uint][]models.TaskStatusEvent)
+	for _, event := range events {
+		taskEvents[event.InferenceTaskID] = append(taskEvents[event.InferenceTaskID], event)
+	}
+
+	timelines := make([]TaskTimeline, len(clientTask.InferenceTasks))
+	for i, task := range clientTask.InferenceTasks {
+		events := taskEvents[task.ID]
+		if events == nil {
+			events = make([]models.TaskStatusEvent, 0)
+		}
+		var downloaded *models.TaskStatusEvent
+		for j := range events {
+			if events[j].Status == models.InferenceTaskResultDownloaded {
+				downloaded = &events[j]
+			}
+		}
+		timeline := TaskTimeline{
+			InferenceTaskID:  task.ID,
+			TaskIDCommitment: task.TaskIDCommitment,
+			Status:           task.Status,
+			Events:           events,
+		}
+		if downloaded != nil {
+			timeline.Latency = task.Latency(&downloaded.CreatedAt)
+		} else {
+			timeline.Latency = task.Latency(nil)
+		}
+		timelines[i] = timeline
+	}
+
+	return &GetTaskTimelineResponse{Data: timelines}, nil
+}
@@ -0,0 +1,190 @@
+package inference_tasks
+
+import (
+	"context"
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// how often a duplicate request checks the request it waits for before its client task is created
+const idempotencyPollInterval = 500 * time.Millisecond
+
+// RunIdempotent creates the client task of the request and waits for it with wait, which builds the response.
+// timeout limits the creating and the waiting, 0 means no limit.
+//
+// If the request has an Idempotency-Key header, the client task is created at most once for the key of the
+// api key and the client: a duplicate request arriving while the first one is in flight waits for the same
+// client task, and a duplicate arriving after the first one has completed gets the stored response. The key
+// is released if the request fails, so that a retry runs it again.
+func RunIdempotent[T any](c *gin.Context, apiKeyID uint, in *TaskInput, timeout time.Duration, wait func(ctx context.Context, clientTask *models.ClientTask) (*T, error)) (*T, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	create := func(ctx context.Context) (*models.ClientTask, error) {
+		taskResponse, err := DoCreateTask(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return taskResponse.Data, nil
+	}
+
+	keyStr := c.GetHeader(tools.IdempotencyKeyHeader)
+	if len(keyStr) == 0 {
+		clientTask, err := create(waitCtx)
+		if err != nil {
+			return nil, err
+		}
+		return wait(waitCtx, clientTask)
+	}
+	if len(keyStr) > 255 {
+		return nil, response.NewValidationErrorResponse(tools.IdempotencyKeyHeader, "Idempotency key is longer than 255 characters")
+	}
+
+	for {
+		key, acquired, err := models.AcquireIdempotencyKey(ctx, db, &models.IdempotencyKey{
+			APIKeyID:    apiKeyID,
+			ClientID:    in.ClientID,
+			Key:         keyStr,
+			RequestHash: tools.GetRequestHash(c),
+			ExpiresAt:   time.Now().Add(tools.IdempotencyKeyTTL()),
+		})
+		if errors.Is(err, models.ErrIdempotencyKeyMismatch) {
+			return nil, response.NewValidationErrorResponse(tools.IdempotencyKeyHeader, "Idempotency key has been used with a different request")
+		}
+		if err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		if acquired {
+			return runIdempotencyKey(waitCtx, db, key, create, wait)
+		}
+
+		res, released, err := attachIdempotencyKey(ctx, waitCtx, db, key, wait)
+		if !released {
+			return res, err
+		}
+		// the first request has failed, so the request runs again
+	}
+}
+
+// runIdempotencyKey runs the request holding the acquired key, create creates the client task of the request
+func runIdempotencyKey[T any](waitCtx context.Context, db *gorm.DB, key *models.IdempotencyKey, create func(ctx context.Context) (*models.ClientTask, error), wait func(ctx context.Context, clientTask *models.ClientTask) (*T, error)) (*T, error) {
+	clientTask, err := create(waitCtx)
+	if err != nil {
+		releaseIdempotencyKey(db, key)
+		return nil, err
+	}
+	if err := key.Update(context.Background(), db, map[string]interface{}{"client_task_id": clientTask.ID}); err != nil {
+		log.Errorf("Idempotency: cannot save client task %d of key %d: %v", clientTask.ID, key.ID, err)
+	}
+
+	res, err := wait(waitCtx, clientTask)
+	if err != nil {
+		releaseEndedIdempotencyKey(db, key, clientTask)
+		return nil, err
+	}
+	completeIdempotencyKey(db, key, res)
+	return res, nil
+}
+
+// attachIdempotencyKey waits for the request holding the key and returns its response.
+// It returns released if the key has been released by the failed request in between.
+func attachIdempotencyKey[T any](ctx, waitCtx context.Context, db *gorm.DB, key *models.IdempotencyKey, wait func(ctx context.Context, clientTask *models.ClientTask) (*T, error)) (*T, bool, error) {
+	for key.Status == models.IdempotencyKeyProcessing && key.ClientTaskID == 0 {
+		select {
+		case <-waitCtx.Done():
+			return nil, false, response.NewExceptionResponse(waitCtx.Err())
+		case <-time.After(idempotencyPollInterval):
+		}
+		var err error
+		key, err = models.GetIdempotencyKeyByID(ctx, db, key.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, true, nil
+		}
+		if err != nil {
+			return nil, false, response.NewExceptionResponse(err)
+		}
+	}
+
+	if key.Status == models.IdempotencyKeyCompleted {
+		res := new(T)
+		if err := json.Unmarshal([]byte(key.Response), res); err != nil {
+			return nil, false, response.NewExceptionResponse(err)
+		}
+		return res, false, nil
+	}
+
+	clientTask, err := getClientTaskWithTasks(ctx, db, key.ClientTaskID)
+	if err != nil {
+		return nil, false, response.NewExceptionResponse(err)
+	}
+	res, err := wait(waitCtx, clientTask)
+	if err != nil {
+		releaseEndedIdempotencyKey(db, key, clientTask)
+		return nil, false, err
+	}
+	completeIdempotencyKey(db, key, res)
+	return res, false, nil
+}
+
+// getClientTaskWithTasks returns the client task with its tasks, excluding the validation tasks created later
+func getClientTaskWithTasks(ctx context.Context, db *gorm.DB, clientTaskID uint) (*models.ClientTask, error) {
+	clientTask, err := models.GetClientTaskByID(ctx, db, clientTaskID)
+	if err != nil {
+		return nil, err
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err = db.WithContext(dbCtx).Model(&models.InferenceTask{}).
+		Where("client_task_id = ?", clientTask.ID).
+		Where("vrf_proof = '' OR vrf_proof IS NULL").
+		Order("id ASC").
+		Find(&clientTask.InferenceTasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return clientTask, nil
+}
+
+func completeIdempotencyKey(db *gorm.DB, key *models.IdempotencyKey, res interface{}) {
+	b, err := json.Marshal(res)
+	if err == nil {
+		err = key.Complete(context.Background(), db, string(b))
+	}
+	if err != nil {
+		log.Errorf("Idempotency: cannot save the response of key %d: %v", key.ID, err)
+	}
+}
+
+// releaseEndedIdempotencyKey releases the key if its client task has ended without a result, so that a retry runs the request again.
+// The key of a running client task is kept for the retries to attach to, even if the request has timed out or been abandoned.
+func releaseEndedIdempotencyKey(db *gorm.DB, key *models.IdempotencyKey, clientTask *models.ClientTask) {
+	if err := clientTask.Sync(context.Background(), db); err != nil {
+		log.Errorf("Idempotency: cannot get client task %d of key %d: %v", clientTask.ID, key.ID, err)
+		return
+	}
+	if clientTask.Status != models.ClientTaskStatusRunning {
+		releaseIdempotencyKey(db, key)
+	}
+}
+
+func releaseIdempotencyKey(db *gorm.DB, key *models.IdempotencyKey) {
+	if err := key.Release(context.Background(), db); err != nil {
+		log.Errorf("Idempotency: cannot release key %d: %v", key.ID, err)
+	}
+}
@@ -0,0 +1,113 @@
+package inference_tasks
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newIdempotencyTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to ":memory:" opens a new database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	err = db.AutoMigrate(&models.IdempotencyKey{}, &models.ClientTask{}, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func acquireTestKey(t *testing.T, db *gorm.DB) (*models.IdempotencyKey, bool) {
+	t.Helper()
+	key, acquired, err := models.AcquireIdempotencyKey(context.Background(), db, &models.IdempotencyKey{
+		ClientID:    "client",
+		Key:         "key",
+		RequestHash: "hash",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, acquired
+}
+
+func TestIdempotencyKeyTimeout(t *testing.T) {
+	ctx := context.Background()
+	db := newIdempotencyTestDB(t)
+
+	var created *models.ClientTask
+	create := func(ctx context.Context) (*models.ClientTask, error) {
+		created = &models.ClientTask{ClientID: 1}
+		return created, db.Create(created).Error
+	}
+	waitTimeout := func(ctx context.Context, clientTask *models.ClientTask) (*string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	// the first request times out on the server while its client task is still running
+	key, acquired := acquireTestKey(t, db)
+	if !acquired {
+		t.Fatal("the key should be acquired by the first request")
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := runIdempotencyKey(waitCtx, db, key, create, waitTimeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the first request should time out: %v", err)
+	}
+
+	// the retry attaches to the client task of the first request
+	key, acquired = acquireTestKey(t, db)
+	if acquired || key.ClientTaskID != created.ID {
+		t.Fatalf("the key of the running client task should be kept: %v %+v", acquired, key)
+	}
+	result := "result"
+	var attached uint
+	res, released, err := attachIdempotencyKey(ctx, ctx, db, key, func(ctx context.Context, clientTask *models.ClientTask) (*string, error) {
+		attached = clientTask.ID
+		return &result, nil
+	})
+	if err != nil || released || *res != result {
+		t.Fatalf("the retry should get the result of the first client task: %v %v %v", res, released, err)
+	}
+	if attached != created.ID {
+		t.Errorf("the retry should wait for client task %d: %d", created.ID, attached)
+	}
+}
+
+func TestIdempotencyKeyEndedTask(t *testing.T) {
+	db := newIdempotencyTestDB(t)
+
+	create := func(ctx context.Context) (*models.ClientTask, error) {
+		clientTask := &models.ClientTask{ClientID: 1}
+		return clientTask, db.Create(clientTask).Error
+	}
+	waitFailed := func(ctx context.Context, clientTask *models.ClientTask) (*string, error) {
+		if err := db.Model(clientTask).Update("status", models.ClientTaskStatusFailed).Error; err != nil {
+			return nil, err
+		}
+		return nil, errors.New("task failed")
+	}
+
+	key, _ := acquireTestKey(t, db)
+	if _, err := runIdempotencyKey(context.Background(), db, key, create, waitFailed); err == nil {
+		t.Fatal("the request should fail")
+	}
+	// the client task has ended without a result, so the retry runs the request again
+	if _, acquired := acquireTestKey(t, db); !acquired {
+		t.Error("the key of the failed client task should be released")
+	}
+}
@@ -0,0 +1,102 @@
+package inference_tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+	"time"
+)
+
+// PipelineStepRunner runs the steps of the pipelines as client tasks created by DoCreateTask.
+//
+// The outputs of the steps, which can be referred to by the templates of later steps, are:
+//   - llm: content, the message content of the first result, and contents, of all the results
+//   - image: image, the link to the first image, and images, the links to all the images
+//   - finetune: model, the link to the lora model
+//
+// and client_task_id of all the steps.
+type PipelineStepRunner struct{}
+
+func (PipelineStepRunner) CreateTask(ctx context.Context, pipeline *models.Pipeline, step *models.PipelineStep, taskArgs string, maxTotalFee uint64) (*models.ClientTask, error) {
+	settings, err := step.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+	taskType := step.Type.TaskType()
+	in := &TaskInput{
+		ClientID:        pipeline.Client.ClientId,
+		TaskArgs:        taskArgs,
+		TaskType:        &taskType,
+		TaskVersion:     settings.TaskVersion,
+		MinVram:         settings.MinVram,
+		RequiredGPU:     settings.RequiredGPU,
+		RequiredGPUVram: settings.RequiredGPUVram,
+		RepeatNum:       settings.RepeatNum,
+		TaskFee:         settings.TaskFee,
+		Timeout:         settings.Timeout,
+	}
+	if maxTotalFee > 0 {
+		in.MaxTotalFee = &maxTotalFee
+	}
+	res, err := DoCreateTask(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+func (PipelineStepRunner) ReadOutput(ctx context.Context, pipeline *models.Pipeline, step *models.PipelineStep, clientTask *models.ClientTask) (map[string]interface{}, error) {
+	db := config.GetDB()
+	output := map[string]interface{}{
+		"client_task_id": clientTask.ID,
+	}
+
+	if step.Type == models.PipelineStepLLM {
+		var tasks []models.InferenceTask
+		dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		err := db.WithContext(dbCtx).Model(&models.InferenceTask{}).
+			Where("client_task_id = ?", clientTask.ID).
+			Order("id ASC").
+			Find(&tasks).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(tasks) == 0 {
+			return nil, errors.New("client task has no associated tasks")
+		}
+		task := resultTask(tasks)
+		results, err := readGPTTaskResults(ctx, &task)
+		if err != nil {
+			return nil, err
+		}
+		contents := make([]string, 0, len(results))
+		for _, result := range results {
+			if len(result.Choices) > 0 {
+				contents = append(contents, result.Choices[0].Message.Content)
+			}
+		}
+		if len(contents) == 0 {
+			return nil, errors.New("llm task has no result")
+		}
+		output["content"] = contents[0]
+		output["contents"] = contents
+		return output, nil
+	}
+
+	result, err := getTaskResult(ctx, db, pipeline.Client.ClientId, clientTask)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Results) == 0 {
+		return nil, errors.New("client task has no result")
+	}
+	if step.Type == models.PipelineStepFinetune {
+		output["model"] = result.Results[0]
+	} else {
+		output["image"] = result.Results[0]
+		output["images"] = result.Results
+	}
+	return output, nil
+}
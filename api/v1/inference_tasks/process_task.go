@@ -3,46 +3,73 @@ package inference_tasks
 import (
 	"context"
 	"crynux_bridge/api/v1/response"
-	"crynux_bridge/config"
 	"crynux_bridge/models"
+	"crynux_bridge/storage"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"path"
+	"io"
 	"sync"
-	"time"
 
 	"gorm.io/gorm"
 )
 
-func ProcessGPTTask(ctx context.Context, db *gorm.DB, in *TaskInput) (*models.GPTTaskResponse, *models.InferenceTask, error) {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
-	defer cancel()
-	/* 1. Create GPT task by function CreateTask */
-	taskResponse, err := DoCreateTask(ctx, in)
-	if err != nil {
-		return nil, nil, err
+// waitResultTask waits until the tasks of the client task are finished and returns the task whose result
+// is downloaded. The tasks resubmitted by the retry policy of the client task are waited for in turn.
+func waitResultTask(ctx context.Context, db *gorm.DB, clientTask *models.ClientTask) (*models.InferenceTask, error) {
+	tasks := clientTask.InferenceTasks
+	if len(tasks) == 0 {
+		return nil, errors.New("no task created")
+	}
+	if clientTask.VerificationReplicas > 0 {
+		return waitReplicatedResultTask(ctx, db, clientTask)
+	}
+	for {
+		taskGroups, err := models.WaitAllTaskGroup(ctx, db, tasks)
+		if err != nil {
+			return nil, err
+		}
+		task, err := models.WaitResultTask(ctx, db, taskGroups)
+		if !errors.Is(err, models.ErrTaskEndWithoutResult) {
+			return task, err
+		}
+		newTasks, err := models.WaitRetryTasks(ctx, db, clientTask.ID, append(tasks, taskGroups...))
+		if err != nil {
+			return nil, err
+		}
+		if len(newTasks) == 0 {
+			return nil, models.ErrTaskEndWithoutResult
+		}
+		tasks = newTasks
 	}
+}
 
-	/* 2. Get tasks, wait until they are finished and the taks result is downloaded  */
-	tasks := taskResponse.Data.InferenceTasks
-	if len(tasks) == 0 {
-		err := errors.New("no task created")
-		return nil, nil, response.NewExceptionResponse(err)
+// waitReplicatedResultTask waits until the results of the verification replicas of the client task
+// have been compared, and returns the task whose result agrees with the most replicas
+func waitReplicatedResultTask(ctx context.Context, db *gorm.DB, clientTask *models.ClientTask) (*models.InferenceTask, error) {
+	if err := models.WaitClientTaskFinish(ctx, db, clientTask); err != nil {
+		return nil, err
 	}
-	taskGroups, err := models.WaitAllTaskGroup(ctx, db, tasks)
+	if clientTask.Status != models.ClientTaskStatusSuccess {
+		return nil, models.ErrTaskEndWithoutResult
+	}
+	tasks, err := models.GetClientTaskInferenceTasks(ctx, db, clientTask.ID)
 	if err != nil {
-		return nil, nil, response.NewExceptionResponse(err)
+		return nil, err
 	}
-	resultDownloadedTask, err := models.WaitResultTask(ctx, db, taskGroups)
+	task := resultTask(tasks)
+	return &task, nil
+}
+
+// WaitGPTTask waits until the GPT tasks of the client task are finished and reads the task result
+func WaitGPTTask(ctx context.Context, db *gorm.DB, clientTask *models.ClientTask) (*models.GPTTaskResponse, *models.InferenceTask, error) {
+	resultDownloadedTask, err := waitResultTask(ctx, db, clientTask)
 	if err != nil {
 		return nil, nil, response.NewExceptionResponse(err)
 	}
 
-	/* 3. Read task result and return */
-	results, err := readGPTTaskResults(resultDownloadedTask)
-	if err != nil { 
+	results, err := readGPTTaskResults(ctx, resultDownloadedTask)
+	if err != nil {
 		return nil, nil, response.NewExceptionResponse(err)
 	}
 
@@ -52,31 +79,13 @@ func ProcessGPTTask(ctx context.Context, db *gorm.DB, in *TaskInput) (*models.GP
 	return &gptTaskResponse, resultDownloadedTask, nil
 }
 
-func ProcessSDTask(ctx context.Context, db *gorm.DB, in *TaskInput) ([]string, *models.InferenceTask, error) {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
-	defer cancel()
-	/* 1. Create SD task by function CreateTask */
-	taskResponse, err := DoCreateTask(ctx, in)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	/* 2. Get tasks, wait until they are finished and the taks result is downloaded  */
-	tasks := taskResponse.Data.InferenceTasks
-	if len(tasks) == 0 {
-		err := errors.New("no task created")
-		return nil, nil, response.NewExceptionResponse(err)
-	}
-	taskGroups, err := models.WaitAllTaskGroup(ctx, db, tasks)
-	if err != nil {
-		return nil, nil, response.NewExceptionResponse(err)
-	}
-	resultDownloadedTask, err := models.WaitResultTask(ctx, db, taskGroups)
+// WaitSDTask waits until the SD tasks of the client task are finished and returns the keys of the result images
+func WaitSDTask(ctx context.Context, db *gorm.DB, clientTask *models.ClientTask) ([]string, *models.InferenceTask, error) {
+	resultDownloadedTask, err := waitResultTask(ctx, db, clientTask)
 	if err != nil {
 		return nil, nil, response.NewExceptionResponse(err)
 	}
 
-	/* 3. Read task result and return */
 	results, err := readSDTaskResults(resultDownloadedTask)
 	if err != nil {
 		return nil, nil, response.NewExceptionResponse(err)
@@ -116,14 +125,13 @@ func ProcessSDFTLoraTask(ctx context.Context, db *gorm.DB, in *TaskInput) (strin
 	return results, resultDownloadedTask, nil
 }
 
-func readGPTTaskResults(task *models.InferenceTask) ([]models.GPTTaskResponse, error) {
+func readGPTTaskResults(ctx context.Context, task *models.InferenceTask) ([]models.GPTTaskResponse, error) {
 	if task.TaskType != models.TaskTypeLLM {
 		err := errors.New("unsupported task type")
 		return nil, err
 	}
 
-	appConfig := config.GetConfig()
-	taskFolder := path.Join(appConfig.DataDir.InferenceTasks, task.TaskIDCommitment)
+	store := storage.GetResultStore()
 	ext := "json"
 
 	// 1. create a results array
@@ -137,17 +145,24 @@ func readGPTTaskResults(task *models.InferenceTask) ([]models.GPTTaskResponse, e
 		go func(index int) {
 			defer wg.Done()
 
-			filename := path.Join(taskFolder, fmt.Sprintf("%d.%s", index, ext))
-			data, err := os.ReadFile(filename)
+			key := storage.TaskKey(task.TaskIDCommitment, fmt.Sprintf("%d.%s", index, ext))
+			data, err := func() ([]byte, error) {
+				file, _, err := store.Get(ctx, key)
+				if err != nil {
+					return nil, err
+				}
+				defer file.Close()
+				return io.ReadAll(file)
+			}()
 			if err != nil {
-				errCh <- fmt.Errorf("readGPTTaskResults: failed to read file %s, error: %w", filename, err)
+				errCh <- fmt.Errorf("readGPTTaskResults: failed to read file %s, error: %w", key, err)
 				return
 			}
 
 			var result models.GPTTaskResponse
 
 			if err := json.Unmarshal(data, &result); err != nil {
-				errCh <- fmt.Errorf("failed to unmarshal json file %s, error: %w", filename, err)
+				errCh <- fmt.Errorf("failed to unmarshal json file %s, error: %w", key, err)
 				return
 			}
 
@@ -167,34 +182,29 @@ func readGPTTaskResults(task *models.InferenceTask) ([]models.GPTTaskResponse, e
 	return results, nil
 }
 
+// readSDTaskResults returns the keys of the result images in the result store
 func readSDTaskResults(task *models.InferenceTask) ([]string, error) {
 	if task.TaskType != models.TaskTypeSD {
 		err := errors.New("unsupported task type")
 		return nil, err
 	}
 
-	appConfig := config.GetConfig()
-	taskFolder := path.Join(appConfig.DataDir.InferenceTasks, task.TaskIDCommitment)
 	ext := "png"
 
 	results := make([]string, task.TaskSize)
 	for i := 0; i < int(task.TaskSize); i++ {
-		filename := path.Join(taskFolder, fmt.Sprintf("%d.%s", i, ext))
-		results[i] = filename
+		results[i] = storage.TaskKey(task.TaskIDCommitment, fmt.Sprintf("%d.%s", i, ext))
 	}
 
 	return results, nil
 }
 
+// readSDFTLoraTaskResults returns the key of the result checkpoint in the result store
 func readSDFTLoraTaskResults(task *models.InferenceTask) (string, error) {
 	if task.TaskType != models.TaskTypeSDFTLora {
 		err := errors.New("unsupported task type")
 		return "", err
 	}
 
-	appConfig := config.GetConfig()
-	taskFolder := path.Join(appConfig.DataDir.InferenceTasks, task.TaskIDCommitment)
-	filename := path.Join(taskFolder, "checkpoint.zip")
-
-	return filename, nil
+	return storage.TaskKey(task.TaskIDCommitment, "checkpoint.zip"), nil
 }
\ No newline at end of file
@@ -0,0 +1,12 @@
+package inference_tasks
+
+import (
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/tasks"
+)
+
+// mergeRetryPolicy returns the retry policy of the task type overridden by the request
+func mergeRetryPolicy(taskType models.ChainTaskType, in *RetryPolicyInput) config.RetryPolicy {
+	return tasks.DefaultRetryPolicy(taskType).Merge(in.toRetryPolicy())
+}
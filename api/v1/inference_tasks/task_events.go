@@ -0,0 +1,266 @@
+package inference_tasks
+
+import (
+	"context"
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	taskEventsBatchSize = 100
+	// the events are queried again after this interval even if no notification is received,
+	// because the notifications of other bridge instances are not received
+	taskEventsPollInterval = 2 * time.Second
+	taskEventsKeepAlive    = 15 * time.Second
+	// the events created in this window are read again, because an event may commit after one of a higher id
+	taskEventsOverlap = 30 * time.Second
+)
+
+type GetTaskEventsInput struct {
+	ClientID     string `path:"client_id" json:"client_id" description:"Client id" validate:"required"`
+	ClientTaskID uint   `path:"client_task_id" json:"client_task_id" description:"Client task id" validate:"required"`
+	LastEventID  string `header:"Last-Event-ID" json:"-" description:"The id of the last received event, set by the browser when reconnecting. It is the comma separated ids of the recently sent events"`
+}
+
+type TaskResult struct {
+	ClientTaskID uint                    `json:"client_task_id"`
+	Status       models.ClientTaskStatus `json:"status"`
+	Results      []string                `json:"results"`
+}
+
+// getTaskResult returns the links to download the results of the client task
+func getTaskResult(ctx context.Context, db *gorm.DB, clientID string, clientTask *models.ClientTask) (*TaskResult, error) {
+	result := &TaskResult{
+		ClientTaskID: clientTask.ID,
+		Status:       clientTask.Status,
+		Results:      make([]string, 0),
+	}
+	if clientTask.Status != models.ClientTaskStatusSuccess {
+		return result, nil
+	}
+
+	var tasks []models.InferenceTask
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := db.WithContext(dbCtx).Model(&models.InferenceTask{}).
+		Where("client_task_id = ?", clientTask.ID).
+		Order("id ASC").
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return result, nil
+	}
+
+	task := resultTask(tasks)
+	if task.TaskType == models.TaskTypeSDFTLora {
+		result.Results = append(result.Results, fmt.Sprintf("/v1/images/models/%d/result", clientTask.ID))
+		return result, nil
+	}
+	for i := uint64(0); i < task.TaskSize; i++ {
+		result.Results = append(result.Results, fmt.Sprintf("/v1/inference_tasks/%s/%d/images/%d", clientID, clientTask.ID, i))
+	}
+	return result, nil
+}
+
+// eventCursor is the position of the event stream. The events after lastID are read,
+// and the ones in the overlap window before it are read again, skipping the sent ones.
+type eventCursor struct {
+	lastID uint
+	// the ids of the recently sent events, with their creation time
+	sent map[uint]time.Time
+}
+
+// parseEventCursor parses the event id sent last, which lists the ids of the recent events sent before it
+func parseEventCursor(eventID string) (*eventCursor, error) {
+	cursor := &eventCursor{sent: make(map[uint]time.Time)}
+	if len(eventID) == 0 {
+		return cursor, nil
+	}
+	for _, s := range strings.Split(eventID, ",") {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		// the creation time is unknown, the id is kept for the whole window
+		cursor.add(uint(id), time.Now())
+	}
+	return cursor, nil
+}
+
+func (cursor *eventCursor) add(id uint, createdAt time.Time) {
+	cursor.sent[id] = createdAt
+	if id > cursor.lastID {
+		cursor.lastID = id
+	}
+}
+
+// String returns the event id of the latest sent event, the ids of the events out of the window are dropped
+func (cursor *eventCursor) String() string {
+	since := time.Now().Add(-taskEventsOverlap)
+	ids := make([]uint, 0, len(cursor.sent))
+	for id, createdAt := range cursor.sent {
+		if createdAt.Before(since) && id != cursor.lastID {
+			delete(cursor.sent, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+// next returns the events not sent yet, and whether there are more events after them
+func (cursor *eventCursor) next(ctx context.Context, db *gorm.DB, clientTaskID uint) ([]models.TaskEvent, bool, error) {
+	events := make([]models.TaskEvent, 0)
+	if cursor.lastID > 0 {
+		recent, err := models.GetRecentTaskEvents(ctx, db, clientTaskID, cursor.lastID, time.Now().Add(-taskEventsOverlap))
+		if err != nil {
+			return nil, false, err
+		}
+		for _, event := range recent {
+			if _, ok := cursor.sent[event.ID]; !ok {
+				events = append(events, event)
+			}
+		}
+	}
+	newEvents, err := models.GetTaskEvents(ctx, db, clientTaskID, cursor.lastID, taskEventsBatchSize)
+	if err != nil {
+		return nil, false, err
+	}
+	events = append(events, newEvents...)
+	return events, len(newEvents) == taskEventsBatchSize, nil
+}
+
+func writeEvent(c *gin.Context, id string, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if len(id) > 0 {
+		if _, err := fmt.Fprintf(c.Writer, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// GetTaskEvents streams the status transitions of the client task and its inference tasks as server-sent events.
+// A result event with the links of the results is sent after the client task finishes, and the stream is closed then.
+func GetTaskEvents(c *gin.Context, in *GetTaskEventsInput) error {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	client, err := tools.GetClient(ctx, db, in.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NewValidationErrorResponse("client_id", "Client not found")
+		}
+		return response.NewExceptionResponse(err)
+	}
+	clientTask, err := tools.GetClientTask(ctx, db, client.ID, in.ClientTaskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NewValidationErrorResponse("client_task_id", "Client task not found")
+		}
+		return response.NewExceptionResponse(err)
+	}
+
+	cursor, err := parseEventCursor(in.LastEventID)
+	if err != nil {
+		return response.NewValidationErrorResponse("Last-Event-ID", "Invalid event id")
+	}
+
+	wake, unsubscribe := models.Subscribe(models.TaskEventKey(clientTask.ID))
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.Flush()
+
+	lastWrite := time.Now()
+	for {
+		events, more, err := cursor.next(ctx, db, clientTask.ID)
+		if err != nil {
+			log.Errorf("TaskEvents: cannot get events of client task %d: %v", clientTask.ID, err)
+			return nil
+		}
+
+		finished := false
+		for _, event := range events {
+			cursor.add(event.ID, event.CreatedAt)
+			if err := writeEvent(c, cursor.String(), string(event.Type), json.RawMessage(event.Data)); err != nil {
+				return nil
+			}
+			lastWrite = time.Now()
+
+			if event.Type == models.TaskEventClientTask {
+				data := models.ClientTaskEventData{}
+				if err := json.Unmarshal([]byte(event.Data), &data); err == nil {
+					clientTask.Status = data.Status
+					finished = data.Status != models.ClientTaskStatusRunning
+				}
+			}
+		}
+		if more {
+			continue
+		}
+
+		// a client reconnecting after the last event still gets the result
+		if !finished && len(events) == 0 {
+			if err := clientTask.Sync(ctx, db); err != nil {
+				log.Errorf("TaskEvents: cannot get client task %d: %v", clientTask.ID, err)
+				return nil
+			}
+			finished = clientTask.Status != models.ClientTaskStatusRunning
+		}
+		if finished {
+			result, err := getTaskResult(ctx, db, in.ClientID, clientTask)
+			if err != nil {
+				log.Errorf("TaskEvents: cannot get result of client task %d: %v", clientTask.ID, err)
+				return nil
+			}
+			_ = writeEvent(c, "", "result", result)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-time.After(taskEventsPollInterval):
+			if time.Since(lastWrite) > taskEventsKeepAlive {
+				if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+					return nil
+				}
+				c.Writer.Flush()
+				lastWrite = time.Now()
+			}
+		}
+	}
+}
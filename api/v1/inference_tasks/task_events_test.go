@@ -0,0 +1,40 @@
+package inference_tasks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventCursor(t *testing.T) {
+	cursor, err := parseEventCursor("5,3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.lastID != 5 || len(cursor.sent) != 2 {
+		t.Fatalf("unexpected cursor %+v", cursor)
+	}
+
+	// an event of a lower id committed late is sent after the latest one
+	cursor.add(4, time.Now())
+	if cursor.lastID != 5 {
+		t.Errorf("last id should not go back: %d", cursor.lastID)
+	}
+	if id := cursor.String(); id != "3,4,5" {
+		t.Errorf("event id %s, want 3,4,5", id)
+	}
+
+	// the events out of the window are not read again, and are dropped from the event id
+	cursor.add(6, time.Now())
+	cursor.sent[3] = time.Now().Add(-taskEventsOverlap - time.Second)
+	if id := cursor.String(); id != "4,5,6" {
+		t.Errorf("event id %s, want 4,5,6", id)
+	}
+
+	if _, err := parseEventCursor("5,a"); err == nil {
+		t.Error("invalid event id should fail")
+	}
+	cursor, err = parseEventCursor("")
+	if err != nil || cursor.lastID != 0 {
+		t.Errorf("empty event id should start from the beginning: %+v %v", cursor, err)
+	}
+}
@@ -0,0 +1,46 @@
+package inference_tasks
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"crynux_bridge/tasks"
+)
+
+// quoteTaskFee returns the fee decision of the tasks of the request, whose task fee per unit of the
+// task size is set by the request or chosen by the fee policy, within the max task fee of the client
+func quoteTaskFee(ctx context.Context, in *TaskInput, clientID uint) (*models.FeeDecision, error) {
+	maxTaskFee, err := tasks.GetMaxTaskFee(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	decision := &models.FeeDecision{
+		TaskType:   *in.TaskType,
+		MaxTaskFee: maxTaskFee,
+	}
+	if in.TaskFee != nil {
+		decision.Reason = models.FeeDecisionRequested
+		decision.BaseFee = *in.TaskFee
+		decision.Multiplier = 1
+		return decision, nil
+	}
+	quote := tasks.QuoteTaskFee(ctx, *in.TaskType)
+	decision.Reason = models.FeeDecisionPolicy
+	decision.BaseFee = quote.TaskFee
+	decision.Multiplier = quote.Multiplier
+	decision.QueueDepth = quote.QueueDepth
+	decision.StartLatency = quote.StartLatency
+	return decision, nil
+}
+
+// newFeeDecisions copies the fee decision for each of the saved tasks
+func newFeeDecisions(decision *models.FeeDecision, tasks []*models.InferenceTask) []*models.FeeDecision {
+	decisions := make([]*models.FeeDecision, len(tasks))
+	for i, task := range tasks {
+		d := *decision
+		d.InferenceTaskID = task.ID
+		d.ClientTaskID = task.ClientTaskID
+		d.TaskFee = task.TaskFee
+		decisions[i] = &d
+	}
+	return decisions
+}
@@ -1,6 +1,7 @@
 package llm
 
 import (
+	"context"
 	"crynux_bridge/api/ratelimit"
 	"crynux_bridge/api/v1/inference_tasks"
 	"crynux_bridge/api/v1/llm/structs"
@@ -30,6 +31,7 @@ type ChatCompletionsRequest struct {
 	structs.ChatCompletionsRequest
 	Authorization string `header:"Authorization" validate:"required" description:"API key"`
 	Timeout       *uint64 `json:"timeout,omitempty" description:"Task timeout" validate:"omitempty"`
+	IdempotencyKey string `header:"Idempotency-Key" json:"-" description:"Retries of the request with the same key return the response of the first request" validate:"omitempty"`
 }
 
 // build TaskInput from ChatCompletionsRequest, create task, wait for task to finish, get task result, then return ChatCompletionsResponse
@@ -105,7 +107,6 @@ func ChatCompletions(c *gin.Context, in *ChatCompletionsRequest) (*structs.ChatC
 
 	taskType := models.TaskTypeLLM
 	minVram := uint64(24)
-	taskFee := uint64(6000000000)
 
 	task := &inference_tasks.TaskInput{
 		ClientID:        apiKey.ClientID,
@@ -116,17 +117,32 @@ func ChatCompletions(c *gin.Context, in *ChatCompletionsRequest) (*structs.ChatC
 		RequiredGPU:     "",
 		RequiredGPUVram: 0,
 		RepeatNum:       nil,
-		TaskFee:         &taskFee,
 		Timeout:         in.Timeout,
 	}
 
-	/* 2. Create task, wait until task finish and get task result. Implemented by function ProcessGPTTask */
-	gptTaskResponse, resultDownloadedTask, err := inference_tasks.ProcessGPTTask(ctx, db, task)
+	/* 2. Create task, wait until task finish and get task result. Retries with the same Idempotency-Key share the task */
+	ccResponse, err := inference_tasks.RunIdempotent(c, apiKey.ID, task, 3*time.Minute, func(ctx context.Context, clientTask *models.ClientTask) (*structs.ChatCompletionsResponse, error) {
+		gptTaskResponse, resultDownloadedTask, err := inference_tasks.WaitGPTTask(ctx, db, clientTask)
+		if err != nil {
+			return nil, err
+		}
+
+		/* 3. Wrap GPTTaskResponse into ChatCompletionsResponse and return */
+		return newChatCompletionsResponse(gptTaskResponse, resultDownloadedTask), nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	/* 3. Wrap GPTTaskResponse into ChatCompletionsResponse and return */
+	if err := apiKey.Use(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return ccResponse, nil
+}
+
+// newChatCompletionsResponse wraps GPTTaskResponse into ChatCompletionsResponse
+func newChatCompletionsResponse(gptTaskResponse *models.GPTTaskResponse, resultDownloadedTask *models.InferenceTask) *structs.ChatCompletionsResponse {
 	choices := make([]structs.CCResChoice, len(gptTaskResponse.Choices))
 	for i, choice := range gptTaskResponse.Choices {
 
@@ -180,7 +196,7 @@ func ChatCompletions(c *gin.Context, in *ChatCompletionsRequest) (*structs.ChatC
 
 		choices[i] = utils.ResponseChoiceToCCResChoice(choice)
 	}
-	ccResponse := &structs.ChatCompletionsResponse{
+	return &structs.ChatCompletionsResponse{
 		Id:      resultDownloadedTask.TaskIDCommitment,
 		Created: resultDownloadedTask.CreatedAt.Unix(),
 		Model:   gptTaskResponse.Model,
@@ -189,10 +205,4 @@ func ChatCompletions(c *gin.Context, in *ChatCompletionsRequest) (*structs.ChatC
 		// Object:  "text",
 		// ServiceTier: "",
 	}
-
-	if err := apiKey.Use(ctx, db); err != nil {
-		return nil, response.NewExceptionResponse(err)
-	}
-
-	return ccResponse, nil
 }
@@ -1,6 +1,7 @@
 package llm
 
 import (
+	"context"
 	"crynux_bridge/api/ratelimit"
 	"crynux_bridge/api/v1/inference_tasks"
 	"crynux_bridge/api/v1/llm/structs"
@@ -22,6 +23,7 @@ type CompletionsRequest struct {
 	structs.CompletionsRequest
 	Authorization string `header:"Authorization" validate:"required" description:"API key"`
 	Timeout       *uint64 `json:"timeout,omitempty" description:"Task timeout" validate:"omitempty"`
+	IdempotencyKey string `header:"Idempotency-Key" json:"-" description:"Retries of the request with the same key return the response of the first request" validate:"omitempty"`
 }
 
 // build TaskInput from CompletionsRequest, create task, wait for task to finish, get task result, then return CompletionsResponse
@@ -98,7 +100,6 @@ func Completions(c *gin.Context, in *CompletionsRequest) (*structs.CompletionsRe
 
 	taskType := models.TaskTypeLLM
 	minVram := uint64(24)
-	taskFee := uint64(6000000000)
 
 	task := &inference_tasks.TaskInput{
 		ClientID:        apiKey.ClientID,
@@ -109,26 +110,45 @@ func Completions(c *gin.Context, in *CompletionsRequest) (*structs.CompletionsRe
 		RequiredGPU:     "",
 		RequiredGPUVram: 0,
 		RepeatNum:       nil,
-		TaskFee:         &taskFee,
 		Timeout:         in.Timeout,
 	}
 
-	/* 2. Create task, wait until task finish and get task result. Implemented by function ProcessGPTTask */
-	gptTaskResponse, resultDownloadedTask, err := inference_tasks.ProcessGPTTask(ctx, db, task)
+	/* 2. Create task, wait until task finish and get task result. Retries with the same Idempotency-Key share the task */
+	ccResponse, err := inference_tasks.RunIdempotent(c, apiKey.ID, task, 3*time.Minute, func(ctx context.Context, clientTask *models.ClientTask) (*structs.CompletionsResponse, error) {
+		gptTaskResponse, resultDownloadedTask, err := inference_tasks.WaitGPTTask(ctx, db, clientTask)
+		if err != nil {
+			return nil, err
+		}
+
+		/* 3. Wrap GPTTaskResponse into CompletionsResponse and return */
+		ccResponse, err := newCompletionsResponse(gptTaskResponse, resultDownloadedTask)
+		if err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		return ccResponse, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	/* 3. Wrap GPTTaskResponse into CompletionsResponse and return */
+	if err := apiKey.Use(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return ccResponse, nil
+}
+
+// newCompletionsResponse wraps GPTTaskResponse into CompletionsResponse
+func newCompletionsResponse(gptTaskResponse *models.GPTTaskResponse, resultDownloadedTask *models.InferenceTask) (*structs.CompletionsResponse, error) {
 	choices := make([]structs.CResChoice, len(gptTaskResponse.Choices))
 	for i, c := range gptTaskResponse.Choices {
 		choice, err := utils.ResponseChoiceToCResChoice(c)
 		if err != nil {
-			return nil, response.NewExceptionResponse(err)
+			return nil, err
 		}
 		choices[i] = choice
 	}
-	ccResponse := &structs.CompletionsResponse{
+	return &structs.CompletionsResponse{
 		Id:      resultDownloadedTask.TaskIDCommitment,
 		Created: resultDownloadedTask.CreatedAt.Unix(),
 		Model:   gptTaskResponse.Model,
@@ -136,11 +156,5 @@ func Completions(c *gin.Context, in *CompletionsRequest) (*structs.CompletionsRe
 		Usage:   utils.UsageToCResUsage(gptTaskResponse.Usage),
 		// Object:  "text",
 		// SystemFingerprint: resultDownloadedTask.SystemFingerprint,
-	}
-
-	if err := apiKey.Use(ctx, db); err != nil {
-		return nil, response.NewExceptionResponse(err)
-	}
-
-	return ccResponse, nil
+	}, nil
 }
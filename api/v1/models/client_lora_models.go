@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/storage"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// getClientLoraModel returns the lora model if it is owned by the client of the api key
+func getClientLoraModel(ctx context.Context, db *gorm.DB, authorization string, id uint) (*models.LoraModel, error) {
+	apiKey, err := tools.ValidateAuthorization(ctx, db, authorization)
+	if err != nil {
+		return nil, err
+	}
+	client, err := tools.GetClient(ctx, db, apiKey.ClientID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	loraModel, err := models.GetLoraModelByID(ctx, db, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("id", "Lora model not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	if loraModel.ClientID != client.ID {
+		return nil, response.NewValidationErrorResponse("id", "Lora model not found")
+	}
+	return loraModel, nil
+}
+
+// getLoraModelTask returns the final task of the finetune task of the lora model, whose results
+// are the lora weights and the validation images. It returns nil if the task is not found.
+func getLoraModelTask(ctx context.Context, db *gorm.DB, loraModel *models.LoraModel) (*models.InferenceTask, error) {
+	return models.GetSDFTTaskFinalTask(ctx, db, loraModel.ClientTaskID)
+}
+
+type UpdateLoraModelInput struct {
+	ID            uint    `path:"id" json:"id" description:"Lora model id" validate:"required"`
+	Authorization string  `header:"Authorization" validate:"required" description:"API key"`
+	Name          *string `json:"name,omitempty" description:"New name of the lora model" validate:"omitempty,min=1,max=255"`
+	Description   *string `json:"description,omitempty" description:"New description of the lora model" validate:"omitempty"`
+}
+
+type LoraModelOutput struct {
+	response.Response
+	Data *models.LoraModel `json:"data"`
+}
+
+func UpdateLoraModel(c *gin.Context, in *UpdateLoraModelInput) (*LoraModelOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	loraModel, err := getClientLoraModel(ctx, db, in.Authorization, in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	newLoraModel := make(map[string]interface{})
+	if in.Name != nil {
+		newLoraModel["name"] = *in.Name
+	}
+	if in.Description != nil {
+		newLoraModel["description"] = *in.Description
+	}
+	if len(newLoraModel) > 0 {
+		if err := db.WithContext(ctx).Model(loraModel).Updates(newLoraModel).Error; err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		if in.Name != nil {
+			loraModel.Name = *in.Name
+		}
+		if in.Description != nil {
+			loraModel.Description = *in.Description
+		}
+	}
+
+	return &LoraModelOutput{Data: loraModel}, nil
+}
+
+type DeleteLoraModelInput struct {
+	ID            uint   `path:"id" json:"id" description:"Lora model id" validate:"required"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+func DeleteLoraModel(c *gin.Context, in *DeleteLoraModelInput) (*response.Response, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	loraModel, err := getClientLoraModel(ctx, db, in.Authorization, in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := getLoraModelTask(ctx, db, loraModel)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	if err := loraModel.Delete(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	// remove the validation images of the model, the result of the finetune task is kept
+	// because it can still be downloaded from the task
+	if task != nil && !task.ResultExpired() {
+		if err := storage.GetResultStore().DeleteDir(ctx, storage.TaskKey(task.TaskIDCommitment, "validation")); err != nil {
+			log.Errorf("DeleteLoraModel: cannot remove validation images of lora model %d: %v", loraModel.ID, err)
+		}
+	}
+
+	return &response.Response{}, nil
+}
+
+type GetLoraModelImageInput struct {
+	ID            uint   `path:"id" json:"id" description:"Lora model id" validate:"required"`
+	Index         *int   `path:"index" description:"Validation image index" validate:"required"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+func GetLoraModelImage(c *gin.Context, in *GetLoraModelImageInput) error {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	loraModel, err := getClientLoraModel(ctx, db, in.Authorization, in.ID)
+	if err != nil {
+		return err
+	}
+	if *in.Index < 0 || *in.Index >= len(loraModel.ValidationImages) {
+		return response.NewValidationErrorResponse("index", "Image not found")
+	}
+
+	task, err := getLoraModelTask(ctx, db, loraModel)
+	if err != nil {
+		return response.NewExceptionResponse(err)
+	}
+	filename := loraModel.ValidationImages[*in.Index]
+	if task == nil || filename == "" {
+		return response.NewValidationErrorResponse("index", "Image not found")
+	}
+	if task.ResultExpired() {
+		return response.NewValidationErrorResponse("index", "Image expired")
+	}
+	imageFile, size, err := storage.GetResultStore().Get(ctx, storage.TaskKey(task.TaskIDCommitment, path.Join("validation", filename)))
+	if errors.Is(err, storage.ErrNotFound) {
+		return response.NewValidationErrorResponse("index", "Image not found")
+	} else if err != nil {
+		return response.NewExceptionResponse(err)
+	}
+	defer imageFile.Close()
+
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.DataFromReader(http.StatusOK, size, "application/octet-stream", imageFile, nil)
+
+	return nil
+}
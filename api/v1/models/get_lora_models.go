@@ -2,6 +2,7 @@ package models
 
 import (
 	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
 
@@ -9,7 +10,8 @@ import (
 )
 
 type GetLoraModelsInput struct {
-	Type models.ModelType `json:"type" query:"type"`
+	Type          models.ModelType `json:"type" query:"type"`
+	Authorization string           `header:"Authorization" validate:"omitempty" description:"API key. The lora models of the client are listed together with the public ones if provided"`
 }
 
 type GetLoraModelsOutput struct {
@@ -17,7 +19,9 @@ type GetLoraModelsOutput struct {
 	Data []models.LoraModel `json:"data"`
 }
 
-func GetLoraModels(_ *gin.Context, in *GetLoraModelsInput) (*GetLoraModelsOutput, error) {
+func GetLoraModels(c *gin.Context, in *GetLoraModelsInput) (*GetLoraModelsOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
 
 	var loraModels []models.LoraModel
 
@@ -25,9 +29,28 @@ func GetLoraModels(_ *gin.Context, in *GetLoraModelsInput) (*GetLoraModelsOutput
 		return nil, response.NewValidationErrorResponse("type", "Invalid model type")
 	}
 
-	if err := config.GetDB().Where(&models.LoraModel{
+	var clientID uint
+	if in.Authorization != "" {
+		apiKey, err := tools.ValidateAuthorization(ctx, db, in.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		client, err := tools.GetClient(ctx, db, apiKey.ClientID)
+		if err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		clientID = client.ID
+	}
+
+	query := db.WithContext(ctx).Where(&models.LoraModel{
 		Type: in.Type,
-	}).Find(&loraModels).Error; err != nil {
+	})
+	if clientID > 0 {
+		query = query.Where("client_id = 0 OR client_id = ?", clientID)
+	} else {
+		query = query.Where("client_id = 0")
+	}
+	if err := query.Find(&loraModels).Error; err != nil {
 		return nil, response.NewExceptionResponse(err)
 	}
 
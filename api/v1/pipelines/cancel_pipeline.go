@@ -0,0 +1,31 @@
+package pipelines
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/tasks"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CancelPipeline(c *gin.Context, in *PipelineInput) (*PipelineResponse, error) {
+	ctx := c.Request.Context()
+
+	pipeline, err := getClientPipeline(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := tasks.CancelPipeline(ctx, pipeline); err != nil {
+		if errors.Is(err, models.ErrPipelineNotRunning) {
+			return nil, response.NewValidationErrorResponse("id", "Pipeline has finished")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	if err := pipeline.Sync(ctx, config.GetDB()); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &PipelineResponse{Data: newPipelineOutput(pipeline)}, nil
+}
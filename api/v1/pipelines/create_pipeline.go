@@ -0,0 +1,110 @@
+package pipelines
+
+import (
+	"crynux_bridge/api/ratelimit"
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PipelineStepInput struct {
+	Name            string                  `json:"name" description:"Step name, referred to by the templates of other steps as {{steps.<name>.<field>}}" validate:"required"`
+	Type            models.PipelineStepType `json:"type" description:"Step type: llm, image or finetune" validate:"required,oneof=llm image finetune"`
+	TaskArgs        string                  `json:"task_args" description:"Task args, may contain the templates of the outputs of other steps inside json strings" validate:"required"`
+	DependsOn       []string                `json:"depends_on,omitempty" description:"Steps to wait for besides the ones referred to by the templates" validate:"omitempty"`
+	TaskVersion     *string                 `json:"task_version,omitempty" description:"Task version" validate:"omitempty"`
+	MinVram         *uint64                 `json:"min_vram,omitempty" description:"Task minimal vram requirement" validate:"omitempty"`
+	RequiredGPU     string                  `json:"required_gpu,omitempty" description:"Task required GPU name" validate:"omitempty"`
+	RequiredGPUVram uint64                  `json:"required_gpu_vram,omitempty" description:"Task required GPU Vram" validate:"omitempty"`
+	RepeatNum       *int                    `json:"repeat_num,omitempty" description:"Task repeat number" validate:"omitempty"`
+	TaskFee         *uint64                 `json:"task_fee,omitempty" description:"Task fee" validate:"omitempty"`
+	Timeout         *uint64                 `json:"timeout,omitempty" description:"Task timeout" validate:"omitempty"`
+}
+
+type CreatePipelineInput struct {
+	Steps         []PipelineStepInput `json:"steps" description:"Steps of the pipeline" validate:"required,min=1,dive"`
+	MaxTotalFee   *uint64             `json:"max_total_fee,omitempty" description:"Max total task fee of all the steps in GWei" validate:"omitempty"`
+	Authorization string              `header:"Authorization" validate:"required" description:"API key"`
+}
+
+func CreatePipeline(c *gin.Context, in *CreatePipelineInput) (*PipelineResponse, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	apiKey, err := tools.ValidateAuthorization(ctx, db, in.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	allowed, waitTime, err := ratelimit.APIRateLimiter.CheckRateLimit(ctx, apiKey.ClientID, apiKey.RateLimit, time.Minute)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	if !allowed {
+		return nil, response.NewValidationErrorResponse("rate_limit", fmt.Sprintf("rate limit exceeded, please wait %.2f seconds", waitTime))
+	}
+
+	steps := make([]models.PipelineStep, len(in.Steps))
+	for i, stepInput := range in.Steps {
+		settings, err := json.Marshal(models.PipelineStepSettings{
+			TaskVersion:     stepInput.TaskVersion,
+			MinVram:         stepInput.MinVram,
+			RequiredGPU:     stepInput.RequiredGPU,
+			RequiredGPUVram: stepInput.RequiredGPUVram,
+			RepeatNum:       stepInput.RepeatNum,
+			TaskFee:         stepInput.TaskFee,
+			Timeout:         stepInput.Timeout,
+		})
+		if err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		steps[i] = models.PipelineStep{
+			Name:      stepInput.Name,
+			Type:      stepInput.Type,
+			TaskArgs:  stepInput.TaskArgs,
+			DependsOn: stepInput.DependsOn,
+			Settings:  string(settings),
+		}
+	}
+	if err := models.ValidatePipelineSteps(steps); err != nil {
+		return nil, response.NewValidationErrorResponse("steps", err.Error())
+	}
+	// the task args with templates are validated once they are rendered
+	for _, step := range steps {
+		if len(models.TemplateSteps(step.TaskArgs)) > 0 {
+			continue
+		}
+		result, err := models.ValidateTaskArgsJsonStr(step.TaskArgs, step.Type.TaskType())
+		if err != nil {
+			return nil, response.NewExceptionResponse(err)
+		}
+		if result != nil {
+			return nil, response.NewValidationErrorResponse("steps", fmt.Sprintf("step %s: %s", step.Name, result.Error()))
+		}
+	}
+
+	client, err := tools.CreateClientIfNotExist(ctx, db, apiKey.ClientID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	pipeline := &models.Pipeline{
+		ClientID: client.ID,
+		Steps:    steps,
+	}
+	if in.MaxTotalFee != nil {
+		pipeline.MaxTotalFee = *in.MaxTotalFee
+	}
+	if err := pipeline.Save(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	if err := apiKey.Use(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+
+	return &PipelineResponse{Data: newPipelineOutput(pipeline)}, nil
+}
@@ -0,0 +1,81 @@
+package pipelines
+
+import (
+	"context"
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PipelineStepOutput struct {
+	models.PipelineStep
+	DependsOn []string        `json:"depends_on"`
+	Output    json.RawMessage `json:"output,omitempty"`
+}
+
+type PipelineOutput struct {
+	models.Pipeline
+	Steps []PipelineStepOutput `json:"steps"`
+}
+
+type PipelineResponse struct {
+	response.Response
+	Data *PipelineOutput `json:"data"`
+}
+
+func newPipelineOutput(pipeline *models.Pipeline) *PipelineOutput {
+	output := &PipelineOutput{
+		Pipeline: *pipeline,
+		Steps:    make([]PipelineStepOutput, len(pipeline.Steps)),
+	}
+	for i, step := range pipeline.Steps {
+		output.Steps[i] = PipelineStepOutput{
+			PipelineStep: step,
+			DependsOn:    step.Dependencies(),
+		}
+		if len(step.Output) > 0 {
+			output.Steps[i].Output = json.RawMessage(step.Output)
+		}
+	}
+	return output
+}
+
+type PipelineInput struct {
+	ID            uint   `path:"id" json:"id" description:"Pipeline id" validate:"required"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+// getClientPipeline returns the pipeline if it belongs to the client of the api key
+func getClientPipeline(ctx context.Context, in *PipelineInput) (*models.Pipeline, error) {
+	db := config.GetDB()
+
+	apiKey, err := tools.ValidateAuthorization(ctx, db, in.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := models.GetPipelineByID(ctx, db, in.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("id", "Pipeline not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	if pipeline.Client.ClientId != apiKey.ClientID {
+		return nil, response.NewValidationErrorResponse("api_key", "invalid api key")
+	}
+	return pipeline, nil
+}
+
+func GetPipeline(c *gin.Context, in *PipelineInput) (*PipelineResponse, error) {
+	pipeline, err := getClientPipeline(c.Request.Context(), in)
+	if err != nil {
+		return nil, err
+	}
+	return &PipelineResponse{Data: newPipelineOutput(pipeline)}, nil
+}
@@ -3,13 +3,17 @@ package v1
 import (
 	apikey "crynux_bridge/api/v1/api_key"
 	"crynux_bridge/api/v1/application"
+	"crynux_bridge/api/v1/auto_tasks"
 	"crynux_bridge/api/v1/count"
 	"crynux_bridge/api/v1/image"
 	"crynux_bridge/api/v1/inference_tasks"
 	"crynux_bridge/api/v1/llm"
 	"crynux_bridge/api/v1/models"
 	"crynux_bridge/api/v1/network"
+	"crynux_bridge/api/v1/pipelines"
 	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/transactions"
+	"crynux_bridge/api/v1/webhooks"
 
 	"github.com/loopfz/gadgeto/tonic"
 	"github.com/wI2L/fizz"
@@ -33,12 +37,42 @@ func InitRoutes(r *fizz.Fizz) {
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(inference_tasks.GetTaskById, 200))
 
+	tasksGroup.DELETE("/:client_id/:client_task_id", []fizz.OperationOption{
+		fizz.Summary("Cancel a task and all its validation tasks"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(inference_tasks.CancelTask, 200))
+
+	tasksGroup.GET("/:client_id/:client_task_id/timeline", []fizz.OperationOption{
+		fizz.Summary("Get the status timeline of the tasks"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(inference_tasks.GetTaskTimeline, 200))
+
+	tasksGroup.GET("/:client_id/:client_task_id/fees", []fizz.OperationOption{
+		fizz.Summary("Get how the task fees of the tasks were chosen"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(inference_tasks.GetTaskFees, 200))
+
+	tasksGroup.GET("/:client_id/:client_task_id/attempts", []fizz.OperationOption{
+		fizz.Summary("Get the failed attempts of the task and how they were retried"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(inference_tasks.GetTaskAttempts, 200))
+
 	tasksGroup.GET("/:client_id/:client_task_id/images/:index", []fizz.OperationOption{
 		fizz.Summary("Get task details by task id"),
 		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(inference_tasks.GetTaskImage, 200))
 
+	tasksGroup.GET("/:client_id/:client_task_id/events", []fizz.OperationOption{
+		fizz.Summary("Stream the status updates of a task as server-sent events"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(inference_tasks.GetTaskEvents, 200))
+
 	modelsGroup := v1g.Group("models", "Models", "Models related APIs")
 
 	modelsGroup.GET("base", []fizz.OperationOption{
@@ -51,6 +85,24 @@ func InitRoutes(r *fizz.Fizz) {
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(models.GetLoraModels, 200))
 
+	modelsGroup.PATCH("lora/:id", []fizz.OperationOption{
+		fizz.Summary("Rename or describe a lora model of the client"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(models.UpdateLoraModel, 200))
+
+	modelsGroup.DELETE("lora/:id", []fizz.OperationOption{
+		fizz.Summary("Delete a lora model of the client"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(models.DeleteLoraModel, 200))
+
+	modelsGroup.GET("lora/:id/images/:index", []fizz.OperationOption{
+		fizz.Summary("Get a validation image of a lora model of the client"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(models.GetLoraModelImage, 200))
+
 	networkGroup := v1g.Group("network", "Network", "Network status")
 	networkGroup.GET("nodes", []fizz.OperationOption{}, tonic.Handler(network.GetNodeStats, 200))
 
@@ -65,6 +117,42 @@ func InitRoutes(r *fizz.Fizz) {
 		fizz.Summary("Count the task in the recent period"),
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(count.CountTask, 200))
+	countGroup.GET("/latency", []fizz.OperationOption{
+		fizz.Summary("Break down the latency of the successful tasks in the recent period"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(count.CountLatency, 200))
+
+	autoTasksGroup := v1g.Group("auto_tasks", "Auto tasks", "Auto tasks benchmarking the network")
+	autoTasksGroup.GET("/benchmark", []fizz.OperationOption{
+		fizz.Summary("Report the outcomes of the auto tasks in the recent period by their templates and gpus"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(auto_tasks.GetBenchmark, 200))
+
+	autoTasksGroup.GET("/state", []fizz.OperationOption{
+		fizz.Summary("Get the settings in effect and the state of the auto task generator"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(auto_tasks.GetState, 200))
+
+	autoTasksGroup.POST("/pause", []fizz.OperationOption{
+		fizz.Summary("Pause the auto task generator"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(auto_tasks.Pause, 200))
+
+	autoTasksGroup.POST("/resume", []fizz.OperationOption{
+		fizz.Summary("Resume the auto task generator"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(auto_tasks.Resume, 200))
+
+	autoTasksGroup.POST("/settings", []fizz.OperationOption{
+		fizz.Summary("Change the batch size, limits and ratios of the auto task generator at runtime"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(auto_tasks.ChangeSettings, 200))
 
 	// for openrouter, api: /completions and /chat/completions
 	openrouterGroup := v1g.Group("openrouter", "OpenRouter", "OpenRouter related APIs")
@@ -115,16 +203,31 @@ func InitRoutes(r *fizz.Fizz) {
 		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(image.CreateSDFinetuneLoraTask, 200))
+	imagesGroup.POST("/models/estimate", []fizz.OperationOption{
+		fizz.Summary("Estimate the time and the total fee of a finetuning image lora model task"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(image.EstimateSDFinetuneLoraTask, 200))
 	imagesGroup.GET("/models/:id/status", []fizz.OperationOption{
 		fizz.Summary("Get the status of a finetuning image lora model task"),
 		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(image.GetSDFinetuneLoraTaskStatus, 200))
+	imagesGroup.DELETE("/models/:id", []fizz.OperationOption{
+		fizz.Summary("Cancel a finetuning image lora model task"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(image.CancelSDFinetuneLoraTask, 200))
 	imagesGroup.GET("/models/:id/result", []fizz.OperationOption{
 		fizz.Summary("Get the result of a finetuning image lora model task"),
 		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(image.DownloadSDFinetuneLoraTaskResult, 200))
+	imagesGroup.GET("/models/:id/attempts", []fizz.OperationOption{
+		fizz.Summary("Get the attempts and retry decisions of a finetuning image lora model task"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(image.GetSDFinetuneLoraTaskAttempts, 200))
 
 	apiKeyGroup := v1g.Group("api_key", "API Key", "API Key related APIs")
 	apiKeyGroup.POST("", []fizz.OperationOption{
@@ -152,4 +255,80 @@ func InitRoutes(r *fizz.Fizz) {
 		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
 		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
 	}, tonic.Handler(apikey.ChangeRateLimit, 200))
+	apiKeyGroup.POST("/:api_key/priority", []fizz.OperationOption{
+		fizz.Summary("Change the priority class of the tasks of an API key"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(apikey.ChangePriority, 200))
+	apiKeyGroup.POST("/:api_key/max_task_fee", []fizz.OperationOption{
+		fizz.Summary("Change the max task fee of the tasks of an API key"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(apikey.ChangeMaxTaskFee, 200))
+	apiKeyGroup.POST("/:api_key/verification_replicas", []fizz.OperationOption{
+		fizz.Summary("Change the verification replicas of the tasks of an API key"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(apikey.ChangeVerificationReplicas, 200))
+
+	pipelinesGroup := v1g.Group("pipelines", "Pipelines", "Jobs of several tasks using the outputs of each other")
+	pipelinesGroup.POST("", []fizz.OperationOption{
+		fizz.Summary("Create a pipeline"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(pipelines.CreatePipeline, 200))
+	pipelinesGroup.GET("/:id", []fizz.OperationOption{
+		fizz.Summary("Get the status and the step outputs of a pipeline"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(pipelines.GetPipeline, 200))
+	pipelinesGroup.DELETE("/:id", []fizz.OperationOption{
+		fizz.Summary("Cancel a pipeline and the tasks of its running steps"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(pipelines.CancelPipeline, 200))
+
+	webhooksGroup := v1g.Group("webhooks", "Webhooks", "Webhooks receiving the task events")
+	webhooksGroup.POST("", []fizz.OperationOption{
+		fizz.Summary("Register a webhook for the task events of the client"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(webhooks.CreateWebhook, 200))
+	webhooksGroup.GET("", []fizz.OperationOption{
+		fizz.Summary("Get the webhooks of the client"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(webhooks.GetWebhooks, 200))
+	webhooksGroup.DELETE("/:id", []fizz.OperationOption{
+		fizz.Summary("Delete a webhook of the client"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(webhooks.DeleteWebhook, 200))
+	webhooksGroup.GET("/:id/deliveries", []fizz.OperationOption{
+		fizz.Summary("Get the delivery log of a webhook"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(webhooks.GetWebhookDeliveries, 200))
+	webhooksGroup.POST("/:id/redeliver", []fizz.OperationOption{
+		fizz.Summary("Redeliver all the dead-lettered deliveries of a webhook"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(webhooks.RedeliverDeadWebhookDeliveries, 200))
+	webhooksGroup.POST("/:id/deliveries/:delivery_id/redeliver", []fizz.OperationOption{
+		fizz.Summary("Redeliver a delivery of a webhook"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(webhooks.RedeliverWebhookDelivery, 200))
+
+	transactionsGroup := v1g.Group("transactions", "Transactions", "Blockchain transactions sent by the bridge")
+	transactionsGroup.GET("", []fizz.OperationOption{
+		fizz.Summary("Get the transactions sent by the bridge and their states"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(transactions.GetTransactions, 200))
+	transactionsGroup.GET("/:tx_hash", []fizz.OperationOption{
+		fizz.Summary("Get a transaction by its hash or the hash of any of its replacements"),
+		fizz.Response("400", "validation errors", response.ValidationErrorResponse{}, nil, nil),
+		fizz.Response("500", "exception", response.ExceptionResponse{}, nil, nil),
+	}, tonic.Handler(transactions.GetTransaction, 200))
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crynux_bridge/api/ratelimit"
 	"crynux_bridge/api/v1/response"
+	"crynux_bridge/config"
 	"crynux_bridge/models"
 	"crypto/rand"
 	"encoding/base64"
@@ -124,6 +125,47 @@ func ChangeRateLimit(ctx context.Context, db *gorm.DB, apiKey *models.ClientAPIK
 	return ratelimit.APIRateLimiter.UpdateRateLimit(ctx, apiKey.ClientID, rateLimit, time.Minute)
 }
 
+func ChangePriority(ctx context.Context, db *gorm.DB, apiKey *models.ClientAPIKey, priority models.Priority) error {
+	return apiKey.Update(ctx, db, &models.ClientAPIKey{
+		Priority: priority,
+	})
+}
+
+// ChangeMaxTaskFee sets the max task fee of the tasks of the api key, 0 falls back to the fee policy
+func ChangeMaxTaskFee(ctx context.Context, db *gorm.DB, apiKey *models.ClientAPIKey, maxTaskFee uint64) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(apiKey).Update("max_task_fee", maxTaskFee).Error
+}
+
+// ValidateAdminAuthorization validates the api key of the authorization header, which should have the admin role
+func ValidateAdminAuthorization(ctx context.Context, db *gorm.DB, authorization string) (*models.ClientAPIKey, error) {
+	apiKey, err := ValidateAuthorization(ctx, db, authorization)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(apiKey.Roles, models.RoleAdmin) {
+		return nil, response.NewValidationErrorResponse("Authorization", "unauthorized")
+	}
+	return apiKey, nil
+}
+
+// MaxVerificationReplicas returns the max verification replicas of a task
+func MaxVerificationReplicas() int {
+	maxReplicas := config.GetConfig().ResultVerification.MaxReplicas
+	if maxReplicas <= 0 {
+		maxReplicas = 3
+	}
+	return maxReplicas
+}
+
+// ChangeVerificationReplicas sets the verification replicas of the tasks of the api key
+func ChangeVerificationReplicas(ctx context.Context, db *gorm.DB, apiKey *models.ClientAPIKey, verificationReplicas int) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(apiKey).Update("verification_replicas", verificationReplicas).Error
+}
+
 // validate api key
 func ValidateAuthorization(ctx context.Context, db *gorm.DB, authorization string) (*models.ClientAPIKey, error) {
 	if !strings.HasPrefix(authorization, "Bearer ") {
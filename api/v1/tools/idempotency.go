@@ -0,0 +1,52 @@
+package tools
+
+import (
+	"bytes"
+	"crynux_bridge/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const requestHashKey = "request_hash"
+
+// HashIdempotentRequest hashes the method, the path and the body of the requests with an Idempotency-Key
+// header before the body is consumed by the handlers, so that a key reused for a different request can be rejected
+func HashIdempotentRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(c.GetHeader(IdempotencyKeyHeader)) > 0 && c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			h := sha256.New()
+			h.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+			h.Write(body)
+			c.Set(requestHashKey, hex.EncodeToString(h.Sum(nil)))
+		}
+		c.Next()
+	}
+}
+
+// GetRequestHash returns the hash of the request computed by HashIdempotentRequest
+func GetRequestHash(c *gin.Context) string {
+	return c.GetString(requestHashKey)
+}
+
+// IdempotencyKeyTTL returns how long an idempotency key is kept
+func IdempotencyKeyTTL() time.Duration {
+	ttl := config.GetConfig().Idempotency.TTL
+	if ttl == 0 {
+		ttl = 24
+	}
+	return time.Duration(ttl) * time.Hour
+}
@@ -45,10 +45,13 @@ func CreateClientIfNotExist(ctx context.Context, db *gorm.DB, clientID string) (
 	return client, err
 }
 
-// create ClientTask for the given Client
-func CreateClientTask(ctx context.Context, db *gorm.DB, client *models.Client) (*models.ClientTask, error) {
+// create ClientTask for the given Client, with the settings (retry policy, max total fee) in clientTask
+func CreateClientTask(ctx context.Context, db *gorm.DB, client *models.Client, settings *models.ClientTask) (*models.ClientTask, error) {
 	clientTask := models.ClientTask{
-		Client: *client,
+		Client:               *client,
+		RetryPolicy:          settings.RetryPolicy,
+		MaxTotalFee:          settings.MaxTotalFee,
+		VerificationReplicas: settings.VerificationReplicas,
 	}
 	err := func() error {
 		dbCtx, cancel := context.WithTimeout(ctx, time.Second)
@@ -0,0 +1,83 @@
+package transactions
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type GetTransactionsInput struct {
+	Authorization string `header:"Authorization" validate:"required" description:"API key with the admin role"`
+	Status        string `query:"status" json:"status" description:"Filter by transaction status: pending, success, reverted or dropped" validate:"omitempty,oneof=pending success reverted dropped"`
+	Page          int    `query:"page" json:"page" description:"Page number, starts from 1" validate:"omitempty,min=1"`
+	PageSize      int    `query:"page_size" json:"page_size" description:"Page size, 20 by default" validate:"omitempty,min=1,max=100"`
+}
+
+type Transactions struct {
+	Total        int64                          `json:"total"`
+	Transactions []models.BlockchainTransaction `json:"transactions"`
+}
+
+type GetTransactionsOutput struct {
+	response.Response
+	Data *Transactions `json:"data"`
+}
+
+// GetTransactions returns the transactions sent by the bridge from the latest
+func GetTransactions(c *gin.Context, in *GetTransactionsInput) (*GetTransactionsOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	if _, err := tools.ValidateAdminAuthorization(ctx, db, in.Authorization); err != nil {
+		return nil, err
+	}
+
+	page, pageSize := in.Page, in.PageSize
+	if page == 0 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = 20
+	}
+	txs, total, err := models.GetBlockchainTransactions(ctx, db, models.BlockchainTransactionStatus(in.Status), (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &GetTransactionsOutput{
+		Data: &Transactions{Total: total, Transactions: txs},
+	}, nil
+}
+
+type GetTransactionInput struct {
+	Authorization string `header:"Authorization" validate:"required" description:"API key with the admin role"`
+	TxHash        string `path:"tx_hash" json:"tx_hash" description:"Hash of the transaction or of any of its replacements" validate:"required"`
+}
+
+type GetTransactionOutput struct {
+	response.Response
+	Data *models.BlockchainTransaction `json:"data"`
+}
+
+// GetTransaction returns the transaction of the hash, which can be the one replaced when stuck
+func GetTransaction(c *gin.Context, in *GetTransactionInput) (*GetTransactionOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	if _, err := tools.ValidateAdminAuthorization(ctx, db, in.Authorization); err != nil {
+		return nil, err
+	}
+
+	tx, err := models.GetBlockchainTransactionByHash(ctx, db, in.TxHash)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("tx_hash", "Transaction not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &GetTransactionOutput{Data: tx}, nil
+}
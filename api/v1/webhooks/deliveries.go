@@ -0,0 +1,109 @@
+package webhooks
+
+import (
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type GetWebhookDeliveriesInput struct {
+	WebhookInput
+	Status   string `query:"status" json:"status" description:"Filter by delivery status: pending, delivered or dead" validate:"omitempty,oneof=pending delivered dead"`
+	Page     int    `query:"page" json:"page" description:"Page number, starts from 1" validate:"omitempty,min=1"`
+	PageSize int    `query:"page_size" json:"page_size" description:"Page size, 20 by default" validate:"omitempty,min=1,max=100"`
+}
+
+type WebhookDeliveries struct {
+	Total      int64                    `json:"total"`
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+type GetWebhookDeliveriesOutput struct {
+	response.Response
+	Data *WebhookDeliveries `json:"data"`
+}
+
+func GetWebhookDeliveries(c *gin.Context, in *GetWebhookDeliveriesInput) (*GetWebhookDeliveriesOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	webhook, err := getClientWebhook(ctx, db, in.Authorization, in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	page, pageSize := in.Page, in.PageSize
+	if page == 0 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = 20
+	}
+	deliveries, total, err := models.GetWebhookDeliveries(ctx, db, webhook.ID, models.WebhookDeliveryStatus(in.Status), (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &GetWebhookDeliveriesOutput{
+		Data: &WebhookDeliveries{Total: total, Deliveries: deliveries},
+	}, nil
+}
+
+type RedeliverWebhookDeliveryInput struct {
+	WebhookInput
+	DeliveryID uint `path:"delivery_id" json:"delivery_id" description:"Delivery id" validate:"required"`
+}
+
+type WebhookDeliveryOutput struct {
+	response.Response
+	Data *models.WebhookDelivery `json:"data"`
+}
+
+// RedeliverWebhookDelivery sends a delivery again, whether it is delivered or dead
+func RedeliverWebhookDelivery(c *gin.Context, in *RedeliverWebhookDeliveryInput) (*WebhookDeliveryOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	webhook, err := getClientWebhook(ctx, db, in.Authorization, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := models.GetWebhookDeliveryByID(ctx, db, in.DeliveryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("delivery_id", "Delivery not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	if delivery.WebhookID != webhook.ID {
+		return nil, response.NewValidationErrorResponse("delivery_id", "Delivery not found")
+	}
+	if err := delivery.Redeliver(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &WebhookDeliveryOutput{Data: delivery}, nil
+}
+
+type RedeliverWebhookOutput struct {
+	response.Response
+	Data int64 `json:"data"`
+}
+
+// RedeliverDeadWebhookDeliveries sends all the dead deliveries of the webhook again
+func RedeliverDeadWebhookDeliveries(c *gin.Context, in *WebhookInput) (*RedeliverWebhookOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	webhook, err := getClientWebhook(ctx, db, in.Authorization, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	count, err := models.RedeliverDeadWebhookDeliveries(ctx, db, webhook.ID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &RedeliverWebhookOutput{Data: count}, nil
+}
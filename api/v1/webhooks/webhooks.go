@@ -0,0 +1,146 @@
+package webhooks
+
+import (
+	"context"
+	"crynux_bridge/api/v1/response"
+	"crynux_bridge/api/v1/tools"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// getClientWebhook returns the webhook if it is owned by the client of the api key
+func getClientWebhook(ctx context.Context, db *gorm.DB, authorization string, id uint) (*models.Webhook, error) {
+	client, err := getAuthorizedClient(ctx, db, authorization)
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := models.GetWebhookByID(ctx, db, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.NewValidationErrorResponse("id", "Webhook not found")
+		}
+		return nil, response.NewExceptionResponse(err)
+	}
+	if webhook.ClientID != client.ID {
+		return nil, response.NewValidationErrorResponse("id", "Webhook not found")
+	}
+	return webhook, nil
+}
+
+func getAuthorizedClient(ctx context.Context, db *gorm.DB, authorization string) (*models.Client, error) {
+	apiKey, err := tools.ValidateAuthorization(ctx, db, authorization)
+	if err != nil {
+		return nil, err
+	}
+	client, err := tools.CreateClientIfNotExist(ctx, db, apiKey.ClientID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return client, nil
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+type CreateWebhookInput struct {
+	Authorization string   `header:"Authorization" validate:"required" description:"API key"`
+	URL           string   `json:"url" description:"The endpoint receiving the events" validate:"required,url"`
+	Events        []string `json:"events,omitempty" description:"The events to receive, all events if empty" validate:"omitempty"`
+}
+
+type CreatedWebhook struct {
+	*models.Webhook
+	// the secret is only returned when the webhook is created
+	Secret string `json:"secret"`
+}
+
+type CreateWebhookOutput struct {
+	response.Response
+	Data *CreatedWebhook `json:"data"`
+}
+
+func CreateWebhook(c *gin.Context, in *CreateWebhookInput) (*CreateWebhookOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	client, err := getAuthorizedClient(ctx, db, in.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range in.Events {
+		if !models.IsWebhookEvent(event) {
+			return nil, response.NewValidationErrorResponse("events", "Unknown event "+event)
+		}
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	webhook := &models.Webhook{
+		ClientID: client.ID,
+		URL:      in.URL,
+		Secret:   secret,
+		Events:   in.Events,
+	}
+	if err := webhook.Save(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &CreateWebhookOutput{
+		Data: &CreatedWebhook{Webhook: webhook, Secret: secret},
+	}, nil
+}
+
+type GetWebhooksInput struct {
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+type GetWebhooksOutput struct {
+	response.Response
+	Data []models.Webhook `json:"data"`
+}
+
+func GetWebhooks(c *gin.Context, in *GetWebhooksInput) (*GetWebhooksOutput, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	client, err := getAuthorizedClient(ctx, db, in.Authorization)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := models.GetClientWebhooks(ctx, db, client.ID)
+	if err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &GetWebhooksOutput{Data: webhooks}, nil
+}
+
+type WebhookInput struct {
+	ID            uint   `path:"id" json:"id" description:"Webhook id" validate:"required"`
+	Authorization string `header:"Authorization" validate:"required" description:"API key"`
+}
+
+func DeleteWebhook(c *gin.Context, in *WebhookInput) (*response.Response, error) {
+	ctx := c.Request.Context()
+	db := config.GetDB()
+
+	webhook, err := getClientWebhook(ctx, db, in.Authorization, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := webhook.Delete(ctx, db); err != nil {
+		return nil, response.NewExceptionResponse(err)
+	}
+	return &response.Response{}, nil
+}
@@ -0,0 +1,581 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crynux_bridge/blockchain/bindings"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// testChain is a local chain running the Task contract in go, on the abi of the bindings.
+// The bindings carry no bytecode to deploy on a simulated evm, so the contract is reimplemented
+// with the checks the bridge relies on, and reverts with Error(string) like the solidity one.
+// Each transaction is mined in its own block, and only the latest state is kept.
+// With holdTxs set, the transactions wait in the mempool until mine is called.
+type testChain struct {
+	mu sync.Mutex
+
+	chainID     *big.Int
+	signer      types.Signer
+	taskAddress common.Address
+	taskABI     *abi.ABI
+
+	blockNumber uint64
+	nonces      map[common.Address]uint64
+	balances    map[common.Address]*big.Int
+	txs         map[common.Hash]*types.Transaction
+	receipts    map[common.Hash]*types.Receipt
+	mempool     map[common.Address]map[uint64]*types.Transaction
+
+	tasks        map[[32]byte]*bindings.VSSTaskTaskInfo
+	taskSequence int64
+
+	// PendingNonceAt lags behind by one nonce for the next staleNonces calls,
+	// like a load balanced rpc endpoint whose nodes are not synced
+	staleNonces int
+	// the next SendTransaction fails with sendErr
+	sendErr error
+	// the sent transactions are not mined until mine is called, like on a congested chain
+	holdTxs bool
+}
+
+const testGasPrice = 1000000000
+
+func newTestChain(t *testing.T, funded ...common.Address) *testChain {
+	taskABI, err := bindings.TaskMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID := big.NewInt(1337)
+	chain := &testChain{
+		chainID:     chainID,
+		signer:      types.LatestSignerForChainID(chainID),
+		taskAddress: common.HexToAddress(config.GetConfig().Blockchain.Contracts.Task),
+		taskABI:     taskABI,
+		nonces:      make(map[common.Address]uint64),
+		balances:    make(map[common.Address]*big.Int),
+		txs:         make(map[common.Hash]*types.Transaction),
+		receipts:    make(map[common.Hash]*types.Receipt),
+		mempool:     make(map[common.Address]map[uint64]*types.Transaction),
+		tasks:       make(map[[32]byte]*bindings.VSSTaskTaskInfo),
+	}
+	for _, address := range funded {
+		chain.balances[address] = new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))
+	}
+	return chain
+}
+
+// revertError is the error of a reverted eth_call, which carries the revert data like the one of geth
+type revertError struct {
+	reason string
+}
+
+func (e *revertError) Error() string {
+	return "execution reverted: " + e.reason
+}
+
+func (e *revertError) ErrorCode() int {
+	return 3
+}
+
+func (e *revertError) ErrorData() interface{} {
+	return hexutil.Encode(packError(e.reason))
+}
+
+func packError(reason string) []byte {
+	data, _ := abi.Arguments{{Type: abiString}}.Pack(reason)
+	return append(append([]byte{}, errorSig...), data...)
+}
+
+func (c *testChain) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if contract == c.taskAddress {
+		return []byte{0x01}, nil
+	}
+	return nil, nil
+}
+
+func (c *testChain) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	return c.CodeAt(ctx, account, nil)
+}
+
+func (c *testChain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call.To == nil || *call.To != c.taskAddress {
+		return nil, nil
+	}
+	ret, _, reason := c.execute(call.From, call.Value, call.Data)
+	if reason != "" {
+		return nil, &revertError{reason: reason}
+	}
+	return ret, nil
+}
+
+func (c *testChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &types.Header{Number: new(big.Int).SetUint64(c.blockNumber)}, nil
+}
+
+func (c *testChain) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nonce := c.nonces[account] + uint64(len(c.mempool[account]))
+	if c.staleNonces > 0 && nonce > 0 {
+		c.staleNonces--
+		nonce--
+	}
+	return nonce, nil
+}
+
+func (c *testChain) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nonces[account], nil
+}
+
+func (c *testChain) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(testGasPrice), nil
+}
+
+func (c *testChain) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(testGasPrice), nil
+}
+
+func (c *testChain) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return 1000000, nil
+}
+
+func (c *testChain) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sendErr != nil {
+		err := c.sendErr
+		c.sendErr = nil
+		return err
+	}
+	from, err := types.Sender(c.signer, tx)
+	if err != nil {
+		return err
+	}
+	nonce := c.nonces[from]
+	pending := c.mempool[from]
+	if tx.Nonce() < nonce {
+		return fmt.Errorf("nonce too low: address %s, tx: %d state: %d", from.Hex(), tx.Nonce(), nonce)
+	}
+	cost := tx.Cost()
+	balance, ok := c.balances[from]
+	if !ok || balance.Cmp(cost) < 0 {
+		return fmt.Errorf("insufficient funds for gas * price + value: address %s", from.Hex())
+	}
+
+	if old, ok := pending[tx.Nonce()]; ok {
+		// geth requires the replacement to pay at least 10% more gas price, or both more tip and fee cap
+		minGasPrice := new(big.Int).Div(new(big.Int).Mul(old.GasPrice(), big.NewInt(110)), big.NewInt(100))
+		minGasTipCap := new(big.Int).Div(new(big.Int).Mul(old.GasTipCap(), big.NewInt(110)), big.NewInt(100))
+		if tx.GasPrice().Cmp(minGasPrice) < 0 || tx.GasTipCap().Cmp(minGasTipCap) < 0 {
+			return errors.New("replacement transaction underpriced")
+		}
+		delete(c.txs, old.Hash())
+		pending[tx.Nonce()] = tx
+		c.txs[tx.Hash()] = tx
+		return nil
+	}
+	if tx.Nonce() > nonce+uint64(len(pending)) {
+		return fmt.Errorf("nonce too high: address %s, tx: %d state: %d", from.Hex(), tx.Nonce(), nonce)
+	}
+	if c.holdTxs {
+		if pending == nil {
+			pending = make(map[uint64]*types.Transaction)
+			c.mempool[from] = pending
+		}
+		pending[tx.Nonce()] = tx
+		c.txs[tx.Hash()] = tx
+		return nil
+	}
+	c.apply(from, tx)
+	return nil
+}
+
+// mine mines the transactions waiting in the mempool in the order of their nonces
+func (c *testChain) mine() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for from, pending := range c.mempool {
+		for {
+			tx, ok := pending[c.nonces[from]]
+			if !ok {
+				break
+			}
+			delete(pending, tx.Nonce())
+			c.apply(from, tx)
+		}
+	}
+}
+
+// takeNonce mines a transfer of the account sent by others, which drops the transaction of the nonce in the mempool
+func (c *testChain) takeNonce(account common.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.mempool[account], c.nonces[account])
+	c.blockNumber++
+	c.nonces[account]++
+}
+
+// apply mines the transaction in a new block
+func (c *testChain) apply(from common.Address, tx *types.Transaction) {
+	balance := c.balances[from]
+	c.blockNumber++
+	c.nonces[from]++
+	receipt := &types.Receipt{
+		Status:      types.ReceiptStatusSuccessful,
+		TxHash:      tx.Hash(),
+		BlockNumber: new(big.Int).SetUint64(c.blockNumber),
+		GasUsed:     tx.Gas(),
+	}
+	if tx.To() != nil && *tx.To() == c.taskAddress {
+		_, apply, reason := c.execute(from, tx.Value(), tx.Data())
+		if reason != "" {
+			receipt.Status = types.ReceiptStatusFailed
+		} else {
+			apply()
+		}
+	}
+	// the fee is charged for the gas limit, and the value is only transferred by the successful txs
+	c.balances[from] = new(big.Int).Sub(balance, new(big.Int).Mul(tx.GasPrice(), new(big.Int).SetUint64(tx.Gas())))
+	if receipt.Status == types.ReceiptStatusSuccessful && tx.Value() != nil {
+		c.balances[from].Sub(c.balances[from], tx.Value())
+		if to := tx.To(); to != nil {
+			if _, ok := c.balances[*to]; !ok {
+				c.balances[*to] = big.NewInt(0)
+			}
+			c.balances[*to].Add(c.balances[*to], tx.Value())
+		}
+	}
+	c.txs[tx.Hash()] = tx
+	c.receipts[tx.Hash()] = receipt
+}
+
+func (c *testChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	return nil, errors.New("logs are not supported by the test chain")
+}
+
+func (c *testChain) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return nil, errors.New("logs are not supported by the test chain")
+}
+
+func (c *testChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	receipt, ok := c.receipts[txHash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (c *testChain) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tx, ok := c.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, false, nil
+}
+
+func (c *testChain) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if balance, ok := c.balances[account]; ok {
+		return new(big.Int).Set(balance), nil
+	}
+	return big.NewInt(0), nil
+}
+
+func (c *testChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(c.chainID), nil
+}
+
+// setTaskStatus changes the status of the task like the nodes and the relay do on chain
+func (c *testChain) setTaskStatus(taskIDCommitment [32]byte, status models.ChainTaskStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tasks[taskIDCommitment].Status = uint8(status)
+}
+
+func (c *testChain) nonce(account common.Address) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nonces[account]
+}
+
+// execute runs the call of the Task contract on the latest state. It returns the packed result and
+// the function applying the state changes of the call, or the revert reason.
+func (c *testChain) execute(from common.Address, value *big.Int, data []byte) ([]byte, func(), string) {
+	if len(data) < 4 {
+		return nil, nil, "function not found"
+	}
+	method, err := c.taskABI.MethodById(data[:4])
+	if err != nil {
+		return nil, nil, "function not found"
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, nil, "invalid arguments"
+	}
+	if value == nil {
+		value = big.NewInt(0)
+	}
+	noop := func() {}
+
+	switch method.Name {
+	case "getTask":
+		task, ok := c.tasks[args[0].([32]byte)]
+		if !ok {
+			// the mapping of the contract returns the empty task
+			task = emptyTaskInfo()
+		}
+		ret, err := method.Outputs.Pack(*task)
+		if err != nil {
+			return nil, nil, err.Error()
+		}
+		return ret, noop, ""
+
+	case "createTask":
+		taskIDCommitment := args[1].([32]byte)
+		if _, ok := c.tasks[taskIDCommitment]; ok {
+			return nil, nil, "Task already exists"
+		}
+		if value.Sign() == 0 {
+			return nil, nil, "Task fee is zero"
+		}
+		task := &bindings.VSSTaskTaskInfo{
+			TaskType:            args[0].(uint8),
+			Creator:             from,
+			TaskIDCommitment:    taskIDCommitment,
+			Nonce:               args[2].([32]byte),
+			Status:              uint8(models.ChainTaskQueued),
+			Timeout:             big.NewInt(0),
+			Score:               []byte{},
+			TaskFee:             new(big.Int).Set(value),
+			TaskSize:            args[8].(*big.Int),
+			ModelIDs:            args[3].([]string),
+			MinimumVRAM:         args[4].(*big.Int),
+			RequiredGPU:         args[5].(string),
+			RequiredGPUVRAM:     args[6].(*big.Int),
+			TaskVersion:         args[7].([3]*big.Int),
+			PaymentAddresses:    []common.Address{},
+			Payments:            []*big.Int{},
+			CreateTimestamp:     new(big.Int).SetUint64(c.blockNumber),
+			StartTimestamp:      big.NewInt(0),
+			ScoreReadyTimestamp: big.NewInt(0),
+		}
+		return nil, func() {
+			c.taskSequence++
+			task.Sequence = big.NewInt(c.taskSequence)
+			c.tasks[taskIDCommitment] = task
+		}, ""
+
+	case "validateSingleTask":
+		task, reason := c.creatorTask(from, args[0].([32]byte), models.ChainTaskScoreReady)
+		if reason != "" {
+			return nil, nil, reason
+		}
+		if reason := checkPublicKey(from, args[2].([]byte)); reason != "" {
+			return nil, nil, reason
+		}
+		return nil, func() {
+			task.Status = uint8(models.ChainTaskValidated)
+		}, ""
+
+	case "validateTaskGroup":
+		var tasks []*bindings.VSSTaskTaskInfo
+		for i := 0; i < 3; i++ {
+			task, reason := c.creatorTask(from, args[i].([32]byte), models.ChainTaskScoreReady)
+			if reason != "" {
+				return nil, nil, reason
+			}
+			tasks = append(tasks, task)
+		}
+		if reason := checkPublicKey(from, args[5].([]byte)); reason != "" {
+			return nil, nil, reason
+		}
+		return nil, func() {
+			tasks[0].Status = uint8(models.ChainTaskGroupValidated)
+			tasks[1].Status = uint8(models.ChainTaskEndGroupRefund)
+			tasks[2].Status = uint8(models.ChainTaskEndGroupRefund)
+		}, ""
+
+	case "abortTask":
+		task, reason := c.creatorTask(from, args[0].([32]byte))
+		if reason != "" {
+			return nil, nil, reason
+		}
+		if task.Status >= uint8(models.ChainTaskValidated) {
+			return nil, nil, "Illegal task state"
+		}
+		return nil, func() {
+			task.Status = uint8(models.ChainTaskEndAborted)
+			task.AbortReason = args[1].(uint8)
+		}, ""
+	}
+	return nil, nil, "function not supported by the test chain"
+}
+
+func emptyTaskInfo() *bindings.VSSTaskTaskInfo {
+	return &bindings.VSSTaskTaskInfo{
+		Sequence:            big.NewInt(0),
+		Timeout:             big.NewInt(0),
+		Score:               []byte{},
+		TaskFee:             big.NewInt(0),
+		TaskSize:            big.NewInt(0),
+		ModelIDs:            []string{},
+		MinimumVRAM:         big.NewInt(0),
+		RequiredGPUVRAM:     big.NewInt(0),
+		TaskVersion:         [3]*big.Int{big.NewInt(0), big.NewInt(0), big.NewInt(0)},
+		PaymentAddresses:    []common.Address{},
+		Payments:            []*big.Int{},
+		CreateTimestamp:     big.NewInt(0),
+		StartTimestamp:      big.NewInt(0),
+		ScoreReadyTimestamp: big.NewInt(0),
+	}
+}
+
+// setTaskStarted starts the task on the node like the relay does on chain
+func (c *testChain) setTaskStarted(taskIDCommitment [32]byte, node common.Address, samplingSeed [32]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	task := c.tasks[taskIDCommitment]
+	task.Status = uint8(models.ChainTaskStarted)
+	task.SelectedNode = node
+	task.SamplingSeed = samplingSeed
+	task.StartTimestamp = new(big.Int).SetUint64(c.blockNumber)
+}
+
+// creatorTask returns the task created by from, which should be in one of the statuses if any given
+func (c *testChain) creatorTask(from common.Address, taskIDCommitment [32]byte, statuses ...models.ChainTaskStatus) (*bindings.VSSTaskTaskInfo, string) {
+	task, ok := c.tasks[taskIDCommitment]
+	if !ok {
+		return nil, "Task not found"
+	}
+	if task.Creator != from {
+		return nil, "Not task creator"
+	}
+	if len(statuses) == 0 {
+		return task, ""
+	}
+	for _, status := range statuses {
+		if task.Status == uint8(status) {
+			return task, ""
+		}
+	}
+	return nil, "Illegal task state"
+}
+
+func checkPublicKey(from common.Address, publicKey []byte) string {
+	if len(publicKey) != 64 || !bytes.Equal(crypto.Keccak256(publicKey)[12:], from.Bytes()) {
+		return "Invalid public key"
+	}
+	return ""
+}
+
+const testConfig = `
+environment: "test"
+db:
+  driver: "sqlite"
+  connection: "%s"
+  log:
+    level: "error"
+    output: "stderr"
+cluster:
+  instance_id: "blockchain-test"
+  lease_duration: 30
+blockchain:
+  rps: 1000
+  gas_limit: 8000000
+  contracts:
+    task: "0x0000000000000000000000000000000000000100"
+    node: "0x0000000000000000000000000000000000000101"
+    netstats: "0x0000000000000000000000000000000000000102"
+test:
+  root_address: "%s"
+  root_private_key: "%s"
+`
+
+// setupConfig initializes the config with a generated account, and the sqlite database of the transaction lease in dir
+func setupConfig(dir string) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	privateKey := hexutil.Encode(crypto.FromECDSA(key))[2:]
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	conf := fmt.Sprintf(testConfig, filepath.Join(dir, "bridge.db"), address, privateKey)
+	if err := os.WriteFile(filepath.Join(dir, "config.yml"), []byte(conf), 0o600); err != nil {
+		return err
+	}
+	if err := config.InitConfig(dir); err != nil {
+		return err
+	}
+	if err := config.InitDB(config.GetConfig()); err != nil {
+		return err
+	}
+	return config.GetDB().AutoMigrate(&models.LeaderLease{}, &models.BlockchainTransaction{})
+}
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.WarnLevel)
+
+	dir, err := os.MkdirTemp("", "bridge-blockchain-*")
+	if err != nil {
+		panic(err)
+	}
+	if err := setupConfig(dir); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	_ = config.CloseDB()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// initTestChain starts a test chain with the account of the bridge funded, and initializes the package on it.
+// The transactions saved for the previous chain are deleted.
+func initTestChain(t *testing.T) *testChain {
+	if err := config.GetDB().Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(&models.BlockchainTransaction{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	chain := newTestChain(t, accountAddress())
+	if err := InitWithBackend(context.Background(), chain); err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func accountAddress() common.Address {
+	return common.HexToAddress(config.GetConfig().Blockchain.Account.Address)
+}
@@ -18,10 +18,22 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	log "github.com/sirupsen/logrus"
 )
 
-var ethRpcClient *ethclient.Client
+// Backend is the blockchain the package reads from and sends transactions to.
+// It is the rpc client of the endpoint in config, and is replaced by a local chain in tests.
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+var ethRpcClient Backend
 
 var chainID *big.Int
 var gasPrice *big.Int
@@ -30,7 +42,7 @@ var taskContractInstance *bindings.Task
 var nodeContractInstance *bindings.Node
 var netstatsContractInstance *bindings.NetworkStats
 
-func GetRpcClient() *ethclient.Client {
+func GetRpcClient() Backend {
 	if ethRpcClient == nil {
 		log.Panicln("eth rpc client is nil")
 	}
@@ -73,10 +85,18 @@ func getChainID() *big.Int {
 }
 
 func Init(ctx context.Context) error {
-	appConfig := config.GetConfig()
-	if err := initEthRpcClient(appConfig.Blockchain.RpcEndpoint); err != nil {
+	client, err := ethclient.Dial(config.GetConfig().Blockchain.RpcEndpoint)
+	if err != nil {
 		return err
 	}
+	return InitWithBackend(ctx, client)
+}
+
+// InitWithBackend initializes the contract instances, the chain id and the gas price on the backend
+func InitWithBackend(ctx context.Context, backend Backend) error {
+	appConfig := config.GetConfig()
+	ethRpcClient = backend
+	localNonce = nil
 	if err := initTaskContractInstance(appConfig.Blockchain.Contracts.Task); err != nil {
 		return err
 	}
@@ -95,15 +115,6 @@ func Init(ctx context.Context) error {
 	return nil
 }
 
-func initEthRpcClient(endpoint string) error {
-	client, err := ethclient.Dial(endpoint)
-	if err != nil {
-		return err
-	}
-	ethRpcClient = client
-	return nil
-}
-
 func initTaskContractInstance(taskContractAddress string) error {
 	client := GetRpcClient()
 	taskInstance, err := bindings.NewTask(common.HexToAddress(taskContractAddress), client)
@@ -189,15 +200,15 @@ func GetAuth(ctx context.Context, address common.Address, privateKeyStr string)
 	return auth, nil
 }
 
+// WaitTxReceipt waits for the transaction, or the one replacing it if it is stuck, to be mined
 func WaitTxReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
 	deadline, hasDeadline := ctx.Deadline()
-	client := GetRpcClient()
 
 	for {
 		r, err := func() (*types.Receipt, error) {
 			callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 			defer cancel()
-			return client.TransactionReceipt(callCtx, txHash)
+			return getTxReceipt(callCtx, txHash)
 		}()
 		if err == ethereum.NotFound {
 			time.Sleep(time.Second)
@@ -215,42 +226,10 @@ func WaitTxReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, err
 }
 
 func SendETH(ctx context.Context, from common.Address, to common.Address, amount *big.Int, privateKeyStr string) (*types.Transaction, error) {
-	client := GetRpcClient()
-	gasLimit := config.GetConfig().Blockchain.GasLimit
-
-	txMutex.Lock()
-	defer txMutex.Unlock()
-	nonce, err := getNonce(ctx, from)
-	if err != nil {
-		return nil, err
-	}
-
-	tx := types.NewTransaction(nonce, to, amount, gasLimit, getGasPrice(), nil)
-
-	privateKey, err := crypto.HexToECDSA(privateKeyStr)
-	if err != nil {
-		return nil, err
-	}
-
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(getChainID()), privateKey)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := getLimiter().Wait(ctx); err != nil {
-		return nil, err
-	}
-
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	err = client.SendTransaction(callCtx, signedTx)
-	if err != nil {
-		err = processSendingTxError(err)
-		return nil, err
-	}
-
-	addNonce(nonce)
-	return signedTx, nil
+	return sendTx(ctx, from, privateKeyStr, "transfer", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		tx := types.NewTransaction(auth.Nonce.Uint64(), to, amount, auth.GasLimit, auth.GasPrice, nil)
+		return auth.Signer(auth.From, tx)
+	})
 }
 
 func GetErrorMessageFromReceipt(ctx context.Context, receipt *types.Receipt) (string, error) {
@@ -280,7 +259,11 @@ func GetErrorMessageFromReceipt(ctx context.Context, receipt *types.Receipt) (st
 
 	res, err := client.CallContract(ctx2, msg, blockNumber)
 	if err != nil {
-		return "", err
+		// geth returns the revert data of the call in the error instead of the result
+		res, err = getRevertData(err)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	errMsg, err := unpackError(res)
@@ -295,6 +278,22 @@ var (
 	abiString, _ = abi.NewType("string", "", nil)
 )
 
+func getRevertData(err error) ([]byte, error) {
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return nil, err
+	}
+	data, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil, err
+	}
+	res, decodeErr := hexutil.Decode(data)
+	if decodeErr != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func unpackError(result []byte) (string, error) {
 	if len(result) < 4 {
 		return "", errors.New("tx result length too short")
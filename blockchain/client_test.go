@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestUnpackError(t *testing.T) {
+	errMsg, err := unpackError(packError("Task not found"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errMsg != "Task not found" {
+		t.Errorf("revert reason mismatch: %s", errMsg)
+	}
+
+	if _, err := unpackError([]byte{0x08, 0xc3}); err == nil {
+		t.Error("too short result should not be unpacked")
+	}
+	data := packError("Task not found")
+	data[0] = 0x4e
+	if _, err := unpackError(data); err == nil {
+		t.Error("result of other types should not be unpacked")
+	}
+}
+
+func TestGetRevertData(t *testing.T) {
+	data, err := getRevertData(&revertError{reason: "Not task creator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errMsg, err := unpackError(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errMsg != "Not task creator" {
+		t.Errorf("revert reason mismatch: %s", errMsg)
+	}
+
+	callErr := errors.New("connection refused")
+	if _, err := getRevertData(callErr); err != callErr {
+		t.Errorf("the error without revert data should be returned: %v", err)
+	}
+}
+
+func TestSendETH(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	to := common.HexToAddress("0x0000000000000000000000000000000000000200")
+	amount := big.NewInt(1000)
+
+	tx, err := SendETH(ctx, accountAddress(), to, amount, config.GetConfig().Blockchain.Account.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitTestTx(t, tx.Hash().Hex(), types.ReceiptStatusSuccessful)
+	balance, err := chain.BalanceAt(ctx, to, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cmp(amount) != 0 {
+		t.Errorf("balance should be %s: %s", amount, balance)
+	}
+}
+
+func TestCheckBalanceForTaskCreator(t *testing.T) {
+	initTestChain(t)
+	if err := CheckBalanceForTaskCreator(); err != nil {
+		t.Errorf("the funded account should have enough balance: %v", err)
+	}
+
+	if err := InitWithBackend(context.Background(), newTestChain(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBalanceForTaskCreator(); err == nil {
+		t.Error("the account without balance should not create tasks")
+	}
+}
@@ -0,0 +1,39 @@
+package blockchain
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// GetNodeByAddress reads the node from the Node contract, in the same form as the node returned by the relay
+func GetNodeByAddress(ctx context.Context, address string) (*models.RelayNode, error) {
+	nodeInstance := GetNodeContractInstance()
+
+	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	opts := &bind.CallOpts{
+		Pending: false,
+		Context: callCtx,
+	}
+
+	nodeInfo, err := nodeInstance.GetNodeInfo(opts, common.HexToAddress(address))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RelayNode{
+		Address:       address,
+		Status:        models.NodeStatus(nodeInfo.Status),
+		GPUName:       nodeInfo.Gpu.Name,
+		GPUVram:       nodeInfo.Gpu.Vram.Uint64(),
+		QOSScore:      nodeInfo.Score.Uint64(),
+		Version:       formatVersion(nodeInfo.Version),
+		InUseModelIDs: nodeInfo.LastModelIDs,
+		ModelIDs:      nodeInfo.LocalModelIDs,
+	}, nil
+}
@@ -2,7 +2,8 @@ package blockchain
 
 import (
 	"context"
-	"fmt"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
 	"regexp"
 	"strconv"
 	"sync"
@@ -16,8 +17,51 @@ import (
 var localNonce *uint64
 var txMutex sync.Mutex
 
+// txLeaseName is the leader lease that serializes the transactions sent by all the bridge instances
+// sharing the same account
+const txLeaseName = "blockchain_tx"
+
 var pattern *regexp.Regexp = regexp.MustCompile(`[Nn]once`)
 
+// lockTx takes the transaction lock of this instance and the transaction lease shared by all the instances.
+// The local nonce is dropped after taking the lease, because other instances may have sent transactions
+// with the same account in between.
+func lockTx(ctx context.Context) (func(), error) {
+	txMutex.Lock()
+
+	db := config.GetDB()
+	owner := config.GetInstanceID()
+	for {
+		acquired, err := models.AcquireLeaderLease(ctx, db, txLeaseName, owner, config.GetLeaseDuration())
+		if err != nil {
+			txMutex.Unlock()
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			txMutex.Unlock()
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	localNonce = nil
+
+	return func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := models.ReleaseLeaderLease(releaseCtx, db, txLeaseName, owner); err != nil {
+			log.Errorf("cannot release transaction lease: %v", err)
+		}
+		txMutex.Unlock()
+	}, nil
+}
+
+// getNonce returns the next nonce of the account. After the local nonce is dropped, it is synced from
+// the pending nonce of the blockchain, or from the transactions saved as pending if they are ahead,
+// because the rpc endpoint may not have seen the transactions just sent by other instances.
 func getNonce(ctx context.Context, address common.Address) (uint64, error) {
 	if localNonce == nil {
 		client := GetRpcClient()
@@ -29,14 +73,30 @@ func getNonce(ctx context.Context, address common.Address) (uint64, error) {
 			return 0, err
 		}
 		log.Debugln("Nonce from blockchain: " + strconv.FormatUint(nonce, 10))
+		savedNonce, err := models.GetNextPendingNonce(callCtx, config.GetDB(), address.Hex())
+		if err != nil {
+			return 0, err
+		}
+		if savedNonce > nonce {
+			log.Debugln("Nonce from pending transactions: " + strconv.FormatUint(savedNonce, 10))
+			nonce = savedNonce
+		}
 		localNonce = &nonce
 	}
 	return *localNonce, nil
 }
 
+// addNonce moves the local nonce past the nonce just sent. The local nonce is dropped to be synced again
+// if it does not match, instead of guessing which one is right.
 func addNonce(nonce uint64) {
-	if *localNonce != nonce {
-		log.Panic(fmt.Sprintf("local nonce changed, local nonce: %d, nonce: %d", *localNonce, nonce))
+	if localNonce == nil || *localNonce != nonce {
+		local := "nil"
+		if localNonce != nil {
+			local = strconv.FormatUint(*localNonce, 10)
+		}
+		log.Warnf("local nonce changed, local nonce: %s, nonce: %d, sync from blockchain", local, nonce)
+		localNonce = nil
+		return
 	}
 	(*localNonce)++
 }
@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestMatchNonceError(t *testing.T) {
+	cases := map[string]bool{
+		"nonce too low: address 0x01, tx: 0 state: 1": true,
+		"Nonce too high":                             true,
+		"replacement transaction underpriced":        false,
+		"insufficient funds for gas * price + value": false,
+	}
+	for errStr, expected := range cases {
+		if matchNonceError(errStr) != expected {
+			t.Errorf("nonce error of %q should be %v", errStr, expected)
+		}
+	}
+}
+
+func TestNonceRecovery(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	createTestTask(t)
+	if localNonce == nil || *localNonce != 1 {
+		t.Fatalf("local nonce should be increased after the tx sent: %v", localNonce)
+	}
+
+	// the rpc endpoint returns a stale nonce, which is behind the tx saved as pending
+	chain.staleNonces = 1
+	createTestTask(t)
+	if nonce := chain.nonce(accountAddress()); nonce != 2 {
+		t.Fatalf("nonce of the account should be 2: %d", nonce)
+	}
+
+	// the tx is rejected for the nonce by the rpc endpoint
+	chain.sendErr = errors.New("nonce too low: address 0x01, tx: 2 state: 3")
+	if _, err := CreateTaskOnChain(ctx, newTestTask()); err == nil || !matchNonceError(err.Error()) {
+		t.Fatalf("tx of the nonce error should be rejected: %v", err)
+	}
+	if localNonce != nil {
+		t.Fatalf("local nonce should be dropped after the nonce error: %d", *localNonce)
+	}
+
+	// the nonce is fetched again by the next tx
+	createTestTask(t)
+	if nonce := chain.nonce(accountAddress()); nonce != 3 {
+		t.Errorf("nonce of the account should be 3: %d", nonce)
+	}
+
+	// other errors keep the local nonce
+	chain.sendErr = errors.New("connection refused")
+	if _, err := CreateTaskOnChain(ctx, newTestTask()); err == nil {
+		t.Fatal("tx should fail to be sent")
+	}
+	if localNonce == nil || *localNonce != 3 {
+		t.Errorf("local nonce should be kept after other errors: %v", localNonce)
+	}
+}
+
+func TestAddNonceMismatch(t *testing.T) {
+	initTestChain(t)
+	nonce := uint64(5)
+	localNonce = &nonce
+
+	// the mismatched nonce drops the local nonce instead of panicking
+	addNonce(3)
+	if localNonce != nil {
+		t.Fatalf("local nonce should be dropped after the mismatch: %d", *localNonce)
+	}
+	addNonce(0)
+	if localNonce != nil {
+		t.Fatalf("local nonce should not be set without syncing: %d", *localNonce)
+	}
+	createTestTask(t)
+	if localNonce == nil || *localNonce != 1 {
+		t.Errorf("local nonce should be synced by the next tx: %v", localNonce)
+	}
+}
+
+func TestConcurrentTxs(t *testing.T) {
+	chain := initTestChain(t)
+
+	const n = 8
+	var wg sync.WaitGroup
+	txHashes := make([]string, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			txHashes[i], errs[i] = CreateTaskOnChain(context.Background(), newTestTask())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("tx %d failed: %v", i, errs[i])
+		}
+		waitTestTx(t, txHashes[i], types.ReceiptStatusSuccessful)
+	}
+	if nonce := chain.nonce(accountAddress()); nonce != n {
+		t.Errorf("every tx should take its own nonce: %d", nonce)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
 	log "github.com/sirupsen/logrus"
@@ -54,28 +55,6 @@ func CreateTaskOnChain(ctx context.Context, task *models.InferenceTask) (string,
 	address := common.HexToAddress(appConfig.Blockchain.Account.Address)
 	privkey := appConfig.Blockchain.Account.PrivateKey
 
-	txMutex.Lock()
-	defer txMutex.Unlock()
-
-	auth, err := GetAuth(ctx, address, privkey)
-	if err != nil {
-		return "", err
-	}
-
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := getLimiter().Wait(callCtx); err != nil {
-		return "", err
-	}
-	auth.Context = callCtx
-	auth.Value = big.NewInt(int64(task.TaskFee))
-
-	txNonce, err := getNonce(callCtx, address)
-	if err != nil {
-		return "", err
-	}
-	auth.Nonce = big.NewInt(int64(txNonce))
-
 	taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
 	nonce, _ := utils.HexStrToBytes32(task.Nonce)
 
@@ -93,24 +72,25 @@ func CreateTaskOnChain(ctx context.Context, task *models.InferenceTask) (string,
 		taskVersion[i] = big.NewInt(int64(version))
 	}
 
-	tx, err := taskInstance.CreateTask(
-		auth,
-		uint8(task.TaskType),
-		*taskIDCommitment,
-		*nonce,
-		task.TaskModelIDs,
-		big.NewInt(int64(task.MinVram)),
-		task.RequiredGPU,
-		big.NewInt(int64(task.RequiredGPUVram)),
-		taskVersion,
-		big.NewInt(int64(task.TaskSize)),
-	)
+	tx, err := sendTx(ctx, address, privkey, "createTask", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		// the task fee is in gwei
+		auth.Value = utils.GweiToWei(big.NewInt(int64(task.TaskFee)))
+		return taskInstance.CreateTask(
+			auth,
+			uint8(task.TaskType),
+			*taskIDCommitment,
+			*nonce,
+			task.TaskModelIDs,
+			big.NewInt(int64(task.MinVram)),
+			task.RequiredGPU,
+			big.NewInt(int64(task.RequiredGPUVram)),
+			taskVersion,
+			big.NewInt(int64(task.TaskSize)),
+		)
+	})
 	if err != nil {
-		err = processSendingTxError(err)
 		return "", err
 	}
-
-	addNonce(txNonce)
 	return tx.Hash().Hex(), nil
 }
 
@@ -121,52 +101,19 @@ func ValidateSingleTask(ctx context.Context, task *models.InferenceTask) (string
 	address := common.HexToAddress(appConfig.Blockchain.Account.Address)
 	privkey := appConfig.Blockchain.Account.PrivateKey
 
-	txMutex.Lock()
-	defer txMutex.Unlock()
-
-	auth, err := GetAuth(ctx, address, privkey)
-	if err != nil {
-		return "", err
-	}
-
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := getLimiter().Wait(callCtx); err != nil {
-		return "", err
-	}
-	auth.Context = callCtx
-
-	txNonce, err := getNonce(callCtx, address)
-	if err != nil {
-		return "", err
-	}
-	auth.Nonce = big.NewInt(int64(txNonce))
-
 	taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
 	vrfProof, _ := hexutil.Decode(task.VRFProof)
-	privateKey, err := crypto.HexToECDSA(privkey)
+	publicKeyBytes, err := getPublicKeyBytes(privkey)
 	if err != nil {
 		return "", err
 	}
 
-	publicKey := privateKey.Public()
-
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return "", errors.New("error casting public key to ECDSA")
-	}
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	if len(publicKeyBytes) != 65 {
-		return "", errors.New("umcompressed public key bytes length is not 65")
-	}
-	publicKeyBytes = publicKeyBytes[1:]
-
-	tx, err := taskInstance.ValidateSingleTask(auth, *taskIDCommitment, vrfProof, publicKeyBytes)
+	tx, err := sendTx(ctx, address, privkey, "validateSingleTask", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return taskInstance.ValidateSingleTask(auth, *taskIDCommitment, vrfProof, publicKeyBytes)
+	})
 	if err != nil {
-		err = processSendingTxError(err)
 		return "", err
 	}
-	addNonce(txNonce)
 	return tx.Hash().Hex(), nil
 }
 
@@ -177,27 +124,6 @@ func ValidateTaskGroup(ctx context.Context, task1, task2, task3 *models.Inferenc
 	address := common.HexToAddress(appConfig.Blockchain.Account.Address)
 	privkey := appConfig.Blockchain.Account.PrivateKey
 
-	txMutex.Lock()
-	defer txMutex.Unlock()
-
-	auth, err := GetAuth(ctx, address, privkey)
-	if err != nil {
-		return "", err
-	}
-
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := getLimiter().Wait(callCtx); err != nil {
-		return "", err
-	}
-	auth.Context = callCtx
-
-	txNonce, err := getNonce(callCtx, address)
-	if err != nil {
-		return "", err
-	}
-	auth.Nonce = big.NewInt(int64(txNonce))
-
 	if !(task1.TaskID == task2.TaskID && task1.TaskID == task3.TaskID) {
 		return "", errors.New("taskID of tasks in group is not the same")
 	}
@@ -210,29 +136,38 @@ func ValidateTaskGroup(ctx context.Context, task1, task2, task3 *models.Inferenc
 	taskIDCommitment3, _ := utils.HexStrToBytes32(task3.TaskIDCommitment)
 	taskID, _ := utils.HexStrToBytes32(task1.TaskID)
 	vrfProof, _ := hexutil.Decode(task1.VRFProof)
-	privateKey, err := crypto.HexToECDSA(privkey)
+	publicKeyBytes, err := getPublicKeyBytes(privkey)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := sendTx(ctx, address, privkey, "validateTaskGroup", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return taskInstance.ValidateTaskGroup(auth, *taskIDCommitment1, *taskIDCommitment2, *taskIDCommitment3, *taskID, vrfProof, publicKeyBytes)
+	})
 	if err != nil {
 		return "", err
 	}
+	return tx.Hash().Hex(), nil
+}
+
+// getPublicKeyBytes returns the uncompressed public key of the private key without the prefix byte
+func getPublicKeyBytes(privkey string) ([]byte, error) {
+	privateKey, err := crypto.HexToECDSA(privkey)
+	if err != nil {
+		return nil, err
+	}
 
 	publicKey := privateKey.Public()
 
 	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
 	if !ok {
-		return "", errors.New("error casting public key to ECDSA")
+		return nil, errors.New("error casting public key to ECDSA")
 	}
 	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
 	if len(publicKeyBytes) != 65 {
-		return "", errors.New("umcompressed public key bytes length is not 65")
-	}
-	publicKeyBytes = publicKeyBytes[1:]
-	tx, err := taskInstance.ValidateTaskGroup(auth, *taskIDCommitment1, *taskIDCommitment2, *taskIDCommitment3, *taskID, vrfProof, publicKeyBytes)
-	if err != nil {
-		err = processSendingTxError(err)
-		return "", err
+		return nil, errors.New("umcompressed public key bytes length is not 65")
 	}
-	addNonce(txNonce)
-	return tx.Hash().Hex(), nil
+	return publicKeyBytes[1:], nil
 }
 
 func CancelTask(ctx context.Context, task *models.InferenceTask) (string, error) {
@@ -246,35 +181,14 @@ func CancelTask(ctx context.Context, task *models.InferenceTask) (string, error)
 	if err != nil {
 		return "", err
 	}
-	
-	txMutex.Lock()
-	defer txMutex.Unlock()
 
-	auth, err := GetAuth(ctx, address, privkey)
+	tx, err := sendTx(ctx, address, privkey, "abortTask", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return taskInstance.AbortTask(auth, *taskIDCommitment, uint8(models.TaskAbortTimeout))
+	})
 	if err != nil {
 		return "", err
 	}
-
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := getLimiter().Wait(callCtx); err != nil {
-		return "", err
-	}
-	auth.Context = callCtx
-
-	txNonce, err := getNonce(callCtx, address)
-	if err != nil {
-		return "", err
-	}
-	auth.Nonce = big.NewInt(int64(txNonce))
-
-	tx, err := taskInstance.AbortTask(auth, *taskIDCommitment, uint8(models.TaskAbortTimeout))
-	if err != nil {
-		err = processSendingTxError(err)
-		return "", err
-	}
-	addNonce(txNonce)
-	return tx.Hash().Hex(), err
+	return tx.Hash().Hex(), nil
 }
 
 func GetTaskResultCommitment(result []byte) (commitment [32]byte, nonce [32]byte) {
@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"context"
+	"crynux_bridge/blockchain/bindings"
+	"crynux_bridge/models"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// ErrTaskNotFound is returned when the task of the commitment is not created on chain
+var ErrTaskNotFound = errors.New("task not found on chain")
+
+// GetRelayTask reads the task from the Task contract, in the same form as the task returned by the relay.
+// The contract does not record the validated time and the result uploaded time of the task, they are left empty.
+func GetRelayTask(ctx context.Context, taskIDCommitment string) (*models.RelayTask, error) {
+	commitment, err := hexutil.Decode(taskIDCommitment)
+	if err != nil {
+		return nil, err
+	}
+	if len(commitment) != 32 {
+		return nil, errors.New("task id commitment length is not 32")
+	}
+	taskInfo, err := GetTaskByCommitment(ctx, [32]byte(commitment))
+	if err != nil {
+		return nil, err
+	}
+	// the contract returns the empty task of the commitments not created
+	if taskInfo.Creator == (common.Address{}) {
+		return nil, ErrTaskNotFound
+	}
+	return taskInfoToRelayTask(taskInfo), nil
+}
+
+func taskInfoToRelayTask(taskInfo *bindings.VSSTaskTaskInfo) *models.RelayTask {
+	task := &models.RelayTask{
+		Sequence:         taskInfo.Sequence.Uint64(),
+		TaskIDCommitment: hexutil.Encode(taskInfo.TaskIDCommitment[:]),
+		Creator:          taskInfo.Creator.Hex(),
+		SamplingSeed:     hexutil.Encode(taskInfo.SamplingSeed[:]),
+		Nonce:            hexutil.Encode(taskInfo.Nonce[:]),
+		Status:           models.ChainTaskStatus(taskInfo.Status),
+		TaskType:         models.ChainTaskType(taskInfo.TaskType),
+		TaskVersion:      formatVersion(taskInfo.TaskVersion),
+		Timeout:          taskInfo.Timeout.Uint64(),
+		MinVRAM:          taskInfo.MinimumVRAM.Uint64(),
+		RequiredGPU:      taskInfo.RequiredGPU,
+		RequiredGPUVRAM:  taskInfo.RequiredGPUVRAM.Uint64(),
+		TaskFee:          models.BigInt{Int: *new(big.Int).Set(taskInfo.TaskFee)},
+		TaskSize:         taskInfo.TaskSize.Uint64(),
+		ModelIDs:         taskInfo.ModelIDs,
+		AbortReason:      models.TaskAbortReason(taskInfo.AbortReason),
+		TaskError:        models.TaskError(taskInfo.Error),
+		CreateTime:       timestampToTime(taskInfo.CreateTimestamp),
+		StartTime:        timestampToTime(taskInfo.StartTimestamp),
+		ScoreReadyTime:   timestampToTime(taskInfo.ScoreReadyTimestamp),
+	}
+	if len(taskInfo.Score) > 0 {
+		task.Score = hexutil.Encode(taskInfo.Score)
+	}
+	if taskInfo.SelectedNode != (common.Address{}) {
+		task.SelectedNode = taskInfo.SelectedNode.Hex()
+	}
+	return task
+}
+
+func formatVersion(version [3]*big.Int) string {
+	return fmt.Sprintf("%d.%d.%d", version[0], version[1], version[2])
+}
+
+// timestampToTime converts the unix timestamp in the contract to time, 0 means the time is not set
+func timestampToTime(timestamp *big.Int) *time.Time {
+	if timestamp == nil || timestamp.Sign() == 0 {
+		return nil
+	}
+	t := time.Unix(timestamp.Int64(), 0)
+	return &t
+}
@@ -0,0 +1,285 @@
+package blockchain
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"crynux_bridge/utils"
+	"crypto/rand"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func randomBytes32Hex() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hexutil.Encode(b)
+}
+
+func newTestTask() *models.InferenceTask {
+	return &models.InferenceTask{
+		TaskType:         models.TaskTypeSD,
+		TaskModelIDs:     models.StringArray{"base:crynux-network/sdxl-turbo"},
+		TaskVersion:      "2.6.0",
+		TaskFee:          1000000000,
+		MinVram:          8,
+		RequiredGPU:      "NVIDIA GeForce RTX 4090",
+		RequiredGPUVram:  24,
+		TaskSize:         1,
+		TaskID:           randomBytes32Hex(),
+		TaskIDCommitment: randomBytes32Hex(),
+		Nonce:            randomBytes32Hex(),
+		VRFProof:         randomBytes32Hex(),
+	}
+}
+
+// waitTestTx waits for the receipt of the tx, which should have the status
+func waitTestTx(t *testing.T, txHash string, status uint64) *types.Receipt {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	receipt, err := WaitTxReceipt(ctx, common.HexToHash(txHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != status {
+		t.Fatalf("tx %s status should be %d: %d", txHash, status, receipt.Status)
+	}
+	return receipt
+}
+
+func getTestTaskStatus(t *testing.T, task *models.InferenceTask) models.ChainTaskStatus {
+	t.Helper()
+	taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
+	taskInfo, err := GetTaskByCommitment(context.Background(), *taskIDCommitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.ChainTaskStatus(taskInfo.Status)
+}
+
+func createTestTask(t *testing.T) *models.InferenceTask {
+	t.Helper()
+	task := newTestTask()
+	txHash, err := CreateTaskOnChain(context.Background(), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+	return task
+}
+
+func TestCreateTaskOnChain(t *testing.T) {
+	ctx := context.Background()
+	initTestChain(t)
+
+	task := newTestTask()
+	txHash, err := CreateTaskOnChain(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+
+	taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
+	taskInfo, err := GetTaskByCommitment(ctx, *taskIDCommitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskInfo.Creator != accountAddress() || models.ChainTaskStatus(taskInfo.Status) != models.ChainTaskQueued {
+		t.Errorf("task should be queued and created by the account: %s %d", taskInfo.Creator.Hex(), taskInfo.Status)
+	}
+	if taskInfo.TaskFee.Cmp(utils.GweiToWei(big.NewInt(int64(task.TaskFee)))) != 0 || taskInfo.TaskSize.Uint64() != task.TaskSize || taskInfo.MinimumVRAM.Uint64() != task.MinVram {
+		t.Errorf("task fee, size and min vram mismatch: %s %s %s", taskInfo.TaskFee, taskInfo.TaskSize, taskInfo.MinimumVRAM)
+	}
+	if len(taskInfo.ModelIDs) != 1 || taskInfo.ModelIDs[0] != task.TaskModelIDs[0] || taskInfo.RequiredGPU != task.RequiredGPU {
+		t.Errorf("task models and gpu mismatch: %v %s", taskInfo.ModelIDs, taskInfo.RequiredGPU)
+	}
+	if taskInfo.TaskVersion[0].Int64() != 2 || taskInfo.TaskVersion[1].Int64() != 6 || taskInfo.TaskVersion[2].Int64() != 0 {
+		t.Errorf("task version mismatch: %v", taskInfo.TaskVersion)
+	}
+
+	// the same task commitment is rejected by the contract
+	txHash, err = CreateTaskOnChain(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt := waitTestTx(t, txHash, types.ReceiptStatusFailed)
+	errMsg, err := GetErrorMessageFromReceipt(ctx, receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errMsg != "Task already exists" {
+		t.Errorf("revert reason mismatch: %s", errMsg)
+	}
+}
+
+func TestCreateTaskOnChainInvalidVersion(t *testing.T) {
+	chain := initTestChain(t)
+
+	task := newTestTask()
+	task.TaskVersion = "2.6"
+	if _, err := CreateTaskOnChain(context.Background(), task); err == nil {
+		t.Fatal("task of invalid version should not be created")
+	}
+	if nonce := chain.nonce(accountAddress()); nonce != 0 {
+		t.Errorf("no tx should be sent: %d", nonce)
+	}
+}
+
+func TestValidateSingleTask(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	task := createTestTask(t)
+
+	// the task cannot be validated before its score is submitted
+	txHash, err := ValidateSingleTask(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt := waitTestTx(t, txHash, types.ReceiptStatusFailed)
+	errMsg, err := GetErrorMessageFromReceipt(ctx, receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errMsg != "Illegal task state" {
+		t.Errorf("revert reason mismatch: %s", errMsg)
+	}
+
+	taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
+	chain.setTaskStatus(*taskIDCommitment, models.ChainTaskScoreReady)
+	txHash, err = ValidateSingleTask(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+	if status := getTestTaskStatus(t, task); status != models.ChainTaskValidated {
+		t.Errorf("task should be validated: %d", status)
+	}
+}
+
+func TestValidateTaskGroup(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+
+	var tasks []*models.InferenceTask
+	for i := 0; i < 3; i++ {
+		task := newTestTask()
+		if i > 0 {
+			task.TaskID = tasks[0].TaskID
+			task.VRFProof = tasks[0].VRFProof
+		}
+		task.Sequence = uint64(i + 1)
+		txHash, err := CreateTaskOnChain(ctx, task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+		taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
+		chain.setTaskStatus(*taskIDCommitment, models.ChainTaskScoreReady)
+		tasks = append(tasks, task)
+	}
+
+	// the group is checked before sending the tx
+	nonce := chain.nonce(accountAddress())
+	if _, err := ValidateTaskGroup(ctx, tasks[1], tasks[0], tasks[2]); err == nil {
+		t.Error("tasks of the group in wrong order should not be validated")
+	}
+	other := newTestTask()
+	other.Sequence = 4
+	if _, err := ValidateTaskGroup(ctx, tasks[0], tasks[1], other); err == nil {
+		t.Error("tasks of different task ids should not be validated in a group")
+	}
+	if n := chain.nonce(accountAddress()); n != nonce {
+		t.Errorf("no tx should be sent for the invalid groups: %d %d", nonce, n)
+	}
+
+	txHash, err := ValidateTaskGroup(ctx, tasks[0], tasks[1], tasks[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+	expected := []models.ChainTaskStatus{models.ChainTaskGroupValidated, models.ChainTaskEndGroupRefund, models.ChainTaskEndGroupRefund}
+	for i, task := range tasks {
+		if status := getTestTaskStatus(t, task); status != expected[i] {
+			t.Errorf("status of task %d should be %d: %d", i, expected[i], status)
+		}
+	}
+}
+
+func TestCancelTask(t *testing.T) {
+	ctx := context.Background()
+	initTestChain(t)
+	task := createTestTask(t)
+
+	txHash, err := CancelTask(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+	taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
+	taskInfo, err := GetTaskByCommitment(ctx, *taskIDCommitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if models.ChainTaskStatus(taskInfo.Status) != models.ChainTaskEndAborted || models.TaskAbortReason(taskInfo.AbortReason) != models.TaskAbortTimeout {
+		t.Errorf("task should be aborted for timeout: %d %d", taskInfo.Status, taskInfo.AbortReason)
+	}
+
+	// the aborted task cannot be cancelled again
+	txHash, err = CancelTask(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt := waitTestTx(t, txHash, types.ReceiptStatusFailed)
+	errMsg, err := GetErrorMessageFromReceipt(ctx, receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errMsg != "Illegal task state" {
+		t.Errorf("revert reason mismatch: %s", errMsg)
+	}
+
+	if _, err := CancelTask(ctx, &models.InferenceTask{TaskIDCommitment: "0xzz"}); err == nil {
+		t.Error("task of invalid commitment should not be cancelled")
+	}
+}
+
+func TestGetRelayTask(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	task := createTestTask(t)
+
+	relayTask, err := GetRelayTask(ctx, task.TaskIDCommitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relayTask.Status != models.ChainTaskQueued || relayTask.TaskIDCommitment != task.TaskIDCommitment || relayTask.Nonce != task.Nonce {
+		t.Errorf("task should be queued: %+v", relayTask)
+	}
+	if relayTask.TaskVersion != task.TaskVersion || relayTask.SelectedNode != "" || relayTask.StartTime != nil || relayTask.Score != "" {
+		t.Errorf("task should not be started: %+v", relayTask)
+	}
+
+	node := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	taskIDCommitment, _ := utils.HexStrToBytes32(task.TaskIDCommitment)
+	samplingSeed, _ := utils.HexStrToBytes32(randomBytes32Hex())
+	chain.setTaskStarted(*taskIDCommitment, node, *samplingSeed)
+	relayTask, err = GetRelayTask(ctx, task.TaskIDCommitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relayTask.Status != models.ChainTaskStarted || relayTask.SelectedNode != node.Hex() || relayTask.StartTime == nil {
+		t.Errorf("task should be started on the node: %+v", relayTask)
+	}
+	if relayTask.SamplingSeed != hexutil.Encode(samplingSeed[:]) {
+		t.Errorf("sampling seed mismatch: %s", relayTask.SamplingSeed)
+	}
+
+	if _, err := GetRelayTask(ctx, randomBytes32Hex()); err != ErrTaskNotFound {
+		t.Errorf("task not created should not be found: %v", err)
+	}
+}
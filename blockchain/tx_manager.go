@@ -0,0 +1,348 @@
+package blockchain
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrTxDropped means none of the transactions sent for the nonce is mined, and the nonce has been taken by another one
+var ErrTxDropped = errors.New("transaction dropped")
+
+// sendTx sends the transaction of the next nonce from the account, and saves it to be tracked until mined.
+// build gets the transact opts of the nonce with NoSend set, and returns the signed transaction.
+// Nothing is sent if build fails, so the checks of the arguments can be done in build.
+func sendTx(ctx context.Context, from common.Address, privateKeyStr string, method string, build func(auth *bind.TransactOpts) (*types.Transaction, error)) (*types.Transaction, error) {
+	unlock, err := lockTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	auth, err := GetAuth(ctx, from, privateKeyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := getLimiter().Wait(callCtx); err != nil {
+		return nil, err
+	}
+	auth.Context = callCtx
+	auth.NoSend = true
+
+	txNonce, err := getNonce(callCtx, from)
+	if err != nil {
+		return nil, err
+	}
+	auth.Nonce = new(big.Int).SetUint64(txNonce)
+
+	tx, err := build(auth)
+	if err != nil {
+		return nil, err
+	}
+	if err := GetRpcClient().SendTransaction(callCtx, tx); err != nil {
+		return nil, processSendingTxError(err)
+	}
+	addNonce(txNonce)
+
+	if err := saveTx(ctx, from, method, tx); err != nil {
+		// the transaction is sent anyway, it only cannot be replaced when stuck
+		log.Errorf("cannot save tx %s: %v", tx.Hash().Hex(), err)
+	}
+	return tx, nil
+}
+
+func saveTx(ctx context.Context, from common.Address, method string, tx *types.Transaction) error {
+	var to string
+	if tx.To() != nil {
+		to = tx.To().Hex()
+	}
+	value := "0"
+	if tx.Value() != nil {
+		value = tx.Value().String()
+	}
+	record := &models.BlockchainTransaction{
+		Account:   from.Hex(),
+		Nonce:     tx.Nonce(),
+		Method:    method,
+		To:        to,
+		Value:     value,
+		Data:      hexutil.Encode(tx.Data()),
+		GasLimit:  tx.Gas(),
+		TxType:    tx.Type(),
+		GasPrice:  tx.GasPrice().String(),
+		GasTipCap: tx.GasTipCap().String(),
+		TxHash:    tx.Hash().Hex(),
+		TxHashes:  models.StringArray{tx.Hash().Hex()},
+		Status:    models.BlockchainTransactionPending,
+		SentAt:    time.Now(),
+	}
+	return record.Save(ctx, config.GetDB())
+}
+
+// getReceipt returns the receipt of the first mined transaction of the hashes, or ethereum.NotFound if none is mined
+func getReceipt(ctx context.Context, txHashes []string) (*types.Receipt, error) {
+	client := GetRpcClient()
+	for _, txHash := range txHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		receipt, err := client.TransactionReceipt(ctx, common.HexToHash(txHash))
+		if err == nil {
+			return receipt, nil
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			return nil, err
+		}
+	}
+	return nil, ethereum.NotFound
+}
+
+// getTxReceipt returns the receipt of the transaction, or of the one replacing it if it has been replaced
+func getTxReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	txHashes := []string{txHash.Hex()}
+	record, err := models.GetBlockchainTransactionByHash(ctx, config.GetDB(), txHash.Hex())
+	if err == nil {
+		if record.Status == models.BlockchainTransactionDropped {
+			return nil, ErrTxDropped
+		}
+		txHashes = record.TxHashes
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Warnf("cannot get the replacements of tx %s: %v", txHash.Hex(), err)
+	}
+	return getReceipt(ctx, txHashes)
+}
+
+// ProcessPendingTransactions updates the pending transactions that are mined or dropped,
+// and replaces the ones stuck for longer than the stuck timeout with a higher gas price
+func ProcessPendingTransactions(ctx context.Context) error {
+	txs, err := models.GetPendingBlockchainTransactions(ctx, config.GetDB(), 100)
+	if err != nil {
+		return err
+	}
+	minedNonces := make(map[string]uint64)
+	for i := range txs {
+		if err := processPendingTx(ctx, &txs[i], minedNonces); err != nil {
+			log.Errorf("cannot process pending tx %s: %v", txs[i].TxHash, err)
+		}
+	}
+	return nil
+}
+
+// processPendingTx checks the pending transaction. minedNonces caches the nonce of the latest block of each account,
+// which is fetched before the receipts, so that a transaction mined in between is not taken as dropped.
+func processPendingTx(ctx context.Context, tx *models.BlockchainTransaction, minedNonces map[string]uint64) error {
+	minedNonce, ok := minedNonces[tx.Account]
+	if !ok {
+		callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		nonce, err := GetRpcClient().NonceAt(callCtx, common.HexToAddress(tx.Account), nil)
+		cancel()
+		if err != nil {
+			return err
+		}
+		minedNonce = nonce
+		minedNonces[tx.Account] = nonce
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	receipt, err := getReceipt(callCtx, tx.TxHashes)
+	cancel()
+	if err == nil {
+		return markTxMined(ctx, tx, receipt)
+	}
+	if !errors.Is(err, ethereum.NotFound) {
+		return err
+	}
+
+	if tx.Nonce < minedNonce {
+		log.Warnf("tx %s of nonce %d is dropped, the nonce is taken by another tx", tx.TxHash, tx.Nonce)
+		return tx.Update(ctx, config.GetDB(), map[string]interface{}{
+			"status": models.BlockchainTransactionDropped,
+		})
+	}
+	if time.Since(tx.SentAt) < config.GetTxStuckTimeout() {
+		return nil
+	}
+	return replaceTx(ctx, tx)
+}
+
+func markTxMined(ctx context.Context, tx *models.BlockchainTransaction, receipt *types.Receipt) error {
+	values := map[string]interface{}{
+		"status":        models.BlockchainTransactionSuccess,
+		"mined_tx_hash": receipt.TxHash.Hex(),
+		"block_number":  receipt.BlockNumber.Uint64(),
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		values["status"] = models.BlockchainTransactionReverted
+		errMsg, err := GetErrorMessageFromReceipt(ctx, receipt)
+		if err != nil {
+			log.Errorf("cannot get the revert reason of tx %s: %v", receipt.TxHash.Hex(), err)
+		} else {
+			values["last_error"] = errMsg
+		}
+	}
+	return tx.Update(ctx, config.GetDB(), values)
+}
+
+// replaceTx sends the transaction again with the same nonce and a higher gas price.
+// Only the transactions of the account of the bridge are replaced, the keys of other accounts are not kept.
+// The transaction lease is held like sendTx, so that the nonce is not bumped by two bridges at the same time.
+func replaceTx(ctx context.Context, tx *models.BlockchainTransaction) error {
+	account := config.GetConfig().Blockchain.Account
+	if common.HexToAddress(tx.Account) != common.HexToAddress(account.Address) {
+		return nil
+	}
+
+	unlock, err := lockTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the transaction may have been mined or replaced by another bridge before the lease is acquired
+	current, err := models.GetBlockchainTransactionByHash(ctx, config.GetDB(), tx.TxHash)
+	if err != nil {
+		return err
+	}
+	if current.Status != models.BlockchainTransactionPending || current.TxHash != tx.TxHash {
+		return nil
+	}
+	tx = current
+
+	newTx, err := buildReplacementTx(tx)
+	if err != nil {
+		return err
+	}
+	if newTx == nil {
+		log.Warnf("tx %s is stuck at the max gas price %s", tx.TxHash, tx.GasPrice)
+		return nil
+	}
+	privateKey, err := crypto.HexToECDSA(account.PrivateKey)
+	if err != nil {
+		return err
+	}
+	newTx, err = types.SignTx(newTx, types.LatestSignerForChainID(getChainID()), privateKey)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := getLimiter().Wait(callCtx); err != nil {
+		return err
+	}
+	if err := GetRpcClient().SendTransaction(callCtx, newTx); err != nil {
+		// the stuck transaction may be mined in between, which is found in the next round
+		if updateErr := tx.Update(ctx, config.GetDB(), map[string]interface{}{"last_error": err.Error()}); updateErr != nil {
+			log.Errorf("cannot save the error of tx %s: %v", tx.TxHash, updateErr)
+		}
+		return err
+	}
+	log.Infof("tx %s of nonce %d is replaced by %s with gas price %s", tx.TxHash, tx.Nonce, newTx.Hash().Hex(), newTx.GasPrice())
+
+	txHashes := append(models.StringArray{}, tx.TxHashes...)
+	txHashes = append(txHashes, newTx.Hash().Hex())
+	return tx.Update(ctx, config.GetDB(), map[string]interface{}{
+		"tx_hash":      newTx.Hash().Hex(),
+		"tx_hashes":    txHashes,
+		"gas_price":    newTx.GasPrice().String(),
+		"gas_tip_cap":  newTx.GasTipCap().String(),
+		"replacements": tx.Replacements + 1,
+		"sent_at":      time.Now(),
+		"last_error":   "",
+	})
+}
+
+// buildReplacementTx returns the unsigned replacement of the same type as the transaction, with the gas price bumped,
+// or the tip and the fee cap for a dynamic fee transaction. It returns nil if the gas price has reached the max.
+func buildReplacementTx(tx *models.BlockchainTransaction) (*types.Transaction, error) {
+	// the bridge does not deploy contracts, a transaction without the receiver is not sent by it
+	if len(tx.To) == 0 {
+		return nil, fmt.Errorf("cannot replace tx %s without the receiver", tx.TxHash)
+	}
+	to := common.HexToAddress(tx.To)
+	value, ok := new(big.Int).SetString(tx.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid value %s", tx.Value)
+	}
+	data, err := hexutil.Decode(tx.Data)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, ok := new(big.Int).SetString(tx.GasPrice, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid gas price %s", tx.GasPrice)
+	}
+	newGasPrice := bumpGasPrice(gasPrice)
+	if newGasPrice == nil {
+		return nil, nil
+	}
+
+	switch tx.TxType {
+	case types.LegacyTxType:
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    tx.Nonce,
+			GasPrice: newGasPrice,
+			Gas:      tx.GasLimit,
+			To:       &to,
+			Value:    value,
+			Data:     data,
+		}), nil
+	case types.DynamicFeeTxType:
+		gasTipCap, ok := new(big.Int).SetString(tx.GasTipCap, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid gas tip cap %s", tx.GasTipCap)
+		}
+		// the node requires both the tip and the fee cap to be bumped, and the tip cannot exceed the fee cap
+		newGasTipCap := bumpGasPrice(gasTipCap)
+		if newGasTipCap == nil || newGasTipCap.Cmp(newGasPrice) > 0 {
+			newGasTipCap = newGasPrice
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:   getChainID(),
+			Nonce:     tx.Nonce,
+			GasTipCap: newGasTipCap,
+			GasFeeCap: newGasPrice,
+			Gas:       tx.GasLimit,
+			To:        &to,
+			Value:     value,
+			Data:      data,
+		}), nil
+	default:
+		return nil, fmt.Errorf("cannot replace tx %s of type %d", tx.TxHash, tx.TxType)
+	}
+}
+
+// bumpGasPrice returns the gas price of the replacement, or nil if the gas price has reached the max
+func bumpGasPrice(gasPrice *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(100+config.GetTxGasPriceBump()))
+	bumped.Div(bumped, big.NewInt(100))
+	if bumped.Cmp(gasPrice) <= 0 {
+		bumped.Add(gasPrice, big.NewInt(1))
+	}
+	if maxGasPrice := config.GetTxMaxGasPrice(); maxGasPrice > 0 {
+		limit := new(big.Int).SetUint64(maxGasPrice)
+		if gasPrice.Cmp(limit) >= 0 {
+			return nil
+		}
+		if bumped.Cmp(limit) > 0 {
+			bumped = limit
+		}
+	}
+	return bumped
+}
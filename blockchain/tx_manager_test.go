@@ -0,0 +1,314 @@
+package blockchain
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func getTestTxRecord(t *testing.T, txHash string) *models.BlockchainTransaction {
+	t.Helper()
+	record, err := models.GetBlockchainTransactionByHash(context.Background(), config.GetDB(), txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func processTestTxs(t *testing.T) {
+	t.Helper()
+	if err := ProcessPendingTransactions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setTestTxStuck moves the sent time of the tx back past the stuck timeout
+func setTestTxStuck(t *testing.T, record *models.BlockchainTransaction) {
+	t.Helper()
+	err := record.Update(context.Background(), config.GetDB(), map[string]interface{}{
+		"sent_at": time.Now().Add(-config.GetTxStuckTimeout() - time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrackTransactions(t *testing.T) {
+	ctx := context.Background()
+	initTestChain(t)
+
+	task := newTestTask()
+	txHash, err := CreateTaskOnChain(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := getTestTxRecord(t, txHash)
+	if record.Status != models.BlockchainTransactionPending || record.Method != "createTask" || record.Nonce != 0 {
+		t.Fatalf("tx should be saved as pending: %+v", record)
+	}
+	if record.Value != big.NewInt(int64(task.TaskFee)*1000000000).String() || record.To != config.GetConfig().Blockchain.Contracts.Task {
+		t.Errorf("value and receiver of the tx mismatch: %s %s", record.Value, record.To)
+	}
+
+	// the same task commitment is reverted by the contract
+	revertedHash, err := CreateTaskOnChain(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processTestTxs(t)
+	record = getTestTxRecord(t, txHash)
+	if record.Status != models.BlockchainTransactionSuccess || record.MinedTxHash != txHash || record.BlockNumber != 1 {
+		t.Errorf("tx should be mined: %+v", record)
+	}
+	record = getTestTxRecord(t, revertedHash)
+	if record.Status != models.BlockchainTransactionReverted || record.LastError != "Task already exists" {
+		t.Errorf("tx should be reverted: %+v", record)
+	}
+
+	txs, total, err := models.GetBlockchainTransactions(ctx, config.GetDB(), models.BlockchainTransactionSuccess, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(txs) != 1 || txs[0].TxHash != txHash {
+		t.Errorf("only the mined tx should be listed: %d %v", total, txs)
+	}
+}
+
+func TestReplaceStuckTx(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	chain.holdTxs = true
+
+	task := newTestTask()
+	txHash, err := CreateTaskOnChain(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tx is not replaced before the stuck timeout
+	processTestTxs(t)
+	record := getTestTxRecord(t, txHash)
+	if record.Status != models.BlockchainTransactionPending || len(record.TxHashes) != 1 {
+		t.Fatalf("tx should be pending without replacements: %+v", record)
+	}
+
+	setTestTxStuck(t, record)
+	processTestTxs(t)
+	record = getTestTxRecord(t, txHash)
+	if record.Replacements != 1 || len(record.TxHashes) != 2 || record.TxHash == txHash || record.TxHashes[0] != txHash {
+		t.Fatalf("tx should be replaced: %+v", record)
+	}
+	if record.GasPrice != big.NewInt(testGasPrice*120/100).String() {
+		t.Errorf("gas price should be bumped by 20%%: %s", record.GasPrice)
+	}
+	replacedHash := record.TxHash
+
+	// the next tx takes the nonce after the stuck one
+	nextHash, err := CreateTaskOnChain(ctx, newTestTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := getTestTxRecord(t, nextHash); next.Nonce != 1 {
+		t.Errorf("next tx should take nonce 1: %d", next.Nonce)
+	}
+
+	chain.mine()
+	// the receipt of the stuck tx is found from the one replacing it
+	receipt := waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+	if receipt.TxHash != common.HexToHash(replacedHash) {
+		t.Errorf("the replacement should be mined: %s", receipt.TxHash.Hex())
+	}
+
+	processTestTxs(t)
+	record = getTestTxRecord(t, txHash)
+	if record.Status != models.BlockchainTransactionSuccess || record.MinedTxHash != replacedHash {
+		t.Errorf("tx should be mined by the replacement: %+v", record)
+	}
+	if next := getTestTxRecord(t, nextHash); next.Status != models.BlockchainTransactionSuccess {
+		t.Errorf("next tx should be mined: %+v", next)
+	}
+	// the replacement lands on chain in place of the stuck tx
+	if status := getTestTaskStatus(t, task); status != models.ChainTaskQueued {
+		t.Errorf("task of the replaced tx should be created on chain: %d", status)
+	}
+	if nonce, _ := chain.NonceAt(ctx, accountAddress(), nil); nonce != 2 {
+		t.Errorf("the replacement and the next tx should take nonce 0 and 1: %d", nonce)
+	}
+}
+
+func TestReplaceStuckTxLease(t *testing.T) {
+	ctx := context.Background()
+	db := config.GetDB()
+	chain := initTestChain(t)
+	chain.holdTxs = true
+
+	txHash, err := CreateTaskOnChain(ctx, newTestTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestTxStuck(t, getTestTxRecord(t, txHash))
+
+	// another bridge holds the transaction lease, the tx is not replaced until it is released
+	const other = "other-bridge"
+	if acquired, err := models.AcquireLeaderLease(ctx, db, txLeaseName, other, time.Minute); err != nil || !acquired {
+		t.Fatalf("cannot acquire the lease: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		processCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		done <- ProcessPendingTransactions(processCtx)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	if record := getTestTxRecord(t, txHash); record.Replacements != 0 {
+		t.Fatalf("tx should not be replaced without the lease: %+v", record)
+	}
+
+	// the other bridge replaces the tx before releasing the lease
+	record := getTestTxRecord(t, txHash)
+	err = record.Update(ctx, db, map[string]interface{}{
+		"tx_hash":      "0x01",
+		"tx_hashes":    models.StringArray{txHash, "0x01"},
+		"replacements": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.ReleaseLeaderLease(ctx, db, txLeaseName, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if record := getTestTxRecord(t, txHash); record.Replacements != 1 || record.TxHash != "0x01" {
+		t.Errorf("tx replaced by the other bridge should not be replaced again: %+v", record)
+	}
+}
+
+func TestReplaceDynamicFeeTx(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	chain.holdTxs = true
+
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	sent, err := sendTx(ctx, accountAddress(), config.GetConfig().Blockchain.Account.PrivateKey, "transfer", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return auth.Signer(auth.From, types.NewTx(&types.DynamicFeeTx{
+			ChainID:   getChainID(),
+			Nonce:     auth.Nonce.Uint64(),
+			GasTipCap: big.NewInt(testGasPrice / 10),
+			GasFeeCap: big.NewInt(testGasPrice),
+			Gas:       21000,
+			To:        &to,
+			Value:     big.NewInt(1),
+		}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash := sent.Hash().Hex()
+
+	setTestTxStuck(t, getTestTxRecord(t, txHash))
+	processTestTxs(t)
+	record := getTestTxRecord(t, txHash)
+	if record.Replacements != 1 || record.TxType != types.DynamicFeeTxType {
+		t.Fatalf("tx should be replaced by a dynamic fee tx: %+v", record)
+	}
+	if record.GasPrice != big.NewInt(testGasPrice*120/100).String() || record.GasTipCap != big.NewInt(testGasPrice/10*120/100).String() {
+		t.Errorf("fee cap and tip should be bumped by 20%%: %s %s", record.GasPrice, record.GasTipCap)
+	}
+
+	chain.mine()
+	receipt := waitTestTx(t, txHash, types.ReceiptStatusSuccessful)
+	if receipt.TxHash != common.HexToHash(record.TxHash) {
+		t.Errorf("the replacement should be mined: %s", receipt.TxHash.Hex())
+	}
+	replacement, _, err := chain.TransactionByHash(ctx, receipt.TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replacement.Type() != types.DynamicFeeTxType || *replacement.To() != to || replacement.Value().Int64() != 1 {
+		t.Errorf("the replacement should be a copy of the tx: %d %s %s", replacement.Type(), replacement.To().Hex(), replacement.Value())
+	}
+}
+
+func TestReplaceTxWithoutReceiver(t *testing.T) {
+	record := &models.BlockchainTransaction{
+		Value:    "0",
+		Data:     "0x00",
+		GasPrice: big.NewInt(testGasPrice).String(),
+	}
+	if _, err := buildReplacementTx(record); err == nil {
+		t.Error("tx without the receiver should not be replaced")
+	}
+}
+
+func TestReplaceStuckTxMaxGasPrice(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	chain.holdTxs = true
+	conf := config.GetConfig()
+	conf.Blockchain.Transaction.MaxGasPrice = testGasPrice
+	defer func() { conf.Blockchain.Transaction.MaxGasPrice = 0 }()
+
+	txHash, err := CreateTaskOnChain(ctx, newTestTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestTxStuck(t, getTestTxRecord(t, txHash))
+	processTestTxs(t)
+	record := getTestTxRecord(t, txHash)
+	if record.Replacements != 0 || record.Status != models.BlockchainTransactionPending {
+		t.Errorf("tx at the max gas price should not be replaced: %+v", record)
+	}
+}
+
+func TestBumpGasPrice(t *testing.T) {
+	conf := config.GetConfig()
+	defer func() { conf.Blockchain.Transaction.MaxGasPrice = 0 }()
+
+	if gasPrice := bumpGasPrice(big.NewInt(100)); gasPrice.Int64() != 120 {
+		t.Errorf("gas price should be bumped by 20%%: %s", gasPrice)
+	}
+	if gasPrice := bumpGasPrice(big.NewInt(1)); gasPrice.Int64() != 2 {
+		t.Errorf("tiny gas price should be bumped by at least 1: %s", gasPrice)
+	}
+	conf.Blockchain.Transaction.MaxGasPrice = 110
+	if gasPrice := bumpGasPrice(big.NewInt(100)); gasPrice.Int64() != 110 {
+		t.Errorf("gas price should be capped by the max: %s", gasPrice)
+	}
+	if gasPrice := bumpGasPrice(big.NewInt(110)); gasPrice != nil {
+		t.Errorf("gas price at the max should not be bumped: %s", gasPrice)
+	}
+}
+
+func TestDroppedTx(t *testing.T) {
+	ctx := context.Background()
+	chain := initTestChain(t)
+	chain.holdTxs = true
+
+	txHash, err := CreateTaskOnChain(ctx, newTestTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another tx of the account takes the nonce
+	chain.takeNonce(accountAddress())
+	processTestTxs(t)
+	if record := getTestTxRecord(t, txHash); record.Status != models.BlockchainTransactionDropped {
+		t.Fatalf("tx should be dropped: %+v", record)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if _, err := WaitTxReceipt(waitCtx, common.HexToHash(txHash)); !errors.Is(err, ErrTxDropped) {
+		t.Errorf("waiting for the dropped tx should fail: %v", err)
+	}
+}
@@ -34,11 +34,45 @@ type AppConfig struct {
 		Port string `mapstructure:"port"`
 	} `mapstructure:"http"`
 
+	Cluster struct {
+		// unique id of this bridge instance, generated from the hostname if empty
+		InstanceID    string `mapstructure:"instance_id"`
+		LeaseDuration uint64 `mapstructure:"lease_duration"` // seconds
+		// how long to wait for the running workers to finish on shutdown
+		ShutdownTimeout uint64 `mapstructure:"shutdown_timeout"` // seconds
+	} `mapstructure:"cluster"`
+
 	DataDir struct {
 		InferenceTasks string `mapstructure:"inference_tasks"`
 		ModelImages    string `mapstructure:"model_images"`
 	} `mapstructure:"data_dir"`
 
+	Storage struct {
+		Type string   `mapstructure:"type"` // local (default) or s3, local results are kept in DataDir.InferenceTasks
+		S3   S3Config `mapstructure:"s3"`
+	} `mapstructure:"storage"`
+
+	// Retention deletes the result files of the ended tasks once they have been kept for the ttl.
+	// 0 keeps the results forever. The results of the auto tasks are deleted as soon as they are downloaded.
+	Retention struct {
+		SDTTL         uint64            `mapstructure:"sd_ttl"`          // hours
+		LLMTTL        uint64            `mapstructure:"llm_ttl"`         // hours
+		SDFinetuneTTL uint64            `mapstructure:"sd_finetune_ttl"` // hours
+		Clients       []ClientRetention `mapstructure:"clients"`
+		SweepInterval uint64            `mapstructure:"sweep_interval"` // seconds
+	} `mapstructure:"retention"`
+
+	// ResultVerification checks the downloaded results against the hashes submitted by the nodes
+	ResultVerification struct {
+		Enabled bool `mapstructure:"enabled"`
+		// downloads of the mismatched results before the task fails
+		MaxDownloads int `mapstructure:"max_downloads"`
+		// max hamming distance between the pHash of a downloaded image and the one submitted by the node
+		PHashDistance int `mapstructure:"phash_distance"`
+		// max verification replicas of a task, which run besides it to compare their results
+		MaxReplicas int `mapstructure:"max_replicas"`
+	} `mapstructure:"result_verification"`
+
 	Blockchain struct {
 		RPS           uint64 `mapstructure:"rps"`
 		RpcEndpoint   string `mapstructure:"rpc_endpoint"`
@@ -58,28 +92,83 @@ type AppConfig struct {
 			Task     string `mapstructure:"task"`
 			Node     string `mapstructure:"node"`
 		} `mapstructure:"contracts"`
+
+		// Transaction is how the transactions stuck in the mempool are replaced with a higher gas price
+		Transaction struct {
+			StuckTimeout uint64 `mapstructure:"stuck_timeout"`  // seconds before a pending transaction is replaced
+			GasPriceBump uint64 `mapstructure:"gas_price_bump"` // percent of the gas price added by each replacement
+			MaxGasPrice  uint64 `mapstructure:"max_gas_price"`  // wei, the gas price is not bumped over it if not 0
+		} `mapstructure:"transaction"`
 	} `mapstructure:"blockchain"`
 
 	Relay struct {
 		BaseURL string `mapstructure:"base_url"`
 	} `mapstructure:"relay"`
 
+	// TaskBackend is where the tasks are created, validated and cancelled, and their states are read from.
+	// The chain backend sends the transactions itself and reads the contracts, the task args and the results
+	// are still exchanged with the nodes through the relay. It does not support the sd finetune tasks,
+	// because the checkpoints of their segments can only be uploaded with the tasks created on the relay.
+	TaskBackend struct {
+		Type string `mapstructure:"type"` // relay (default) or chain
+	} `mapstructure:"task_backend"`
+
 	Task struct {
-		SDTaskFee                     uint64    `mapstructure:"sd_task_fee"`
-		SDXLTaskFee                   uint64    `mapstructure:"sd_xl_task_fee"`
-		LLMTaskFee                    uint64    `mapstructure:"llm_task_fee"`
-		LLMQuantTaskFee               uint64    `mapstructure:"llm_quant_task_fee"`
-		RepeatNum                     int       `mapstructure:"repeat_num"`
-		PendingAutoTasksLimit         uint64    `mapstructure:"pending_auto_tasks_limit"`
-		PendingLargeVramLLMTasksLimit uint64    `mapstructure:"pending_large_vram_llm_tasks_limit"`
-		AutoTasksBatchSize            uint64    `mapstructure:"auto_tasks_batch_size"`
-		DefaultTimeout                uint64    `mapstructure:"default_timeout"`
-		SDFinetuneTimeout             uint64    `mapstructure:"sd_finetune_timeout"`
-		TaskVersions                  []string  `mapstructure:"task_versions"`
-		AutoTaskVersionRatio          []float64 `mapstructure:"auto_task_version_ratio"`
-		AutoTaskTypeRatio             []float64 `mapstructure:"auto_task_type_ratio"`
+		SDTaskFee                     uint64      `mapstructure:"sd_task_fee"`
+		SDXLTaskFee                   uint64      `mapstructure:"sd_xl_task_fee"`
+		LLMTaskFee                    uint64      `mapstructure:"llm_task_fee"`
+		LLMQuantTaskFee               uint64      `mapstructure:"llm_quant_task_fee"`
+		RepeatNum                     int         `mapstructure:"repeat_num"`
+		PendingAutoTasksLimit         uint64      `mapstructure:"pending_auto_tasks_limit"`
+		PendingLargeVramLLMTasksLimit uint64      `mapstructure:"pending_large_vram_llm_tasks_limit"`
+		AutoTasksBatchSize            uint64      `mapstructure:"auto_tasks_batch_size"`
+		DefaultTimeout                uint64      `mapstructure:"default_timeout"`
+		SDFinetuneTimeout             uint64      `mapstructure:"sd_finetune_timeout"`
+		TaskVersions                  []string    `mapstructure:"task_versions"`
+		AutoTaskVersionRatio          []float64   `mapstructure:"auto_task_version_ratio"`
+		AutoTaskTypeRatio             []float64   `mapstructure:"auto_task_type_ratio"`
+		AutoTaskTemplates             string      `mapstructure:"auto_task_templates"` // yaml or json file of the auto task templates, the built-in templates if empty
+		SDRetryPolicy                 RetryPolicy `mapstructure:"sd_retry_policy"`
+		LLMRetryPolicy                RetryPolicy `mapstructure:"llm_retry_policy"`
+		SDFinetuneRetryPolicy         RetryPolicy `mapstructure:"sd_finetune_retry_policy"`
+		SDFinetuneTaskFee             uint64      `mapstructure:"sd_finetune_task_fee"`
+		SDFinetuneSecondsPerStep      float64     `mapstructure:"sd_finetune_seconds_per_step"` // at 512x512 resolution and batch size 1
+		SchedulerWorkers              int         `mapstructure:"scheduler_workers"`
 	} `mapstructure:"task"`
 
+	Submission struct {
+		MaxInFlight          int                `mapstructure:"max_in_flight"`            // in-flight relay tasks of all clients, 0 means no limit
+		MaxInFlightPerClient int                `mapstructure:"max_in_flight_per_client"` // 0 means no limit
+		RealtimeReserved     int                `mapstructure:"realtime_reserved"`        // in-flight slots only realtime tasks can take
+		Weights              map[string]float64 `mapstructure:"weights"`                  // fair queuing weight of each priority class
+	} `mapstructure:"submission"`
+
+	FeePolicy struct {
+		SDBaseFee         uint64  `mapstructure:"sd_base_fee"`          // GWei per image
+		LLMBaseFee        uint64  `mapstructure:"llm_base_fee"`         // GWei
+		SDFinetuneBaseFee uint64  `mapstructure:"sd_finetune_base_fee"` // GWei per segment
+		QueueTarget       int64   `mapstructure:"queue_target"`         // queued relay tasks above which the fee rises
+		QueueWeight       float64 `mapstructure:"queue_weight"`         // fee increase per queue target of extra queued tasks
+		LatencyTarget     uint64  `mapstructure:"latency_target"`       // seconds, start latency above which the fee rises
+		LatencyWeight     float64 `mapstructure:"latency_weight"`       // fee increase per latency target of extra start latency
+		LatencyWindow     uint64  `mapstructure:"latency_window"`       // seconds of recent tasks the start latency is measured on
+		MaxMultiplier     float64 `mapstructure:"max_multiplier"`       // max ratio of the initial fee to the base fee
+		MaxTaskFee        uint64  `mapstructure:"max_task_fee"`         // GWei, default max fee of the api keys, 0 means no limit
+	} `mapstructure:"fee_policy"`
+
+	Idempotency struct {
+		TTL uint64 `mapstructure:"ttl"` // hours an Idempotency-Key is kept
+	} `mapstructure:"idempotency"`
+
+	Webhook struct {
+		Timeout        uint64  `mapstructure:"timeout"`         // seconds
+		MaxAttempts    int     `mapstructure:"max_attempts"`    // deliveries failed this many times are dead-lettered
+		InitialBackoff uint64  `mapstructure:"initial_backoff"` // seconds
+		MaxBackoff     uint64  `mapstructure:"max_backoff"`     // seconds
+		Workers        int     `mapstructure:"workers"`
+		Multiplier     float64 `mapstructure:"multiplier"`
+	} `mapstructure:"webhook"`
+
 	TaskSchema struct {
 		StableDiffusionInference    string `mapstructure:"stable_diffusion_inference"`
 		GPTInference                string `mapstructure:"gpt_inference"`
@@ -95,3 +184,114 @@ type AppConfig struct {
 		RootPrivateKey string `mapstructure:"root_private_key"`
 	} `mapstructure:"test"`
 }
+
+// ClientRetention overrides the ttl of the results of all the task types of a client
+type ClientRetention struct {
+	ClientID string `mapstructure:"client_id"`
+	TTL      uint64 `mapstructure:"ttl"` // hours, 0 keeps the results forever
+}
+
+// S3Config is an S3-compatible object storage for the task results, like AWS S3 or MinIO
+type S3Config struct {
+	Endpoint        string `mapstructure:"endpoint"` // e.g. https://s3.us-east-1.amazonaws.com or http://127.0.0.1:9000
+	Region          string `mapstructure:"region"`
+	Bucket          string `mapstructure:"bucket"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	Prefix          string `mapstructure:"prefix"`         // prepended to the keys of the results
+	UsePathStyle    bool   `mapstructure:"use_path_style"` // bucket in the path instead of the host name, required by MinIO
+}
+
+// RetryPolicy controls how a failed task is resubmitted.
+// Zero values fall back to the defaults in RetryPolicy.WithDefaults.
+type RetryPolicy struct {
+	MaxAttempts       int     `mapstructure:"max_attempts" json:"max_attempts"`
+	InitialBackoff    uint64  `mapstructure:"initial_backoff" json:"initial_backoff"` // seconds
+	MaxBackoff        uint64  `mapstructure:"max_backoff" json:"max_backoff"`         // seconds
+	BackoffMultiplier float64 `mapstructure:"backoff_multiplier" json:"backoff_multiplier"`
+	FeeIncreaseRatio  float64 `mapstructure:"fee_increase_ratio" json:"fee_increase_ratio"`
+	MaxTaskFee        uint64  `mapstructure:"max_task_fee" json:"max_task_fee"` // GWei, 0 means no limit
+	VramIncrease      uint64  `mapstructure:"vram_increase" json:"vram_increase"`
+	MaxVram           uint64  `mapstructure:"max_vram" json:"max_vram"`
+	// Actions maps a failure reason (timeout, model_download_failed, incorrect_result,
+	// task_fee_too_low, task_error, invalidated, group_refund, result_mismatch) to one of
+	// retry, raise_fee or fail. The relay reports no out of memory errors, so no reason calls for
+	// more vram: VramIncrease and MaxVram only apply to the policies saved with raise_vram before.
+	Actions map[string]string `mapstructure:"actions" json:"actions"`
+}
+
+// Merge returns a copy of p with the non-zero fields of override applied
+func (p RetryPolicy) Merge(override RetryPolicy) RetryPolicy {
+	if override.MaxAttempts > 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.InitialBackoff > 0 {
+		p.InitialBackoff = override.InitialBackoff
+	}
+	if override.MaxBackoff > 0 {
+		p.MaxBackoff = override.MaxBackoff
+	}
+	if override.BackoffMultiplier > 0 {
+		p.BackoffMultiplier = override.BackoffMultiplier
+	}
+	if override.FeeIncreaseRatio > 0 {
+		p.FeeIncreaseRatio = override.FeeIncreaseRatio
+	}
+	if override.MaxTaskFee > 0 {
+		p.MaxTaskFee = override.MaxTaskFee
+	}
+	if override.VramIncrease > 0 {
+		p.VramIncrease = override.VramIncrease
+	}
+	if override.MaxVram > 0 {
+		p.MaxVram = override.MaxVram
+	}
+	actions := make(map[string]string)
+	for k, v := range p.Actions {
+		actions[k] = v
+	}
+	for k, v := range override.Actions {
+		actions[k] = v
+	}
+	p.Actions = actions
+	return p
+}
+
+func (p RetryPolicy) WithDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 4
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = 10
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 300
+	}
+	if p.BackoffMultiplier < 1 {
+		p.BackoffMultiplier = 2
+	}
+	if p.FeeIncreaseRatio <= 1 {
+		p.FeeIncreaseRatio = 1.2
+	}
+	if p.VramIncrease == 0 {
+		p.VramIncrease = 8
+	}
+	if p.MaxVram == 0 {
+		p.MaxVram = 80
+	}
+	actions := map[string]string{
+		"timeout":               "raise_fee",
+		"model_download_failed": "retry",
+		"incorrect_result":      "retry",
+		"task_fee_too_low":      "raise_fee",
+		"task_error":            "fail",
+		"invalidated":           "retry",
+		"group_refund":          "retry",
+		"result_mismatch":       "retry",
+	}
+	for k, v := range p.Actions {
+		actions[k] = v
+	}
+	p.Actions = actions
+	return p
+}
@@ -0,0 +1,33 @@
+package config
+
+import "time"
+
+// GetTxStuckTimeout returns how long a transaction stays in the mempool before it is replaced
+func GetTxStuckTimeout() time.Duration {
+	if appConfig == nil || appConfig.Blockchain.Transaction.StuckTimeout == 0 {
+		return 2 * time.Minute
+	}
+	return time.Duration(appConfig.Blockchain.Transaction.StuckTimeout) * time.Second
+}
+
+// GetTxGasPriceBump returns the percent of the gas price added each time a stuck transaction is replaced.
+// Geth nodes reject the replacement of less than 10 percent.
+func GetTxGasPriceBump() uint64 {
+	if appConfig == nil || appConfig.Blockchain.Transaction.GasPriceBump == 0 {
+		return 20
+	}
+	return appConfig.Blockchain.Transaction.GasPriceBump
+}
+
+// GetTxMaxGasPrice returns the gas price a stuck transaction is not bumped over, 0 for no limit
+func GetTxMaxGasPrice() uint64 {
+	if appConfig == nil {
+		return 0
+	}
+	return appConfig.Blockchain.Transaction.MaxGasPrice
+}
+
+// IsChainTaskBackend reports whether the tasks are created on chain by the bridge instead of the relay
+func IsChainTaskBackend() bool {
+	return appConfig != nil && appConfig.TaskBackend.Type == "chain"
+}
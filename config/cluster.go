@@ -0,0 +1,48 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var instanceID string
+var instanceIDOnce sync.Once
+
+// GetInstanceID returns the id used by this bridge instance to lease tasks
+func GetInstanceID() string {
+	instanceIDOnce.Do(func() {
+		if appConfig != nil && appConfig.Cluster.InstanceID != "" {
+			instanceID = appConfig.Cluster.InstanceID
+			return
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "bridge"
+		}
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		instanceID = fmt.Sprintf("%s-%s", hostname, hex.EncodeToString(suffix))
+	})
+	return instanceID
+}
+
+// GetLeaseDuration returns how long a task or a singleton loop is leased to an instance
+// before other instances can take it over
+func GetLeaseDuration() time.Duration {
+	if appConfig == nil || appConfig.Cluster.LeaseDuration == 0 {
+		return time.Minute
+	}
+	return time.Duration(appConfig.Cluster.LeaseDuration) * time.Second
+}
+
+// GetShutdownTimeout returns how long the running workers are waited for on shutdown
+func GetShutdownTimeout() time.Duration {
+	if appConfig == nil || appConfig.Cluster.ShutdownTimeout == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(appConfig.Cluster.ShutdownTimeout) * time.Second
+}
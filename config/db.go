@@ -60,3 +60,15 @@ func InitDB(appConfig *AppConfig) error {
 func GetDB() *gorm.DB {
 	return db
 }
+
+// CloseDB closes the connections of the database, it is called when the bridge stops
+func CloseDB() error {
+	if db == nil {
+		return nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
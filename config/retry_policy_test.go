@@ -0,0 +1,156 @@
+package config_test
+
+import (
+	"crynux_bridge/config"
+	"reflect"
+	"testing"
+)
+
+func TestRetryPolicyMerge(t *testing.T) {
+	base := config.RetryPolicy{
+		MaxAttempts:       3,
+		InitialBackoff:    5,
+		MaxBackoff:        60,
+		BackoffMultiplier: 2,
+		FeeIncreaseRatio:  1.2,
+		MaxTaskFee:        100,
+		VramIncrease:      8,
+		MaxVram:           80,
+		Actions:           map[string]string{"timeout": "raise_fee", "invalidated": "retry"},
+	}
+
+	cases := []struct {
+		name     string
+		override config.RetryPolicy
+		expected config.RetryPolicy
+	}{
+		{
+			name:     "empty override",
+			override: config.RetryPolicy{},
+			expected: base,
+		},
+		{
+			name:     "fields override",
+			override: config.RetryPolicy{MaxAttempts: 6, MaxBackoff: 30, FeeIncreaseRatio: 1.5, MaxVram: 40},
+			expected: config.RetryPolicy{
+				MaxAttempts:       6,
+				InitialBackoff:    5,
+				MaxBackoff:        30,
+				BackoffMultiplier: 2,
+				FeeIncreaseRatio:  1.5,
+				MaxTaskFee:        100,
+				VramIncrease:      8,
+				MaxVram:           40,
+				Actions:           base.Actions,
+			},
+		},
+		{
+			name:     "actions override",
+			override: config.RetryPolicy{Actions: map[string]string{"timeout": "fail", "task_error": "raise_vram"}},
+			expected: config.RetryPolicy{
+				MaxAttempts:       3,
+				InitialBackoff:    5,
+				MaxBackoff:        60,
+				BackoffMultiplier: 2,
+				FeeIncreaseRatio:  1.2,
+				MaxTaskFee:        100,
+				VramIncrease:      8,
+				MaxVram:           80,
+				Actions:           map[string]string{"timeout": "fail", "invalidated": "retry", "task_error": "raise_vram"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged := base.Merge(c.override)
+			if !reflect.DeepEqual(merged, c.expected) {
+				t.Errorf("merged policy %+v, want %+v", merged, c.expected)
+			}
+		})
+	}
+
+	if base.Actions["timeout"] != "raise_fee" || len(base.Actions) != 2 {
+		t.Errorf("actions of the base policy should not be changed: %v", base.Actions)
+	}
+}
+
+func TestRetryPolicyWithDefaults(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   config.RetryPolicy
+		expected config.RetryPolicy
+	}{
+		{
+			name:   "zero policy",
+			policy: config.RetryPolicy{},
+			expected: config.RetryPolicy{
+				MaxAttempts:       4,
+				InitialBackoff:    10,
+				MaxBackoff:        300,
+				BackoffMultiplier: 2,
+				FeeIncreaseRatio:  1.2,
+				VramIncrease:      8,
+				MaxVram:           80,
+			},
+		},
+		{
+			name: "configured policy",
+			policy: config.RetryPolicy{
+				MaxAttempts:       2,
+				InitialBackoff:    1,
+				MaxBackoff:        5,
+				BackoffMultiplier: 3,
+				FeeIncreaseRatio:  1.5,
+				MaxTaskFee:        10,
+				VramIncrease:      4,
+				MaxVram:           24,
+			},
+			expected: config.RetryPolicy{
+				MaxAttempts:       2,
+				InitialBackoff:    1,
+				MaxBackoff:        5,
+				BackoffMultiplier: 3,
+				FeeIncreaseRatio:  1.5,
+				MaxTaskFee:        10,
+				VramIncrease:      4,
+				MaxVram:           24,
+			},
+		},
+		{
+			name:   "invalid multiplier and ratio",
+			policy: config.RetryPolicy{MaxAttempts: -1, BackoffMultiplier: 0.5, FeeIncreaseRatio: 1},
+			expected: config.RetryPolicy{
+				MaxAttempts:       4,
+				InitialBackoff:    10,
+				MaxBackoff:        300,
+				BackoffMultiplier: 2,
+				FeeIncreaseRatio:  1.2,
+				VramIncrease:      8,
+				MaxVram:           80,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy := c.policy.WithDefaults()
+			actions := policy.Actions
+			policy.Actions = nil
+			if !reflect.DeepEqual(policy, c.expected) {
+				t.Errorf("policy %+v, want %+v", policy, c.expected)
+			}
+			if len(actions) != 8 {
+				t.Errorf("every failure reason should have an action: %v", actions)
+			}
+		})
+	}
+
+	policy := config.RetryPolicy{Actions: map[string]string{"timeout": "retry"}}.WithDefaults()
+	if policy.Actions["timeout"] != "retry" || policy.Actions["task_fee_too_low"] != "raise_fee" {
+		t.Errorf("configured actions should be kept along the default ones: %v", policy.Actions)
+	}
+	for reason, action := range (config.RetryPolicy{}).WithDefaults().Actions {
+		if action == "raise_vram" {
+			t.Errorf("no failure reason means out of memory, %s should not raise the vram by default", reason)
+		}
+	}
+}
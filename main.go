@@ -3,13 +3,20 @@ package main
 import (
 	"context"
 	"crynux_bridge/api"
+	"crynux_bridge/api/v1/inference_tasks"
 	"crynux_bridge/blockchain"
 	"crynux_bridge/config"
 	"crynux_bridge/migrate"
-	"crynux_bridge/relay"
+	"crynux_bridge/storage"
 	"crynux_bridge/tasks"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -35,33 +42,82 @@ func main() {
 
 	startDBMigration()
 
+	if err := storage.InitResultStore(conf); err != nil {
+		log.Fatalln(err)
+	}
+
 	if err := blockchain.Init(context.Background()); err != nil {
 		log.Fatalln(err)
 	}
 
+	if err := tasks.InitTaskBackend(conf); err != nil {
+		log.Fatalln(err)
+	}
+
 	// Check the account balance
-	if err := relay.CheckQuotaForTaskCreator(context.Background()); err != nil {
+	if err := tasks.GetTaskBackend().CheckQuota(context.Background()); err != nil {
 		log.Fatalln(err)
 	}
 
-	go tasks.ProcessTasks(context.Background())
-	go tasks.AutoCreateTasks(context.Background())
-	go tasks.CancelTasks(context.Background())
-	go tasks.ProcessSDFTTasks(context.Background())
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	loops := []func(context.Context){
+		tasks.ProcessTasks,
+		tasks.AutoCreateTasks,
+		tasks.CancelTasks,
+		tasks.DeliverWebhooks,
+		tasks.ProcessPipelines(inference_tasks.PipelineStepRunner{}),
+		tasks.CleanIdempotencyKeys,
+		tasks.SweepResults,
+		tasks.TrackTransactions,
+	}
+	for _, loop := range loops {
+		wg.Add(1)
+		go func(loop func(context.Context)) {
+			defer wg.Done()
+			loop(ctx)
+		}(loop)
+	}
 
-	startServer()
+	startServer(ctx)
+
+	log.Infoln("Waiting for the background tasks to stop...")
+	wg.Wait()
+
+	if err := config.CloseDB(); err != nil {
+		log.Errorln(err)
+	}
+	log.Infoln("Application stopped")
 }
 
-func startServer() {
+// startServer serves the http api until ctx is done, and then waits for the pending requests
+func startServer(ctx context.Context) {
 	conf := config.GetConfig()
 
 	app := api.GetHttpApplication(conf)
 	address := fmt.Sprintf("%s:%s", conf.Http.Host, conf.Http.Port)
+	server := &http.Server{
+		Addr:    address,
+		Handler: app,
+	}
 
 	log.Infoln("Starting application server...")
 
-	if err := app.Run(address); err != nil {
-		log.Fatalln(err)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Infoln("Stopping application server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Errorln(err)
 	}
 }
 
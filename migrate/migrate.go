@@ -53,4 +53,23 @@ func InitMigration(db *gorm.DB) {
 	migrationScripts = append(migrationScripts, migrations.M20250703(db))
 	migrationScripts = append(migrationScripts, migrations.M20250704(db))
 	migrationScripts = append(migrationScripts, migrations.M20250706(db))
+	migrationScripts = append(migrationScripts, migrations.M20261019(db))
+	migrationScripts = append(migrationScripts, migrations.M20261020(db))
+	migrationScripts = append(migrationScripts, migrations.M20261021(db))
+	migrationScripts = append(migrationScripts, migrations.M20261022(db))
+	migrationScripts = append(migrationScripts, migrations.M20261023(db))
+	migrationScripts = append(migrationScripts, migrations.M20261024(db))
+	migrationScripts = append(migrationScripts, migrations.M20261025(db))
+	migrationScripts = append(migrationScripts, migrations.M20261026(db))
+	migrationScripts = append(migrationScripts, migrations.M20261027(db))
+	migrationScripts = append(migrationScripts, migrations.M20261028(db))
+	migrationScripts = append(migrationScripts, migrations.M20261029(db))
+	migrationScripts = append(migrationScripts, migrations.M20261030(db))
+	migrationScripts = append(migrationScripts, migrations.M20261031(db))
+	migrationScripts = append(migrationScripts, migrations.M20261101(db))
+	migrationScripts = append(migrationScripts, migrations.M20261102(db))
+	migrationScripts = append(migrationScripts, migrations.M20261103(db))
+	migrationScripts = append(migrationScripts, migrations.M20261104(db))
+	migrationScripts = append(migrationScripts, migrations.M20261105(db))
+	migrationScripts = append(migrationScripts, migrations.M20261106(db))
 }
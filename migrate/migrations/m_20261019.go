@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261019(db *gorm.DB) *gormigrate.Gormigrate {
+	type ClientTask struct {
+		RetryPolicy string `json:"retry_policy" gorm:"type:text"`
+	}
+
+	type TaskAttempt struct {
+		ID              uint           `gorm:"primarykey"`
+		CreatedAt       time.Time      `gorm:"index"`
+		UpdatedAt       time.Time      `gorm:"index"`
+		DeletedAt       gorm.DeletedAt `gorm:"index"`
+		ClientTaskID    uint           `gorm:"index"`
+		InferenceTaskID uint           `gorm:"index"`
+		Attempt         int
+		TaskStatus      int
+		AbortReason     uint8
+		TaskError       uint8
+		Reason          string `gorm:"type:string;size:64"`
+		Decision        string `gorm:"type:string;size:32"`
+		TaskFee         uint64
+		MinVram         uint64
+		Backoff         uint64
+		NextTaskID      uint
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261019",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().AddColumn(&ClientTask{}, "RetryPolicy"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().CreateTable(&TaskAttempt{}); err != nil {
+					return err
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&TaskAttempt{}); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&ClientTask{}, "RetryPolicy"); err != nil {
+					return err
+				}
+				return nil
+			},
+		},
+	})
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261020(db *gorm.DB) *gormigrate.Gormigrate {
+	type LoraModel struct {
+		ClientID         uint   `gorm:"index"`
+		ClientTaskID     uint   `gorm:"index"`
+		BaseModel        string `gorm:"type:string;size:255"`
+		TrainArgs        string `gorm:"type:text"`
+		ValidationImages string `gorm:"type:text"`
+	}
+
+	columns := []string{"ClientID", "ClientTaskID", "BaseModel", "TrainArgs", "ValidationImages"}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261020",
+			Migrate: func(tx *gorm.DB) error {
+				for _, column := range columns {
+					if err := tx.Migrator().AddColumn(&LoraModel{}, column); err != nil {
+						return err
+					}
+				}
+				if err := tx.Migrator().CreateIndex(&LoraModel{}, "ClientID"); err != nil {
+					return err
+				}
+				return tx.Migrator().CreateIndex(&LoraModel{}, "ClientTaskID")
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropIndex(&LoraModel{}, "ClientTaskID"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropIndex(&LoraModel{}, "ClientID"); err != nil {
+					return err
+				}
+				for _, column := range columns {
+					if err := tx.Migrator().DropColumn(&LoraModel{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	})
+}
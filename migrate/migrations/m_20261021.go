@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261021(db *gorm.DB) *gormigrate.Gormigrate {
+	type ClientTask struct {
+		MaxTotalFee uint64
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261021",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.Migrator().AddColumn(&ClientTask{}, "MaxTotalFee")
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&ClientTask{}, "MaxTotalFee")
+			},
+		},
+	})
+}
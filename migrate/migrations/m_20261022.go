@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261022(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		NextPollAt     time.Time `gorm:"index"`
+		ValidationSent bool
+	}
+
+	type ClientTask struct {
+		NextPollAt time.Time `gorm:"index"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261022",
+			Migrate: func(tx *gorm.DB) error {
+				now := time.Now()
+				if err := tx.Migrator().AddColumn(&InferenceTask{}, "NextPollAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().AddColumn(&InferenceTask{}, "ValidationSent"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().CreateIndex(&InferenceTask{}, "NextPollAt"); err != nil {
+					return err
+				}
+				if err := tx.Model(&InferenceTask{}).Where("1 = 1").Update("next_poll_at", now).Error; err != nil {
+					return err
+				}
+				if err := tx.Migrator().AddColumn(&ClientTask{}, "NextPollAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().CreateIndex(&ClientTask{}, "NextPollAt"); err != nil {
+					return err
+				}
+				return tx.Model(&ClientTask{}).Where("1 = 1").Update("next_poll_at", now).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropIndex(&ClientTask{}, "NextPollAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&ClientTask{}, "NextPollAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropIndex(&InferenceTask{}, "NextPollAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&InferenceTask{}, "ValidationSent"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&InferenceTask{}, "NextPollAt")
+			},
+		},
+	})
+}
@@ -0,0 +1,81 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261023(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		LeaseOwner     string `gorm:"index;type:string;size:64"`
+		LeaseExpiresAt time.Time
+	}
+
+	type ClientTask struct {
+		LeaseOwner     string `gorm:"index;type:string;size:64"`
+		LeaseExpiresAt time.Time
+	}
+
+	type LeaderLease struct {
+		Name      string `gorm:"primarykey;type:string;size:64"`
+		Owner     string `gorm:"type:string;size:64"`
+		ExpiresAt time.Time
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261023",
+			Migrate: func(tx *gorm.DB) error {
+				now := time.Now()
+				if err := tx.Migrator().AddColumn(&InferenceTask{}, "LeaseOwner"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().AddColumn(&InferenceTask{}, "LeaseExpiresAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().CreateIndex(&InferenceTask{}, "LeaseOwner"); err != nil {
+					return err
+				}
+				if err := tx.Model(&InferenceTask{}).Where("1 = 1").Update("lease_expires_at", now).Error; err != nil {
+					return err
+				}
+				if err := tx.Migrator().AddColumn(&ClientTask{}, "LeaseOwner"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().AddColumn(&ClientTask{}, "LeaseExpiresAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().CreateIndex(&ClientTask{}, "LeaseOwner"); err != nil {
+					return err
+				}
+				if err := tx.Model(&ClientTask{}).Where("1 = 1").Update("lease_expires_at", now).Error; err != nil {
+					return err
+				}
+				return tx.Migrator().CreateTable(&LeaderLease{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&LeaderLease{}); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropIndex(&ClientTask{}, "LeaseOwner"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&ClientTask{}, "LeaseExpiresAt"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&ClientTask{}, "LeaseOwner"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropIndex(&InferenceTask{}, "LeaseOwner"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&InferenceTask{}, "LeaseExpiresAt"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&InferenceTask{}, "LeaseOwner")
+			},
+		},
+	})
+}
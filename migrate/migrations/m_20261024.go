@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261024(db *gorm.DB) *gormigrate.Gormigrate {
+	type Webhook struct {
+		ID        uint           `gorm:"primarykey"`
+		CreatedAt time.Time      `gorm:"index"`
+		UpdatedAt time.Time      `gorm:"index"`
+		DeletedAt gorm.DeletedAt `gorm:"index"`
+		ClientID  uint           `gorm:"index"`
+		URL       string         `gorm:"type:string;size:1024"`
+		Secret    string         `gorm:"type:string;size:128"`
+		Events    string         `gorm:"type:text"`
+	}
+
+	type WebhookDelivery struct {
+		ID             uint           `gorm:"primarykey"`
+		CreatedAt      time.Time      `gorm:"index"`
+		UpdatedAt      time.Time      `gorm:"index"`
+		DeletedAt      gorm.DeletedAt `gorm:"index"`
+		WebhookID      uint           `gorm:"index"`
+		Event          string         `gorm:"type:string;size:64"`
+		Payload        string         `gorm:"type:text"`
+		Status         string         `gorm:"index;type:string;size:16"`
+		Attempts       int
+		NextAttemptAt  time.Time `gorm:"index"`
+		LastStatusCode int
+		LastError      string `gorm:"type:text"`
+		DeliveredAt    time.Time
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261024",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().CreateTable(&Webhook{}); err != nil {
+					return err
+				}
+				return tx.Migrator().CreateTable(&WebhookDelivery{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&WebhookDelivery{}); err != nil {
+					return err
+				}
+				return tx.Migrator().DropTable(&Webhook{})
+			},
+		},
+	})
+}
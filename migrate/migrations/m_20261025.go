@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261025(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		StartTime          *time.Time
+		ScoreReadyTime     *time.Time
+		ValidatedTime      *time.Time
+		ResultUploadedTime *time.Time
+	}
+
+	type TaskEvent struct {
+		ID              uint      `gorm:"primarykey"`
+		CreatedAt       time.Time `gorm:"index"`
+		ClientTaskID    uint      `gorm:"index"`
+		InferenceTaskID uint
+		Type            string `gorm:"type:string;size:32"`
+		Data            string `gorm:"type:text"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261025",
+			Migrate: func(tx *gorm.DB) error {
+				for _, field := range []string{"StartTime", "ScoreReadyTime", "ValidatedTime", "ResultUploadedTime"} {
+					if err := tx.Migrator().AddColumn(&InferenceTask{}, field); err != nil {
+						return err
+					}
+				}
+				return tx.Migrator().CreateTable(&TaskEvent{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&TaskEvent{}); err != nil {
+					return err
+				}
+				for _, field := range []string{"StartTime", "ScoreReadyTime", "ValidatedTime", "ResultUploadedTime"} {
+					if err := tx.Migrator().DropColumn(&InferenceTask{}, field); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261026(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		SelectedNode string `gorm:"type:string;size:191"`
+		QOSScore     uint64
+	}
+
+	type TaskStatusEvent struct {
+		ID              uint `gorm:"primarykey"`
+		CreatedAt       time.Time
+		InferenceTaskID uint `gorm:"index"`
+		ClientTaskID    uint `gorm:"index"`
+		FromStatus      int
+		Status          int `gorm:"index"`
+
+		SelectedNode       string `gorm:"type:string;size:191"`
+		QOSScore           uint64
+		StartTime          *time.Time
+		ScoreReadyTime     *time.Time
+		ValidatedTime      *time.Time
+		ResultUploadedTime *time.Time
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261026",
+			Migrate: func(tx *gorm.DB) error {
+				for _, field := range []string{"SelectedNode", "QOSScore"} {
+					if err := tx.Migrator().AddColumn(&InferenceTask{}, field); err != nil {
+						return err
+					}
+				}
+				return tx.Migrator().CreateTable(&TaskStatusEvent{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&TaskStatusEvent{}); err != nil {
+					return err
+				}
+				for _, field := range []string{"SelectedNode", "QOSScore"} {
+					if err := tx.Migrator().DropColumn(&InferenceTask{}, field); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261027(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		ClientID uint
+		Status   int
+		Priority string `gorm:"type:string;size:16"`
+	}
+
+	type ClientAPIKey struct {
+		Priority string `gorm:"type:string;size:16;default:standard"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261027",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().AddColumn(&InferenceTask{}, "Priority"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().AddColumn(&ClientAPIKey{}, "Priority"); err != nil {
+					return err
+				}
+				// the auto tasks waiting for submission yield to the client tasks
+				autoClients := tx.Table("clients").Select("id").Where("client_id = ?", "auto-task")
+				return tx.Model(&InferenceTask{}).
+					Where("status = ? AND client_id IN (?)", 0, autoClients).
+					Update("priority", "auto").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropColumn(&ClientAPIKey{}, "Priority"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&InferenceTask{}, "Priority")
+			},
+		},
+	})
+}
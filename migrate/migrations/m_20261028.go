@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261028(db *gorm.DB) *gormigrate.Gormigrate {
+	type Pipeline struct {
+		ID          uint `gorm:"primarykey"`
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+		DeletedAt   gorm.DeletedAt `gorm:"index"`
+		ClientID    uint           `gorm:"index"`
+		Status      string         `gorm:"index;type:string;size:16"`
+		MaxTotalFee uint64
+		TotalFee    uint64
+		Error       string `gorm:"type:text"`
+	}
+
+	type PipelineStep struct {
+		ID           uint `gorm:"primarykey"`
+		CreatedAt    time.Time
+		UpdatedAt    time.Time
+		DeletedAt    gorm.DeletedAt `gorm:"index"`
+		PipelineID   uint           `gorm:"index"`
+		Name         string         `gorm:"type:string;size:64"`
+		Type         string         `gorm:"type:string;size:16"`
+		TaskArgs     string         `gorm:"type:text"`
+		DependsOn    string         `gorm:"type:text"`
+		Settings     string         `gorm:"type:text"`
+		Status       string         `gorm:"type:string;size:16"`
+		ClientTaskID uint
+		TaskFee      uint64
+		Output       string `gorm:"type:text"`
+		Error        string `gorm:"type:text"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261028",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().CreateTable(&Pipeline{}); err != nil {
+					return err
+				}
+				return tx.Migrator().CreateTable(&PipelineStep{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&PipelineStep{}); err != nil {
+					return err
+				}
+				return tx.Migrator().DropTable(&Pipeline{})
+			},
+		},
+	})
+}
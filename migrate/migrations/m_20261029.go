@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261029(db *gorm.DB) *gormigrate.Gormigrate {
+	type IdempotencyKey struct {
+		ID           uint `gorm:"primarykey"`
+		CreatedAt    time.Time
+		UpdatedAt    time.Time
+		APIKeyID     uint   `gorm:"uniqueIndex:idx_idempotency_key"`
+		ClientID     string `gorm:"uniqueIndex:idx_idempotency_key;type:string;size:191"`
+		Key          string `gorm:"column:idempotency_key;uniqueIndex:idx_idempotency_key;type:string;size:255"`
+		RequestHash  string `gorm:"type:string;size:64"`
+		Status       string `gorm:"type:string;size:16"`
+		ClientTaskID uint
+		Response     string    `gorm:"type:text"`
+		ExpiresAt    time.Time `gorm:"index"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261029",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().CreateTable(&IdempotencyKey{}); err != nil {
+					return err
+				}
+				// the stored responses of the image requests contain base64 images,
+				// which do not fit in the 64KB text column of mysql
+				if tx.Dialector.Name() == "mysql" {
+					return tx.Exec("ALTER TABLE idempotency_keys MODIFY response LONGTEXT").Error
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&IdempotencyKey{})
+			},
+		},
+	})
+}
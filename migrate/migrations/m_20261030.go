@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261030(db *gorm.DB) *gormigrate.Gormigrate {
+	type FeeDecision struct {
+		ID              uint `gorm:"primarykey"`
+		CreatedAt       time.Time
+		UpdatedAt       time.Time
+		DeletedAt       gorm.DeletedAt `gorm:"index"`
+		InferenceTaskID uint           `gorm:"index"`
+		ClientTaskID    uint           `gorm:"index"`
+		TaskType        uint8
+		Reason          string `gorm:"type:string;size:16"`
+		BaseFee         uint64
+		Multiplier      float64
+		QueueDepth      int64
+		StartLatency    int64
+		PreviousFee     uint64
+		TaskFee         uint64
+		MaxTaskFee      uint64
+		Finished        bool
+		Outcome         int
+		AbortReason     uint8
+		StartDelay      int64
+	}
+
+	type ClientAPIKey struct {
+		MaxTaskFee uint64 `gorm:"default:0"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261030",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().CreateTable(&FeeDecision{}); err != nil {
+					return err
+				}
+				return tx.Migrator().AddColumn(&ClientAPIKey{}, "MaxTaskFee")
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropColumn(&ClientAPIKey{}, "MaxTaskFee"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropTable(&FeeDecision{})
+			},
+		},
+	})
+}
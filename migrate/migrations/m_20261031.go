@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261031(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		ResultDeletedAt *time.Time `gorm:"index"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261031",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().AddColumn(&InferenceTask{}, "ResultDeletedAt"); err != nil {
+					return err
+				}
+				return tx.Migrator().CreateIndex(&InferenceTask{}, "ResultDeletedAt")
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropIndex(&InferenceTask{}, "ResultDeletedAt"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&InferenceTask{}, "ResultDeletedAt")
+			},
+		},
+	})
+}
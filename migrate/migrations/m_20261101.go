@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261101(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		Score              string
+		ResultVerification string `gorm:"type:string;size:16"`
+		ResultMismatches   int    `gorm:"default:0"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261101",
+			Migrate: func(tx *gorm.DB) error {
+				for _, column := range []string{"Score", "ResultVerification", "ResultMismatches"} {
+					if err := tx.Migrator().AddColumn(&InferenceTask{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, column := range []string{"ResultMismatches", "ResultVerification", "Score"} {
+					if err := tx.Migrator().DropColumn(&InferenceTask{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	})
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261102(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		Replica int `gorm:"default:0"`
+	}
+
+	type ClientTask struct {
+		VerificationReplicas int `gorm:"default:0"`
+	}
+
+	type ClientAPIKey struct {
+		VerificationReplicas int `gorm:"default:0"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261102",
+			Migrate: func(tx *gorm.DB) error {
+				if err := tx.Migrator().AddColumn(&InferenceTask{}, "Replica"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().AddColumn(&ClientTask{}, "VerificationReplicas"); err != nil {
+					return err
+				}
+				return tx.Migrator().AddColumn(&ClientAPIKey{}, "VerificationReplicas")
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropColumn(&ClientAPIKey{}, "VerificationReplicas"); err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(&ClientTask{}, "VerificationReplicas"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&InferenceTask{}, "Replica")
+			},
+		},
+	})
+}
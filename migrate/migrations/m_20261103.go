@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261103(db *gorm.DB) *gormigrate.Gormigrate {
+	type InferenceTask struct {
+		AutoTaskTemplate string `gorm:"index;type:string;size:64"`
+		SelectedGPU      string
+		SelectedGPUVram  uint64
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261103",
+			Migrate: func(tx *gorm.DB) error {
+				for _, column := range []string{"AutoTaskTemplate", "SelectedGPU", "SelectedGPUVram"} {
+					if err := tx.Migrator().AddColumn(&InferenceTask{}, column); err != nil {
+						return err
+					}
+				}
+				return tx.Migrator().CreateIndex(&InferenceTask{}, "AutoTaskTemplate")
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropIndex(&InferenceTask{}, "AutoTaskTemplate"); err != nil {
+					return err
+				}
+				for _, column := range []string{"SelectedGPUVram", "SelectedGPU", "AutoTaskTemplate"} {
+					if err := tx.Migrator().DropColumn(&InferenceTask{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	})
+}
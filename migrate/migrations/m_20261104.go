@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261104(db *gorm.DB) *gormigrate.Gormigrate {
+	type AutoTaskSettings struct {
+		ID                            uint `gorm:"primarykey"`
+		UpdatedAt                     time.Time
+		Paused                        bool
+		AutoTasksBatchSize            *uint64
+		PendingAutoTasksLimit         *uint64
+		PendingLargeVramLLMTasksLimit *uint64
+		AutoTaskVersionRatio          string `gorm:"type:text"`
+		AutoTaskTypeRatio             string `gorm:"type:text"`
+		LastBatchAt                   *time.Time
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261104",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.Migrator().CreateTable(&AutoTaskSettings{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&AutoTaskSettings{})
+			},
+		},
+	})
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261105(db *gorm.DB) *gormigrate.Gormigrate {
+	type BlockchainTransaction struct {
+		ID           uint           `gorm:"primarykey"`
+		CreatedAt    time.Time      `gorm:"index"`
+		UpdatedAt    time.Time      `gorm:"index"`
+		DeletedAt    gorm.DeletedAt `gorm:"index"`
+		Account      string         `gorm:"index:idx_blockchain_transactions_account_nonce;type:string;size:64"`
+		Nonce        uint64         `gorm:"index:idx_blockchain_transactions_account_nonce"`
+		Method       string         `gorm:"type:string;size:64"`
+		To           string         `gorm:"type:string;size:64"`
+		Value        string         `gorm:"type:string;size:128"`
+		Data         string         `gorm:"type:text"`
+		GasLimit     uint64
+		GasPrice     string `gorm:"type:string;size:128"`
+		TxHash       string `gorm:"index;type:string;size:128"`
+		TxHashes     string `gorm:"type:text"`
+		Replacements int
+		Status       string `gorm:"index;type:string;size:16"`
+		SentAt       time.Time
+		MinedTxHash  string `gorm:"type:string;size:128"`
+		BlockNumber  uint64
+		LastError    string `gorm:"type:text"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261105",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.Migrator().CreateTable(&BlockchainTransaction{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&BlockchainTransaction{})
+			},
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func M20261106(db *gorm.DB) *gormigrate.Gormigrate {
+	type BlockchainTransaction struct {
+		TxType    uint8  `gorm:"default:0"`
+		GasTipCap string `gorm:"type:string;size:128"`
+	}
+
+	return gormigrate.New(db, gormigrate.DefaultOptions, []*gormigrate.Migration{
+		{
+			ID: "M20261106",
+			Migrate: func(tx *gorm.DB) error {
+				for _, column := range []string{"TxType", "GasTipCap"} {
+					if err := tx.Migrator().AddColumn(&BlockchainTransaction{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, column := range []string{"GasTipCap", "TxType"} {
+					if err := tx.Migrator().DropColumn(&BlockchainTransaction{}, column); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	})
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var abortReasonNames = map[TaskAbortReason]string{
+	TaskAbortReasonNone:          "none",
+	TaskAbortTimeout:             "timeout",
+	TaskAbortModelDownloadFailed: "model_download_failed",
+	TaskAbortIncorrectResult:     "incorrect_result",
+	TaskAbortTaskFeeTooLow:       "task_fee_too_low",
+}
+
+// AutoTaskBenchmarkStats is the outcome of the ended auto tasks, with the latencies in milliseconds
+type AutoTaskBenchmarkStats struct {
+	TaskCount    int          `json:"task_count"`
+	SuccessCount int          `json:"success_count"`
+	SuccessRate  float64      `json:"success_rate"`
+	StartLatency LatencyStats `json:"start_latency"` // from the creation of the task to the start of it on the relay
+	Execution    LatencyStats `json:"execution"`     // from the start of the task to the score submitted by the node
+	// the abort reasons of the aborted tasks, and invalidated, group_refund or result_mismatch for the other failed tasks
+	FailureReasons map[string]int `json:"failure_reasons"`
+}
+
+type AutoTaskGPUBenchmark struct {
+	GPU     string `json:"gpu"` // empty for the tasks which never started on a node
+	GPUVram uint64 `json:"gpu_vram"`
+	AutoTaskBenchmarkStats
+}
+
+type AutoTaskBenchmark struct {
+	Template string `json:"template"` // empty for the auto tasks generated before the templates
+	AutoTaskBenchmarkStats
+	GPUs []AutoTaskGPUBenchmark `json:"gpus"`
+}
+
+// autoTaskBenchmarkStats collects the latencies of the tasks before computing their distributions
+type autoTaskBenchmarkStats struct {
+	stats        AutoTaskBenchmarkStats
+	startLatency []int64
+	execution    []int64
+}
+
+func (s *autoTaskBenchmarkStats) add(task *InferenceTask) {
+	if s.stats.FailureReasons == nil {
+		s.stats.FailureReasons = make(map[string]int)
+	}
+	s.stats.TaskCount++
+	switch task.Status {
+	case InferenceTaskResultDownloaded:
+		s.stats.SuccessCount++
+	case InferenceTaskEndAborted:
+		s.stats.FailureReasons[abortReasonNames[task.AbortReason]]++
+	case InferenceTaskEndInvalidated:
+		s.stats.FailureReasons["invalidated"]++
+	case InferenceTaskEndGroupRefund:
+		s.stats.FailureReasons["group_refund"]++
+	case InferenceTaskResultMismatch:
+		s.stats.FailureReasons["result_mismatch"]++
+	}
+
+	latency := task.Latency(nil)
+	if task.StartTime != nil {
+		s.startLatency = append(s.startLatency, latency.QueueWait)
+	}
+	if task.StartTime != nil && task.ScoreReadyTime != nil {
+		s.execution = append(s.execution, latency.Execution)
+	}
+}
+
+func (s *autoTaskBenchmarkStats) result() AutoTaskBenchmarkStats {
+	stats := s.stats
+	if stats.TaskCount > 0 {
+		stats.SuccessRate = float64(stats.SuccessCount) / float64(stats.TaskCount)
+	}
+	stats.StartLatency = newLatencyStats(s.startLatency)
+	stats.Execution = newLatencyStats(s.execution)
+	return stats
+}
+
+type autoTaskGPU struct {
+	name string
+	vram uint64
+}
+
+// GetAutoTaskBenchmarks aggregates the outcomes of the auto tasks created after since, which have ended,
+// by their templates and by the gpus of the nodes running them
+func GetAutoTaskBenchmarks(ctx context.Context, db *gorm.DB, since time.Time) ([]AutoTaskBenchmark, error) {
+	templates := make(map[string]*autoTaskBenchmarkStats)
+	gpus := make(map[string]map[autoTaskGPU]*autoTaskBenchmarkStats)
+
+	offset := 0
+	limit := 500
+	for {
+		var tasks []InferenceTask
+		err := func() error {
+			dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			return db.WithContext(dbCtx).Model(&InferenceTask{}).
+				Where("priority = ? AND created_at >= ?", PriorityAuto, since).
+				Where("status IN ?", endedTaskStatuses).
+				Order("id").Offset(offset).Limit(limit).Find(&tasks).Error
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range tasks {
+			task := &tasks[i]
+			if _, ok := templates[task.AutoTaskTemplate]; !ok {
+				templates[task.AutoTaskTemplate] = &autoTaskBenchmarkStats{}
+				gpus[task.AutoTaskTemplate] = make(map[autoTaskGPU]*autoTaskBenchmarkStats)
+			}
+			templates[task.AutoTaskTemplate].add(task)
+
+			gpu := autoTaskGPU{name: task.SelectedGPU, vram: task.SelectedGPUVram}
+			if _, ok := gpus[task.AutoTaskTemplate][gpu]; !ok {
+				gpus[task.AutoTaskTemplate][gpu] = &autoTaskBenchmarkStats{}
+			}
+			gpus[task.AutoTaskTemplate][gpu].add(task)
+		}
+
+		if len(tasks) < limit {
+			break
+		}
+		offset += limit
+	}
+
+	benchmarks := make([]AutoTaskBenchmark, 0, len(templates))
+	for template, stats := range templates {
+		benchmark := AutoTaskBenchmark{
+			Template:               template,
+			AutoTaskBenchmarkStats: stats.result(),
+			GPUs:                   make([]AutoTaskGPUBenchmark, 0, len(gpus[template])),
+		}
+		for gpu, gpuStats := range gpus[template] {
+			benchmark.GPUs = append(benchmark.GPUs, AutoTaskGPUBenchmark{
+				GPU:                    gpu.name,
+				GPUVram:                gpu.vram,
+				AutoTaskBenchmarkStats: gpuStats.result(),
+			})
+		}
+		sort.Slice(benchmark.GPUs, func(i, j int) bool {
+			if benchmark.GPUs[i].GPU != benchmark.GPUs[j].GPU {
+				return benchmark.GPUs[i].GPU < benchmark.GPUs[j].GPU
+			}
+			return benchmark.GPUs[i].GPUVram < benchmark.GPUs[j].GPUVram
+		})
+		benchmarks = append(benchmarks, benchmark)
+	}
+	sort.Slice(benchmarks, func(i, j int) bool { return benchmarks[i].Template < benchmarks[j].Template })
+	return benchmarks, nil
+}
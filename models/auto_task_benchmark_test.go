@@ -0,0 +1,73 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestGetAutoTaskBenchmarks(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.InferenceTask{}, &models.TaskStatusEvent{})
+
+	now := time.Now()
+	newTask := func(template, gpu string, status models.TaskStatus, abortReason models.TaskAbortReason, priority models.Priority) {
+		startTime := now.Add(-50 * time.Second)
+		scoreReadyTime := now.Add(-20 * time.Second)
+		task := &models.InferenceTask{
+			ClientID:         1,
+			Priority:         priority,
+			AutoTaskTemplate: template,
+			SelectedGPU:      gpu,
+			SelectedGPUVram:  24,
+			StartTime:        &startTime,
+			ScoreReadyTime:   &scoreReadyTime,
+		}
+		if err := db.Create(task).Error; err != nil {
+			t.Fatal(err)
+		}
+		err := db.Model(task).UpdateColumns(map[string]interface{}{
+			"status":       status,
+			"abort_reason": abortReason,
+			"created_at":   now.Add(-time.Minute),
+		}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	newTask("sdxl", "RTX 4090", models.InferenceTaskResultDownloaded, models.TaskAbortReasonNone, models.PriorityAuto)
+	newTask("sdxl", "RTX 4090", models.InferenceTaskEndAborted, models.TaskAbortTimeout, models.PriorityAuto)
+	newTask("sdxl", "RTX 3090", models.InferenceTaskResultDownloaded, models.TaskAbortReasonNone, models.PriorityAuto)
+	newTask("qwen", "RTX 4090", models.InferenceTaskEndInvalidated, models.TaskAbortReasonNone, models.PriorityAuto)
+	// running auto tasks and the tasks of the clients are not reported
+	newTask("sdxl", "RTX 4090", models.InferenceTaskStarted, models.TaskAbortReasonNone, models.PriorityAuto)
+	newTask("", "RTX 4090", models.InferenceTaskResultDownloaded, models.TaskAbortReasonNone, models.PriorityStandard)
+
+	benchmarks, err := models.GetAutoTaskBenchmarks(ctx, db, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(benchmarks) != 2 || benchmarks[0].Template != "qwen" || benchmarks[1].Template != "sdxl" {
+		t.Fatalf("wrong benchmarks %+v", benchmarks)
+	}
+
+	qwen := benchmarks[0]
+	if qwen.TaskCount != 1 || qwen.SuccessCount != 0 || qwen.FailureReasons["invalidated"] != 1 {
+		t.Fatalf("wrong qwen benchmark %+v", qwen)
+	}
+
+	sdxl := benchmarks[1]
+	if sdxl.TaskCount != 3 || sdxl.SuccessCount != 2 || sdxl.FailureReasons["timeout"] != 1 {
+		t.Fatalf("wrong sdxl benchmark %+v", sdxl)
+	}
+	if sdxl.StartLatency.Avg != 10000 || sdxl.Execution.Avg != 30000 {
+		t.Fatalf("wrong sdxl latencies %+v %+v", sdxl.StartLatency, sdxl.Execution)
+	}
+	if len(sdxl.GPUs) != 2 || sdxl.GPUs[0].GPU != "RTX 3090" || sdxl.GPUs[1].GPU != "RTX 4090" {
+		t.Fatalf("wrong sdxl gpus %+v", sdxl.GPUs)
+	}
+	if sdxl.GPUs[1].TaskCount != 2 || sdxl.GPUs[1].SuccessRate != 0.5 {
+		t.Fatalf("wrong sdxl benchmark of RTX 4090 %+v", sdxl.GPUs[1])
+	}
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Float64Array is stored as a json array, nil is stored as NULL
+type Float64Array []float64
+
+func (arr *Float64Array) Scan(val interface{}) error {
+	var b []byte
+	switch v := val.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	case nil:
+		*arr = nil
+		return nil
+	default:
+		return errors.New(fmt.Sprint("Unable to parse value to Float64Array: ", val))
+	}
+	return json.Unmarshal(b, (*[]float64)(arr))
+}
+
+func (arr Float64Array) Value() (driver.Value, error) {
+	if arr == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal([]float64(arr))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+const autoTaskSettingsID = 1
+
+// AutoTaskSettings are the settings of the auto task generator changed at runtime, which survive restarts.
+// The nil fields keep the values of the task config. There is only one row.
+type AutoTaskSettings struct {
+	ID                            uint         `json:"-" gorm:"primarykey"`
+	UpdatedAt                     time.Time    `json:"updated_at"`
+	Paused                        bool         `json:"paused"`
+	AutoTasksBatchSize            *uint64      `json:"auto_tasks_batch_size"`
+	PendingAutoTasksLimit         *uint64      `json:"pending_auto_tasks_limit"`
+	PendingLargeVramLLMTasksLimit *uint64      `json:"pending_large_vram_llm_tasks_limit"`
+	AutoTaskVersionRatio          Float64Array `json:"auto_task_version_ratio" gorm:"type:text"`
+	AutoTaskTypeRatio             Float64Array `json:"auto_task_type_ratio" gorm:"type:text"`
+	// the time the generator saved its last batch of auto tasks
+	LastBatchAt *time.Time `json:"last_batch_at"`
+}
+
+// GetAutoTaskSettings returns the runtime settings of the auto task generator, which are empty if never changed
+func GetAutoTaskSettings(ctx context.Context, db *gorm.DB) (*AutoTaskSettings, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	// Find instead of First, as the generator reads the settings every few seconds and they usually never change
+	var settings []AutoTaskSettings
+	err := db.WithContext(dbCtx).Model(&AutoTaskSettings{}).Where("id = ?", autoTaskSettingsID).Limit(1).Find(&settings).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return &AutoTaskSettings{ID: autoTaskSettingsID}, nil
+	}
+	return &settings[0], nil
+}
+
+func createAutoTaskSettings(ctx context.Context, db *gorm.DB) error {
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&AutoTaskSettings{ID: autoTaskSettingsID}).Error
+}
+
+// UpdateAutoTaskSettings sets the columns of the runtime settings of the auto task generator to values
+func UpdateAutoTaskSettings(ctx context.Context, db *gorm.DB, values map[string]interface{}) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := createAutoTaskSettings(dbCtx, db); err != nil {
+		return err
+	}
+	return db.WithContext(dbCtx).Model(&AutoTaskSettings{ID: autoTaskSettingsID}).Updates(values).Error
+}
+
+// SetAutoTaskLastBatchAt records the time of the last batch of auto tasks, UpdatedAt is kept
+// as the time the settings were changed
+func SetAutoTaskLastBatchAt(ctx context.Context, db *gorm.DB, lastBatchAt time.Time) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := createAutoTaskSettings(dbCtx, db); err != nil {
+		return err
+	}
+	return db.WithContext(dbCtx).Model(&AutoTaskSettings{ID: autoTaskSettingsID}).UpdateColumn("last_batch_at", lastBatchAt).Error
+}
@@ -0,0 +1,103 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAutoTaskSettings(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.AutoTaskSettings{})
+
+	settings, err := models.GetAutoTaskSettings(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Paused || settings.AutoTasksBatchSize != nil || settings.AutoTaskTypeRatio != nil || settings.LastBatchAt != nil {
+		t.Fatalf("settings should be empty before changed: %+v", settings)
+	}
+
+	err = models.UpdateAutoTaskSettings(ctx, db, map[string]interface{}{
+		"paused":                true,
+		"auto_tasks_batch_size": uint64(5),
+		"auto_task_type_ratio":  models.Float64Array{0.5, 0, 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err = models.GetAutoTaskSettings(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.Paused {
+		t.Error("settings should be paused")
+	}
+	if settings.AutoTasksBatchSize == nil || *settings.AutoTasksBatchSize != 5 {
+		t.Errorf("auto tasks batch size should be 5: %v", settings.AutoTasksBatchSize)
+	}
+	if !reflect.DeepEqual(settings.AutoTaskTypeRatio, models.Float64Array{0.5, 0, 0.5}) {
+		t.Errorf("unexpected auto task type ratio %v", settings.AutoTaskTypeRatio)
+	}
+	if settings.AutoTaskVersionRatio != nil {
+		t.Errorf("auto task version ratio should be nil: %v", settings.AutoTaskVersionRatio)
+	}
+	updatedAt := settings.UpdatedAt
+
+	lastBatchAt := time.Now().Add(time.Minute)
+	if err := models.SetAutoTaskLastBatchAt(ctx, db, lastBatchAt); err != nil {
+		t.Fatal(err)
+	}
+	err = models.UpdateAutoTaskSettings(ctx, db, map[string]interface{}{
+		"paused":                false,
+		"auto_tasks_batch_size": nil,
+		"auto_task_type_ratio":  models.Float64Array(nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err = models.GetAutoTaskSettings(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Paused || settings.AutoTasksBatchSize != nil || settings.AutoTaskTypeRatio != nil {
+		t.Errorf("settings should be reset: %+v", settings)
+	}
+	if settings.LastBatchAt == nil || !settings.LastBatchAt.Equal(lastBatchAt) {
+		t.Errorf("last batch at should be %v: %v", lastBatchAt, settings.LastBatchAt)
+	}
+	if settings.UpdatedAt.Before(updatedAt) {
+		t.Errorf("updated at should not go back: %v < %v", settings.UpdatedAt, updatedAt)
+	}
+}
+
+func TestSetAutoTaskLastBatchAtKeepsUpdatedAt(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.AutoTaskSettings{})
+
+	if err := models.UpdateAutoTaskSettings(ctx, db, map[string]interface{}{"paused": true}); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := models.GetAutoTaskSettings(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatedAt := settings.UpdatedAt
+
+	time.Sleep(10 * time.Millisecond)
+	if err := models.SetAutoTaskLastBatchAt(ctx, db, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	settings, err = models.GetAutoTaskSettings(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.UpdatedAt.Equal(updatedAt) {
+		t.Errorf("updated at should be kept: %v != %v", settings.UpdatedAt, updatedAt)
+	}
+	if !settings.Paused {
+		t.Error("settings should stay paused")
+	}
+}
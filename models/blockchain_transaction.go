@@ -0,0 +1,125 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type BlockchainTransactionStatus string
+
+const (
+	// the transaction is sent and waiting to be mined
+	BlockchainTransactionPending  BlockchainTransactionStatus = "pending"
+	BlockchainTransactionSuccess  BlockchainTransactionStatus = "success"
+	BlockchainTransactionReverted BlockchainTransactionStatus = "reverted"
+	// none of the sent transactions is mined, and the nonce has been taken by another transaction of the account
+	BlockchainTransactionDropped BlockchainTransactionStatus = "dropped"
+)
+
+// BlockchainTransaction is a transaction sent by the bridge, tracked until it is mined.
+// A stuck transaction is replaced by one of the same nonce and a higher gas price,
+// all the hashes sent for the nonce are kept in TxHashes because any of them may be mined.
+// The replacement is of the same TxType, GasPrice is the gas fee cap of a dynamic fee transaction.
+type BlockchainTransaction struct {
+	RootModel
+	Account      string                      `json:"account" gorm:"index:idx_blockchain_transactions_account_nonce"`
+	Nonce        uint64                      `json:"nonce" gorm:"index:idx_blockchain_transactions_account_nonce"`
+	Method       string                      `json:"method"`
+	To           string                      `json:"to"`
+	Value        string                      `json:"value"`
+	Data         string                      `json:"data" gorm:"type:text"`
+	GasLimit     uint64                      `json:"gas_limit"`
+	TxType       uint8                       `json:"tx_type"`
+	GasPrice     string                      `json:"gas_price"`
+	GasTipCap    string                      `json:"gas_tip_cap"`
+	TxHash       string                      `json:"tx_hash" gorm:"index"`
+	TxHashes     StringArray                 `json:"tx_hashes" gorm:"type:text"`
+	Replacements int                         `json:"replacements"`
+	Status       BlockchainTransactionStatus `json:"status" gorm:"index"`
+	SentAt       time.Time                   `json:"sent_at"`
+	MinedTxHash  string                      `json:"mined_tx_hash"`
+	BlockNumber  uint64                      `json:"block_number"`
+	LastError    string                      `json:"last_error"`
+}
+
+func (tx *BlockchainTransaction) Save(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Save(tx).Error
+}
+
+func (tx *BlockchainTransaction) Update(ctx context.Context, db *gorm.DB, values map[string]interface{}) error {
+	if tx.ID == 0 {
+		return errors.New("BlockchainTransaction.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(tx).Updates(values).Error
+}
+
+// GetBlockchainTransactionByHash returns the transaction that has sent the hash, either the current one or a replaced one
+func GetBlockchainTransactionByHash(ctx context.Context, db *gorm.DB, txHash string) (*BlockchainTransaction, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	tx := BlockchainTransaction{}
+	if err := db.WithContext(dbCtx).Model(&BlockchainTransaction{}).Where("tx_hashes LIKE ?", "%"+txHash+"%").First(&tx).Error; err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// GetPendingBlockchainTransactions returns the transactions waiting to be mined in the order of their nonces
+func GetPendingBlockchainTransactions(ctx context.Context, db *gorm.DB, limit int) ([]BlockchainTransaction, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	txs := make([]BlockchainTransaction, 0)
+	err := db.WithContext(dbCtx).Model(&BlockchainTransaction{}).
+		Where("status = ?", BlockchainTransactionPending).
+		Order("account ASC, nonce ASC").
+		Limit(limit).
+		Find(&txs).Error
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// GetNextPendingNonce returns the nonce after the pending transactions of the account, or 0 if there is none
+func GetNextPendingNonce(ctx context.Context, db *gorm.DB, account string) (uint64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	var nonce *uint64
+	err := db.WithContext(dbCtx).Model(&BlockchainTransaction{}).
+		Where("account = ? AND status = ?", account, BlockchainTransactionPending).
+		Select("MAX(nonce)").
+		Scan(&nonce).Error
+	if err != nil {
+		return 0, err
+	}
+	if nonce == nil {
+		return 0, nil
+	}
+	return *nonce + 1, nil
+}
+
+// GetBlockchainTransactions returns the transactions from the latest, filtered by status if it is not empty
+func GetBlockchainTransactions(ctx context.Context, db *gorm.DB, status BlockchainTransactionStatus, offset, limit int) ([]BlockchainTransaction, int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := db.WithContext(dbCtx).Model(&BlockchainTransaction{})
+	if len(status) > 0 {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	txs := make([]BlockchainTransaction, 0)
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&txs).Error; err != nil {
+		return nil, 0, err
+	}
+	return txs, total, nil
+}
@@ -2,7 +2,9 @@ package models
 
 import (
 	"context"
+	"crynux_bridge/config"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -22,22 +24,40 @@ const (
 	ClientTaskStatusRunning ClientTaskStatus = "running"
 	ClientTaskStatusSuccess ClientTaskStatus = "success"
 	ClientTaskStatusFailed  ClientTaskStatus = "failed"
+	// the task stopped because submitting another inference task would exceed MaxTotalFee
+	ClientTaskStatusBudgetExceeded ClientTaskStatus = "budget_exceeded"
+	// the task was cancelled by the client
+	ClientTaskStatusCancelled ClientTaskStatus = "cancelled"
 )
 
 type ClientTask struct {
 	RootModel
-	ClientID       uint             `json:"client_id"`
-	Status         ClientTaskStatus `json:"status"`
-	FailedCount    int              `json:"failed_count"`
-	Client         Client           `json:"-"`
-	InferenceTasks []InferenceTask  `json:"-"`
+	ClientID    uint             `json:"client_id"`
+	Status      ClientTaskStatus `json:"status"`
+	FailedCount int              `json:"failed_count"`
+	RetryPolicy string           `json:"-"`
+	MaxTotalFee uint64           `json:"max_total_fee"` // GWei, 0 means no limit
+	// replicas of the task run besides it to compare their results, 0 means no replica
+	VerificationReplicas int             `json:"verification_replicas"`
+	NextPollAt           time.Time       `json:"-" gorm:"index"`
+	LeaseOwner           string          `json:"-" gorm:"index;type:string;size:64"`
+	LeaseExpiresAt       time.Time       `json:"-"`
+	Client               Client          `json:"-"`
+	InferenceTasks       []InferenceTask `json:"-"`
 }
 
 func (task *ClientTask) BeforeCreate(*gorm.DB) error {
 	task.Status = ClientTaskStatusRunning
+	if task.NextPollAt.IsZero() {
+		task.NextPollAt = time.Now()
+	}
 	return nil
 }
 
+func (task *ClientTask) AfterCreate(tx *gorm.DB) error {
+	return task.onStatusChanged(tx)
+}
+
 func GetClientTaskByID(ctx context.Context, db *gorm.DB, clientTaskID uint) (*ClientTask, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, 3 * time.Second)
 	defer cancel()
@@ -53,13 +73,123 @@ func GetClientTaskByID(ctx context.Context, db *gorm.DB, clientTaskID uint) (*Cl
 	return &clientTask, nil
 }
 
+// GetRetryPolicy returns the retry policy overridden by the client request,
+// or defaultPolicy if the request did not set one
+func (task *ClientTask) GetRetryPolicy(defaultPolicy config.RetryPolicy) (config.RetryPolicy, error) {
+	if len(task.RetryPolicy) == 0 {
+		return defaultPolicy.WithDefaults(), nil
+	}
+	policy := config.RetryPolicy{}
+	if err := json.Unmarshal([]byte(task.RetryPolicy), &policy); err != nil {
+		return defaultPolicy.WithDefaults(), err
+	}
+	return policy.WithDefaults(), nil
+}
+
+// WithinBudget reports whether another inference task of taskFee can be submitted
+// without the total fee exceeding MaxTotalFee
+func (task *ClientTask) WithinBudget(ctx context.Context, db *gorm.DB, taskFee uint64) (bool, error) {
+	if task.MaxTotalFee == 0 {
+		return true, nil
+	}
+	totalFee, err := GetClientTaskTotalFee(ctx, db, task.ID)
+	if err != nil {
+		return false, err
+	}
+	return totalFee+taskFee <= task.MaxTotalFee, nil
+}
+
 func (task *ClientTask) Update(ctx context.Context, db *gorm.DB, newTask *ClientTask) error {
 	if task.ID == 0 {
 		return errors.New("ClientTask.ID cannot be 0 when update")
 	}
 	dbCtx, cancel := context.WithTimeout(ctx, 3 * time.Second)
 	defer cancel()
-	return db.WithContext(dbCtx).Model(task).Updates(newTask).Error
+	if len(newTask.Status) == 0 || newTask.Status == task.Status {
+		if err := db.WithContext(dbCtx).Model(task).Updates(newTask).Error; err != nil {
+			return err
+		}
+	} else {
+		// the status change and its webhook event are committed together
+		err := db.WithContext(dbCtx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(task).Updates(newTask).Error; err != nil {
+				return err
+			}
+			return task.onStatusChanged(tx)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	Publish(ClientTaskKey(task.ID))
+	return nil
+}
+
+func (task *ClientTask) Sync(ctx context.Context, db *gorm.DB) error {
+	if task.ID == 0 {
+		return errors.New("ClientTask.ID cannot be 0 when sync")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return db.WithContext(dbCtx).Model(task).Where("id = ?", task.ID).First(task).Error
+}
+
+var ErrClientTaskNotRunning = errors.New("client task is not running")
+
+// Cancel sets the client task to cancelled, and marks its unfinished inference tasks to be cancelled.
+// It returns all the inference tasks of the client task, or ErrClientTaskNotRunning if the client task has finished.
+func (task *ClientTask) Cancel(ctx context.Context, db *gorm.DB) ([]InferenceTask, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var tasks []InferenceTask
+	err := db.WithContext(dbCtx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(task).Where("status = ?", ClientTaskStatusRunning).Update("status", ClientTaskStatusCancelled)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrClientTaskNotRunning
+		}
+		if err := task.onStatusChanged(tx); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&InferenceTask{}).Where("client_task_id = ?", task.ID).Order("id ASC").Find(&tasks).Error; err != nil {
+			return err
+		}
+		for i := range tasks {
+			// the results of the successful tasks are still downloaded
+			if tasks[i].Finished() || tasks[i].Status == InferenceTaskEndSuccess {
+				continue
+			}
+			if err := tasks[i].Update(ctx, tx, &InferenceTask{Status: InferenceTaskNeedCancel}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	Publish(ClientTaskKey(task.ID))
+	return tasks, nil
+}
+
+// WakeClientTask lets the scheduler process the client task as soon as possible,
+// it is called when one of the inference tasks of the client task ends
+func WakeClientTask(ctx context.Context, db *gorm.DB, clientTaskID uint) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := db.WithContext(dbCtx).Model(&ClientTask{}).
+		Where("id = ?", clientTaskID).
+		Update("next_poll_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	Publish(SchedulerKey)
+	return nil
 }
 
 type Role string
@@ -115,6 +245,10 @@ type ClientAPIKey struct {
 	UsedCount  int64     `json:"used_count" gorm:"default:0"`
 	UseLimit   int64     `json:"use_limit" gorm:"default:20"`
 	RateLimit  int64     `json:"rate_limit" gorm:"default:1"`
+	Priority   Priority  `json:"priority" gorm:"type:string;size:16;default:standard"`
+	MaxTaskFee uint64    `json:"max_task_fee" gorm:"default:0"` // GWei, 0 means the default of the fee policy
+	// verification replicas of the tasks of the client, unless set by the request
+	VerificationReplicas int `json:"verification_replicas" gorm:"default:0"`
 }
 
 func (key *ClientAPIKey) Save(ctx context.Context, db *gorm.DB) error {
@@ -172,3 +306,35 @@ func GetAPIKeyByKeyPrefix(ctx context.Context, db *gorm.DB, keyPrefix string) (*
 	}
 	return &apiKey, nil
 }
+
+// WaitClientTaskFinish waits until the client task is no longer running
+func WaitClientTaskFinish(ctx context.Context, db *gorm.DB, task *ClientTask) error {
+	ch, unsubscribe := Subscribe(ClientTaskKey(task.ID))
+	defer unsubscribe()
+	for {
+		if err := task.Sync(ctx, db); err != nil {
+			return err
+		}
+		if task.Status != ClientTaskStatusRunning {
+			return nil
+		}
+		if err := waitForNotification(ctx, ch); err != nil {
+			return err
+		}
+	}
+}
+
+// GetClientTaskInferenceTasks returns all the inference tasks of the client task in the order of creation
+func GetClientTaskInferenceTasks(ctx context.Context, db *gorm.DB, clientTaskID uint) ([]InferenceTask, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tasks := make([]InferenceTask, 0)
+	err := db.WithContext(dbCtx).Model(&InferenceTask{}).
+		Where("client_task_id = ?", clientTaskID).
+		Order("id ASC").
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
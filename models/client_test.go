@@ -0,0 +1,59 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+)
+
+func TestClientTaskWithinBudget(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.ClientTask{}, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{})
+
+	clientTask := &models.ClientTask{ClientID: 1, MaxTotalFee: 300}
+	if err := db.Create(clientTask).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the fees of the aborted and refunded tasks are returned, so they do not count
+	tasks := []*models.InferenceTask{
+		{ClientID: 1, ClientTaskID: clientTask.ID, TaskID: "0x01", TaskFee: 100, Status: models.InferenceTaskResultDownloaded},
+		{ClientID: 1, ClientTaskID: clientTask.ID, TaskID: "0x02", TaskFee: 100, Status: models.InferenceTaskStarted},
+		{ClientID: 1, ClientTaskID: clientTask.ID, TaskID: "0x03", TaskFee: 100, Status: models.InferenceTaskEndAborted},
+		{ClientID: 1, ClientTaskID: clientTask.ID, TaskID: "0x04", TaskFee: 100, Status: models.InferenceTaskEndGroupRefund},
+	}
+	for _, task := range tasks {
+		// tasks are always created pending
+		status := task.Status
+		if err := db.Create(task).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Model(task).UpdateColumn("status", status).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name        string
+		maxTotalFee uint64
+		taskFee     uint64
+		within      bool
+	}{
+		{"below the cap", 300, 50, true},
+		{"at the cap", 300, 100, true},
+		{"above the cap", 300, 101, false},
+		{"already at the cap", 200, 1, false},
+		{"no cap", 0, 1000000, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientTask.MaxTotalFee = c.maxTotalFee
+			within, err := clientTask.WithinBudget(ctx, db, c.taskFee)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if within != c.within {
+				t.Errorf("task fee %d within max total fee %d should be %v", c.taskFee, c.maxTotalFee, c.within)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type FeeDecisionReason string
+
+const (
+	// the task fee is set by the request
+	FeeDecisionRequested FeeDecisionReason = "requested"
+	// the task fee is chosen by the fee policy from the network queue and the start latency
+	FeeDecisionPolicy FeeDecisionReason = "policy"
+	// the task fee is raised after the previous task was aborted for a low task fee
+	FeeDecisionFeeTooLow FeeDecisionReason = "fee_too_low"
+	// the task fee is raised after the previous task timed out
+	FeeDecisionTimeout FeeDecisionReason = "timeout"
+	// the task fee is raised after the previous task failed for another reason
+	FeeDecisionRaised FeeDecisionReason = "raised"
+	// the task fee of the previous task is kept for a retry or the next segment
+	FeeDecisionKept FeeDecisionReason = "kept"
+)
+
+// FeeDecision records how the task fee of an inference task was chosen, and the outcome of the task,
+// so that the fee policy can be audited
+type FeeDecision struct {
+	RootModel
+	InferenceTaskID uint              `json:"inference_task_id" gorm:"index"`
+	ClientTaskID    uint              `json:"client_task_id" gorm:"index"`
+	TaskType        ChainTaskType     `json:"task_type"`
+	Reason          FeeDecisionReason `json:"reason" gorm:"type:string;size:16"`
+	BaseFee         uint64            `json:"base_fee"`      // GWei per unit of the task size
+	Multiplier      float64           `json:"multiplier"`    // ratio of the chosen fee to the base fee
+	QueueDepth      int64             `json:"queue_depth"`   // queued relay tasks when the fee was chosen
+	StartLatency    int64             `json:"start_latency"` // ms, recent p50 start latency when the fee was chosen
+	PreviousFee     uint64            `json:"previous_fee"`  // GWei, fee of the task retried
+	TaskFee         uint64            `json:"task_fee"`      // GWei
+	MaxTaskFee      uint64            `json:"max_task_fee"`  // GWei, 0 means no limit
+	Finished        bool              `json:"finished"`
+	Outcome         TaskStatus        `json:"outcome"`
+	AbortReason     TaskAbortReason   `json:"abort_reason"`
+	StartDelay      int64             `json:"start_delay"` // ms from the task creation to its start, 0 if not started
+}
+
+// GetClientMaxTaskFee returns the max task fee set on the api key of the client of id, 0 if it is not set
+func GetClientMaxTaskFee(ctx context.Context, db *gorm.DB, clientID uint) (uint64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	apiKey := ClientAPIKey{}
+	err := db.WithContext(dbCtx).Model(&ClientAPIKey{}).
+		Where("client_id = (?)", db.Model(&Client{}).Select("client_id").Where("id = ?", clientID)).
+		First(&apiKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return apiKey.MaxTaskFee, nil
+}
+
+func SaveFeeDecisions(ctx context.Context, db *gorm.DB, decisions []*FeeDecision) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Create(decisions).Error
+}
+
+func GetFeeDecisions(ctx context.Context, db *gorm.DB, clientTaskID uint) ([]FeeDecision, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	decisions := make([]FeeDecision, 0)
+	err := db.WithContext(dbCtx).Model(&FeeDecision{}).
+		Where("client_task_id = ?", clientTaskID).
+		Order("id ASC").
+		Find(&decisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+// recordFeeOutcome sets the outcome of the fee decision of the task once the task has ended
+func recordFeeOutcome(db *gorm.DB, task *InferenceTask) error {
+	switch task.Status {
+	case InferenceTaskEndAborted, InferenceTaskEndGroupRefund, InferenceTaskEndInvalidated, InferenceTaskResultDownloaded, InferenceTaskResultMismatch:
+	default:
+		return nil
+	}
+	var startDelay int64
+	if task.StartTime != nil && task.StartTime.After(task.CreatedAt) {
+		startDelay = task.StartTime.Sub(task.CreatedAt).Milliseconds()
+	}
+	return db.Model(&FeeDecision{}).
+		Where("inference_task_id = ?", task.ID).
+		Updates(map[string]interface{}{
+			"finished":     true,
+			"outcome":      task.Status,
+			"abort_reason": task.AbortReason,
+			"start_delay":  startDelay,
+		}).Error
+}
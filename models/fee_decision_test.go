@@ -0,0 +1,86 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestFeeDecisionOutcome(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{}, &models.FeeDecision{})
+
+	task := &models.InferenceTask{ClientID: 1, ClientTaskID: 2, TaskFee: 5000000000}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	decision := &models.FeeDecision{
+		InferenceTaskID: task.ID,
+		ClientTaskID:    task.ClientTaskID,
+		Reason:          models.FeeDecisionPolicy,
+		BaseFee:         5000000000,
+		Multiplier:      1,
+		TaskFee:         task.TaskFee,
+	}
+	if err := models.SaveFeeDecisions(ctx, db, []*models.FeeDecision{decision}); err != nil {
+		t.Fatal(err)
+	}
+
+	startTime := task.CreatedAt.Add(2 * time.Second)
+	if err := task.Update(ctx, db, &models.InferenceTask{Status: models.InferenceTaskStarted, StartTime: &startTime}); err != nil {
+		t.Fatal(err)
+	}
+	decisions, err := models.GetFeeDecisions(ctx, db, task.ClientTaskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 1 || decisions[0].Finished {
+		t.Fatalf("unexpected decisions of the running task %+v", decisions)
+	}
+
+	if err := task.Update(ctx, db, &models.InferenceTask{Status: models.InferenceTaskEndAborted, AbortReason: models.TaskAbortTimeout}); err != nil {
+		t.Fatal(err)
+	}
+	decisions, err = models.GetFeeDecisions(ctx, db, task.ClientTaskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := decisions[0]
+	if !d.Finished || d.Outcome != models.InferenceTaskEndAborted || d.AbortReason != models.TaskAbortTimeout || d.StartDelay != 2000 {
+		t.Fatalf("unexpected outcome %+v", d)
+	}
+}
+
+func TestGetClientMaxTaskFee(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.Client{}, &models.ClientAPIKey{})
+
+	client := &models.Client{ClientId: "client"}
+	if err := db.Create(client).Error; err != nil {
+		t.Fatal(err)
+	}
+	other := &models.Client{ClientId: "other"}
+	if err := db.Create(other).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.ClientAPIKey{ClientID: "client", KeyPrefix: "a", MaxTaskFee: 20000000000}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	maxTaskFee, err := models.GetClientMaxTaskFee(ctx, db, client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxTaskFee != 20000000000 {
+		t.Fatalf("max task fee %d, want 20000000000", maxTaskFee)
+	}
+	// the client without an api key has no max task fee of its own
+	maxTaskFee, err = models.GetClientMaxTaskFee(ctx, db, other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxTaskFee != 0 {
+		t.Fatalf("max task fee %d, want 0", maxTaskFee)
+	}
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdempotencyKeyStatus string
+
+const (
+	IdempotencyKeyProcessing IdempotencyKeyStatus = "processing"
+	IdempotencyKeyCompleted  IdempotencyKeyStatus = "completed"
+)
+
+// IdempotencyKey makes the retries of a task-creating request with the same Idempotency-Key header
+// share the client task of the first request instead of creating and paying for a new one.
+// The keys are scoped to the api key and the client, and are deleted once expired.
+type IdempotencyKey struct {
+	ID           uint                 `json:"id" gorm:"primarykey"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+	APIKeyID     uint                 `json:"api_key_id" gorm:"uniqueIndex:idx_idempotency_key"` // 0 for the requests without an api key
+	ClientID     string               `json:"client_id" gorm:"uniqueIndex:idx_idempotency_key;type:string;size:191"`
+	Key          string               `json:"key" gorm:"column:idempotency_key;uniqueIndex:idx_idempotency_key;type:string;size:255"`
+	RequestHash  string               `json:"request_hash" gorm:"type:string;size:64"`
+	Status       IdempotencyKeyStatus `json:"status" gorm:"type:string;size:16"`
+	ClientTaskID uint                 `json:"client_task_id"`
+	Response     string               `json:"-" gorm:"type:text"`
+	ExpiresAt    time.Time            `json:"expires_at" gorm:"index"`
+}
+
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key has been used with a different request")
+
+// AcquireIdempotencyKey inserts the key for a new request. If the key exists and has not expired,
+// it returns the existing key and false, or ErrIdempotencyKeyMismatch if the key has been used with
+// a different request hash.
+func AcquireIdempotencyKey(ctx context.Context, db *gorm.DB, key *IdempotencyKey) (*IdempotencyKey, bool, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	key.Status = IdempotencyKeyProcessing
+	// an expired key is deleted and acquired again at most once
+	for i := 0; i < 2; i++ {
+		res := db.WithContext(dbCtx).Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+		if res.Error != nil {
+			return nil, false, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return key, true, nil
+		}
+
+		existing := IdempotencyKey{}
+		err := db.WithContext(dbCtx).Model(&IdempotencyKey{}).
+			Where("api_key_id = ? AND client_id = ? AND idempotency_key = ?", key.APIKeyID, key.ClientID, key.Key).
+			First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// released by the request in between
+			key.ID = 0
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if existing.ExpiresAt.Before(time.Now()) {
+			if err := db.WithContext(dbCtx).Where("id = ? AND expires_at < ?", existing.ID, time.Now()).Delete(&IdempotencyKey{}).Error; err != nil {
+				return nil, false, err
+			}
+			key.ID = 0
+			continue
+		}
+		if existing.RequestHash != key.RequestHash {
+			return nil, false, ErrIdempotencyKeyMismatch
+		}
+		return &existing, false, nil
+	}
+	return nil, false, errors.New("cannot acquire idempotency key")
+}
+
+func GetIdempotencyKeyByID(ctx context.Context, db *gorm.DB, id uint) (*IdempotencyKey, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	key := IdempotencyKey{}
+	if err := db.WithContext(dbCtx).Model(&IdempotencyKey{}).Where("id = ?", id).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (key *IdempotencyKey) Update(ctx context.Context, db *gorm.DB, values map[string]interface{}) error {
+	if key.ID == 0 {
+		return errors.New("IdempotencyKey.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(key).Updates(values).Error
+}
+
+// Complete stores the response of the request, which is returned to the later retries
+func (key *IdempotencyKey) Complete(ctx context.Context, db *gorm.DB, response string) error {
+	return key.Update(ctx, db, map[string]interface{}{
+		"status":   IdempotencyKeyCompleted,
+		"response": response,
+	})
+}
+
+// Release deletes the key of a failed request, so that a retry runs the request again
+func (key *IdempotencyKey) Release(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Delete(key).Error
+}
+
+// DeleteExpiredIdempotencyKeys deletes at most limit expired keys and returns the number deleted
+func DeleteExpiredIdempotencyKeys(ctx context.Context, db *gorm.DB, limit int) (int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var ids []uint
+	err := db.WithContext(dbCtx).Model(&IdempotencyKey{}).
+		Where("expires_at < ?", time.Now()).
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	res := db.WithContext(dbCtx).Where("id IN ?", ids).Delete(&IdempotencyKey{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,102 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newIdempotencyKey(hash string, expiresAt time.Time) *models.IdempotencyKey {
+	return &models.IdempotencyKey{
+		APIKeyID:    1,
+		ClientID:    "client",
+		Key:         "key",
+		RequestHash: hash,
+		ExpiresAt:   expiresAt,
+	}
+}
+
+func TestAcquireIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.IdempotencyKey{})
+	expiresAt := time.Now().Add(time.Hour)
+
+	key, acquired, err := models.AcquireIdempotencyKey(ctx, db, newIdempotencyKey("hash", expiresAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired || key.Status != models.IdempotencyKeyProcessing {
+		t.Fatalf("new key is not acquired: %+v", key)
+	}
+	if err := key.Update(ctx, db, map[string]interface{}{"client_task_id": 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, acquired, err := models.AcquireIdempotencyKey(ctx, db, newIdempotencyKey("hash", expiresAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acquired || existing.ID != key.ID || existing.ClientTaskID != 3 {
+		t.Fatalf("duplicate key is acquired: %+v", existing)
+	}
+
+	if _, _, err := models.AcquireIdempotencyKey(ctx, db, newIdempotencyKey("other", expiresAt)); !errors.Is(err, models.ErrIdempotencyKeyMismatch) {
+		t.Fatalf("reused key error %v, want %v", err, models.ErrIdempotencyKeyMismatch)
+	}
+
+	// the same key of another client is a different key
+	other := newIdempotencyKey("other", expiresAt)
+	other.ClientID = "other"
+	if _, acquired, err := models.AcquireIdempotencyKey(ctx, db, other); err != nil || !acquired {
+		t.Fatalf("key of another client is not acquired: %v", err)
+	}
+
+	if err := key.Complete(ctx, db, `{"id":"1"}`); err != nil {
+		t.Fatal(err)
+	}
+	existing, acquired, err = models.AcquireIdempotencyKey(ctx, db, newIdempotencyKey("hash", expiresAt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acquired || existing.Status != models.IdempotencyKeyCompleted || existing.Response != `{"id":"1"}` {
+		t.Fatalf("completed key is not returned: %+v", existing)
+	}
+
+	if err := key.Release(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if _, acquired, err := models.AcquireIdempotencyKey(ctx, db, newIdempotencyKey("other", expiresAt)); err != nil || !acquired {
+		t.Fatalf("released key is not acquired: %v", err)
+	}
+}
+
+func TestExpiredIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.IdempotencyKey{})
+
+	if _, _, err := models.AcquireIdempotencyKey(ctx, db, newIdempotencyKey("hash", time.Now().Add(-time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	key, acquired, err := models.AcquireIdempotencyKey(ctx, db, newIdempotencyKey("other", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired || key.RequestHash != "other" {
+		t.Fatalf("expired key is not acquired again: %+v", key)
+	}
+
+	expired := newIdempotencyKey("hash", time.Now().Add(-time.Minute))
+	expired.Key = "expired"
+	if _, _, err := models.AcquireIdempotencyKey(ctx, db, expired); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := models.DeleteExpiredIdempotencyKeys(ctx, db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatalf("%d expired keys deleted, want 1", deleted)
+	}
+}
@@ -49,6 +49,8 @@ const (
 	InferenceTaskEndSuccess
 	InferenceTaskResultDownloaded
 	InferenceTaskNeedCancel
+	// the downloaded results kept mismatching the score submitted by the node
+	InferenceTaskResultMismatch
 )
 
 type ChainTaskType uint8
@@ -90,6 +92,11 @@ func (arr *StringArray) Scan(val interface{}) error {
 	default:
 		return errors.New(fmt.Sprint("Unable to parse value to StringArray: ", val))
 	}
+	// the empty array is stored as the empty string, which strings.Split turns into [""]
+	if arrString == "" {
+		*arr = StringArray{}
+		return nil
+	}
 	*arr = strings.Split(arrString, ";")
 	return nil
 }
@@ -123,6 +130,7 @@ type InferenceTask struct {
 	RequiredGPUVram uint64        `json:"required_gpu_vram"`
 	TaskSize        uint64        `json:"task_size"`
 	Timeout         uint64        `json:"timeout"`
+	Priority        Priority      `json:"priority" gorm:"type:string;size:16"`
 
 	Status           TaskStatus `json:"status"`
 	TaskID           string     `json:"task_id"`
@@ -136,15 +144,64 @@ type InferenceTask struct {
 
 	AbortReason TaskAbortReason `json:"abort_reason"`
 	TaskError   TaskError       `json:"task_error"`
+
+	// the timestamps of the task on the relay
+	StartTime          *time.Time `json:"start_time,omitempty"`
+	ScoreReadyTime     *time.Time `json:"score_ready_time,omitempty"`
+	ValidatedTime      *time.Time `json:"validated_time,omitempty"`
+	ResultUploadedTime *time.Time `json:"result_uploaded_time,omitempty"`
+	SelectedNode       string     `json:"selected_node"`
+	QOSScore           uint64     `json:"qos_score"`
+
+	// the template of the auto task, and the gpu of the node selected for it, which break down the benchmark report
+	AutoTaskTemplate string `json:"auto_task_template,omitempty" gorm:"index;type:string;size:64"`
+	SelectedGPU      string `json:"selected_gpu,omitempty"`
+	SelectedGPUVram  uint64 `json:"selected_gpu_vram,omitempty"`
+
+	// the verification replica of the client task the task runs, 0 is the task itself
+	Replica int `json:"replica"`
+
+	// the result hashes submitted by the node, which the downloaded results are verified against
+	Score              string             `json:"score"`
+	ResultVerification ResultVerification `json:"result_verification" gorm:"type:string;size:16"`
+	ResultMismatches   int                `json:"result_mismatches"` // downloads whose results mismatched the score
+
+	// the time the result files were deleted by the retention policy
+	ResultDeletedAt *time.Time `json:"result_deleted_at,omitempty" gorm:"index"`
+
+	// the scheduler processes the task again after NextPollAt
+	NextPollAt     time.Time `json:"-" gorm:"index"`
+	ValidationSent bool      `json:"-"`
+	// the bridge instance processing the task, see ClaimDueInferenceTasks
+	LeaseOwner     string    `json:"-" gorm:"index;type:string;size:64"`
+	LeaseExpiresAt time.Time `json:"-"`
 }
 
 func (t *InferenceTask) BeforeCreate(*gorm.DB) error {
 	t.Status = InferenceTaskPending
+	if t.NextPollAt.IsZero() {
+		t.NextPollAt = time.Now()
+	}
 	return nil
 }
 
+// AfterCreate records the creation of the task as the first transition of its timeline
+func (t *InferenceTask) AfterCreate(tx *gorm.DB) error {
+	return recordTaskStatusEvent(tx, t, InferenceTaskPending)
+}
+
+// finishedTaskStatuses are the statuses a task will not leave by processing
+var finishedTaskStatuses = []TaskStatus{
+	InferenceTaskEndAborted,
+	InferenceTaskEndInvalidated,
+	InferenceTaskEndGroupRefund,
+	InferenceTaskResultDownloaded,
+	InferenceTaskNeedCancel,
+	InferenceTaskResultMismatch,
+}
+
 func (task *InferenceTask) Finished() bool {
-	return task.Status == InferenceTaskEndAborted || task.Status == InferenceTaskEndGroupRefund || task.Status == InferenceTaskEndInvalidated || task.Status == InferenceTaskResultDownloaded || task.Status == InferenceTaskNeedCancel
+	return task.Status == InferenceTaskEndAborted || task.Status == InferenceTaskEndGroupRefund || task.Status == InferenceTaskEndInvalidated || task.Status == InferenceTaskResultDownloaded || task.Status == InferenceTaskNeedCancel || task.Status == InferenceTaskResultMismatch
 }
 
 func (task *InferenceTask) Success() bool {
@@ -157,19 +214,49 @@ func (task *InferenceTask) Save(ctx context.Context, db *gorm.DB) error {
 	if err := db.WithContext(dbCtx).Save(&task).Error; err != nil {
 		return err
 	}
+	Publish(SchedulerKey)
 	return nil
 }
 
-// Update the task in the database
+// ErrInferenceTaskStatusChanged means the status of the task has been changed by others since it was read
+var ErrInferenceTaskStatusChanged = errors.New("inference task status has been changed")
+
+// Update the task in the database. A status change is only written if the status is still the one read,
+// otherwise ErrInferenceTaskStatusChanged is returned, so that a cancelled task is not brought back by a worker.
 func (task *InferenceTask) Update(ctx context.Context, db *gorm.DB, newTask *InferenceTask) error {
 	if task.ID == 0 {
 		return errors.New("InferenceTask.ID cannot be 0 when update")
 	}
 	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	if err := db.WithContext(dbCtx).Model(task).Updates(newTask).Error; err != nil {
-		return err
+	if newTask.Status == task.Status || newTask.Status == InferenceTaskPending {
+		if err := db.WithContext(dbCtx).Model(task).Updates(newTask).Error; err != nil {
+			return err
+		}
+	} else {
+		// the status change and its webhook event are committed together
+		fromStatus := task.Status
+		err := db.WithContext(dbCtx).Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(task).Where("status = ?", fromStatus).Updates(newTask)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return ErrInferenceTaskStatusChanged
+			}
+			if err := recordTaskStatusEvent(tx, task, fromStatus); err != nil {
+				return err
+			}
+			if err := recordFeeOutcome(tx, task); err != nil {
+				return err
+			}
+			return task.onStatusChanged(tx)
+		})
+		if err != nil {
+			return err
+		}
 	}
+	Publish(InferenceTaskKey(task.ID))
 	return nil
 }
 
@@ -186,7 +273,26 @@ func (task *InferenceTask) Sync(ctx context.Context, db *gorm.DB) error {
 func SaveTasks(ctx context.Context, db *gorm.DB, tasks []*InferenceTask) error {
 	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	return db.WithContext(dbCtx).Save(tasks).Error
+	if err := db.WithContext(dbCtx).Save(tasks).Error; err != nil {
+		return err
+	}
+	Publish(SchedulerKey)
+	return nil
+}
+
+// GetUnfinishedTaskIDs returns the ids of all the tasks that still need processing
+func GetUnfinishedTaskIDs(ctx context.Context, db *gorm.DB) ([]uint, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ids := make([]uint, 0)
+	err := db.WithContext(dbCtx).Model(&InferenceTask{}).
+		Where("status NOT IN ?", finishedTaskStatuses).
+		Order("id ASC").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
 func GetTaskGroup(ctx context.Context, db *gorm.DB, taskID string) ([]InferenceTask, error) {
@@ -217,7 +323,26 @@ func byteArrayToByte32Array(input []byte) *[32]byte {
 	return &output
 }
 
+// waitFallbackInterval is how often the waiters check the database without being notified,
+// in case the task is changed by another process
+const waitFallbackInterval = 10 * time.Second
+
+// waitForNotification blocks until ch receives a notification, the fallback interval passes or ctx is done
+func waitForNotification(ctx context.Context, ch <-chan struct{}) error {
+	timer := time.NewTimer(waitFallbackInterval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ch:
+	case <-timer.C:
+	}
+	return nil
+}
+
 func WaitTaskGroup(ctx context.Context, db *gorm.DB, task *InferenceTask) ([]InferenceTask, error) {
+	ch, unsubscribe := Subscribe(InferenceTaskKey(task.ID))
+	defer unsubscribe()
 	for {
 		err := task.Sync(ctx, db)
 		if err != nil {
@@ -226,7 +351,9 @@ func WaitTaskGroup(ctx context.Context, db *gorm.DB, task *InferenceTask) ([]Inf
 		if len(task.VRFNumber) > 0 {
 			break
 		}
-		time.Sleep(time.Second)
+		if err := waitForNotification(ctx, ch); err != nil {
+			return nil, err
+		}
 	}
 	vrfNumber, _ := hexutil.Decode(task.VRFNumber)
 	if utils.VrfNeedValidation(vrfNumber) {
@@ -269,6 +396,8 @@ func WaitAllTaskGroup(ctx context.Context, db *gorm.DB, tasks []InferenceTask) (
 }
 
 func WaitForTaskFinish(ctx context.Context, db *gorm.DB, task *InferenceTask) (TaskStatus, error) {
+	ch, unsubscribe := Subscribe(InferenceTaskKey(task.ID))
+	defer unsubscribe()
 	for {
 		// 1. get task by id
 		err := task.Sync(ctx, db)
@@ -279,15 +408,17 @@ func WaitForTaskFinish(ctx context.Context, db *gorm.DB, task *InferenceTask) (T
 		// 2. check task status
 		taskStatus := task.Status
 		// task end without result downloaded
-		if taskStatus == InferenceTaskEndInvalidated || taskStatus == InferenceTaskEndGroupRefund || taskStatus == InferenceTaskEndAborted {
+		if taskStatus == InferenceTaskEndInvalidated || taskStatus == InferenceTaskEndGroupRefund || taskStatus == InferenceTaskEndAborted || taskStatus == InferenceTaskResultMismatch {
 			return taskStatus, nil
 		}
 		// task end with result downloaded
 		if taskStatus == InferenceTaskResultDownloaded {
 			return taskStatus, nil
 		}
-		// task not end, then sleep and continue looping
-		time.Sleep(time.Second)
+		// task not end, then wait for the task to be changed
+		if err := waitForNotification(ctx, ch); err != nil {
+			return task.Status, err
+		}
 	}
 }
 
@@ -359,6 +490,59 @@ func WaitResultTask(ctx context.Context, db *gorm.DB, tasks []InferenceTask) (*I
 	return nil, ErrTaskEndWithoutResult
 }
 
+// WaitRetryTasks waits until the tasks of the client task have been resubmitted by its retry policy after
+// they ended without result, and returns the first task of each task group of the new attempt.
+// It returns no task if the client task has ended instead.
+func WaitRetryTasks(ctx context.Context, db *gorm.DB, clientTaskID uint, tasks []InferenceTask) ([]InferenceTask, error) {
+	ch, unsubscribe := Subscribe(ClientTaskKey(clientTaskID))
+	defer unsubscribe()
+
+	var lastID uint
+	taskIDs := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		if task.ID > lastID {
+			lastID = task.ID
+		}
+		taskIDs = append(taskIDs, task.TaskID)
+	}
+	for {
+		clientTask, err := GetClientTaskByID(ctx, db, clientTaskID)
+		if err != nil {
+			return nil, err
+		}
+
+		var newTasks []InferenceTask
+		dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		err = db.WithContext(dbCtx).Model(&InferenceTask{}).
+			Where("client_task_id = ? AND id > ?", clientTaskID, lastID).
+			Where("task_id NOT IN ?", taskIDs).
+			Order("id ASC").
+			Find(&newTasks).Error
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if len(newTasks) > 0 {
+			// the validation tasks are created after the task of their group
+			seen := make(map[string]bool)
+			res := make([]InferenceTask, 0, len(newTasks))
+			for _, task := range newTasks {
+				if !seen[task.TaskID] {
+					seen[task.TaskID] = true
+					res = append(res, task)
+				}
+			}
+			return res, nil
+		}
+		if clientTask.Status != ClientTaskStatusRunning {
+			return nil, nil
+		}
+		if err := waitForNotification(ctx, ch); err != nil {
+			return nil, err
+		}
+	}
+}
+
 func GetSDFTTaskFinalTask(ctx context.Context, db *gorm.DB, clientTaskID uint) (*InferenceTask, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -398,3 +582,20 @@ func GetSDFTTaskFailedCount(ctx context.Context, db *gorm.DB, clientTaskID uint)
 
 	return uint(totalCount - successCount), nil
 }
+
+// GetClientTaskTotalFee returns the sum of task fee of all the inference tasks of the client task,
+// except the ones refunded by the network
+func GetClientTaskTotalFee(ctx context.Context, db *gorm.DB, clientTaskID uint) (uint64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var totalFee uint64
+	err := db.WithContext(dbCtx).Model(&InferenceTask{}).
+		Select("COALESCE(SUM(task_fee), 0)").
+		Where("client_task_id = ?", clientTaskID).
+		Where("status NOT IN ?", []TaskStatus{InferenceTaskEndAborted, InferenceTaskEndGroupRefund}).
+		Scan(&totalFee).Error
+	if err != nil {
+		return 0, err
+	}
+	return totalFee, nil
+}
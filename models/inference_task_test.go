@@ -0,0 +1,108 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestWaitRetryTasks(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.ClientTask{}, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{}, &models.FeeDecision{})
+
+	clientTask := &models.ClientTask{ClientID: 1}
+	if err := db.Create(clientTask).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the failed task and its validation tasks
+	var tasks []models.InferenceTask
+	for i := 0; i < 3; i++ {
+		task := &models.InferenceTask{ClientID: 1, ClientTaskID: clientTask.ID, TaskID: "0x01"}
+		if err := db.Create(task).Error; err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, *task)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		for i := 0; i < 2; i++ {
+			db.Create(&models.InferenceTask{ClientID: 1, ClientTaskID: clientTask.ID, TaskID: "0x02"})
+		}
+		models.Publish(models.ClientTaskKey(clientTask.ID))
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	newTasks, err := models.WaitRetryTasks(waitCtx, db, clientTask.ID, tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one task of the new task group
+	if len(newTasks) != 1 || newTasks[0].TaskID != "0x02" {
+		t.Fatalf("unexpected retry tasks %+v", newTasks)
+	}
+
+	// no more retry once the client task has failed
+	tasks = append(tasks, newTasks...)
+	if err := clientTask.Update(ctx, db, &models.ClientTask{Status: models.ClientTaskStatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+	newTasks, err = models.WaitRetryTasks(waitCtx, db, clientTask.ID, tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newTasks) != 0 {
+		t.Fatalf("unexpected retry tasks %+v", newTasks)
+	}
+}
+
+func TestResultMismatchEndsTask(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{}, &models.FeeDecision{})
+
+	task := &models.InferenceTask{ClientID: 1, ClientTaskID: 2, TaskFee: 5000000000}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SaveFeeDecisions(ctx, db, []*models.FeeDecision{{InferenceTaskID: task.ID, ClientTaskID: 2, TaskFee: task.TaskFee}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := task.Update(ctx, db, &models.InferenceTask{Status: models.InferenceTaskEndSuccess, Score: "0x0102"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a mismatched download keeps the task for another download
+	if err := task.Update(ctx, db, &models.InferenceTask{ResultVerification: models.ResultVerificationMismatch, ResultMismatches: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if task.Finished() {
+		t.Fatal("task with a mismatched result should be downloaded again")
+	}
+
+	if err := task.Update(ctx, db, &models.InferenceTask{Status: models.InferenceTaskResultMismatch, ResultMismatches: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if !task.Finished() || task.Success() {
+		t.Fatalf("task should end without result, status %d", task.Status)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	status, err := models.WaitForTaskFinish(waitCtx, db, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != models.InferenceTaskResultMismatch || task.ResultVerification != models.ResultVerificationMismatch || task.ResultMismatches != 2 {
+		t.Fatalf("unexpected task after result mismatch %+v", task)
+	}
+
+	decisions, err := models.GetFeeDecisions(ctx, db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 1 || !decisions[0].Finished || decisions[0].Outcome != models.InferenceTaskResultMismatch {
+		t.Fatalf("fee decision outcome is not recorded %+v", decisions)
+	}
+}
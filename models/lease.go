@@ -0,0 +1,201 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LeaderLease makes sure only one bridge instance runs a singleton loop at a time
+type LeaderLease struct {
+	Name      string    `json:"name" gorm:"primarykey;type:string;size:64"`
+	Owner     string    `json:"owner" gorm:"type:string;size:64"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcquireLeaderLease takes or renews the lease of name for owner, it returns false if
+// the lease is held by another owner and has not expired
+func AcquireLeaderLease(ctx context.Context, db *gorm.DB, name, owner string, duration time.Duration) (bool, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	err := db.WithContext(dbCtx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&LeaderLease{Name: name, ExpiresAt: now}).Error
+	if err != nil {
+		return false, err
+	}
+	res := db.WithContext(dbCtx).Model(&LeaderLease{}).
+		Where("name = ?", name).
+		Where("owner = ? OR owner = ? OR expires_at < ?", owner, "", now).
+		Updates(map[string]interface{}{"owner": owner, "expires_at": now.Add(duration)})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func ReleaseLeaderLease(ctx context.Context, db *gorm.DB, name, owner string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(&LeaderLease{}).
+		Where("name = ? AND owner = ?", name, owner).
+		Updates(map[string]interface{}{"owner": "", "expires_at": time.Now()}).Error
+}
+
+// supportSkipLocked reports whether the database supports SELECT ... FOR UPDATE SKIP LOCKED
+func supportSkipLocked(db *gorm.DB) bool {
+	name := db.Dialector.Name()
+	return name == "mysql" || name == "postgres"
+}
+
+// claimRows leases at most limit rows of model selected by scope to owner, and returns the ids of the claimed rows.
+// The rows are locked with SELECT ... FOR UPDATE SKIP LOCKED on mysql and postgres. Other databases
+// claim the rows one by one with a conditional update, and skip the rows claimed by others in between.
+func claimRows(ctx context.Context, db *gorm.DB, model interface{}, scope func(*gorm.DB) *gorm.DB, owner string, limit int, duration time.Duration) ([]uint, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	claimable := func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("lease_owner = '' OR lease_owner IS NULL OR lease_expires_at < ?", now)
+	}
+	lease := map[string]interface{}{"lease_owner": owner, "lease_expires_at": now.Add(duration)}
+
+	var ids []uint
+	if supportSkipLocked(db) {
+		err := db.WithContext(dbCtx).Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(model).
+				Scopes(scope, claimable).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Limit(limit).
+				Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+			return tx.Model(model).Where("id IN ?", ids).Updates(lease).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ids, nil
+	}
+
+	var candidates []uint
+	err := db.WithContext(dbCtx).Model(model).Scopes(scope, claimable).Limit(limit).Pluck("id", &candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range candidates {
+		res := db.WithContext(dbCtx).Model(model).Where("id = ?", id).Scopes(claimable).Updates(lease)
+		if res.Error != nil {
+			return ids, res.Error
+		}
+		if res.RowsAffected == 1 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// dueInferenceTasks are the submitted tasks to process, the pending tasks
+// are submitted in the order of the submission queue, see GetPendingSubmissions
+func dueInferenceTasks(tx *gorm.DB) *gorm.DB {
+	return tx.Where("status NOT IN ?", finishedTaskStatuses).
+		Where("status <> ?", InferenceTaskPending).
+		Where("next_poll_at <= ?", time.Now()).
+		Order("next_poll_at ASC")
+}
+
+func dueClientTasks(tx *gorm.DB) *gorm.DB {
+	return tx.Where("status = ?", ClientTaskStatusRunning).
+		Where("next_poll_at <= ?", time.Now()).
+		Order("next_poll_at ASC")
+}
+
+// ClaimDueInferenceTasks leases the unfinished inference tasks whose NextPollAt is due to owner
+func ClaimDueInferenceTasks(ctx context.Context, db *gorm.DB, owner string, limit int, duration time.Duration) ([]InferenceTask, error) {
+	ids, err := claimRows(ctx, db, &InferenceTask{}, dueInferenceTasks, owner, limit, duration)
+	tasks := make([]InferenceTask, 0)
+	if len(ids) == 0 {
+		return tasks, err
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := db.WithContext(dbCtx).Model(&InferenceTask{}).Where("id IN ?", ids).Order("id ASC").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, err
+}
+
+// ClaimDueClientTasks leases the running client tasks whose NextPollAt is due to owner
+func ClaimDueClientTasks(ctx context.Context, db *gorm.DB, owner string, limit int, duration time.Duration) ([]ClientTask, error) {
+	ids, err := claimRows(ctx, db, &ClientTask{}, dueClientTasks, owner, limit, duration)
+	tasks := make([]ClientTask, 0)
+	if len(ids) == 0 {
+		return tasks, err
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := db.WithContext(dbCtx).Model(&ClientTask{}).Where("id IN ?", ids).Order("id ASC").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, err
+}
+
+// ClaimInferenceTask leases the inference task of id to owner regardless of its NextPollAt,
+// it returns false if the task is leased by another owner
+func ClaimInferenceTask(ctx context.Context, db *gorm.DB, id uint, owner string, duration time.Duration) (bool, error) {
+	ids, err := claimRows(ctx, db, &InferenceTask{}, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id = ?", id)
+	}, owner, 1, duration)
+	if err != nil {
+		return false, err
+	}
+	return len(ids) == 1, nil
+}
+
+// RenewLeases extends the leases of the rows of model held by owner
+func RenewLeases(ctx context.Context, db *gorm.DB, model interface{}, ids []uint, owner string, duration time.Duration) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(model).
+		Where("id IN ? AND lease_owner = ?", ids, owner).
+		Update("lease_expires_at", time.Now().Add(duration)).Error
+}
+
+// ReleaseLease gives up the lease of the row of model held by owner, and sets
+// the time the row should be processed again
+func ReleaseLease(ctx context.Context, db *gorm.DB, model interface{}, id uint, owner string, nextPollAt time.Time) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	values := map[string]interface{}{"lease_owner": "", "lease_expires_at": time.Now()}
+	if !nextPollAt.IsZero() {
+		values["next_poll_at"] = nextPollAt
+	}
+	return db.WithContext(dbCtx).Model(model).
+		Where("id = ? AND lease_owner = ?", id, owner).
+		Updates(values).Error
+}
+
+// ReleaseTaskLeases gives up all the task leases held by owner. The leader leases are
+// released by the loops holding them.
+func ReleaseTaskLeases(ctx context.Context, db *gorm.DB, owner string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	values := map[string]interface{}{"lease_owner": "", "lease_expires_at": time.Now()}
+	for _, model := range []interface{}{&InferenceTask{}, &ClientTask{}} {
+		if err := db.WithContext(dbCtx).Model(model).Where("lease_owner = ?", owner).Updates(values).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
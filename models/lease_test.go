@@ -0,0 +1,80 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestLeaderLease(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.LeaderLease{})
+
+	acquire := func(owner string, duration time.Duration) bool {
+		acquired, err := models.AcquireLeaderLease(ctx, db, "test", owner, duration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return acquired
+	}
+
+	if !acquire("a", time.Minute) {
+		t.Fatal("a should acquire the free lease")
+	}
+	if !acquire("a", time.Minute) {
+		t.Fatal("a should renew its own lease")
+	}
+	if acquire("b", time.Minute) {
+		t.Fatal("b should not acquire the lease held by a")
+	}
+	if err := models.ReleaseLeaderLease(ctx, db, "test", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if !acquire("b", -time.Second) {
+		t.Fatal("b should acquire the released lease")
+	}
+	if !acquire("a", time.Minute) {
+		t.Fatal("a should take over the expired lease")
+	}
+}
+
+func TestClaimDueClientTasks(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.ClientTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{})
+	for i := 0; i < 3; i++ {
+		clientTask := &models.ClientTask{Status: models.ClientTaskStatusRunning, NextPollAt: time.Now().Add(-time.Second)}
+		if err := db.Create(clientTask).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimedA, err := models.ClaimDueClientTasks(ctx, db, "a", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimedB, err := models.ClaimDueClientTasks(ctx, db, "b", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimedA) != 2 || len(claimedB) != 1 {
+		t.Fatalf("a claimed %d tasks and b claimed %d tasks, want 2 and 1", len(claimedA), len(claimedB))
+	}
+	for _, clientTask := range claimedA {
+		if clientTask.ID == claimedB[0].ID {
+			t.Fatalf("client task %d claimed twice", clientTask.ID)
+		}
+	}
+
+	next := time.Now().Add(time.Hour)
+	if err := models.ReleaseLease(ctx, db, &models.ClientTask{}, claimedB[0].ID, "b", next); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := models.ClaimDueClientTasks(ctx, db, "c", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 0 {
+		t.Fatalf("c claimed %d tasks, want 0", len(claimed))
+	}
+}
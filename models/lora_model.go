@@ -1,10 +1,97 @@
 package models
 
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
 type LoraModel struct {
 	RootModel
-	Name         string    `json:"name"`
-	Description  string    `json:"description"`
-	Type         ModelType `json:"type"`
-	DisplayLink  string    `json:"display_link"`
-	DownloadLink string    `json:"download_link"`
+	Name             string      `json:"name"`
+	Description      string      `json:"description"`
+	Type             ModelType   `json:"type"`
+	DisplayLink      string      `json:"display_link"`
+	DownloadLink     string      `json:"download_link"`
+	ClientID         uint        `json:"client_id" gorm:"index"` // 0 for public models
+	ClientTaskID     uint        `json:"client_task_id" gorm:"index"`
+	BaseModel        string      `json:"base_model"`
+	TrainArgs        string      `json:"train_args"`
+	ValidationImages StringArray `json:"validation_images"`
+}
+
+func (model *LoraModel) Save(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Save(model).Error
+}
+
+func (model *LoraModel) Update(ctx context.Context, db *gorm.DB, newModel *LoraModel) error {
+	if model.ID == 0 {
+		return errors.New("LoraModel.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(model).Updates(newModel).Error
+}
+
+func (model *LoraModel) Delete(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Delete(model).Error
+}
+
+func GetLoraModelByID(ctx context.Context, db *gorm.DB, id uint) (*LoraModel, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	model := LoraModel{}
+	if err := db.WithContext(dbCtx).Model(&LoraModel{}).Where("id = ?", id).First(&model).Error; err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// GetLoraModelByClientTaskID returns nil if the client task has not been registered as a lora model
+func GetLoraModelByClientTaskID(ctx context.Context, db *gorm.DB, clientTaskID uint) (*LoraModel, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	model := LoraModel{}
+	err := db.WithContext(dbCtx).Model(&LoraModel{}).Where("client_task_id = ?", clientTaskID).First(&model).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &model, nil
+}
+
+// GetModelTypeOfBaseModel returns the model type of the base model from the base model list,
+// and guesses it from the model name if the base model is not in the list
+func GetModelTypeOfBaseModel(ctx context.Context, db *gorm.DB, name string) (ModelType, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	baseModel := BaseModel{}
+	err := db.WithContext(dbCtx).Model(&BaseModel{}).Where(&BaseModel{Key: name}).First(&baseModel).Error
+	if err == nil {
+		return baseModel.Type, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	lowerName := strings.ToLower(name)
+	switch {
+	case strings.Contains(lowerName, "sdxl-turbo"):
+		return ModelType_SDXL_Turbo, nil
+	case strings.Contains(lowerName, "xl"):
+		return ModelType_SD_XL, nil
+	case strings.Contains(lowerName, "2-1"):
+		return ModelType_SD_2_1, nil
+	default:
+		return ModelType_SD_1_5, nil
+	}
 }
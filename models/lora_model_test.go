@@ -0,0 +1,50 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLoraModelValidationImages(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.LoraModel{})
+
+	cases := []struct {
+		name   string
+		images models.StringArray
+		json   string
+	}{
+		{"nil", nil, "[]"},
+		{"empty", models.StringArray{}, "[]"},
+		{"one", models.StringArray{"0.png"}, `["0.png"]`},
+		{"several", models.StringArray{"0.png", "1.png", "2.png"}, `["0.png","1.png","2.png"]`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			model := &models.LoraModel{Name: c.name, ValidationImages: c.images}
+			if err := model.Save(ctx, db); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := models.GetLoraModelByID(ctx, db, model.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved.ValidationImages) != len(c.images) {
+				t.Fatalf("%d validation images read back, want %d: %q", len(saved.ValidationImages), len(c.images), saved.ValidationImages)
+			}
+			if len(c.images) > 0 && !reflect.DeepEqual(saved.ValidationImages, c.images) {
+				t.Errorf("validation images %q, want %q", saved.ValidationImages, c.images)
+			}
+			b, err := json.Marshal(saved.ValidationImages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != c.json {
+				t.Errorf("validation images marshaled to %s, want %s", b, c.json)
+			}
+		})
+	}
+}
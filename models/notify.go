@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+)
+
+// notificationBus wakes up the goroutines waiting for a task to change,
+// so that they do not need to poll the database every second.
+// It only works inside one process, waiters should still poll at a low rate.
+type notificationBus struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+var bus = &notificationBus{
+	subscribers: make(map[string]map[chan struct{}]struct{}),
+}
+
+// WebhookKey is published when new webhook deliveries are ready to be sent
+const WebhookKey = "webhook"
+
+// SchedulerKey is published when new inference tasks or client tasks are ready to be processed
+const SchedulerKey = "scheduler"
+
+func InferenceTaskKey(id uint) string {
+	return fmt.Sprintf("inference_task:%d", id)
+}
+
+func ClientTaskKey(id uint) string {
+	return fmt.Sprintf("client_task:%d", id)
+}
+
+// Subscribe returns a channel which receives a value after key is published,
+// and a function to unsubscribe. Notifications are not queued: publishing
+// several times before the channel is read wakes up the subscriber once.
+func Subscribe(key string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	bus.mu.Lock()
+	subscribers, ok := bus.subscribers[key]
+	if !ok {
+		subscribers = make(map[chan struct{}]struct{})
+		bus.subscribers[key] = subscribers
+	}
+	subscribers[ch] = struct{}{}
+	bus.mu.Unlock()
+
+	return ch, func() {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		subscribers := bus.subscribers[key]
+		delete(subscribers, ch)
+		if len(subscribers) == 0 {
+			delete(bus.subscribers, key)
+		}
+	}
+}
+
+func Publish(key string) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for ch := range bus.subscribers[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package models_test
+
+import (
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestNotificationBus(t *testing.T) {
+	key := models.InferenceTaskKey(1)
+	ch1, unsubscribe1 := models.Subscribe(key)
+	ch2, unsubscribe2 := models.Subscribe(key)
+	defer unsubscribe2()
+
+	// publishing several times before reading wakes up the subscriber once
+	models.Publish(key)
+	models.Publish(key)
+	for _, ch := range []<-chan struct{}{ch1, ch2} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("subscriber is not notified")
+		}
+	}
+	select {
+	case <-ch1:
+		t.Fatal("notifications should not be queued")
+	default:
+	}
+
+	// other keys do not wake up the subscribers
+	models.Publish(models.InferenceTaskKey(2))
+	unsubscribe1()
+	models.Publish(key)
+	select {
+	case <-ch1:
+		t.Fatal("unsubscribed channel is notified")
+	default:
+	}
+	select {
+	case <-ch2:
+	default:
+		t.Fatal("subscriber is not notified")
+	}
+}
@@ -0,0 +1,358 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PipelineStatus string
+
+const (
+	PipelineStatusRunning   PipelineStatus = "running"
+	PipelineStatusSuccess   PipelineStatus = "success"
+	PipelineStatusFailed    PipelineStatus = "failed"
+	PipelineStatusCancelled PipelineStatus = "cancelled"
+)
+
+type PipelineStepType string
+
+const (
+	PipelineStepLLM      PipelineStepType = "llm"
+	PipelineStepImage    PipelineStepType = "image"
+	PipelineStepFinetune PipelineStepType = "finetune"
+)
+
+func (t PipelineStepType) TaskType() ChainTaskType {
+	switch t {
+	case PipelineStepLLM:
+		return TaskTypeLLM
+	case PipelineStepFinetune:
+		return TaskTypeSDFTLora
+	default:
+		return TaskTypeSD
+	}
+}
+
+type PipelineStepStatus string
+
+const (
+	PipelineStepPending PipelineStepStatus = "pending"
+	PipelineStepRunning PipelineStepStatus = "running"
+	PipelineStepSuccess PipelineStepStatus = "success"
+	PipelineStepFailed  PipelineStepStatus = "failed"
+	// the step is not run because the pipeline has failed or has been cancelled
+	PipelineStepSkipped PipelineStepStatus = "skipped"
+)
+
+// Pipeline is a job of several inference tasks, whose task args can refer to
+// the outputs of the steps they depend on
+type Pipeline struct {
+	RootModel
+	ClientID    uint           `json:"-" gorm:"index"`
+	Client      Client         `json:"-"`
+	Status      PipelineStatus `json:"status" gorm:"index"`
+	MaxTotalFee uint64         `json:"max_total_fee"` // GWei, 0 means no limit
+	TotalFee    uint64         `json:"total_fee"`     // GWei
+	Error       string         `json:"error"`
+	Steps       []PipelineStep `json:"steps"`
+}
+
+// PipelineStepSettings are the task settings of a step, see inference_tasks.TaskInput
+type PipelineStepSettings struct {
+	TaskVersion     *string `json:"task_version,omitempty"`
+	MinVram         *uint64 `json:"min_vram,omitempty"`
+	RequiredGPU     string  `json:"required_gpu,omitempty"`
+	RequiredGPUVram uint64  `json:"required_gpu_vram,omitempty"`
+	RepeatNum       *int    `json:"repeat_num,omitempty"`
+	TaskFee         *uint64 `json:"task_fee,omitempty"`
+	Timeout         *uint64 `json:"timeout,omitempty"`
+}
+
+type PipelineStep struct {
+	RootModel
+	PipelineID   uint               `json:"-" gorm:"index"`
+	Name         string             `json:"name"`
+	Type         PipelineStepType   `json:"type"`
+	TaskArgs     string             `json:"task_args" gorm:"type:text"` // may contain the templates of the outputs of other steps
+	DependsOn    StringArray        `json:"depends_on"`
+	Settings     string             `json:"-" gorm:"type:text"`
+	Status       PipelineStepStatus `json:"status"`
+	ClientTaskID uint               `json:"client_task_id"`
+	TaskFee      uint64             `json:"task_fee"` // GWei
+	Output       string             `json:"-" gorm:"type:text"`
+	Error        string             `json:"error"`
+}
+
+// PipelineKey is published when a pipeline is created or cancelled
+const PipelineKey = "pipelines"
+
+func (step *PipelineStep) GetSettings() (PipelineStepSettings, error) {
+	settings := PipelineStepSettings{}
+	if len(step.Settings) == 0 {
+		return settings, nil
+	}
+	err := json.Unmarshal([]byte(step.Settings), &settings)
+	return settings, err
+}
+
+// GetOutput returns the output of a successful step
+func (step *PipelineStep) GetOutput() (map[string]interface{}, error) {
+	output := make(map[string]interface{})
+	if len(step.Output) == 0 {
+		return output, nil
+	}
+	err := json.Unmarshal([]byte(step.Output), &output)
+	return output, err
+}
+
+var pipelineStepNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+
+// pipelineTemplatePattern matches {{steps.<name>.<field>[.<index>]}}
+var pipelineTemplatePattern = regexp.MustCompile(`\{\{\s*steps\.([A-Za-z][A-Za-z0-9_-]*)((?:\.[A-Za-z0-9_]+)+)\s*\}\}`)
+
+const MaxPipelineSteps = 16
+
+// TemplateSteps returns the names of the steps referred to by the templates in taskArgs
+func TemplateSteps(taskArgs string) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range pipelineTemplatePattern.FindAllStringSubmatch(taskArgs, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// Dependencies returns the steps the step waits for, the explicit ones and the ones referred to by its templates
+func (step *PipelineStep) Dependencies() []string {
+	deps := make([]string, 0, len(step.DependsOn))
+	seen := make(map[string]bool)
+	for _, name := range append([]string(step.DependsOn), TemplateSteps(step.TaskArgs)...) {
+		if len(name) > 0 && !seen[name] {
+			seen[name] = true
+			deps = append(deps, name)
+		}
+	}
+	return deps
+}
+
+// ValidatePipelineSteps checks that the step names are unique, and the dependencies of the steps
+// refer to existing steps without cycles
+func ValidatePipelineSteps(steps []PipelineStep) error {
+	if len(steps) == 0 {
+		return errors.New("pipeline has no step")
+	}
+	if len(steps) > MaxPipelineSteps {
+		return fmt.Errorf("pipeline has more than %d steps", MaxPipelineSteps)
+	}
+	index := make(map[string]int)
+	for i, step := range steps {
+		if !pipelineStepNamePattern.MatchString(step.Name) {
+			return fmt.Errorf("invalid step name %q", step.Name)
+		}
+		if _, ok := index[step.Name]; ok {
+			return fmt.Errorf("duplicate step name %q", step.Name)
+		}
+		index[step.Name] = i
+	}
+
+	// 0: not visited, 1: visiting, 2: visited
+	state := make([]int, len(steps))
+	var visit func(i int) error
+	visit = func(i int) error {
+		if state[i] == 1 {
+			return fmt.Errorf("step %q depends on itself", steps[i].Name)
+		}
+		if state[i] == 2 {
+			return nil
+		}
+		state[i] = 1
+		for _, name := range steps[i].Dependencies() {
+			j, ok := index[name]
+			if !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", steps[i].Name, name)
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		state[i] = 2
+		return nil
+	}
+	for i := range steps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lookupOutput(output interface{}, fields []string) (interface{}, error) {
+	value := output
+	for _, field := range fields {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[field]
+			if !ok {
+				return nil, fmt.Errorf("output has no field %q", field)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("output has no item %q", field)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("output has no field %q", field)
+		}
+	}
+	return value, nil
+}
+
+// RenderTaskArgs replaces the templates in the task args with the outputs of the steps.
+// The task args are json, so the outputs are inserted as escaped json string content, and
+// the templates must be placed inside json strings.
+func RenderTaskArgs(taskArgs string, outputs map[string]map[string]interface{}) (string, error) {
+	var renderErr error
+	rendered := pipelineTemplatePattern.ReplaceAllStringFunc(taskArgs, func(template string) string {
+		match := pipelineTemplatePattern.FindStringSubmatch(template)
+		output, ok := outputs[match[1]]
+		if !ok {
+			renderErr = fmt.Errorf("step %q has no output", match[1])
+			return template
+		}
+		value, err := lookupOutput(output, strings.Split(strings.TrimPrefix(match[2], "."), "."))
+		if err != nil {
+			renderErr = fmt.Errorf("step %q: %w", match[1], err)
+			return template
+		}
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				renderErr = err
+				return template
+			}
+			s = string(b)
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			renderErr = err
+			return template
+		}
+		return string(b[1 : len(b)-1])
+	})
+	if renderErr != nil {
+		return "", renderErr
+	}
+	return rendered, nil
+}
+
+func (pipeline *Pipeline) BeforeCreate(*gorm.DB) error {
+	pipeline.Status = PipelineStatusRunning
+	for i := range pipeline.Steps {
+		pipeline.Steps[i].Status = PipelineStepPending
+	}
+	return nil
+}
+
+func (pipeline *Pipeline) Save(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := db.WithContext(dbCtx).Save(pipeline).Error; err != nil {
+		return err
+	}
+	Publish(PipelineKey)
+	return nil
+}
+
+func (pipeline *Pipeline) Update(ctx context.Context, db *gorm.DB, values map[string]interface{}) error {
+	if pipeline.ID == 0 {
+		return errors.New("Pipeline.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(pipeline).Updates(values).Error
+}
+
+var ErrPipelineNotRunning = errors.New("pipeline is not running")
+
+// End sets the status of the running pipeline, or returns ErrPipelineNotRunning if it has ended
+func (pipeline *Pipeline) End(ctx context.Context, db *gorm.DB, status PipelineStatus, reason string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	res := db.WithContext(dbCtx).Model(pipeline).
+		Where("status = ?", PipelineStatusRunning).
+		Updates(map[string]interface{}{"status": status, "error": reason})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrPipelineNotRunning
+	}
+	return nil
+}
+
+// Sync reloads the pipeline with its steps
+func (pipeline *Pipeline) Sync(ctx context.Context, db *gorm.DB) error {
+	synced, err := GetPipelineByID(ctx, db, pipeline.ID)
+	if err != nil {
+		return err
+	}
+	*pipeline = *synced
+	return nil
+}
+
+func (step *PipelineStep) Update(ctx context.Context, db *gorm.DB, values map[string]interface{}) error {
+	if step.ID == 0 {
+		return errors.New("PipelineStep.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(step).Updates(values).Error
+}
+
+func GetPipelineByID(ctx context.Context, db *gorm.DB, id uint) (*Pipeline, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	pipeline := Pipeline{}
+	err := db.WithContext(dbCtx).Model(&Pipeline{}).
+		Preload("Steps", func(tx *gorm.DB) *gorm.DB { return tx.Order("id ASC") }).
+		Preload("Client").
+		Where("id = ?", id).
+		First(&pipeline).Error
+	if err != nil {
+		return nil, err
+	}
+	return &pipeline, nil
+}
+
+func GetRunningPipelines(ctx context.Context, db *gorm.DB) ([]Pipeline, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	pipelines := make([]Pipeline, 0)
+	err := db.WithContext(dbCtx).Model(&Pipeline{}).
+		Preload("Steps", func(tx *gorm.DB) *gorm.DB { return tx.Order("id ASC") }).
+		Preload("Client").
+		Where("status = ?", PipelineStatusRunning).
+		Order("id ASC").
+		Find(&pipelines).Error
+	if err != nil {
+		return nil, err
+	}
+	return pipelines, nil
+}
@@ -0,0 +1,110 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"errors"
+	"testing"
+)
+
+func TestValidatePipelineSteps(t *testing.T) {
+	steps := []models.PipelineStep{
+		{Name: "expand", Type: models.PipelineStepLLM, TaskArgs: `{"messages":[]}`},
+		{Name: "render", Type: models.PipelineStepImage, TaskArgs: `{"prompt":"{{steps.expand.content}}"}`},
+		{Name: "caption", Type: models.PipelineStepLLM, TaskArgs: `{"image":"{{ steps.render.images.0 }}"}`, DependsOn: models.StringArray{"expand"}},
+	}
+	if err := models.ValidatePipelineSteps(steps); err != nil {
+		t.Fatal(err)
+	}
+	deps := steps[2].Dependencies()
+	if len(deps) != 2 || deps[0] != "expand" || deps[1] != "render" {
+		t.Fatalf("unexpected dependencies %v", deps)
+	}
+
+	steps[0].DependsOn = models.StringArray{"caption"}
+	if err := models.ValidatePipelineSteps(steps); err == nil {
+		t.Fatal("cycle is not detected")
+	}
+	steps[0].DependsOn = models.StringArray{"unknown"}
+	if err := models.ValidatePipelineSteps(steps); err == nil {
+		t.Fatal("unknown step is not detected")
+	}
+	steps[0].DependsOn = nil
+	steps[1].Name = "expand"
+	if err := models.ValidatePipelineSteps(steps); err == nil {
+		t.Fatal("duplicate step name is not detected")
+	}
+}
+
+func TestRenderTaskArgs(t *testing.T) {
+	outputs := map[string]map[string]interface{}{
+		"expand": {"content": "a \"red\" fox\nin snow"},
+		"render": {"images": []interface{}{"/v1/a/0", "/v1/a/1"}, "client_task_id": float64(3)},
+	}
+	rendered, err := models.RenderTaskArgs(`{"prompt":"{{steps.expand.content}}, {{steps.render.images.1}} #{{steps.render.client_task_id}}"}`, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"prompt":"a \"red\" fox\nin snow, /v1/a/1 #3"}`
+	if rendered != want {
+		t.Fatalf("rendered %s, want %s", rendered, want)
+	}
+
+	if _, err := models.RenderTaskArgs(`{"prompt":"{{steps.render.images.2}}"}`, outputs); err == nil {
+		t.Fatal("missing output item is not reported")
+	}
+	if _, err := models.RenderTaskArgs(`{"prompt":"{{steps.caption.content}}"}`, outputs); err == nil {
+		t.Fatal("missing step output is not reported")
+	}
+}
+
+func TestPipelineEnd(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.Client{}, &models.Pipeline{}, &models.PipelineStep{})
+
+	client := &models.Client{ClientId: "client"}
+	if err := db.Create(client).Error; err != nil {
+		t.Fatal(err)
+	}
+	pipeline := &models.Pipeline{
+		ClientID: client.ID,
+		Steps: []models.PipelineStep{
+			{Name: "expand", Type: models.PipelineStepLLM},
+			{Name: "render", Type: models.PipelineStepImage},
+		},
+	}
+	if err := pipeline.Save(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := models.GetRunningPipelines(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || len(running[0].Steps) != 2 || running[0].Client.ClientId != "client" {
+		t.Fatalf("unexpected running pipelines %+v", running)
+	}
+	step := &running[0].Steps[0]
+	if step.Status != models.PipelineStepPending {
+		t.Fatalf("step status %s, want %s", step.Status, models.PipelineStepPending)
+	}
+	if err := step.Update(ctx, db, map[string]interface{}{"status": models.PipelineStepRunning}); err != nil {
+		t.Fatal(err)
+	}
+	if step.Status != models.PipelineStepRunning {
+		t.Fatalf("step status %s is not updated", step.Status)
+	}
+
+	if err := pipeline.End(ctx, db, models.PipelineStatusCancelled, "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeline.End(ctx, db, models.PipelineStatusFailed, "failed"); !errors.Is(err, models.ErrPipelineNotRunning) {
+		t.Fatalf("end twice error %v, want %v", err, models.ErrPipelineNotRunning)
+	}
+	if err := pipeline.Sync(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if pipeline.Status != models.PipelineStatusCancelled || pipeline.Steps[0].Status != models.PipelineStepRunning {
+		t.Fatalf("unexpected pipeline %+v", pipeline)
+	}
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Priority is the scheduling class of the tasks of a client, set on its API key
+type Priority string
+
+const (
+	// interactive traffic like chat completions
+	PriorityRealtime Priority = "realtime"
+	PriorityStandard Priority = "standard"
+	PriorityBatch    Priority = "batch"
+	// the benchmark tasks generated by the bridge itself
+	PriorityAuto Priority = "auto"
+)
+
+var Priorities = []Priority{PriorityRealtime, PriorityStandard, PriorityBatch, PriorityAuto}
+
+func IsPriority(priority Priority) bool {
+	for _, p := range Priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClientPriority returns the priority of the API key of the client,
+// or PriorityStandard if the client has no API key
+func GetClientPriority(ctx context.Context, db *gorm.DB, clientID string) (Priority, error) {
+	apiKey, err := GetAPIKeyByClientID(ctx, db, clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return PriorityStandard, nil
+		}
+		return "", err
+	}
+	if len(apiKey.Priority) == 0 {
+		return PriorityStandard, nil
+	}
+	return apiKey.Priority, nil
+}
+
+// GetClientVerificationReplicas returns the verification replicas set on the API key of the client,
+// or 0 if the client has no API key
+func GetClientVerificationReplicas(ctx context.Context, db *gorm.DB, clientID string) (int, error) {
+	apiKey, err := GetAPIKeyByClientID(ctx, db, clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return apiKey.VerificationReplicas, nil
+}
+
+// inFlightTaskStatuses are the statuses of the tasks submitted to the relay and not ended yet
+var inFlightTaskStatuses = []TaskStatus{
+	InferenceTaskCreated,
+	InferenceTaskStarted,
+	InferenceTaskParamsUploaded,
+	InferenceTaskScoreReady,
+	InferenceTaskErrorReported,
+	InferenceTaskValidated,
+}
+
+// CountInFlightTasks returns the number of in-flight relay tasks of each client
+func CountInFlightTasks(ctx context.Context, db *gorm.DB) (map[uint]int, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	var rows []struct {
+		ClientID uint
+		Count    int
+	}
+	err := db.WithContext(dbCtx).Model(&InferenceTask{}).
+		Select("client_id, COUNT(*) AS count").
+		Where("status IN ?", inFlightTaskStatuses).
+		Group("client_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int)
+	for _, row := range rows {
+		counts[row.ClientID] = row.Count
+	}
+	return counts, nil
+}
+
+func pendingSubmissions(tx *gorm.DB) *gorm.DB {
+	return tx.Where("status = ?", InferenceTaskPending).
+		Where("next_poll_at <= ?", time.Now())
+}
+
+// GetPendingSubmissions returns the pending tasks waiting to be submitted to the relay, not leased by any instance,
+// in the order of creation: at most limit validation tasks, and the oldest perClient other tasks of each client.
+// The tasks are taken per client, so that a client with many pending tasks cannot hide the tasks of the others.
+func GetPendingSubmissions(ctx context.Context, db *gorm.DB, limit, perClient int) ([]InferenceTask, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := func() *gorm.DB {
+		return db.WithContext(dbCtx).Model(&InferenceTask{}).
+			Scopes(pendingSubmissions).
+			Where("lease_owner = '' OR lease_owner IS NULL OR lease_expires_at < ?", time.Now())
+	}
+	columns := []string{"id", "client_id", "priority", "vrf_proof"}
+
+	tasks := make([]InferenceTask, 0)
+	err := query().Select(columns).
+		Where("vrf_proof <> ''").
+		Order("id ASC").
+		Limit(limit).
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var clientIDs []uint
+	err = query().
+		Where("vrf_proof = '' OR vrf_proof IS NULL").
+		Distinct("client_id").
+		Pluck("client_id", &clientIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, clientID := range clientIDs {
+		heads := make([]InferenceTask, 0)
+		err := query().Select(columns).
+			Where("client_id = ?", clientID).
+			Where("vrf_proof = '' OR vrf_proof IS NULL").
+			Order("id ASC").
+			Limit(perClient).
+			Find(&heads).Error
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, heads...)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks, nil
+}
+
+// ClaimPendingSubmissions leases the pending tasks of ids to owner. The tasks claimed by
+// other instances in between are skipped.
+func ClaimPendingSubmissions(ctx context.Context, db *gorm.DB, ids []uint, owner string, duration time.Duration) ([]InferenceTask, error) {
+	tasks := make([]InferenceTask, 0)
+	if len(ids) == 0 {
+		return tasks, nil
+	}
+	scope := func(tx *gorm.DB) *gorm.DB {
+		return tx.Scopes(pendingSubmissions).Where("id IN ?", ids).Order("id ASC")
+	}
+	claimed, err := claimRows(ctx, db, &InferenceTask{}, scope, owner, len(ids), duration)
+	if len(claimed) == 0 {
+		return tasks, err
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := db.WithContext(dbCtx).Model(&InferenceTask{}).Where("id IN ?", claimed).Order("id ASC").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, err
+}
@@ -0,0 +1,101 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestPendingSubmissions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{}, &models.FeeDecision{})
+
+	statuses := []models.TaskStatus{models.InferenceTaskPending, models.InferenceTaskPending, models.InferenceTaskStarted, models.InferenceTaskResultDownloaded}
+	for i, status := range statuses {
+		task := &models.InferenceTask{ClientID: uint(i%2 + 1), Priority: models.PriorityBatch, NextPollAt: time.Now().Add(-time.Second)}
+		if err := db.Create(task).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Model(task).Update("status", status).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inFlight, err := models.CountInFlightTasks(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inFlight) != 1 || inFlight[1] != 1 {
+		t.Fatalf("unexpected in-flight tasks %v", inFlight)
+	}
+
+	pending, err := models.GetPendingSubmissions(ctx, db, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Priority != models.PriorityBatch {
+		t.Fatalf("unexpected pending tasks %+v", pending)
+	}
+
+	// the pending tasks are not claimed as due tasks, they wait for the submission queue
+	due, err := models.ClaimDueInferenceTasks(ctx, db, "a", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].Status != models.InferenceTaskStarted {
+		t.Fatalf("unexpected due tasks %+v", due)
+	}
+
+	claimed, err := models.ClaimPendingSubmissions(ctx, db, []uint{pending[0].ID}, "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != pending[0].ID {
+		t.Fatalf("unexpected claimed tasks %+v", claimed)
+	}
+	claimed, err = models.ClaimPendingSubmissions(ctx, db, []uint{pending[0].ID, pending[1].ID}, "b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != pending[1].ID {
+		t.Fatalf("b claimed %+v, want only task %d", claimed, pending[1].ID)
+	}
+}
+
+func TestPendingSubmissionsPerClient(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{}, &models.FeeDecision{})
+
+	// the batch client has many pending tasks before the task of the realtime client
+	tasks := make([]*models.InferenceTask, 0)
+	for i := 0; i < 5; i++ {
+		tasks = append(tasks, &models.InferenceTask{ClientID: 1, Priority: models.PriorityBatch})
+	}
+	tasks = append(tasks, &models.InferenceTask{ClientID: 1, Priority: models.PriorityBatch, VRFProof: "0x01"})
+	tasks = append(tasks, &models.InferenceTask{ClientID: 2, Priority: models.PriorityRealtime})
+	for _, task := range tasks {
+		task.NextPollAt = time.Now().Add(-time.Second)
+		if err := db.Create(task).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending, err := models.GetPendingSubmissions(ctx, db, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint, len(pending))
+	for i, task := range pending {
+		ids[i] = task.ID
+	}
+	want := []uint{tasks[0].ID, tasks[1].ID, tasks[5].ID, tasks[6].ID}
+	if len(ids) != len(want) {
+		t.Fatalf("pending tasks %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("pending tasks %v, want %v", ids, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// endedTaskStatuses are the statuses of the tasks whose result files will not change anymore
+var endedTaskStatuses = []TaskStatus{
+	InferenceTaskEndAborted,
+	InferenceTaskEndInvalidated,
+	InferenceTaskEndGroupRefund,
+	InferenceTaskResultDownloaded,
+	InferenceTaskResultMismatch,
+}
+
+// GetExpiredResultTasks returns at most limit tasks selected by scope, which have ended before finishedBefore
+// and whose results have not been deleted yet
+func GetExpiredResultTasks(ctx context.Context, db *gorm.DB, scope func(*gorm.DB) *gorm.DB, finishedBefore time.Time, limit int) ([]InferenceTask, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	tasks := make([]InferenceTask, 0)
+	err := db.WithContext(dbCtx).Model(&InferenceTask{}).
+		Scopes(scope).
+		Where("status IN ?", endedTaskStatuses).
+		Where("result_deleted_at IS NULL").
+		Where("updated_at < ?", finishedBefore).
+		Order("id ASC").
+		Limit(limit).
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// MarkResultDeleted records that the result files of the task have been deleted by the retention policy
+func (task *InferenceTask) MarkResultDeleted(ctx context.Context, db *gorm.DB) error {
+	if task.ID == 0 {
+		return errors.New("InferenceTask.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	now := time.Now()
+	// updated_at is kept, as it is the time the task ended
+	if err := db.WithContext(dbCtx).Model(task).UpdateColumn("result_deleted_at", now).Error; err != nil {
+		return err
+	}
+	task.ResultDeletedAt = &now
+	return nil
+}
+
+// ResultExpired reports whether the result files of the task have been deleted by the retention policy
+func (task *InferenceTask) ResultExpired() bool {
+	return task.ResultDeletedAt != nil
+}
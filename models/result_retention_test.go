@@ -0,0 +1,65 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestGetExpiredResultTasks(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.InferenceTask{}, &models.TaskStatusEvent{})
+
+	now := time.Now()
+	newTask := func(taskType models.ChainTaskType, status models.TaskStatus, endedAt time.Time) *models.InferenceTask {
+		task := &models.InferenceTask{ClientID: 1, TaskType: taskType, TaskIDCommitment: "0x01"}
+		if err := db.Create(task).Error; err != nil {
+			t.Fatal(err)
+		}
+		err := db.Model(task).UpdateColumns(map[string]interface{}{"status": status, "updated_at": endedAt}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+	expired := newTask(models.TaskTypeSD, models.InferenceTaskResultDownloaded, now.Add(-2*time.Hour))
+	aborted := newTask(models.TaskTypeSD, models.InferenceTaskEndAborted, now.Add(-3*time.Hour))
+	newTask(models.TaskTypeSD, models.InferenceTaskResultDownloaded, now)
+	newTask(models.TaskTypeSD, models.InferenceTaskStarted, now.Add(-2*time.Hour))
+	newTask(models.TaskTypeLLM, models.InferenceTaskResultDownloaded, now.Add(-2*time.Hour))
+
+	sdTasks := func(db *gorm.DB) *gorm.DB {
+		return db.Where("task_type = ?", models.TaskTypeSD)
+	}
+	tasks, err := models.GetExpiredResultTasks(ctx, db, sdTasks, now.Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].ID != expired.ID || tasks[1].ID != aborted.ID {
+		t.Fatalf("wrong expired tasks %+v", tasks)
+	}
+
+	if err := tasks[0].MarkResultDeleted(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if !tasks[0].ResultExpired() {
+		t.Fatal("task result should be expired")
+	}
+	if err := expired.Sync(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if !expired.ResultExpired() || expired.UpdatedAt.After(now.Add(-time.Hour)) {
+		t.Fatalf("wrong task after the result is deleted %+v", expired)
+	}
+
+	tasks, err = models.GetExpiredResultTasks(ctx, db, sdTasks, now.Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != aborted.ID {
+		t.Fatalf("wrong expired tasks after deletion %+v", tasks)
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+// ResultVerification is the outcome of checking the downloaded results of a task against its score
+type ResultVerification string
+
+const (
+	// the results have not been verified yet
+	ResultVerificationNone ResultVerification = ""
+	// the hashes of the results match the score
+	ResultVerificationVerified ResultVerification = "verified"
+	// the hashes of the results do not match the score
+	ResultVerificationMismatch ResultVerification = "mismatch"
+	// the results cannot be verified, because the verification is disabled, the task has no score
+	// or the results of the task type have no hashes, like the finetune checkpoints
+	ResultVerificationSkipped ResultVerification = "skipped"
+)
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"net/url"
-	"os"
 	"reflect"
 
 	"github.com/santhosh-tekuri/jsonschema/v5"
@@ -337,9 +336,6 @@ func GetSDFTTaskConfigCheckpoint(taskArgs string) (string, error) {
 	if !ok {
 		return "", errors.New("checkpoint is not a string")
 	}
-	if _, err := os.Stat(checkpoint); os.IsNotExist(err) {
-		return "", errors.New("checkpoint does not exist")
-	}
 	return checkpoint, nil
 }
 
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AttemptDecision string
+
+const (
+	AttemptDecisionRetry          AttemptDecision = "retry"
+	AttemptDecisionRaiseFee       AttemptDecision = "raise_fee"
+	AttemptDecisionRaiseVram      AttemptDecision = "raise_vram"
+	AttemptDecisionFail           AttemptDecision = "fail"
+	AttemptDecisionNextSegment    AttemptDecision = "next_segment"
+	AttemptDecisionFinish         AttemptDecision = "finish"
+	AttemptDecisionBudgetExceeded AttemptDecision = "budget_exceeded"
+)
+
+// TaskAttempt records the outcome of one inference task of a client task
+// and the decision taken for the next one
+type TaskAttempt struct {
+	RootModel
+	ClientTaskID    uint            `json:"client_task_id" gorm:"index"`
+	InferenceTaskID uint            `json:"inference_task_id" gorm:"index"`
+	Attempt         int             `json:"attempt"`
+	TaskStatus      TaskStatus      `json:"task_status"`
+	AbortReason     TaskAbortReason `json:"abort_reason"`
+	TaskError       TaskError       `json:"task_error"`
+	Reason          string          `json:"reason"`
+	Decision        AttemptDecision `json:"decision"`
+	TaskFee         uint64          `json:"task_fee"`
+	MinVram         uint64          `json:"min_vram"`
+	Backoff         uint64          `json:"backoff"`
+	NextTaskID      uint            `json:"next_task_id"`
+}
+
+func (attempt *TaskAttempt) Save(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Save(attempt).Error
+}
+
+func (attempt *TaskAttempt) Update(ctx context.Context, db *gorm.DB, newAttempt *TaskAttempt) error {
+	if attempt.ID == 0 {
+		return errors.New("TaskAttempt.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(attempt).Updates(newAttempt).Error
+}
+
+// NextAttemptAt returns the earliest time the next task of this attempt can be submitted
+func (attempt *TaskAttempt) NextAttemptAt() time.Time {
+	return attempt.CreatedAt.Add(time.Duration(attempt.Backoff) * time.Second)
+}
+
+// GetTaskAttemptByInferenceTaskID returns nil if no decision has been made for the inference task yet
+func GetTaskAttemptByInferenceTaskID(ctx context.Context, db *gorm.DB, inferenceTaskID uint) (*TaskAttempt, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	attempt := TaskAttempt{}
+	err := db.WithContext(dbCtx).Model(&TaskAttempt{}).Where("inference_task_id = ?", inferenceTaskID).First(&attempt).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func GetTaskAttempts(ctx context.Context, db *gorm.DB, clientTaskID uint) ([]TaskAttempt, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	attempts := make([]TaskAttempt, 0)
+	err := db.WithContext(dbCtx).Model(&TaskAttempt{}).Where("client_task_id = ?", clientTaskID).Order("id ASC").Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func CountTaskAttempts(ctx context.Context, db *gorm.DB, clientTaskID uint) (int, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	var count int64
+	err := db.WithContext(dbCtx).Model(&TaskAttempt{}).Where("client_task_id = ?", clientTaskID).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TaskEventType string
+
+const (
+	TaskEventClientTask    TaskEventType = "client_task"
+	TaskEventInferenceTask TaskEventType = "inference_task"
+)
+
+// TaskEvent is a status transition of a client task or one of its inference tasks.
+// The ids are increasing, and are used as the event ids of the event stream.
+type TaskEvent struct {
+	ID              uint          `json:"id" gorm:"primarykey"`
+	CreatedAt       time.Time     `json:"created_at"`
+	ClientTaskID    uint          `json:"client_task_id" gorm:"index"`
+	InferenceTaskID uint          `json:"inference_task_id"`
+	Type            TaskEventType `json:"type"`
+	Data            string        `json:"data" gorm:"type:text"`
+}
+
+// TaskEventKey is published when new events of the client task are recorded
+func TaskEventKey(clientTaskID uint) string {
+	return fmt.Sprintf("task_events:%d", clientTaskID)
+}
+
+func recordTaskEvent(db *gorm.DB, clientTaskID, inferenceTaskID uint, eventType TaskEventType, data interface{}) error {
+	// auto tasks do not belong to a client task, and nobody listens to them
+	if clientTaskID == 0 {
+		return nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	event := &TaskEvent{
+		ClientTaskID:    clientTaskID,
+		InferenceTaskID: inferenceTaskID,
+		Type:            eventType,
+		Data:            string(b),
+	}
+	if err := db.Create(event).Error; err != nil {
+		return err
+	}
+	Publish(TaskEventKey(clientTaskID))
+	return nil
+}
+
+// onStatusChanged records the status transition and emits the webhook event of it.
+// db should be the transaction that changes the status.
+func (task *ClientTask) onStatusChanged(db *gorm.DB) error {
+	data := task.eventData()
+	if err := recordTaskEvent(db, task.ID, 0, TaskEventClientTask, data); err != nil {
+		return err
+	}
+	if event, ok := task.webhookEvent(); ok {
+		return enqueueWebhookEvent(db, task.ClientID, event, data)
+	}
+	return nil
+}
+
+func (task *InferenceTask) onStatusChanged(db *gorm.DB) error {
+	data := task.eventData()
+	if err := recordTaskEvent(db, task.ClientTaskID, task.ID, TaskEventInferenceTask, data); err != nil {
+		return err
+	}
+	if event, ok := task.webhookEvent(); ok {
+		return enqueueWebhookEvent(db, task.ClientID, event, data)
+	}
+	return nil
+}
+
+// GetTaskEvents returns the events of the client task after the event of afterID
+func GetTaskEvents(ctx context.Context, db *gorm.DB, clientTaskID, afterID uint, limit int) ([]TaskEvent, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	events := make([]TaskEvent, 0)
+	err := db.WithContext(dbCtx).Model(&TaskEvent{}).
+		Where("client_task_id = ? AND id > ?", clientTaskID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetRecentTaskEvents returns the events of the client task up to the event of beforeID that are created since the time.
+// An event of a lower id may commit after one of a higher id, and is only found by reading the recent events again.
+func GetRecentTaskEvents(ctx context.Context, db *gorm.DB, clientTaskID, beforeID uint, since time.Time) ([]TaskEvent, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	events := make([]TaskEvent, 0)
+	err := db.WithContext(dbCtx).Model(&TaskEvent{}).
+		Where("client_task_id = ? AND id <= ? AND created_at >= ?", clientTaskID, beforeID, since).
+		Order("id ASC").
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
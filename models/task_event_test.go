@@ -0,0 +1,80 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestTaskEvents(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.ClientTask{}, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{}, &models.FeeDecision{})
+
+	clientTask := &models.ClientTask{ClientID: 1}
+	if err := db.Create(clientTask).Error; err != nil {
+		t.Fatal(err)
+	}
+	task := &models.InferenceTask{ClientID: 1, ClientTaskID: clientTask.ID}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []models.TaskStatus{models.InferenceTaskCreated, models.InferenceTaskCreated, models.InferenceTaskStarted} {
+		if err := task.Update(ctx, db, &models.InferenceTask{Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := models.GetTaskEvents(ctx, db, clientTask.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// client task created, inference task created and started, the repeated status is not an event
+	if len(events) != 3 {
+		t.Fatalf("%d events, want 3", len(events))
+	}
+	if events[0].Type != models.TaskEventClientTask || events[2].Type != models.TaskEventInferenceTask {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events, err = models.GetTaskEvents(ctx, db, clientTask.ID, events[1].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].InferenceTaskID != task.ID {
+		t.Fatalf("unexpected events after the second one %+v", events)
+	}
+}
+
+func TestRecentTaskEvents(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.TaskEvent{})
+
+	now := time.Now()
+	events := []models.TaskEvent{
+		{ClientTaskID: 1, CreatedAt: now.Add(-time.Minute)},
+		{ClientTaskID: 1, CreatedAt: now},
+		{ClientTaskID: 2, CreatedAt: now},
+		{ClientTaskID: 1, CreatedAt: now},
+	}
+	for i := range events {
+		if err := db.Create(&events[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recent, err := models.GetRecentTaskEvents(ctx, db, 1, events[3].ID, now.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].ID != events[1].ID || recent[1].ID != events[3].ID {
+		t.Fatalf("only the recent events of the client task should be read %+v", recent)
+	}
+	recent, err = models.GetRecentTaskEvents(ctx, db, 1, events[1].ID, now.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 || recent[0].ID != events[1].ID {
+		t.Fatalf("the events after beforeID should not be read %+v", recent)
+	}
+}
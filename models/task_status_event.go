@@ -0,0 +1,216 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaskStatusEvent is a status transition of an inference task,
+// with the relay side state of the task at the time of the transition
+type TaskStatusEvent struct {
+	ID              uint       `json:"id" gorm:"primarykey"`
+	CreatedAt       time.Time  `json:"created_at"`
+	InferenceTaskID uint       `json:"inference_task_id" gorm:"index"`
+	ClientTaskID    uint       `json:"client_task_id" gorm:"index"`
+	FromStatus      TaskStatus `json:"from_status"`
+	Status          TaskStatus `json:"status" gorm:"index"`
+
+	SelectedNode       string     `json:"selected_node"`
+	QOSScore           uint64     `json:"qos_score"`
+	StartTime          *time.Time `json:"start_time,omitempty"`
+	ScoreReadyTime     *time.Time `json:"score_ready_time,omitempty"`
+	ValidatedTime      *time.Time `json:"validated_time,omitempty"`
+	ResultUploadedTime *time.Time `json:"result_uploaded_time,omitempty"`
+}
+
+func recordTaskStatusEvent(db *gorm.DB, task *InferenceTask, fromStatus TaskStatus) error {
+	event := &TaskStatusEvent{
+		InferenceTaskID:    task.ID,
+		ClientTaskID:       task.ClientTaskID,
+		FromStatus:         fromStatus,
+		Status:             task.Status,
+		SelectedNode:       task.SelectedNode,
+		QOSScore:           task.QOSScore,
+		StartTime:          task.StartTime,
+		ScoreReadyTime:     task.ScoreReadyTime,
+		ValidatedTime:      task.ValidatedTime,
+		ResultUploadedTime: task.ResultUploadedTime,
+	}
+	return db.Create(event).Error
+}
+
+func GetTaskStatusEvents(ctx context.Context, db *gorm.DB, inferenceTaskIDs []uint) ([]TaskStatusEvent, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	events := make([]TaskStatusEvent, 0)
+	if len(inferenceTaskIDs) == 0 {
+		return events, nil
+	}
+	err := db.WithContext(dbCtx).Model(&TaskStatusEvent{}).
+		Where("inference_task_id IN ?", inferenceTaskIDs).
+		Order("id ASC").
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// TaskLatency is the time spent in each stage of a successful inference task, in milliseconds.
+// The stage is 0 if its boundaries are unknown.
+type TaskLatency struct {
+	// from the creation of the task in the bridge to the start of it on the relay
+	QueueWait int64 `json:"queue_wait"`
+	// from the start of the task to the score submitted by the node
+	Execution int64 `json:"execution"`
+	// from the score submitted to the task validated
+	Validation int64 `json:"validation"`
+	// from the task validated to the result downloaded by the bridge, including the result upload of the node
+	Download int64 `json:"download"`
+	Total    int64 `json:"total"`
+}
+
+func millisecondsBetween(start, end *time.Time) int64 {
+	if start == nil || end == nil || end.Before(*start) {
+		return 0
+	}
+	return end.Sub(*start).Milliseconds()
+}
+
+// Latency computes the latency of the task from its relay timestamps.
+// downloadedTime is the time the task turned to InferenceTaskResultDownloaded, nil if it hasn't.
+func (task *InferenceTask) Latency(downloadedTime *time.Time) TaskLatency {
+	createdAt := task.CreatedAt
+	return TaskLatency{
+		QueueWait:  millisecondsBetween(&createdAt, task.StartTime),
+		Execution:  millisecondsBetween(task.StartTime, task.ScoreReadyTime),
+		Validation: millisecondsBetween(task.ScoreReadyTime, task.ValidatedTime),
+		Download:   millisecondsBetween(task.ValidatedTime, downloadedTime),
+		Total:      millisecondsBetween(&createdAt, downloadedTime),
+	}
+}
+
+// GetTaskStatusTimes returns the time each task turned to the status
+func GetTaskStatusTimes(ctx context.Context, db *gorm.DB, inferenceTaskIDs []uint, status TaskStatus) (map[uint]time.Time, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	times := make(map[uint]time.Time)
+	if len(inferenceTaskIDs) == 0 {
+		return times, nil
+	}
+	var events []TaskStatusEvent
+	err := db.WithContext(dbCtx).Model(&TaskStatusEvent{}).
+		Select("inference_task_id", "created_at").
+		Where("inference_task_id IN ? AND status = ?", inferenceTaskIDs, status).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		times[event.InferenceTaskID] = event.CreatedAt
+	}
+	return times, nil
+}
+
+// LatencyStats is the latency distribution of one stage, in milliseconds
+type LatencyStats struct {
+	Avg int64 `json:"avg"`
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+}
+
+func newLatencyStats(values []int64) LatencyStats {
+	stats := LatencyStats{}
+	if len(values) == 0 {
+		return stats
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	var sum int64
+	for _, v := range values {
+		sum += v
+	}
+	percentile := func(p int) int64 {
+		return values[(len(values)-1)*p/100]
+	}
+	stats.Avg = sum / int64(len(values))
+	stats.P50 = percentile(50)
+	stats.P90 = percentile(90)
+	stats.P99 = percentile(99)
+	stats.Max = values[len(values)-1]
+	return stats
+}
+
+type LatencyBreakdown struct {
+	TaskCount  int          `json:"task_count"`
+	QueueWait  LatencyStats `json:"queue_wait"`
+	Execution  LatencyStats `json:"execution"`
+	Validation LatencyStats `json:"validation"`
+	Download   LatencyStats `json:"download"`
+	Total      LatencyStats `json:"total"`
+}
+
+// GetLatencyBreakdown aggregates the latencies of the inference tasks created after since,
+// whose results have been downloaded. A nil taskType counts tasks of all types.
+func GetLatencyBreakdown(ctx context.Context, db *gorm.DB, since time.Time, taskType *ChainTaskType) (*LatencyBreakdown, error) {
+	var queueWait, execution, validation, download, total []int64
+
+	offset := 0
+	limit := 500
+	for {
+		var tasks []InferenceTask
+		err := func() error {
+			dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			query := db.WithContext(dbCtx).Model(&InferenceTask{}).
+				Where("created_at >= ? AND status = ?", since, InferenceTaskResultDownloaded)
+			if taskType != nil {
+				query = query.Where("task_type = ?", *taskType)
+			}
+			return query.Order("id").Offset(offset).Limit(limit).Find(&tasks).Error
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]uint, len(tasks))
+		for i, task := range tasks {
+			ids[i] = task.ID
+		}
+		downloadedTimes, err := GetTaskStatusTimes(ctx, db, ids, InferenceTaskResultDownloaded)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			downloadedTime, ok := downloadedTimes[task.ID]
+			if !ok {
+				// downloaded before the status events are recorded
+				continue
+			}
+			latency := task.Latency(&downloadedTime)
+			queueWait = append(queueWait, latency.QueueWait)
+			execution = append(execution, latency.Execution)
+			validation = append(validation, latency.Validation)
+			download = append(download, latency.Download)
+			total = append(total, latency.Total)
+		}
+
+		if len(tasks) < limit {
+			break
+		}
+		offset += limit
+	}
+
+	return &LatencyBreakdown{
+		TaskCount:  len(total),
+		QueueWait:  newLatencyStats(queueWait),
+		Execution:  newLatencyStats(execution),
+		Validation: newLatencyStats(validation),
+		Download:   newLatencyStats(download),
+		Total:      newLatencyStats(total),
+	}, nil
+}
@@ -0,0 +1,68 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+func TestTaskStatusEvents(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.InferenceTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{}, &models.TaskStatusEvent{}, &models.FeeDecision{})
+
+	task := &models.InferenceTask{ClientID: 1}
+	if err := db.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	startTime := task.CreatedAt.Add(time.Second)
+	scoreReadyTime := startTime.Add(2 * time.Second)
+	validatedTime := scoreReadyTime.Add(3 * time.Second)
+	updates := []*models.InferenceTask{
+		{Status: models.InferenceTaskStarted, StartTime: &startTime, SelectedNode: "0x01", QOSScore: 4},
+		{Status: models.InferenceTaskScoreReady, ScoreReadyTime: &scoreReadyTime},
+		{Status: models.InferenceTaskValidated, ValidatedTime: &validatedTime},
+		{Status: models.InferenceTaskEndSuccess},
+		{Status: models.InferenceTaskResultDownloaded},
+	}
+	for _, newTask := range updates {
+		if err := task.Update(ctx, db, newTask); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := models.GetTaskStatusEvents(ctx, db, []uint{task.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the creation and every transition
+	if len(events) != 6 {
+		t.Fatalf("%d events, want 6", len(events))
+	}
+	if events[1].FromStatus != models.InferenceTaskPending || events[1].Status != models.InferenceTaskStarted || events[1].SelectedNode != "0x01" {
+		t.Fatalf("unexpected started event %+v", events[1])
+	}
+	if events[5].FromStatus != models.InferenceTaskEndSuccess || events[5].ValidatedTime == nil {
+		t.Fatalf("unexpected downloaded event %+v", events[5])
+	}
+
+	breakdown, err := models.GetLatencyBreakdown(ctx, db, task.CreatedAt.Add(-time.Minute), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breakdown.TaskCount != 1 {
+		t.Fatalf("%d tasks counted, want 1", breakdown.TaskCount)
+	}
+	if breakdown.QueueWait.Avg != 1000 || breakdown.Execution.Avg != 2000 || breakdown.Validation.P50 != 3000 {
+		t.Fatalf("unexpected latency breakdown %+v", breakdown)
+	}
+
+	taskType := models.TaskTypeLLM
+	breakdown, err = models.GetLatencyBreakdown(ctx, db, task.CreatedAt.Add(-time.Minute), &taskType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breakdown.TaskCount != 0 {
+		t.Fatalf("%d LLM tasks counted, want 0", breakdown.TaskCount)
+	}
+}
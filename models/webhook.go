@@ -0,0 +1,349 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WebhookEvent string
+
+const (
+	WebhookEventClientTaskCreated      WebhookEvent = "client_task.created"
+	WebhookEventClientTaskSuccess      WebhookEvent = "client_task.success"
+	WebhookEventClientTaskFailed       WebhookEvent = "client_task.failed"
+	WebhookEventClientTaskCancelled    WebhookEvent = "client_task.cancelled"
+	WebhookEventInferenceTaskCreated   WebhookEvent = "inference_task.created"
+	WebhookEventInferenceTaskStarted   WebhookEvent = "inference_task.started"
+	WebhookEventInferenceTaskValidated WebhookEvent = "inference_task.validated"
+	WebhookEventInferenceTaskSuccess   WebhookEvent = "inference_task.success"
+	WebhookEventInferenceTaskFailed    WebhookEvent = "inference_task.failed"
+	WebhookEventInferenceTaskAborted   WebhookEvent = "inference_task.aborted"
+)
+
+var WebhookEvents = []WebhookEvent{
+	WebhookEventClientTaskCreated,
+	WebhookEventClientTaskSuccess,
+	WebhookEventClientTaskFailed,
+	WebhookEventClientTaskCancelled,
+	WebhookEventInferenceTaskCreated,
+	WebhookEventInferenceTaskStarted,
+	WebhookEventInferenceTaskValidated,
+	WebhookEventInferenceTaskSuccess,
+	WebhookEventInferenceTaskFailed,
+	WebhookEventInferenceTaskAborted,
+}
+
+// Webhook is an endpoint of a client that receives the events of its tasks
+type Webhook struct {
+	RootModel
+	ClientID uint        `json:"-" gorm:"index"`
+	URL      string      `json:"url"`
+	Secret   string      `json:"-"`
+	Events   StringArray `json:"events"` // empty means all events
+}
+
+func (webhook *Webhook) Save(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Save(webhook).Error
+}
+
+func (webhook *Webhook) Delete(ctx context.Context, db *gorm.DB) error {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Delete(webhook).Error
+}
+
+// Subscribed reports whether the webhook receives event
+func (webhook *Webhook) Subscribed(event WebhookEvent) bool {
+	all := true
+	for _, e := range webhook.Events {
+		if len(e) == 0 {
+			continue
+		}
+		all = false
+		if WebhookEvent(e) == event {
+			return true
+		}
+	}
+	return all
+}
+
+func GetWebhookByID(ctx context.Context, db *gorm.DB, id uint) (*Webhook, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	webhook := Webhook{}
+	if err := db.WithContext(dbCtx).Model(&Webhook{}).Where("id = ?", id).First(&webhook).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func GetClientWebhooks(ctx context.Context, db *gorm.DB, clientID uint) ([]Webhook, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	webhooks := make([]Webhook, 0)
+	if err := db.WithContext(dbCtx).Model(&Webhook{}).Where("client_id = ?", clientID).Order("id ASC").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	// the delivery failed too many times and will not be retried unless redelivered
+	WebhookDeliveryDead WebhookDeliveryStatus = "dead"
+)
+
+// WebhookDelivery is an event waiting to be or having been sent to a webhook.
+// It is written in the same transaction as the task change that emits the event.
+type WebhookDelivery struct {
+	RootModel
+	WebhookID      uint                  `json:"webhook_id" gorm:"index"`
+	Event          WebhookEvent          `json:"event"`
+	Payload        string                `json:"payload" gorm:"type:text"`
+	Status         WebhookDeliveryStatus `json:"status" gorm:"index"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  time.Time             `json:"next_attempt_at" gorm:"index"`
+	LastStatusCode int                   `json:"last_status_code"`
+	LastError      string                `json:"last_error"`
+	DeliveredAt    time.Time             `json:"delivered_at"`
+}
+
+func (delivery *WebhookDelivery) Update(ctx context.Context, db *gorm.DB, values map[string]interface{}) error {
+	if delivery.ID == 0 {
+		return errors.New("WebhookDelivery.ID cannot be 0 when update")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return db.WithContext(dbCtx).Model(delivery).Updates(values).Error
+}
+
+// Redeliver sends the delivery again as soon as possible, regardless of its status
+func (delivery *WebhookDelivery) Redeliver(ctx context.Context, db *gorm.DB) error {
+	err := delivery.Update(ctx, db, map[string]interface{}{
+		"status":          WebhookDeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	Publish(WebhookKey)
+	return nil
+}
+
+func GetWebhookDeliveryByID(ctx context.Context, db *gorm.DB, id uint) (*WebhookDelivery, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	delivery := WebhookDelivery{}
+	if err := db.WithContext(dbCtx).Model(&WebhookDelivery{}).Where("id = ?", id).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// GetWebhookDeliveries returns the deliveries of the webhook from the latest, filtered by status if it is not empty
+func GetWebhookDeliveries(ctx context.Context, db *gorm.DB, webhookID uint, status WebhookDeliveryStatus, offset, limit int) ([]WebhookDelivery, int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := db.WithContext(dbCtx).Model(&WebhookDelivery{}).Where("webhook_id = ?", webhookID)
+	if len(status) > 0 {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	deliveries := make([]WebhookDelivery, 0)
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// RedeliverDeadWebhookDeliveries sends all the dead deliveries of the webhook again, and returns the count of them
+func RedeliverDeadWebhookDeliveries(ctx context.Context, db *gorm.DB, webhookID uint) (int64, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	res := db.WithContext(dbCtx).Model(&WebhookDelivery{}).
+		Where("webhook_id = ? AND status = ?", webhookID, WebhookDeliveryDead).
+		Updates(map[string]interface{}{
+			"status":          WebhookDeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	Publish(WebhookKey)
+	return res.RowsAffected, nil
+}
+
+// GetDueWebhookDeliveries returns the pending deliveries whose NextAttemptAt is due
+func GetDueWebhookDeliveries(ctx context.Context, db *gorm.DB, limit int) ([]WebhookDelivery, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	deliveries := make([]WebhookDelivery, 0)
+	err := db.WithContext(dbCtx).Model(&WebhookDelivery{}).
+		Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryPending, time.Now()).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+type WebhookPayload struct {
+	Event     WebhookEvent `json:"event"`
+	Timestamp int64        `json:"timestamp"`
+	Data      interface{}  `json:"data"`
+}
+
+type ClientTaskEventData struct {
+	ClientTaskID uint             `json:"client_task_id"`
+	Status       ClientTaskStatus `json:"status"`
+	FailedCount  int              `json:"failed_count"`
+}
+
+type InferenceTaskEventData struct {
+	ClientTaskID       uint            `json:"client_task_id"`
+	TaskID             uint            `json:"task_id"`
+	TaskIDCommitment   string          `json:"task_id_commitment"`
+	TaskType           ChainTaskType   `json:"task_type"`
+	Sequence           uint64          `json:"sequence"`
+	Status             TaskStatus      `json:"status"`
+	AbortReason        TaskAbortReason `json:"abort_reason"`
+	TaskError          TaskError       `json:"task_error"`
+	StartTime          *time.Time      `json:"start_time,omitempty"`
+	ScoreReadyTime     *time.Time      `json:"score_ready_time,omitempty"`
+	ValidatedTime      *time.Time      `json:"validated_time,omitempty"`
+	ResultUploadedTime *time.Time      `json:"result_uploaded_time,omitempty"`
+}
+
+// enqueueWebhookEvent writes a delivery of the event for each webhook of the client subscribing to it.
+// db should be the transaction that changes the task, so the event is emitted if and only if the change is committed.
+func enqueueWebhookEvent(db *gorm.DB, clientID uint, event WebhookEvent, data interface{}) error {
+	var webhooks []Webhook
+	if err := db.Model(&Webhook{}).Where("client_id = ?", clientID).Find(&webhooks).Error; err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(WebhookPayload{
+		Event:     event,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+	deliveries := make([]WebhookDelivery, 0)
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(event) {
+			continue
+		}
+		deliveries = append(deliveries, WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        WebhookDeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := db.Create(&deliveries).Error; err != nil {
+		return err
+	}
+	Publish(WebhookKey)
+	return nil
+}
+
+func (task *ClientTask) webhookEvent() (WebhookEvent, bool) {
+	switch task.Status {
+	case ClientTaskStatusRunning:
+		return WebhookEventClientTaskCreated, true
+	case ClientTaskStatusSuccess:
+		return WebhookEventClientTaskSuccess, true
+	case ClientTaskStatusFailed, ClientTaskStatusBudgetExceeded:
+		return WebhookEventClientTaskFailed, true
+	case ClientTaskStatusCancelled:
+		return WebhookEventClientTaskCancelled, true
+	}
+	return "", false
+}
+
+func (task *ClientTask) eventData() *ClientTaskEventData {
+	return &ClientTaskEventData{
+		ClientTaskID: task.ID,
+		Status:       task.Status,
+		FailedCount:  task.FailedCount,
+	}
+}
+
+func (task *InferenceTask) webhookEvent() (WebhookEvent, bool) {
+	switch task.Status {
+	case InferenceTaskCreated:
+		return WebhookEventInferenceTaskCreated, true
+	case InferenceTaskStarted:
+		return WebhookEventInferenceTaskStarted, true
+	case InferenceTaskValidated:
+		return WebhookEventInferenceTaskValidated, true
+	case InferenceTaskResultDownloaded:
+		return WebhookEventInferenceTaskSuccess, true
+	case InferenceTaskEndInvalidated, InferenceTaskEndGroupRefund, InferenceTaskResultMismatch:
+		return WebhookEventInferenceTaskFailed, true
+	case InferenceTaskEndAborted:
+		return WebhookEventInferenceTaskAborted, true
+	}
+	return "", false
+}
+
+func (task *InferenceTask) eventData() *InferenceTaskEventData {
+	return &InferenceTaskEventData{
+		ClientTaskID:       task.ClientTaskID,
+		TaskID:             task.ID,
+		TaskIDCommitment:   task.TaskIDCommitment,
+		TaskType:           task.TaskType,
+		Sequence:           task.Sequence,
+		Status:             task.Status,
+		AbortReason:        task.AbortReason,
+		TaskError:          task.TaskError,
+		StartTime:          task.StartTime,
+		ScoreReadyTime:     task.ScoreReadyTime,
+		ValidatedTime:      task.ValidatedTime,
+		ResultUploadedTime: task.ResultUploadedTime,
+	}
+}
+
+// SignWebhookPayload returns the hex encoded HMAC-SHA256 of "<timestamp>.<payload>" with the webhook secret.
+// Receivers should compute the same signature and reject requests with an old timestamp.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func IsWebhookEvent(event string) bool {
+	return slices.Contains(WebhookEvents, WebhookEvent(event))
+}
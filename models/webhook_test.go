@@ -0,0 +1,74 @@
+package models_test
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	payload := []byte(`{"event":"client_task.success"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(payload)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if sig := models.SignWebhookPayload("secret", 1700000000, payload); sig != expected {
+		t.Fatalf("signature %s, want %s", sig, expected)
+	}
+	if sig := models.SignWebhookPayload("secret", 1700000001, payload); sig == expected {
+		t.Fatal("signature should depend on the timestamp")
+	}
+}
+
+func TestWebhookEventOutbox(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &models.Client{}, &models.ClientTask{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.TaskEvent{})
+
+	client := &models.Client{ClientId: "client"}
+	if err := db.Create(client).Error; err != nil {
+		t.Fatal(err)
+	}
+	all := &models.Webhook{ClientID: client.ID, URL: "http://localhost/all", Secret: "a"}
+	success := &models.Webhook{ClientID: client.ID, URL: "http://localhost/success", Secret: "b", Events: models.StringArray{string(models.WebhookEventClientTaskSuccess)}}
+	for _, webhook := range []*models.Webhook{all, success} {
+		if err := webhook.Save(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clientTask := &models.ClientTask{ClientID: client.ID}
+	if err := db.Create(clientTask).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := clientTask.Update(ctx, db, &models.ClientTask{Status: models.ClientTaskStatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	allDeliveries, _, err := models.GetWebhookDeliveries(ctx, db, all.ID, "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allDeliveries) != 2 {
+		t.Fatalf("%d deliveries of all events, want 2", len(allDeliveries))
+	}
+	successDeliveries, _, err := models.GetWebhookDeliveries(ctx, db, success.ID, models.WebhookDeliveryPending, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(successDeliveries) != 1 || successDeliveries[0].Event != models.WebhookEventClientTaskSuccess {
+		t.Fatalf("deliveries of success webhook: %+v", successDeliveries)
+	}
+
+	payload := models.WebhookPayload{Data: &models.ClientTaskEventData{}}
+	if err := json.Unmarshal([]byte(successDeliveries[0].Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	data := payload.Data.(*models.ClientTaskEventData)
+	if data.ClientTaskID != clientTask.ID || data.Status != models.ClientTaskStatusSuccess {
+		t.Fatalf("unexpected payload data %+v", data)
+	}
+}
@@ -0,0 +1,141 @@
+package mockrelay
+
+import (
+	"bytes"
+	"crynux_bridge/blockchain"
+	"crynux_bridge/models"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"time"
+)
+
+// DefaultNode is the node running the tasks whose behavior does not choose one
+var DefaultNode = models.RelayNode{
+	Address:  "0x0000000000000000000000000000000000000001",
+	Status:   models.NodeStatusBusy,
+	GPUName:  "NVIDIA GeForce RTX 4090",
+	GPUVram:  24,
+	QOSScore: 1000,
+	Version:  "2.6.0",
+}
+
+// Behavior scripts how the node running a task behaves on the relay
+type Behavior struct {
+	// address of the node selected for the task, DefaultNode if empty
+	Node string
+	// time from the creation of the task to the start of it on the node
+	StartDelay time.Duration
+	// time from the start of the task to the score submitted, the error reported or the task aborted
+	ExecutionDelay time.Duration
+	// time from the validation of the task to the results uploaded
+	UploadDelay time.Duration
+	// the task is aborted for the reason after the execution, instead of submitting the score
+	AbortReason models.TaskAbortReason
+	// the node reports the error after the execution, instead of submitting the score
+	TaskError models.TaskError
+	// the sampling seed makes the bridge validate the task with two more tasks in a group.
+	// It requires the private key of the bridge passed to New.
+	NeedValidation bool
+	// the task is invalidated when validated in a group
+	Invalid bool
+	// the score submitted by the node mismatches the uploaded results
+	WrongScore bool
+	// returns the index-th result file of the task, the results are generated if nil
+	Results func(task *models.RelayTask, index uint64) []byte
+}
+
+// taskSeed is a seed derived from the task to generate its results deterministically
+func taskSeed(task *models.RelayTask) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(task.TaskIDCommitment))
+	return int64(h.Sum64())
+}
+
+// generateImage returns a png of random noise, which has a pHash far from the other generated images
+func generateImage(seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y += 8 {
+		for x := 0; x < 64; x += 8 {
+			c := color.Gray{Y: uint8(r.Intn(256))}
+			for dy := 0; dy < 8; dy++ {
+				for dx := 0; dx < 8; dx++ {
+					img.SetGray(x+dx, y+dy, c)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func generateLLMResponse(task *models.RelayTask, index uint64) []byte {
+	resp := map[string]interface{}{
+		"model": "mock",
+		"choices": []map[string]interface{}{
+			{
+				"index":         index,
+				"finish_reason": "stop",
+				"message": map[string]string{
+					"role":    "assistant",
+					"content": fmt.Sprintf("response %d of task %s", index, task.TaskIDCommitment),
+				},
+			},
+		},
+		"usage": map[string]int{"prompt_tokens": 10, "completion_tokens": 10, "total_tokens": 20},
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
+
+// result returns the index-th result file of the task
+func (b *Behavior) result(task *models.RelayTask, index uint64) []byte {
+	if b.Results != nil {
+		return b.Results(task, index)
+	}
+	if task.TaskType == models.TaskTypeLLM {
+		return generateLLMResponse(task, index)
+	}
+	return generateImage(taskSeed(task) + int64(index))
+}
+
+// checkpoint returns the checkpoint uploaded as the result of a sd finetune task
+func (b *Behavior) checkpoint(task *models.RelayTask) []byte {
+	if b.Results != nil {
+		return b.Results(task, 0)
+	}
+	return []byte("checkpoint of " + task.TaskIDCommitment)
+}
+
+// score computes the score the node submits for its results: the pHashes of the images,
+// or the hashes of the llm responses, concatenated
+func (b *Behavior) score(task *models.RelayTask) (string, error) {
+	if task.TaskType == models.TaskTypeSDFTLora {
+		return "", nil
+	}
+	var score []byte
+	for i := uint64(0); i < task.TaskSize; i++ {
+		result := b.result(task, i)
+		if task.TaskType == models.TaskTypeLLM {
+			score = append(score, blockchain.GetHashForGPTResponse(string(result))...)
+			continue
+		}
+		hash, err := blockchain.GetPHashForImageReader(bytes.NewReader(result))
+		if err != nil {
+			return "", err
+		}
+		score = append(score, hash...)
+	}
+	if b.WrongScore {
+		for i := range score {
+			score[i] = ^score[i]
+		}
+	}
+	return fmt.Sprintf("0x%x", score), nil
+}
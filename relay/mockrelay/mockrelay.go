@@ -0,0 +1,545 @@
+// Package mockrelay is an in-memory relay serving the endpoints used by the bridge, to run the bridge
+// end to end without the network. The nodes running the tasks are scripted by Behavior.
+package mockrelay
+
+import (
+	"crynux_bridge/models"
+	"crynux_bridge/relay"
+	"crynux_bridge/utils"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/vechain/go-ecvrf"
+)
+
+type mockTask struct {
+	task       models.RelayTask
+	behavior   Behavior
+	checkpoint []byte // the checkpoint uploaded with a sd finetune task
+	// the task has been returned to the bridge in the started status, in which the bridge
+	// handles the sampling seed, so that the task does not skip it between two polls
+	startSynced bool
+}
+
+type failure struct {
+	method     string
+	pathPrefix string
+	statusCode int
+	message    string
+	times      int
+}
+
+// Relay is a mock relay. The tasks advance on the time of the requests: a task starts after
+// the StartDelay of its behavior, and submits the score after the ExecutionDelay.
+type Relay struct {
+	privateKey string
+	server     *httptest.Server
+	mux        *http.ServeMux
+
+	mu              sync.Mutex
+	tasks           map[string]*mockTask
+	sequence        uint64
+	nodes           map[string]models.RelayNode
+	defaultBehavior Behavior
+	script          []Behavior
+	failures        []*failure
+	quota           *big.Int
+	requests        map[string]int
+}
+
+// New creates a mock relay. privateKey is the private key of the bridge account, in hex without 0x,
+// which is required to choose the sampling seeds of the behaviors with NeedValidation.
+func New(privateKey string) *Relay {
+	r := &Relay{
+		privateKey: privateKey,
+		mux:        http.NewServeMux(),
+		tasks:      make(map[string]*mockTask),
+		nodes:      map[string]models.RelayNode{DefaultNode.Address: DefaultNode},
+		quota:      new(big.Int).Mul(big.NewInt(1000000), big.NewInt(1e18)),
+		requests:   make(map[string]int),
+	}
+	r.mux.HandleFunc("POST /v1/inference_tasks/validate", r.validateTask)
+	r.mux.HandleFunc("GET /v1/inference_tasks/{commitment}", r.getTask)
+	r.mux.HandleFunc("POST /v1/inference_tasks/{commitment}", r.createTask)
+	r.mux.HandleFunc("POST /v1/inference_tasks/{commitment}/abort_reason", r.abortTask)
+	r.mux.HandleFunc("GET /v1/inference_tasks/{commitment}/results/checkpoint", r.getCheckpoint)
+	r.mux.HandleFunc("GET /v1/inference_tasks/{commitment}/results/{index}", r.getResult)
+	r.mux.HandleFunc("GET /v1/node/{address}", r.getNode)
+	r.mux.HandleFunc("GET /v1/stats/queue/count", r.getQueueCount)
+	r.mux.HandleFunc("GET /v1/task_quota/{address}", r.getTaskQuota)
+	return r
+}
+
+// Start serves the mock relay on a local port, the base url of the relay is returned by URL
+func (r *Relay) Start() {
+	r.server = httptest.NewServer(r)
+}
+
+func (r *Relay) URL() string {
+	return r.server.URL
+}
+
+func (r *Relay) Close() {
+	if r.server != nil {
+		r.server.Close()
+	}
+}
+
+// SetDefaultBehavior sets the behavior of the tasks created after the scripted behaviors are used up
+func (r *Relay) SetDefaultBehavior(b Behavior) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultBehavior = b
+}
+
+// Script queues behaviors, which are taken by the tasks in the order they are created,
+// including the validation tasks created by the bridge
+func (r *Relay) Script(behaviors ...Behavior) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.script = append(r.script, behaviors...)
+}
+
+// AddNode adds a node which can be selected by the behaviors
+func (r *Relay) AddNode(node models.RelayNode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[node.Address] = node
+}
+
+// SetQuota sets the task quota of the bridge account, in wei
+func (r *Relay) SetQuota(quota *big.Int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quota = new(big.Int).Set(quota)
+}
+
+// Fail makes the next times requests of method, whose path starts with pathPrefix, fail with statusCode and message
+func (r *Relay) Fail(method, pathPrefix string, statusCode int, message string, times int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, &failure{
+		method:     method,
+		pathPrefix: pathPrefix,
+		statusCode: statusCode,
+		message:    message,
+		times:      times,
+	})
+}
+
+// Task returns the task of taskIDCommitment as returned to the bridge
+func (r *Relay) Task(taskIDCommitment string) (models.RelayTask, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[taskIDCommitment]
+	if !ok {
+		return models.RelayTask{}, false
+	}
+	r.advance(t, time.Now())
+	return t.task, true
+}
+
+// Checkpoint returns the checkpoint uploaded by the bridge when creating the sd finetune task of taskIDCommitment
+func (r *Relay) Checkpoint(taskIDCommitment string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.tasks[taskIDCommitment]; ok {
+		return t.checkpoint
+	}
+	return nil
+}
+
+// Tasks returns all the tasks in the order of their creation
+func (r *Relay) Tasks() []models.RelayTask {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	tasks := make([]models.RelayTask, 0, len(r.tasks))
+	for _, t := range r.tasks {
+		r.advance(t, now)
+		tasks = append(tasks, t.task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Sequence < tasks[j].Sequence })
+	return tasks
+}
+
+// Requests returns the number of the requests handled by the route pattern, like "POST /v1/inference_tasks/validate"
+func (r *Relay) Requests(pattern string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests[pattern]
+}
+
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	for i, f := range r.failures {
+		if f.method == req.Method && strings.HasPrefix(req.URL.Path, f.pathPrefix) {
+			f.times--
+			if f.times <= 0 {
+				r.failures = append(r.failures[:i], r.failures[i+1:]...)
+			}
+			r.mu.Unlock()
+			writeError(w, f.statusCode, f.message)
+			return
+		}
+	}
+	_, pattern := r.mux.Handler(req)
+	r.requests[pattern]++
+	r.mu.Unlock()
+
+	r.mux.ServeHTTP(w, req)
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "success",
+		"data":    data,
+	})
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+	})
+}
+
+// samplingSeed returns a random sampling seed, whose vrf number computed by the bridge
+// needs validation or not as required
+func (r *Relay) samplingSeed(needValidation bool) (string, error) {
+	seed := make([]byte, 32)
+	if len(r.privateKey) == 0 {
+		if needValidation {
+			return "", errors.New("private key is required for validation")
+		}
+		rand.Read(seed)
+		return hexutil.Encode(seed), nil
+	}
+	privateKey, err := hexutil.Decode("0x" + r.privateKey)
+	if err != nil {
+		return "", err
+	}
+	privKey := secp256k1.PrivKeyFromBytes(privateKey)
+	for i := 0; i < 10000; i++ {
+		rand.Read(seed)
+		vrfNum, _, err := ecvrf.Secp256k1Sha256Tai.Prove(privKey.ToECDSA(), seed)
+		if err != nil {
+			return "", err
+		}
+		if utils.VrfNeedValidation(vrfNum) == needValidation {
+			return hexutil.Encode(seed), nil
+		}
+	}
+	return "", errors.New("cannot find sampling seed")
+}
+
+// advance moves the task to the status it should have at now by its behavior
+func (r *Relay) advance(t *mockTask, now time.Time) {
+	task := &t.task
+	b := &t.behavior
+	if task.Status == models.ChainTaskQueued && !now.Before(task.CreateTime.Add(b.StartDelay)) {
+		startTime := task.CreateTime.Add(b.StartDelay)
+		task.Status = models.ChainTaskStarted
+		task.StartTime = &startTime
+		task.SelectedNode = b.Node
+		if len(task.SelectedNode) == 0 {
+			task.SelectedNode = DefaultNode.Address
+		}
+		if node, ok := r.nodes[task.SelectedNode]; ok {
+			task.QOSScore = node.QOSScore
+		}
+	}
+	if task.Status == models.ChainTaskStarted && t.startSynced && !now.Before(task.StartTime.Add(b.ExecutionDelay)) {
+		scoreReadyTime := task.StartTime.Add(b.ExecutionDelay)
+		if b.AbortReason != models.TaskAbortReasonNone {
+			task.Status = models.ChainTaskEndAborted
+			task.AbortReason = b.AbortReason
+		} else if b.TaskError != models.TaskErrorNone {
+			task.Status = models.ChainTaskErrorReported
+			task.TaskError = b.TaskError
+			task.ScoreReadyTime = &scoreReadyTime
+		} else if score, err := b.score(task); err != nil {
+			task.Status = models.ChainTaskErrorReported
+			task.TaskError = models.TaskErrorParametersValidationFailed
+			task.ScoreReadyTime = &scoreReadyTime
+		} else {
+			task.Status = models.ChainTaskScoreReady
+			task.Score = score
+			task.ScoreReadyTime = &scoreReadyTime
+		}
+	}
+	if (task.Status == models.ChainTaskValidated || task.Status == models.ChainTaskGroupValidated) &&
+		!now.Before(task.ValidatedTime.Add(b.UploadDelay)) {
+		uploadedTime := task.ValidatedTime.Add(b.UploadDelay)
+		task.ResultUploadedTime = &uploadedTime
+		if task.Status == models.ChainTaskValidated {
+			task.Status = models.ChainTaskEndSuccess
+		} else {
+			task.Status = models.ChainTaskEndGroupSuccess
+		}
+	}
+}
+
+func (r *Relay) getTask(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[req.PathValue("commitment")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Task not found")
+		return
+	}
+	r.advance(t, time.Now())
+	if t.task.Status == models.ChainTaskStarted {
+		t.startSynced = true
+	}
+	writeData(w, t.task)
+}
+
+func (r *Relay) createTask(w http.ResponseWriter, req *http.Request) {
+	var err error
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		err = req.ParseMultipartForm(32 << 20)
+	} else {
+		err = req.ParseForm()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	parseUint := func(key string) uint64 {
+		v, _ := strconv.ParseUint(req.FormValue(key), 10, 64)
+		return v
+	}
+	taskFee, ok := new(big.Int).SetString(req.FormValue("task_fee"), 10)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid task fee")
+		return
+	}
+	var checkpoint []byte
+	if file, _, err := req.FormFile("checkpoint"); err == nil {
+		checkpoint, err = io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	commitment := req.PathValue("commitment")
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tasks[commitment]; ok {
+		writeError(w, http.StatusBadRequest, "Task already exists")
+		return
+	}
+	behavior := r.defaultBehavior
+	if len(r.script) > 0 {
+		behavior = r.script[0]
+		r.script = r.script[1:]
+	}
+	samplingSeed, err := r.samplingSeed(behavior.NeedValidation)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.sequence++
+	r.tasks[commitment] = &mockTask{
+		task: models.RelayTask{
+			Sequence:         r.sequence,
+			TaskArgs:         req.FormValue("task_args"),
+			TaskIDCommitment: commitment,
+			SamplingSeed:     samplingSeed,
+			Nonce:            req.FormValue("nonce"),
+			Status:           models.ChainTaskQueued,
+			TaskType:         models.ChainTaskType(parseUint("task_type")),
+			TaskVersion:      req.FormValue("task_version"),
+			Timeout:          parseUint("timeout"),
+			MinVRAM:          parseUint("min_vram"),
+			RequiredGPU:      req.FormValue("required_gpu"),
+			RequiredGPUVRAM:  parseUint("required_gpu_vram"),
+			TaskFee:          models.BigInt{Int: *taskFee},
+			TaskSize:         parseUint("task_size"),
+			ModelIDs:         req.Form["task_model_ids"],
+			CreateTime:       &now,
+		},
+		behavior:   behavior,
+		checkpoint: checkpoint,
+	}
+	writeData(w, nil)
+}
+
+func (r *Relay) validateTask(w http.ResponseWriter, req *http.Request) {
+	var input relay.ValidateTaskInput
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(input.TaskIDCommitments) != 1 && len(input.TaskIDCommitments) != 3 {
+		writeError(w, http.StatusBadRequest, "Invalid task id commitments")
+		return
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tasks := make([]*mockTask, len(input.TaskIDCommitments))
+	for i, commitment := range input.TaskIDCommitments {
+		t, ok := r.tasks[commitment]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Task not found")
+			return
+		}
+		r.advance(t, now)
+		status := t.task.Status
+		if status != models.ChainTaskScoreReady && status != models.ChainTaskErrorReported &&
+			!(len(tasks) == 3 && status == models.ChainTaskEndAborted) {
+			writeError(w, http.StatusBadRequest, "Illegal task state")
+			return
+		}
+		tasks[i] = t
+	}
+
+	groupSuccess := false
+	for _, t := range tasks {
+		task := &t.task
+		if task.Status == models.ChainTaskEndAborted {
+			continue
+		}
+		if task.Status == models.ChainTaskErrorReported {
+			task.Status = models.ChainTaskEndAborted
+			continue
+		}
+		if len(tasks) == 1 {
+			task.Status = models.ChainTaskValidated
+		} else if t.behavior.Invalid {
+			task.Status = models.ChainTaskEndInvalidated
+			continue
+		} else if !groupSuccess {
+			task.Status = models.ChainTaskGroupValidated
+			groupSuccess = true
+		} else {
+			task.Status = models.ChainTaskEndGroupRefund
+			continue
+		}
+		task.ValidatedTime = &now
+	}
+	writeData(w, nil)
+}
+
+func (r *Relay) abortTask(w http.ResponseWriter, req *http.Request) {
+	var input relay.CancelTaskInput
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[req.PathValue("commitment")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Task not found")
+		return
+	}
+	r.advance(t, time.Now())
+	switch t.task.Status {
+	case models.ChainTaskEndAborted, models.ChainTaskEndSuccess, models.ChainTaskEndGroupSuccess,
+		models.ChainTaskEndGroupRefund, models.ChainTaskEndInvalidated:
+		writeError(w, http.StatusBadRequest, "Illegal task state")
+		return
+	}
+	t.task.Status = models.ChainTaskEndAborted
+	t.task.AbortReason = models.TaskAbortReason(input.AbortReason)
+	writeData(w, nil)
+}
+
+// uploadedTask returns the task of the request whose results have been uploaded
+func (r *Relay) uploadedTask(w http.ResponseWriter, req *http.Request) (*mockTask, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[req.PathValue("commitment")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Task not found")
+		return nil, false
+	}
+	r.advance(t, time.Now())
+	if t.task.Status != models.ChainTaskEndSuccess && t.task.Status != models.ChainTaskEndGroupSuccess {
+		writeError(w, http.StatusBadRequest, "Task results not uploaded")
+		return nil, false
+	}
+	return t, true
+}
+
+func (r *Relay) getResult(w http.ResponseWriter, req *http.Request) {
+	t, ok := r.uploadedTask(w, req)
+	if !ok {
+		return
+	}
+	index, err := strconv.ParseUint(req.PathValue("index"), 10, 64)
+	if err != nil || index >= t.task.TaskSize {
+		writeError(w, http.StatusBadRequest, "Invalid index")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(t.behavior.result(&t.task, index))
+}
+
+func (r *Relay) getCheckpoint(w http.ResponseWriter, req *http.Request) {
+	t, ok := r.uploadedTask(w, req)
+	if !ok {
+		return
+	}
+	if t.task.TaskType != models.TaskTypeSDFTLora {
+		writeError(w, http.StatusBadRequest, "Task type mismatch")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(t.behavior.checkpoint(&t.task))
+}
+
+func (r *Relay) getNode(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	node, ok := r.nodes[req.PathValue("address")]
+	r.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Node not found")
+		return
+	}
+	writeData(w, node)
+}
+
+func (r *Relay) getQueueCount(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	count := 0
+	for _, t := range r.tasks {
+		r.advance(t, now)
+		if t.task.Status == models.ChainTaskQueued {
+			count++
+		}
+	}
+	writeData(w, count)
+}
+
+func (r *Relay) getTaskQuota(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	writeData(w, r.quota.String())
+}
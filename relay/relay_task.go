@@ -5,6 +5,7 @@ import (
 	"context"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
+	"crynux_bridge/storage"
 	"crynux_bridge/utils"
 	"encoding/json"
 	"errors"
@@ -14,8 +15,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
@@ -204,7 +204,9 @@ func CreateTask(ctx context.Context, task *models.InferenceTask) error {
 		return err
 	}
 
+	// the checkpoint is the key of the uploaded or the previous checkpoint in the result store
 	var checkpointFilePath string
+	var checkpointFile io.ReadCloser
 	if task.TaskType == models.TaskTypeSDFTLora {
 		checkpoint, err := models.GetSDFTTaskConfigCheckpoint(task.TaskArgs)
 		if err != nil {
@@ -212,6 +214,14 @@ func CreateTask(ctx context.Context, task *models.InferenceTask) error {
 		}
 		if checkpoint != "" {
 			checkpointFilePath = checkpoint
+			checkpointFile, _, err = storage.GetResultStore().Get(ctx, checkpoint)
+			if errors.Is(err, storage.ErrNotFound) {
+				return errors.New("checkpoint does not exist")
+			}
+			if err != nil {
+				return err
+			}
+			defer checkpointFile.Close()
 		}
 	}
 
@@ -262,20 +272,13 @@ func CreateTask(ctx context.Context, task *models.InferenceTask) error {
 				multipartWriter.WriteField("task_model_ids", modelID)
 			}
 
-			file, err := os.Open(checkpointFilePath)
-			if err != nil {
-				pw.CloseWithError(fmt.Errorf("failed to open checkpoint file %s: %v", checkpointFilePath, err))
-				return
-			}
-			defer file.Close()
-
-			part, err := multipartWriter.CreateFormFile("checkpoint", filepath.Base(checkpointFilePath))
+			part, err := multipartWriter.CreateFormFile("checkpoint", path.Base(checkpointFilePath))
 			if err != nil {
 				pw.CloseWithError(fmt.Errorf("failed to create form file: %v", err))
 				return
 			}
 
-			_, err = io.Copy(part, file)
+			_, err = io.Copy(part, checkpointFile)
 			if err != nil {
 				pw.CloseWithError(fmt.Errorf("failed to copy file content: %v", err))
 				return
@@ -456,3 +459,63 @@ func CheckQuotaForTaskCreator(ctx context.Context) error {
 
 	return nil
 }
+
+// CheckUploadTaskParams checks the task args can be uploaded by UploadTaskParams.
+// It should be called before the task is created on chain, whose fee is paid even if the args are never uploaded.
+func CheckUploadTaskParams(task *models.InferenceTask) error {
+	if task.TaskType == models.TaskTypeSDFTLora {
+		checkpoint, err := models.GetSDFTTaskConfigCheckpoint(task.TaskArgs)
+		if err != nil {
+			return err
+		}
+		if checkpoint != "" {
+			return errors.New("checkpoints can only be uploaded with the tasks created on the relay")
+		}
+	}
+	return nil
+}
+
+// UploadTaskParams uploads the task args of the task created on chain by the bridge, for the nodes to run it.
+// The tasks created by CreateTask carry their args already.
+func UploadTaskParams(ctx context.Context, task *models.InferenceTask) error {
+	appConfig := config.GetConfig()
+
+	if err := CheckUploadTaskParams(task); err != nil {
+		return err
+	}
+
+	params := &UploadTaskParamsInput{
+		TaskArgs:         task.TaskArgs,
+		TaskIDCommitment: task.TaskIDCommitment,
+	}
+
+	timestamp, signature, err := SignData(params, appConfig.Blockchain.Account.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Add("task_args", task.TaskArgs)
+	form.Add("task_id_commitment", task.TaskIDCommitment)
+	form.Add("timestamp", strconv.FormatInt(timestamp, 10))
+	form.Add("signature", signature)
+
+	reqUrl := appConfig.Relay.BaseURL + "/v1/inference_tasks"
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(timeoutCtx, "POST", reqUrl, strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := processRelayResponse(resp); err != nil {
+		log.Errorf("Relay: upload task params %s error: %v", task.TaskIDCommitment, err)
+		return err
+	}
+
+	log.Debugf("Relay: upload task params %s", task.TaskIDCommitment)
+	return nil
+}
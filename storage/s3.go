@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3Store keeps the results in a bucket of an S3-compatible object storage
+type S3Store struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+	prefix          string
+	usePathStyle    bool
+	client          *http.Client
+}
+
+func NewS3Store(conf config.S3Config) (*S3Store, error) {
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, errors.New("the endpoint and the bucket of the s3 storage are required")
+	}
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	region := conf.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		endpoint:        endpoint,
+		region:          region,
+		bucket:          conf.Bucket,
+		accessKeyID:     conf.AccessKeyID,
+		secretAccessKey: conf.SecretAccessKey,
+		prefix:          strings.Trim(conf.Prefix, "/"),
+		usePathStyle:    conf.UsePathStyle,
+		client:          &http.Client{},
+	}, nil
+}
+
+// objectKey returns the key of the object in the bucket
+func (s *S3Store) objectKey(key string) string {
+	key = strings.TrimLeft(path.Clean("/"+key), "/")
+	if s.prefix == "" {
+		return key
+	}
+	if key == "" {
+		return s.prefix
+	}
+	return s.prefix + "/" + key
+}
+
+// url returns the url of the object of objectKey, or of the bucket if objectKey is empty
+func (s *S3Store) url(objectKey string, query url.Values) *url.URL {
+	u := *s.endpoint
+	p := "/" + objectKey
+	if s.usePathStyle {
+		p = "/" + s.bucket + p
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + p
+	u.RawPath = uriEncode(u.Path, false)
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+	return &u
+}
+
+func (s *S3Store) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+		payloadHash = unsignedPayload
+	}
+	signV4(req, s.accessKeyID, s.secretAccessKey, s.region, "s3", payloadHash, time.Now())
+	return s.client.Do(req)
+}
+
+// s3Error reads the error returned by the storage
+func s3Error(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("s3 storage error: %s %s", resp.Status, string(b))
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, s.url(s.objectKey(key), nil), r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.url(s.objectKey(key), nil), nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, 0, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, 0, s3Error(resp)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (s *S3Store) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.url(s.objectKey(key), nil), nil, 0)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("s3 storage error: %s", resp.Status)
+}
+
+func (s *S3Store) deleteObject(ctx context.Context, objectKey string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.url(objectKey, nil), nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	return s.deleteObject(ctx, s.objectKey(key))
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// listObjects returns the keys of a page of the objects under prefix, and the token of the next page
+func (s *S3Store) listObjects(ctx context.Context, prefix, token string) ([]string, string, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.url("", query), nil, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", s3Error(resp)
+	}
+	result := listBucketResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+	keys := make([]string, len(result.Contents))
+	for i, content := range result.Contents {
+		keys[i] = content.Key
+	}
+	if !result.IsTruncated {
+		return keys, "", nil
+	}
+	return keys, result.NextContinuationToken, nil
+}
+
+func (s *S3Store) DeleteDir(ctx context.Context, dir string) error {
+	prefix := s.objectKey(dir)
+	if prefix == "" || prefix == s.prefix {
+		return errors.New("cannot delete the root of the result store")
+	}
+	prefix += "/"
+	token := ""
+	for {
+		keys, next, err := s.listObjects(ctx, prefix, token)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := s.deleteObject(ctx, key); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// ResultStore keeps the result files of the tasks, the finetune checkpoints and the uploaded checkpoints,
+// so that the bridge instances sharing the database also share the files.
+// Keys are slash separated paths, like "<task id commitment>/0.png".
+type ResultStore interface {
+	// Put stores size bytes read from r as key, replacing the existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get returns the content of key and its size, or ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes key, it does nothing if key does not exist
+	Delete(ctx context.Context, key string) error
+	// DeleteDir removes all the keys under dir
+	DeleteDir(ctx context.Context, dir string) error
+}
+
+var ErrNotFound = errors.New("result not found")
+
+var resultStore ResultStore
+
+func InitResultStore(appConfig *config.AppConfig) error {
+	switch appConfig.Storage.Type {
+	case "", "local":
+		resultStore = NewLocalStore(appConfig.DataDir.InferenceTasks)
+	case "s3":
+		s3Store, err := NewS3Store(appConfig.Storage.S3)
+		if err != nil {
+			return err
+		}
+		resultStore = s3Store
+	default:
+		return fmt.Errorf("unknown storage type %s", appConfig.Storage.Type)
+	}
+	return nil
+}
+
+func GetResultStore() ResultStore {
+	return resultStore
+}
+
+// SetResultStore replaces the result store, it is used by the tests
+func SetResultStore(store ResultStore) {
+	resultStore = store
+}
+
+// TaskKey returns the key of the result file of the task
+func TaskKey(taskIDCommitment, filename string) string {
+	return path.Join(taskIDCommitment, filename)
+}
+
+// PutFile stores the local file as key
+func PutFile(ctx context.Context, store ResultStore, key, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, file, info.Size())
+}
+
+// FetchFile copies key to a local temporary file for the readers which need random access, like zip files.
+// The returned function removes the file.
+func FetchFile(ctx context.Context, store ResultStore, key string) (string, func(), error) {
+	src, _, err := store.Get(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	file, err := os.CreateTemp("", "result-*"+path.Ext(key))
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() { os.Remove(file.Name()) }
+	_, err = io.Copy(file, src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	return file.Name(), remove, nil
+}
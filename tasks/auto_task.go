@@ -4,7 +4,6 @@ import (
 	"context"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
-	"crynux_bridge/relay"
 	crand "crypto/rand"
 	"errors"
 	"fmt"
@@ -17,70 +16,38 @@ import (
 	"gorm.io/gorm"
 )
 
-func generateRandomTask(client models.Client, pendingLargeVramLLMTasksCount uint64) *models.InferenceTask {
-	appConfig := config.GetConfig()
-	pendingLargeVramLLMTasksLimit := appConfig.Task.PendingLargeVramLLMTasksLimit
+func generateRandomTask(client models.Client, conf AutoTaskConfig, templates []AutoTaskTemplate, pendingLargeVramLLMTasksCount uint64) (*models.InferenceTask, error) {
+	pendingLargeVramLLMTasksLimit := conf.PendingLargeVramLLMTasksLimit
 
 	clientTask := models.ClientTask{Client: client}
 
-	var taskArgs string
-	var minVram uint64 = 0
-	var taskType models.ChainTaskType
-	var taskFee uint64
 	var requiredGPU string
 	var requiredGPUVram uint64
 
-	taskVersionSampler := sampleuv.NewWeighted(appConfig.Task.AutoTaskVersionRatio, nil)
+	taskVersionSampler := sampleuv.NewWeighted(conf.AutoTaskVersionRatio, nil)
 	taskVersionIdx, _ := taskVersionSampler.Take()
-	taskVersion := appConfig.Task.TaskVersions[taskVersionIdx]
-
-	taskTypeSampler := sampleuv.NewWeighted(appConfig.Task.AutoTaskTypeRatio, nil)
-	taskTypeIdx, _ := taskTypeSampler.Take()
-
-	switch taskTypeIdx {
-	case 0:
-		prompt := "Self-portrait oil painting,a beautiful cyborg with golden hair,8k"
-		seed := rand.Intn(100000000)
-		if taskVersion == "2.5.0" {
-			taskArgs = fmt.Sprintf(`{"base_model":{"name":"crynux-ai/sdxl-turbo", "variant": "fp16"},"prompt":"%s","negative_prompt":"","scheduler":{"method":"EulerAncestralDiscreteScheduler","args":{"timestep_spacing":"trailing"}},"task_config":{"num_images":1,"seed":%d,"steps":1,"cfg":0,"safety_checker":false}}`, prompt, seed)
-		} else {
-			taskArgs = fmt.Sprintf(`{"base_model":{"name":"crynux-network/sdxl-turbo", "variant": "fp16"},"prompt":"%s","negative_prompt":"","scheduler":{"method":"EulerAncestralDiscreteScheduler","args":{"timestep_spacing":"trailing"}},"task_config":{"num_images":1,"seed":%d,"steps":1,"cfg":0,"safety_checker":false}}`, prompt, seed)
-		}
-		minVram = 14
-		taskType = models.TaskTypeSD
-		taskFee = appConfig.Task.SDXLTaskFee
-	case 1:
-		prompt := "best quality, ultra high res, photorealistic++++, 1girl, off-shoulder sweater, smiling, faded ash gray messy bun hair+, border light, depth of field, looking at viewer, closeup"
-		negativePrompt := "paintings, sketches, worst quality+++++, low quality+++++, normal quality+++++, lowres, normal quality, monochrome++, grayscale++, skin spots, acnes, skin blemishes, age spot, glans"
-		seed := rand.Intn(100000000)
-		if taskVersion == "2.5.0" {
-			taskArgs = fmt.Sprintf(`{"base_model":{"name":"crynux-ai/stable-diffusion-v1-5", "variant": "fp16"},"prompt":"%s","negative_prompt":"%s","task_config":{"num_images":1,"seed":%d,"steps":25,"cfg":0,"safety_checker":false}}`, prompt, negativePrompt, seed)
-		} else {
-			taskArgs = fmt.Sprintf(`{"base_model":{"name":"crynux-network/stable-diffusion-v1-5", "variant": "fp16"},"prompt":"%s","negative_prompt":"%s","task_config":{"num_images":1,"seed":%d,"steps":25,"cfg":0,"safety_checker":false}}`, prompt, negativePrompt, seed)
-		}
-		minVram = 4
-		taskType = models.TaskTypeSD
-		taskFee = appConfig.Task.SDTaskFee
-	default:
-		taskType = models.TaskTypeLLM
-		seed := rand.Intn(100000000)
-		if pendingLargeVramLLMTasksCount < pendingLargeVramLLMTasksLimit {
-			r := rand.Float64()
-			if r < 0.5 {
-				minVram = 32
-				taskArgs = fmt.Sprintf(`{"model":"Qwen/Qwen2.5-14B","messages":[{"role":"user","content":"I want to create an AI agent. Any suggestions?"}],"tools":null,"generation_config":{"max_new_tokens":250,"do_sample":true,"temperature":0.8,"repetition_penalty":1.1},"seed":%d,"dtype":"bfloat16"}`, seed)
-				taskFee = appConfig.Task.LLMTaskFee * 4
-			} else {
-				minVram = 60
-				taskArgs = fmt.Sprintf(`{"model":"Qwen/Qwen2.5-32B","messages":[{"role":"user","content":"I want to create an AI agent. Any suggestions?"}],"tools":null,"generation_config":{"max_new_tokens":250,"do_sample":true,"temperature":0.8,"repetition_penalty":1.1},"seed":%d,"dtype":"bfloat16"}`, seed)
-				taskFee = appConfig.Task.LLMTaskFee * 8
-			}
-		} else {
-			minVram = 24
-			taskArgs = fmt.Sprintf(`{"model":"Qwen/Qwen2.5-7B","messages":[{"role":"user","content":"I want to create an AI agent. Any suggestions?"}],"tools":null,"generation_config":{"max_new_tokens":250,"do_sample":true,"temperature":0.8,"repetition_penalty":1.1},"seed":%d,"dtype":"bfloat16"}`, seed)
-			taskFee = appConfig.Task.LLMTaskFee
-		}
+	taskVersion := conf.TaskVersions[taskVersionIdx]
+
+	selectable := selectableAutoTaskTemplates(templates, taskVersion, pendingLargeVramLLMTasksCount >= pendingLargeVramLLMTasksLimit)
+	if len(selectable) == 0 {
+		return nil, fmt.Errorf("no auto task template for task version %s", taskVersion)
+	}
+	weights := make([]float64, len(selectable))
+	for i, t := range selectable {
+		weights[i] = t.Weight
 	}
+	templateSampler := sampleuv.NewWeighted(weights, nil)
+	templateIdx, _ := templateSampler.Take()
+	template := selectable[templateIdx]
+
+	taskArgs, err := template.renderTaskArgs(rand.Intn(100000000))
+	if err != nil {
+		return nil, err
+	}
+	taskType := template.TaskType
+	minVram := template.MinVram
+	taskFee := template.TaskFee
+
 	taskModelIDs, _ := models.GetTaskConfigModelIDs(taskArgs, taskType)
 
 	taskIDBytes := make([]byte, 32)
@@ -88,20 +55,22 @@ func generateRandomTask(client models.Client, pendingLargeVramLLMTasksCount uint
 	taskID := hexutil.Encode(taskIDBytes)
 
 	task := &models.InferenceTask{
-		Client:          client,
-		ClientTask:      clientTask,
-		TaskArgs:        taskArgs,
-		TaskType:        taskType,
-		TaskModelIDs:    taskModelIDs,
-		TaskVersion:     taskVersion,
-		MinVram:         minVram,
-		RequiredGPU:     requiredGPU,
-		RequiredGPUVram: requiredGPUVram,
-		TaskFee:         taskFee,
-		TaskSize:        1,
-		TaskID:          taskID,
+		Client:           client,
+		ClientTask:       clientTask,
+		TaskArgs:         taskArgs,
+		TaskType:         taskType,
+		TaskModelIDs:     taskModelIDs,
+		TaskVersion:      taskVersion,
+		MinVram:          minVram,
+		RequiredGPU:      requiredGPU,
+		RequiredGPUVram:  requiredGPUVram,
+		TaskFee:          taskFee,
+		TaskSize:         1,
+		TaskID:           taskID,
+		Priority:         models.PriorityAuto,
+		AutoTaskTemplate: template.Name,
 	}
-	return task
+	return task, nil
 }
 
 func getPendingAutoTasksCount(ctx context.Context, client models.Client) (uint64, error) {
@@ -127,15 +96,13 @@ func getPendingLargeVramLLMTasksCount(ctx context.Context, client models.Client)
 		TaskType: models.TaskTypeLLM,
 	}
 	var count int64
-	if err := config.GetDB().WithContext(dbCtx).Model(&task).Where(&task).Where("(status = ? OR status = ?)", models.InferenceTaskPending, models.InferenceTaskStarted).Where("min_vram > ?", 24).Count(&count).Error; err != nil {
+	if err := config.GetDB().WithContext(dbCtx).Model(&task).Where(&task).Where("(status = ? OR status = ?)", models.InferenceTaskPending, models.InferenceTaskStarted).Where("min_vram > ?", largeVramLLMMinVram).Count(&count).Error; err != nil {
 		return 0, err
 	}
 	return uint64(count), nil
 }
 
 func autoCreateTasks(ctx context.Context) error {
-	appConfig := config.GetConfig()
-
 	clientID := "auto-task"
 	client := models.Client{ClientId: clientID}
 
@@ -156,42 +123,62 @@ func autoCreateTasks(ctx context.Context) error {
 	}
 
 	for {
-		batchSize := int(appConfig.Task.AutoTasksBatchSize)
-		if batchSize > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		settings, err := models.GetAutoTaskSettings(ctx, config.GetDB())
+		if err != nil {
+			log.Errorf("AutoTask: cannot get auto task settings: %v", err)
+			_ = sleepContext(ctx, 2*time.Second)
+			continue
+		}
+		conf := getAutoTaskConfig(settings)
+		batchSize := int(conf.AutoTasksBatchSize)
+		if !conf.Paused && batchSize > 0 {
 			tasks := make([]*models.InferenceTask, batchSize)
 			cnt, err := getPendingAutoTasksCount(ctx, client)
 			if err != nil {
 				log.Errorf("AutoTask: cannot get pending auto tasks count %v", err)
-				time.Sleep(2 * time.Second)
+				_ = sleepContext(ctx, 2*time.Second)
 				continue
 			}
 			log.Infof("AutoTask: pending auto tasks count: %d", cnt)
-			if cnt > appConfig.Task.PendingAutoTasksLimit {
-				time.Sleep(2 * time.Second)
+			if cnt > conf.PendingAutoTasksLimit {
+				_ = sleepContext(ctx, 2*time.Second)
 				continue
 			}
-			queuedTasks, err := relay.GetQueuedTasks(ctx)
+			queuedTasks, err := GetTaskBackend().GetQueuedTasks(ctx)
 			if err != nil {
 				log.Errorf("AutoTask: cannot get queued tasks count %v", err)
-				time.Sleep(2 * time.Second)
+				_ = sleepContext(ctx, 2*time.Second)
 				continue
 			}
 			log.Infof("AutoTask: queued task count %d", queuedTasks)
-			if uint64(queuedTasks) > appConfig.Task.PendingAutoTasksLimit {
-				time.Sleep(2 * time.Second)
+			if uint64(queuedTasks) > conf.PendingAutoTasksLimit {
+				_ = sleepContext(ctx, 2*time.Second)
 				continue
 			}
 			pendingLargeVramLLMTasksCount, err := getPendingLargeVramLLMTasksCount(ctx, client)
 			if err != nil {
 				log.Errorf("AutoTask: cannot get pending large vram llm tasks count %v", err)
-				time.Sleep(2 * time.Second)
+				_ = sleepContext(ctx, 2*time.Second)
 				continue
 			}
 
+			templates, err := loadAutoTaskTemplates(conf.AutoTaskTypeRatio)
+			if err != nil {
+				log.Errorf("AutoTask: cannot load auto task templates: %v", err)
+				return err
+			}
+
 			for i := 0; i < batchSize; i++ {
-				task := generateRandomTask(client, pendingLargeVramLLMTasksCount)
+				task, err := generateRandomTask(client, conf, templates, pendingLargeVramLLMTasksCount)
+				if err != nil {
+					log.Errorf("AutoTask: cannot generate auto task: %v", err)
+					return err
+				}
 				tasks[i] = task
-				if task.TaskType == models.TaskTypeLLM && task.MinVram > 24 {
+				if task.TaskType == models.TaskTypeLLM && task.MinVram > largeVramLLMMinVram {
 					pendingLargeVramLLMTasksCount += 1
 				}
 			}
@@ -199,25 +186,26 @@ func autoCreateTasks(ctx context.Context) error {
 				log.Errorf("AutoTask: cannot save auto tasks: %v", err)
 				return err
 			}
+			if err := models.SetAutoTaskLastBatchAt(ctx, config.GetDB(), time.Now()); err != nil {
+				log.Errorf("AutoTask: cannot save the time of the last batch: %v", err)
+			}
 		}
-		time.Sleep(2 * time.Second)
+		_ = sleepContext(ctx, 2*time.Second)
 	}
 }
 
 func AutoCreateTasks(ctx context.Context) {
-	ctx1, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	go func() {
+	runAsLeader(ctx, "auto_tasks", func(ctx context.Context) {
 		for {
-			err := autoCreateTasks(ctx1)
+			err := autoCreateTasks(ctx)
+			if ctx.Err() != nil {
+				log.Infof("AutoTask: %v, finish", ctx.Err())
+				return
+			}
 			if err != nil {
 				log.Errorf("AutoTask: auto create tasks error: %v", err)
-				time.Sleep(5 * time.Second)
+				_ = sleepContext(ctx, 5*time.Second)
 			}
 		}
-	}()
-	<-ctx1.Done()
-	err := ctx1.Err()
-	log.Errorf("AutoTask: timeout %v, finish", err)
+	})
 }
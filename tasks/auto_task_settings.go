@@ -0,0 +1,138 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// AutoTaskConfig is the task config of the auto task generator overridden by the runtime settings
+type AutoTaskConfig struct {
+	Paused                        bool      `json:"paused"`
+	AutoTasksBatchSize            uint64    `json:"auto_tasks_batch_size"`
+	PendingAutoTasksLimit         uint64    `json:"pending_auto_tasks_limit"`
+	PendingLargeVramLLMTasksLimit uint64    `json:"pending_large_vram_llm_tasks_limit"`
+	TaskVersions                  []string  `json:"task_versions"`
+	AutoTaskVersionRatio          []float64 `json:"auto_task_version_ratio"`
+	AutoTaskTypeRatio             []float64 `json:"auto_task_type_ratio"`
+}
+
+func getAutoTaskConfig(settings *models.AutoTaskSettings) AutoTaskConfig {
+	appConfig := config.GetConfig()
+	conf := AutoTaskConfig{
+		Paused:                        settings.Paused,
+		AutoTasksBatchSize:            appConfig.Task.AutoTasksBatchSize,
+		PendingAutoTasksLimit:         appConfig.Task.PendingAutoTasksLimit,
+		PendingLargeVramLLMTasksLimit: appConfig.Task.PendingLargeVramLLMTasksLimit,
+		TaskVersions:                  appConfig.Task.TaskVersions,
+		AutoTaskVersionRatio:          appConfig.Task.AutoTaskVersionRatio,
+		AutoTaskTypeRatio:             appConfig.Task.AutoTaskTypeRatio,
+	}
+	if settings.AutoTasksBatchSize != nil {
+		conf.AutoTasksBatchSize = *settings.AutoTasksBatchSize
+	}
+	if settings.PendingAutoTasksLimit != nil {
+		conf.PendingAutoTasksLimit = *settings.PendingAutoTasksLimit
+	}
+	if settings.PendingLargeVramLLMTasksLimit != nil {
+		conf.PendingLargeVramLLMTasksLimit = *settings.PendingLargeVramLLMTasksLimit
+	}
+	if settings.AutoTaskVersionRatio != nil {
+		// the task versions may have been changed in the config since the ratio was saved
+		if err := validateRatio(settings.AutoTaskVersionRatio, len(conf.TaskVersions)); err != nil {
+			log.Errorf("AutoTask: ignore the auto task version ratio %v of the settings: %v", settings.AutoTaskVersionRatio, err)
+		} else {
+			conf.AutoTaskVersionRatio = settings.AutoTaskVersionRatio
+		}
+	}
+	if settings.AutoTaskTypeRatio != nil {
+		conf.AutoTaskTypeRatio = settings.AutoTaskTypeRatio
+	}
+	return conf
+}
+
+func validateRatio(ratio []float64, size int) error {
+	if len(ratio) != size {
+		return fmt.Errorf("ratio should have %d numbers", size)
+	}
+	var sum float64
+	for _, r := range ratio {
+		if r < 0 {
+			return errors.New("ratio should not be negative")
+		}
+		sum += r
+	}
+	if sum == 0 {
+		return errors.New("ratio should not be all zero")
+	}
+	return nil
+}
+
+// ValidateAutoTaskVersionRatio checks the ratio has a weight for each of the task versions
+func ValidateAutoTaskVersionRatio(ratio []float64) error {
+	return validateRatio(ratio, len(config.GetConfig().Task.TaskVersions))
+}
+
+// ValidateAutoTaskTypeRatio checks the ratio has a weight for each of sdxl, sd and llm tasks
+func ValidateAutoTaskTypeRatio(ratio []float64) error {
+	return validateRatio(ratio, 3)
+}
+
+// AutoTaskState is the current state of the auto task generator
+type AutoTaskState struct {
+	AutoTaskConfig
+	PendingCount int64      `json:"pending_count"` // auto tasks pending or started
+	QueuedCount  int64      `json:"queued_count"`  // tasks queued on the relay
+	LastBatchAt  *time.Time `json:"last_batch_at"`
+	UpdatedAt    time.Time  `json:"updated_at"` // the time the settings were last changed
+}
+
+func getAutoTaskClient(ctx context.Context, db *gorm.DB) (*models.Client, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	client := &models.Client{ClientId: "auto-task"}
+	err := db.WithContext(dbCtx).Model(client).Where(client).First(client).Error
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// GetAutoTaskState returns the settings in effect of the auto task generator and the counts it checks before a batch
+func GetAutoTaskState(ctx context.Context) (*AutoTaskState, error) {
+	db := config.GetDB()
+	settings, err := models.GetAutoTaskSettings(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	state := &AutoTaskState{
+		AutoTaskConfig: getAutoTaskConfig(settings),
+		LastBatchAt:    settings.LastBatchAt,
+		UpdatedAt:      settings.UpdatedAt,
+	}
+
+	client, err := getAutoTaskClient(ctx, db)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if client != nil {
+		pendingCount, err := getPendingAutoTasksCount(ctx, *client)
+		if err != nil {
+			return nil, err
+		}
+		state.PendingCount = int64(pendingCount)
+	}
+
+	queuedCount, err := GetTaskBackend().GetQueuedTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state.QueuedCount = queuedCount
+	return state, nil
+}
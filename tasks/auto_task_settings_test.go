@@ -0,0 +1,29 @@
+package tasks
+
+import (
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"slices"
+	"testing"
+)
+
+func TestAutoTaskVersionRatioOverride(t *testing.T) {
+	appConfig := config.GetConfig()
+	versions := len(appConfig.Task.TaskVersions)
+
+	ratio := make(models.Float64Array, versions)
+	for i := range ratio {
+		ratio[i] = float64(i + 1)
+	}
+	conf := getAutoTaskConfig(&models.AutoTaskSettings{AutoTaskVersionRatio: ratio})
+	if !slices.Equal(conf.AutoTaskVersionRatio, ratio) {
+		t.Errorf("the version ratio of the settings should be used: %v", conf.AutoTaskVersionRatio)
+	}
+
+	// a task version has been added to the config since the ratio was saved
+	stale := ratio[:versions-1]
+	conf = getAutoTaskConfig(&models.AutoTaskSettings{AutoTaskVersionRatio: stale})
+	if !slices.Equal(conf.AutoTaskVersionRatio, appConfig.Task.AutoTaskVersionRatio) {
+		t.Errorf("the stale version ratio should be ignored: %v", conf.AutoTaskVersionRatio)
+	}
+}
@@ -0,0 +1,227 @@
+package tasks
+
+import (
+	"bytes"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// AutoTaskTemplate defines a kind of the auto tasks generated to benchmark the network
+type AutoTaskTemplate struct {
+	Name     string               `mapstructure:"name"`
+	TaskType models.ChainTaskType `mapstructure:"task_type"`
+	// text/template of the task args, {{.Seed}} is replaced by a random seed
+	TaskArgs string  `mapstructure:"task_args"`
+	MinVram  uint64  `mapstructure:"min_vram"`
+	TaskFee  uint64  `mapstructure:"task_fee"` // GWei
+	Weight   float64 `mapstructure:"weight"`
+	// comma separated constraints of the task versions using the template, like ">=2.5.0,!=2.6.1", all versions if empty
+	TaskVersion string `mapstructure:"task_version"`
+	// used only when the other llm templates are excluded by pending_large_vram_llm_tasks_limit
+	Fallback bool `mapstructure:"fallback"`
+
+	taskArgs *template.Template
+}
+
+const largeVramLLMMinVram = 24
+
+// isLargeVramLLM reports whether the tasks of the template count in pending_large_vram_llm_tasks_limit
+func (t *AutoTaskTemplate) isLargeVramLLM() bool {
+	return t.TaskType == models.TaskTypeLLM && t.MinVram > largeVramLLMMinVram
+}
+
+func (t *AutoTaskTemplate) renderTaskArgs(seed int) (string, error) {
+	var buf bytes.Buffer
+	if err := t.taskArgs.Execute(&buf, struct{ Seed int }{seed}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// compareVersions compares two dotted numeric versions
+func compareVersions(a, b string) (int, error) {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		var err error
+		if i < len(as) {
+			if x, err = strconv.Atoi(as[i]); err != nil {
+				return 0, fmt.Errorf("invalid version %s", a)
+			}
+		}
+		if i < len(bs) {
+			if y, err = strconv.Atoi(bs[i]); err != nil {
+				return 0, fmt.Errorf("invalid version %s", b)
+			}
+		}
+		if x != y {
+			if x < y {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// matchesTaskVersion reports whether the task version meets the version constraints of the template
+func (t *AutoTaskTemplate) matchesTaskVersion(version string) (bool, error) {
+	if len(strings.TrimSpace(t.TaskVersion)) == 0 {
+		return true, nil
+	}
+	for _, constraint := range strings.Split(t.TaskVersion, ",") {
+		constraint = strings.TrimSpace(constraint)
+		op := constraint[:len(constraint)-len(strings.TrimLeft(constraint, "<>=!"))]
+		c, err := compareVersions(version, strings.TrimSpace(constraint[len(op):]))
+		if err != nil {
+			return false, err
+		}
+		var ok bool
+		switch op {
+		case "", "=", "==":
+			ok = c == 0
+		case "!=":
+			ok = c != 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		default:
+			return false, fmt.Errorf("invalid version constraint %s", constraint)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (t *AutoTaskTemplate) validate() error {
+	if len(t.Name) == 0 || len(t.Name) > 64 {
+		return errors.New("name should have 1 to 64 characters")
+	}
+	if t.TaskType != models.TaskTypeSD && t.TaskType != models.TaskTypeLLM {
+		return fmt.Errorf("unsupported task type %d", t.TaskType)
+	}
+	if t.Weight < 0 {
+		return errors.New("weight should not be negative")
+	}
+	if _, err := t.matchesTaskVersion("0.0.0"); err != nil {
+		return err
+	}
+	tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(t.TaskArgs)
+	if err != nil {
+		return err
+	}
+	t.taskArgs = tmpl
+	taskArgs, err := t.renderTaskArgs(0)
+	if err != nil {
+		return err
+	}
+	if !json.Valid([]byte(taskArgs)) {
+		return errors.New("task args is not valid json")
+	}
+	if t.TaskType == models.TaskTypeSD {
+		if num, err := models.GetTaskConfigNumImages(taskArgs); err != nil || num != 1 {
+			return errors.New("sd task args should generate 1 image")
+		}
+	}
+	return nil
+}
+
+// defaultAutoTaskTemplates are the auto tasks generated without a template file, weighted by auto_task_type_ratio
+func defaultAutoTaskTemplates(autoTaskTypeRatio []float64) []AutoTaskTemplate {
+	appConfig := config.GetConfig()
+	typeRatio := func(i int) float64 {
+		if i < len(autoTaskTypeRatio) {
+			return autoTaskTypeRatio[i]
+		}
+		return 0
+	}
+	sdxlArgs := `{"base_model":{"name":"%s/sdxl-turbo", "variant": "fp16"},"prompt":"Self-portrait oil painting,a beautiful cyborg with golden hair,8k","negative_prompt":"","scheduler":{"method":"EulerAncestralDiscreteScheduler","args":{"timestep_spacing":"trailing"}},"task_config":{"num_images":1,"seed":{{.Seed}},"steps":1,"cfg":0,"safety_checker":false}}`
+	sdArgs := `{"base_model":{"name":"%s/stable-diffusion-v1-5", "variant": "fp16"},"prompt":"best quality, ultra high res, photorealistic++++, 1girl, off-shoulder sweater, smiling, faded ash gray messy bun hair+, border light, depth of field, looking at viewer, closeup","negative_prompt":"paintings, sketches, worst quality+++++, low quality+++++, normal quality+++++, lowres, normal quality, monochrome++, grayscale++, skin spots, acnes, skin blemishes, age spot, glans","task_config":{"num_images":1,"seed":{{.Seed}},"steps":25,"cfg":0,"safety_checker":false}}`
+	llmArgs := `{"model":"Qwen/Qwen2.5-%s","messages":[{"role":"user","content":"I want to create an AI agent. Any suggestions?"}],"tools":null,"generation_config":{"max_new_tokens":250,"do_sample":true,"temperature":0.8,"repetition_penalty":1.1},"seed":{{.Seed}},"dtype":"bfloat16"}`
+
+	return []AutoTaskTemplate{
+		// the models are published by crynux-ai for the task version 2.5.0, and by crynux-network since then
+		{Name: "sdxl-turbo-2.5.0", TaskType: models.TaskTypeSD, TaskArgs: fmt.Sprintf(sdxlArgs, "crynux-ai"), MinVram: 14, TaskFee: appConfig.Task.SDXLTaskFee, Weight: typeRatio(0), TaskVersion: "2.5.0"},
+		{Name: "sdxl-turbo", TaskType: models.TaskTypeSD, TaskArgs: fmt.Sprintf(sdxlArgs, "crynux-network"), MinVram: 14, TaskFee: appConfig.Task.SDXLTaskFee, Weight: typeRatio(0), TaskVersion: "!=2.5.0"},
+		{Name: "stable-diffusion-v1-5-2.5.0", TaskType: models.TaskTypeSD, TaskArgs: fmt.Sprintf(sdArgs, "crynux-ai"), MinVram: 4, TaskFee: appConfig.Task.SDTaskFee, Weight: typeRatio(1), TaskVersion: "2.5.0"},
+		{Name: "stable-diffusion-v1-5", TaskType: models.TaskTypeSD, TaskArgs: fmt.Sprintf(sdArgs, "crynux-network"), MinVram: 4, TaskFee: appConfig.Task.SDTaskFee, Weight: typeRatio(1), TaskVersion: "!=2.5.0"},
+		{Name: "qwen2.5-14b", TaskType: models.TaskTypeLLM, TaskArgs: fmt.Sprintf(llmArgs, "14B"), MinVram: 32, TaskFee: appConfig.Task.LLMTaskFee * 4, Weight: typeRatio(2) / 2},
+		{Name: "qwen2.5-32b", TaskType: models.TaskTypeLLM, TaskArgs: fmt.Sprintf(llmArgs, "32B"), MinVram: 60, TaskFee: appConfig.Task.LLMTaskFee * 8, Weight: typeRatio(2) / 2},
+		{Name: "qwen2.5-7b", TaskType: models.TaskTypeLLM, TaskArgs: fmt.Sprintf(llmArgs, "7B"), MinVram: 24, TaskFee: appConfig.Task.LLMTaskFee, Weight: typeRatio(2), Fallback: true},
+	}
+}
+
+// loadAutoTaskTemplates loads the auto task templates from the file set by auto_task_templates,
+// or returns the built-in templates weighted by autoTaskTypeRatio if it is not set
+func loadAutoTaskTemplates(autoTaskTypeRatio []float64) ([]AutoTaskTemplate, error) {
+	templates := defaultAutoTaskTemplates(autoTaskTypeRatio)
+	if path := config.GetConfig().Task.AutoTaskTemplates; len(path) > 0 {
+		v := viper.New()
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		templates = nil
+		if err := v.UnmarshalKey("templates", &templates); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range templates {
+		if err := templates[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid auto task template %d %s: %w", i, templates[i].Name, err)
+		}
+		if names[templates[i].Name] {
+			return nil, fmt.Errorf("duplicate auto task template %s", templates[i].Name)
+		}
+		names[templates[i].Name] = true
+	}
+	return templates, nil
+}
+
+// selectableAutoTaskTemplates returns the templates, from which the template of the next auto task of taskVersion is sampled
+func selectableAutoTaskTemplates(templates []AutoTaskTemplate, taskVersion string, largeVramLLMLimitReached bool) []*AutoTaskTemplate {
+	candidates := make([]*AutoTaskTemplate, 0, len(templates))
+	hasLLM := false
+	for i := range templates {
+		t := &templates[i]
+		if t.Weight == 0 {
+			continue
+		}
+		if ok, _ := t.matchesTaskVersion(taskVersion); !ok {
+			continue
+		}
+		if largeVramLLMLimitReached && t.isLargeVramLLM() {
+			continue
+		}
+		if t.TaskType == models.TaskTypeLLM && !t.Fallback {
+			hasLLM = true
+		}
+		candidates = append(candidates, t)
+	}
+
+	selectable := make([]*AutoTaskTemplate, 0, len(candidates))
+	for _, t := range candidates {
+		if t.Fallback && hasLLM {
+			continue
+		}
+		selectable = append(selectable, t)
+	}
+	return selectable
+}
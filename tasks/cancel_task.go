@@ -4,15 +4,48 @@ import (
 	"context"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
-	"crynux_bridge/relay"
 	"errors"
-	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func cancelTask(ctx context.Context, task *models.InferenceTask) error {
+// errTaskLeased means the task is being processed by a scheduler, it is cancelled by CancelTasks later
+var errTaskLeased = errors.New("task is being processed, it will be cancelled later")
+
+// cancelTask aborts the task on the task backend if it is still running, and reports whether
+// the task fee is refunded, either by the cancellation or by the relay before.
+// The task lease is held during the cancellation, so that no scheduler steps the task at the same time.
+func cancelTask(ctx context.Context, task *models.InferenceTask) (bool, error) {
+	db := config.GetDB()
+	owner := config.GetInstanceID() + ":cancel"
+	claimed, err := models.ClaimInferenceTask(ctx, db, task.ID, owner, config.GetLeaseDuration())
+	if err != nil {
+		log.Errorf("CancelTasks: cannot lease task %d: %v", task.ID, err)
+		return false, err
+	}
+	if !claimed {
+		log.Infof("CancelTasks: task %d is leased by a scheduler, skip", task.ID)
+		return false, errTaskLeased
+	}
+	defer func() {
+		if err := models.ReleaseLease(context.Background(), db, &models.InferenceTask{}, task.ID, owner, time.Time{}); err != nil {
+			log.Errorf("CancelTasks: cannot release the lease of task %d: %v", task.ID, err)
+		}
+	}()
+
+	// the task may have been cancelled, or have ended, since it was read
+	if err := task.Sync(ctx, db); err != nil {
+		log.Errorf("CancelTasks: cannot sync task %d: %v", task.ID, err)
+		return false, err
+	}
+	if task.Status != models.InferenceTaskNeedCancel {
+		log.Infof("CancelTasks: task %d is already %d", task.ID, task.Status)
+		refunded := len(task.TaskIDCommitment) > 0 &&
+			(task.Status == models.InferenceTaskEndAborted || task.Status == models.InferenceTaskEndGroupRefund)
+		return refunded, nil
+	}
+
 	log.Infof("CancelTasks: start to cancel task %d", task.ID)
 	taskIDCommitment := task.TaskIDCommitment
 	newTask := &models.InferenceTask{}
@@ -22,57 +55,60 @@ func cancelTask(ctx context.Context, task *models.InferenceTask) error {
 		newTask.AbortReason = models.TaskAbortTimeout
 		if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
 			log.Errorf("CancelTasks: cannot save task %d status: %v", task.ID, err)
-			return err
+			return false, err
 		}
 		log.Infof("CancelTasks: task %d canceled successfully", task.ID)
-		return nil
+		return false, nil
 	}
 
-	chainTask, err := relay.GetTaskByCommitment(ctx, taskIDCommitment)
+	chainTask, err := GetTaskBackend().GetTask(ctx, taskIDCommitment)
 	if err != nil {
-		var relayErr relay.RelayError
-		if errors.As(err, &relayErr) && strings.Contains(relayErr.ErrorMessage, "Task not found") {
+		if isTaskNotFound(err) {
 			log.Infof("CancelTasks: task %d not found", task.ID)
 			newTask.Status = models.InferenceTaskEndAborted
 			newTask.AbortReason = models.TaskAbortTimeout
 			if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
 				log.Errorf("CancelTasks: cannot save task %d status: %v", task.ID, err)
-				return err
+				return false, err
 			}
 			log.Infof("CancelTasks: task %d canceled successfully", task.ID)
-			return nil
+			return false, nil
 		}
 
 		log.Errorf("CancelTasks: cannot get task %d : %v", task.ID, err)
-		return err
+		return false, err
 	}
 
 	// If task is already finished, we don't need to cancel it
 	// Otherwise, cancel it
 	chainTaskStatus := models.ChainTaskStatus(chainTask.Status)
+	refunded := false
 	if chainTaskStatus == models.ChainTaskEndSuccess || chainTaskStatus == models.ChainTaskEndGroupSuccess {
 		newTask.Status = models.InferenceTaskEndSuccess
 	} else if chainTaskStatus == models.ChainTaskEndGroupRefund {
 		newTask.Status = models.InferenceTaskEndGroupRefund
+		refunded = true
 	} else if chainTaskStatus == models.ChainTaskEndInvalidated {
 		newTask.Status = models.InferenceTaskEndInvalidated
 	} else if chainTaskStatus == models.ChainTaskEndAborted {
 		newTask.Status = models.InferenceTaskEndAborted
 		newTask.AbortReason = models.TaskAbortReason(chainTask.AbortReason)
+		refunded = true
 	} else {
-		if err := relay.CancelTask(ctx, task, models.TaskAbortTimeout); err != nil {
+		if err := GetTaskBackend().CancelTask(ctx, task, models.TaskAbortTimeout); err != nil {
 			log.Errorf("CancelTasks: cannot cancel task %d : %v", task.ID, err)
-			return err
+			return false, err
 		}
 		newTask.Status = models.InferenceTaskEndAborted
 		newTask.AbortReason = models.TaskAbortTimeout
+		refunded = true
 	}
 	if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
 		log.Errorf("CancelTasks: cannot save task %d status: %v", task.ID, err)
-		return err
+		return false, err
 	}
 	log.Infof("CancelTasks: task %d canceled successfully", task.ID)
-	return nil
+	return refunded, nil
 }
 
 func getTasksNeedCancel(ctx context.Context) ([]models.InferenceTask, error) {
@@ -112,22 +148,73 @@ func getTasksNeedCancel(ctx context.Context) ([]models.InferenceTask, error) {
 }
 
 func CancelTasks(ctx context.Context) {
+	runAsLeader(ctx, "cancel_tasks", cancelTasks)
+}
+
+func cancelTasks(ctx context.Context) {
 	for {
 		tasks, err := getTasksNeedCancel(ctx)
 		if err != nil {
 			log.Errorf("CancelTasks: cannot get tasks need to cancel: %v", err)
-			time.Sleep(2 * time.Second)
+			if err := sleepContext(ctx, 2*time.Second); err != nil {
+				return
+			}
 			continue
 		}
 		log.Infof("CancelTasks: %d tasks need to cancel", len(tasks))
 
 		for _, task := range tasks {
-			if err := cancelTask(ctx, &task); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if _, err := cancelTask(ctx, &task); err != nil {
 				log.Errorf("CancelTasks: cannot cancel task %d due to %v", task.ID, err)
 				continue
 			}
 		}
 
-		time.Sleep(time.Minute)
+		if err := sleepContext(ctx, time.Minute); err != nil {
+			return
+		}
+	}
+}
+
+type CancelResult struct {
+	TaskID           uint              `json:"task_id"`
+	TaskIDCommitment string            `json:"task_id_commitment"`
+	Status           models.TaskStatus `json:"status"`
+	// whether the task fee is returned by the relay
+	Refunded bool   `json:"refunded"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CancelClientTask cancels the client task and all its unfinished inference tasks, including the validation tasks.
+// The inference tasks that cannot be cancelled now are left to CancelTasks, and are reported with the error.
+func CancelClientTask(ctx context.Context, clientTask *models.ClientTask) ([]CancelResult, error) {
+	tasks, err := clientTask.Cancel(ctx, config.GetDB())
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("CancelTasks: client task %d cancelled by the client", clientTask.ID)
+
+	results := make([]CancelResult, 0, len(tasks))
+	for i := range tasks {
+		task := &tasks[i]
+		result := CancelResult{
+			TaskID:           task.ID,
+			TaskIDCommitment: task.TaskIDCommitment,
+		}
+		if task.Status == models.InferenceTaskNeedCancel {
+			refunded, err := cancelTask(ctx, task)
+			if err != nil {
+				result.Error = err.Error()
+			}
+			result.Refunded = refunded
+		} else if len(task.TaskIDCommitment) > 0 {
+			result.Refunded = task.Status == models.InferenceTaskEndAborted || task.Status == models.InferenceTaskEndGroupRefund
+		}
+		result.Status = task.Status
+		results = append(results, result)
 	}
+	return results, nil
 }
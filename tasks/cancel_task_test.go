@@ -0,0 +1,93 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestCancelTaskLeasedByScheduler(t *testing.T) {
+	ctx := context.Background()
+	db := config.GetDB()
+
+	// the client task is not polled by the scheduler of the integration tests
+	clientTask := &models.ClientTask{
+		ClientID:   1,
+		Status:     models.ClientTaskStatusRunning,
+		NextPollAt: time.Now().Add(time.Hour),
+	}
+	if err := db.Create(clientTask).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the task is being created by a scheduler step
+	const worker = "worker"
+	task := &models.InferenceTask{
+		ClientID:     clientTask.ClientID,
+		ClientTaskID: clientTask.ID,
+		TaskType:     models.TaskTypeSDFTLora,
+		TaskFee:      100,
+		MinVram:      24,
+		TaskID:       "0x01",
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		return tx.Model(task).UpdateColumns(map[string]interface{}{
+			"lease_owner":      worker,
+			"lease_expires_at": time.Now().Add(time.Hour),
+		}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stepTask := *task
+
+	results, err := CancelClientTask(ctx, clientTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != models.InferenceTaskNeedCancel || results[0].Error != errTaskLeased.Error() {
+		t.Fatalf("the leased task should be left to CancelTasks: %+v", results)
+	}
+
+	// the scheduler step finishes after the cancellation, and cannot bring the task back
+	err = stepTask.Update(ctx, db, &models.InferenceTask{Status: models.InferenceTaskCreated})
+	if !errors.Is(err, models.ErrInferenceTaskStatusChanged) {
+		t.Fatalf("the status written by the scheduler step should be rejected: %v", err)
+	}
+	if err := models.ReleaseLease(ctx, db, &models.InferenceTask{}, task.ID, worker, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := *task
+	if _, err := cancelTask(ctx, &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Status != models.InferenceTaskEndAborted {
+		t.Errorf("task should be aborted after the lease is released: %d", first.Status)
+	}
+	// a cancellation from a stale read does not cancel the task again
+	stale := *task
+	stale.Status = models.InferenceTaskNeedCancel
+	refunded, err := cancelTask(ctx, &stale)
+	if err != nil || refunded || stale.Status != models.InferenceTaskEndAborted {
+		t.Errorf("the aborted task should not be cancelled again: %v %v %d", err, refunded, stale.Status)
+	}
+
+	var events int64
+	err = db.Model(&models.TaskStatusEvent{}).
+		Where("inference_task_id = ? AND status = ?", task.ID, models.InferenceTaskEndAborted).
+		Count(&events).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 {
+		t.Errorf("task should be aborted once: %d", events)
+	}
+}
@@ -0,0 +1,202 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how long the queue depth and the start latency are cached for the fee policy
+const feeSignalsTTL = 30 * time.Second
+
+type feePolicySettings struct {
+	baseFees      map[models.ChainTaskType]uint64
+	queueTarget   int64
+	queueWeight   float64
+	latencyTarget float64 // ms
+	latencyWeight float64
+	latencyWindow time.Duration
+	maxMultiplier float64
+	maxTaskFee    uint64
+}
+
+func getFeePolicySettings() feePolicySettings {
+	conf := config.GetConfig().FeePolicy
+	settings := feePolicySettings{
+		baseFees: map[models.ChainTaskType]uint64{
+			models.TaskTypeSD:       5000000000,
+			models.TaskTypeLLM:      6000000000,
+			models.TaskTypeSDFTLora: 15000000000,
+		},
+		queueTarget:   50,
+		queueWeight:   0.5,
+		latencyTarget: 60000,
+		latencyWeight: 0.5,
+		latencyWindow: 10 * time.Minute,
+		maxMultiplier: 3,
+		maxTaskFee:    conf.MaxTaskFee,
+	}
+	if conf.SDBaseFee > 0 {
+		settings.baseFees[models.TaskTypeSD] = conf.SDBaseFee
+	}
+	if conf.LLMBaseFee > 0 {
+		settings.baseFees[models.TaskTypeLLM] = conf.LLMBaseFee
+	}
+	if conf.SDFinetuneBaseFee > 0 {
+		settings.baseFees[models.TaskTypeSDFTLora] = conf.SDFinetuneBaseFee
+	}
+	if conf.QueueTarget > 0 {
+		settings.queueTarget = conf.QueueTarget
+	}
+	if conf.QueueWeight > 0 {
+		settings.queueWeight = conf.QueueWeight
+	}
+	if conf.LatencyTarget > 0 {
+		settings.latencyTarget = float64(conf.LatencyTarget * 1000)
+	}
+	if conf.LatencyWeight > 0 {
+		settings.latencyWeight = conf.LatencyWeight
+	}
+	if conf.LatencyWindow > 0 {
+		settings.latencyWindow = time.Duration(conf.LatencyWindow) * time.Second
+	}
+	if conf.MaxMultiplier >= 1 {
+		settings.maxMultiplier = conf.MaxMultiplier
+	}
+	return settings
+}
+
+// multiplier returns the ratio of the initial fee to the base fee. The fee rises linearly with
+// the queued relay tasks beyond the queue target and with the start latency beyond the latency target.
+func (settings feePolicySettings) multiplier(queueDepth int64, startLatency int64) float64 {
+	m := 1.0
+	if queueDepth > settings.queueTarget {
+		m += settings.queueWeight * float64(queueDepth-settings.queueTarget) / float64(settings.queueTarget)
+	}
+	if float64(startLatency) > settings.latencyTarget {
+		m += settings.latencyWeight * (float64(startLatency) - settings.latencyTarget) / settings.latencyTarget
+	}
+	return math.Min(m, settings.maxMultiplier)
+}
+
+// feeSignals caches the network state the fees are based on, so that creating a task
+// does not query the relay and aggregate the task latencies every time
+type feeSignals struct {
+	mu               sync.Mutex
+	queueDepth       int64
+	queueUpdatedAt   time.Time
+	latencies        map[models.ChainTaskType]int64
+	latencyUpdatedAt map[models.ChainTaskType]time.Time
+}
+
+var signals = &feeSignals{
+	latencies:        make(map[models.ChainTaskType]int64),
+	latencyUpdatedAt: make(map[models.ChainTaskType]time.Time),
+}
+
+// get returns the queued relay tasks and the recent p50 start latency in ms of taskType.
+// The last known values are kept if they cannot be refreshed.
+func (s *feeSignals) get(ctx context.Context, taskType models.ChainTaskType, window time.Duration) (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.queueUpdatedAt) > feeSignalsTTL {
+		queryCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		queueDepth, err := GetTaskBackend().GetQueuedTasks(queryCtx)
+		cancel()
+		if err != nil {
+			log.Errorf("FeePolicy: cannot get queued tasks count: %v", err)
+		} else {
+			s.queueDepth = queueDepth
+		}
+		s.queueUpdatedAt = now
+	}
+	if now.Sub(s.latencyUpdatedAt[taskType]) > feeSignalsTTL {
+		breakdown, err := models.GetLatencyBreakdown(ctx, config.GetDB(), now.Add(-window), &taskType)
+		if err != nil {
+			log.Errorf("FeePolicy: cannot get start latency of task type %d: %v", taskType, err)
+		} else {
+			s.latencies[taskType] = breakdown.QueueWait.P50
+		}
+		s.latencyUpdatedAt[taskType] = now
+	}
+	return s.queueDepth, s.latencies[taskType]
+}
+
+// FeeQuote is the task fee chosen by the fee policy for one unit of the task size
+type FeeQuote struct {
+	TaskFee      uint64
+	BaseFee      uint64
+	Multiplier   float64
+	QueueDepth   int64
+	StartLatency int64 // ms
+}
+
+// QuoteTaskFee chooses the task fee of a new task of taskType from the base fee of the task type,
+// the network queue and the recent start latency of the tasks
+func QuoteTaskFee(ctx context.Context, taskType models.ChainTaskType) FeeQuote {
+	settings := getFeePolicySettings()
+	queueDepth, startLatency := signals.get(ctx, taskType, settings.latencyWindow)
+	baseFee := settings.baseFees[taskType]
+	m := settings.multiplier(queueDepth, startLatency)
+	return FeeQuote{
+		TaskFee:      uint64(math.Ceil(float64(baseFee) * m)),
+		BaseFee:      baseFee,
+		Multiplier:   m,
+		QueueDepth:   queueDepth,
+		StartLatency: startLatency,
+	}
+}
+
+// GetMaxTaskFee returns the max task fee of the tasks of the client, which is set on its api key
+// or defaults to the max task fee of the fee policy. 0 means no limit.
+func GetMaxTaskFee(ctx context.Context, clientID uint) (uint64, error) {
+	maxTaskFee, err := models.GetClientMaxTaskFee(ctx, config.GetDB(), clientID)
+	if err != nil {
+		return 0, err
+	}
+	if maxTaskFee == 0 {
+		maxTaskFee = getFeePolicySettings().maxTaskFee
+	}
+	return maxTaskFee, nil
+}
+
+// raiseTaskFee raises the task fee by ratio within maxTaskFee, 0 means no limit
+func raiseTaskFee(taskFee uint64, ratio float64, maxTaskFee uint64) uint64 {
+	newFee := uint64(math.Ceil(float64(taskFee) * ratio))
+	if maxTaskFee > 0 && newFee > maxTaskFee {
+		newFee = maxTaskFee
+	}
+	return newFee
+}
+
+// newResubmitFeeDecision records the fee of newTask, which resubmits task after it failed for
+// failureReason, or continues task with the next segment if failureReason is empty
+func newResubmitFeeDecision(task, newTask *models.InferenceTask, failureReason string, maxTaskFee uint64) *models.FeeDecision {
+	reason := models.FeeDecisionKept
+	if newTask.TaskFee > task.TaskFee {
+		switch failureReason {
+		case "task_fee_too_low":
+			reason = models.FeeDecisionFeeTooLow
+		case "timeout":
+			reason = models.FeeDecisionTimeout
+		default:
+			reason = models.FeeDecisionRaised
+		}
+	}
+	return &models.FeeDecision{
+		InferenceTaskID: newTask.ID,
+		ClientTaskID:    newTask.ClientTaskID,
+		TaskType:        newTask.TaskType,
+		Reason:          reason,
+		PreviousFee:     task.TaskFee,
+		TaskFee:         newTask.TaskFee,
+		MaxTaskFee:      maxTaskFee,
+	}
+}
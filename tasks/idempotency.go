@@ -0,0 +1,42 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how often the expired idempotency keys are deleted
+const idempotencyCleanInterval = 10 * time.Minute
+
+const idempotencyCleanBatchSize = 1000
+
+func cleanIdempotencyKeys(ctx context.Context) {
+	for {
+		for {
+			deleted, err := models.DeleteExpiredIdempotencyKeys(ctx, config.GetDB(), idempotencyCleanBatchSize)
+			if err != nil {
+				log.Errorf("IdempotencyKeys: cannot delete expired keys: %v", err)
+				break
+			}
+			if deleted > 0 {
+				log.Infof("IdempotencyKeys: %d expired keys deleted", deleted)
+			}
+			if deleted < idempotencyCleanBatchSize {
+				break
+			}
+		}
+
+		if err := sleepContext(ctx, idempotencyCleanInterval); err != nil {
+			return
+		}
+	}
+}
+
+// CleanIdempotencyKeys deletes the expired idempotency keys until ctx is done
+func CleanIdempotencyKeys(ctx context.Context) {
+	runAsLeader(ctx, "idempotency_keys", cleanIdempotencyKeys)
+}
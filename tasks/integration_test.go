@@ -0,0 +1,365 @@
+package tasks_test
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/relay/mockrelay"
+	"crynux_bridge/storage"
+	"crynux_bridge/tasks"
+	crand "crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// the bridge processes the tasks against mockRelay in all the tests, which should not run in parallel
+var mockRelay *mockrelay.Relay
+
+const testConfig = `
+environment: "test"
+db:
+  driver: "sqlite"
+  connection: "%s"
+  log:
+    level: "error"
+    output: "stderr"
+cluster:
+  instance_id: "integration-test"
+  lease_duration: 30
+  shutdown_timeout: 5
+data_dir:
+  inference_tasks: "%s"
+result_verification:
+  enabled: true
+  max_downloads: 2
+  phash_distance: 5
+relay:
+  base_url: "%s"
+task:
+  timeout: 6
+  scheduler_workers: 16
+  task_versions: ["2.6.0"]
+submission:
+  max_in_flight: 64
+  max_in_flight_per_client: 16
+  weights:
+    realtime: 8
+    standard: 4
+    batch: 2
+    auto: 1
+test:
+  root_address: "%s"
+  root_private_key: "%s"
+`
+
+// setupBridge starts the mock relay, and initializes the config, the sqlite database,
+// the result store and the task backend of the bridge in dir
+func setupBridge(dir string) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	privateKey := hexutil.Encode(crypto.FromECDSA(key))[2:]
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	mockRelay = mockrelay.New(privateKey)
+	mockRelay.Start()
+
+	conf := fmt.Sprintf(testConfig, filepath.Join(dir, "bridge.db"), filepath.Join(dir, "results"), mockRelay.URL(), address, privateKey)
+	if err := os.WriteFile(filepath.Join(dir, "config.yml"), []byte(conf), 0o600); err != nil {
+		return err
+	}
+	if err := config.InitConfig(dir); err != nil {
+		return err
+	}
+	if err := config.InitDB(config.GetConfig()); err != nil {
+		return err
+	}
+	// the old migrations renaming tables do not run on sqlite, whose index names are unique in the database
+	err = config.GetDB().AutoMigrate(
+		&models.Client{},
+		&models.ClientAPIKey{},
+		&models.ClientTask{},
+		&models.InferenceTask{},
+		&models.TaskStatusEvent{},
+		&models.TaskEvent{},
+		&models.TaskAttempt{},
+		&models.FeeDecision{},
+		&models.LeaderLease{},
+		&models.BlockchainTransaction{},
+		&models.LoraModel{},
+		&models.Pipeline{},
+		&models.PipelineStep{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.IdempotencyKey{},
+		&models.AutoTaskSettings{},
+	)
+	if err != nil {
+		return err
+	}
+	if err := storage.InitResultStore(config.GetConfig()); err != nil {
+		return err
+	}
+	return tasks.InitTaskBackend(config.GetConfig())
+}
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.WarnLevel)
+
+	dir, err := os.MkdirTemp("", "bridge-integration-*")
+	if err != nil {
+		panic(err)
+	}
+	if err := setupBridge(dir); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tasks.ProcessTasks(ctx)
+	}()
+
+	code := m.Run()
+
+	cancel()
+	<-done
+	mockRelay.Close()
+	_ = config.CloseDB()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+const sdTaskArgs = `{"base_model":{"name":"crynux-network/sdxl-turbo","variant":"fp16"},"prompt":"a cat","negative_prompt":"","task_config":{"num_images":1,"seed":1,"steps":1,"cfg":0,"safety_checker":false}}`
+
+const llmTaskArgs = `{"model":"Qwen/Qwen2.5-7B","messages":[{"role":"user","content":"hello"}],"generation_config":{"max_new_tokens":10},"seed":1,"dtype":"bfloat16"}`
+
+// submitTask creates a client task with an inference task like the create task api, and returns the client task
+func submitTask(t *testing.T, taskType models.ChainTaskType, taskArgs string, retryPolicy string) *models.ClientTask {
+	t.Helper()
+	ctx := context.Background()
+	db := config.GetDB()
+
+	client := &models.Client{ClientId: t.Name()}
+	if err := db.Where(client).FirstOrCreate(client).Error; err != nil {
+		t.Fatal(err)
+	}
+	clientTask := &models.ClientTask{Client: *client, RetryPolicy: retryPolicy}
+	if err := db.Create(clientTask).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	taskIDBytes := make([]byte, 32)
+	crand.Read(taskIDBytes)
+	modelIDs, _ := models.GetTaskConfigModelIDs(taskArgs, taskType)
+	task := &models.InferenceTask{
+		ClientID:     client.ID,
+		ClientTaskID: clientTask.ID,
+		TaskArgs:     taskArgs,
+		TaskType:     taskType,
+		TaskModelIDs: modelIDs,
+		TaskVersion:  "2.6.0",
+		TaskFee:      1000000000,
+		MinVram:      8,
+		TaskSize:     1,
+		TaskID:       hexutil.Encode(taskIDBytes),
+	}
+	if err := models.SaveTasks(ctx, db, []*models.InferenceTask{task}); err != nil {
+		t.Fatal(err)
+	}
+	return clientTask
+}
+
+// waitClientTask waits for the client task to finish, and returns its inference tasks
+func waitClientTask(t *testing.T, clientTask *models.ClientTask) []models.InferenceTask {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	db := config.GetDB()
+	if err := models.WaitClientTaskFinish(ctx, db, clientTask); err != nil {
+		t.Fatalf("client task %d not finished: %v", clientTask.ID, err)
+	}
+	tasks, err := models.GetClientTaskInferenceTasks(ctx, db, clientTask.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tasks
+}
+
+// waitGroupTasks waits for all the tasks of the client task to end, and returns them.
+// The client task succeeds as soon as one task of the group succeeds, before the others are refunded or invalidated,
+// and the tasks in NeedCancel are still to be ended by the cancel loop.
+func waitGroupTasks(t *testing.T, clientTask *models.ClientTask, n int) []models.InferenceTask {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	db := config.GetDB()
+	for {
+		tasks, err := models.GetClientTaskInferenceTasks(ctx, db, clientTask.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ended := len(tasks) == n
+		for _, task := range tasks {
+			if !task.Finished() || task.Status == models.InferenceTaskNeedCancel {
+				ended = false
+			}
+		}
+		if ended {
+			return tasks
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("tasks of client task %d not ended: %+v", clientTask.ID, tasks)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestIntegrationSDTask(t *testing.T) {
+	mockRelay.Script(mockrelay.Behavior{StartDelay: 100 * time.Millisecond, ExecutionDelay: 200 * time.Millisecond})
+
+	clientTask := submitTask(t, models.TaskTypeSD, sdTaskArgs, "")
+	tasks := waitClientTask(t, clientTask)
+	if clientTask.Status != models.ClientTaskStatusSuccess {
+		t.Fatalf("client task status should be success: %s", clientTask.Status)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("client task should have 1 task: %d", len(tasks))
+	}
+	task := tasks[0]
+	if task.Status != models.InferenceTaskResultDownloaded || task.ResultVerification != models.ResultVerificationVerified {
+		t.Errorf("task should be downloaded and verified: %d %s", task.Status, task.ResultVerification)
+	}
+	if task.SelectedNode != mockrelay.DefaultNode.Address || task.StartTime == nil || task.ScoreReadyTime == nil {
+		t.Errorf("task should be synced from the relay: %+v", task)
+	}
+	exists, err := storage.GetResultStore().Exists(context.Background(), storage.TaskKey(task.TaskIDCommitment, "0.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("result of the task should be stored")
+	}
+}
+
+func TestIntegrationValidationGroup(t *testing.T) {
+	validations := mockRelay.Requests("POST /v1/inference_tasks/validate")
+	// the task needs validation, and one of its validation tasks is invalidated
+	mockRelay.Script(
+		mockrelay.Behavior{NeedValidation: true},
+		mockrelay.Behavior{ExecutionDelay: 100 * time.Millisecond},
+		mockrelay.Behavior{ExecutionDelay: 100 * time.Millisecond, Invalid: true},
+	)
+
+	clientTask := submitTask(t, models.TaskTypeLLM, llmTaskArgs, "")
+	waitClientTask(t, clientTask)
+	if clientTask.Status != models.ClientTaskStatusSuccess {
+		t.Fatalf("client task status should be success: %s", clientTask.Status)
+	}
+	tasks := waitGroupTasks(t, clientTask, 3)
+	downloaded, refunded, invalidated := 0, 0, 0
+	for _, task := range tasks {
+		if task.TaskID != tasks[0].TaskID {
+			t.Errorf("tasks of the group should share the task id")
+		}
+		switch task.Status {
+		case models.InferenceTaskResultDownloaded:
+			downloaded++
+		case models.InferenceTaskEndGroupRefund:
+			refunded++
+		case models.InferenceTaskEndInvalidated:
+			invalidated++
+		}
+	}
+	if downloaded != 1 || refunded != 1 || invalidated != 1 {
+		t.Errorf("one task of the group should succeed, one refunded and one invalidated: %d %d %d", downloaded, refunded, invalidated)
+	}
+	if n := mockRelay.Requests("POST /v1/inference_tasks/validate") - validations; n != 1 {
+		t.Errorf("the group should be validated once: %d", n)
+	}
+}
+
+func TestIntegrationRelayErrors(t *testing.T) {
+	mockRelay.Fail(http.MethodPost, "/v1/inference_tasks/0x", http.StatusInternalServerError, "Internal Server Error", 1)
+	mockRelay.Script(mockrelay.Behavior{})
+
+	clientTask := submitTask(t, models.TaskTypeSD, sdTaskArgs, "")
+	tasks := waitClientTask(t, clientTask)
+	if clientTask.Status != models.ClientTaskStatusSuccess {
+		t.Fatalf("client task status should be success after the relay recovers: %s", clientTask.Status)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("failed requests should not create more tasks: %d", len(tasks))
+	}
+}
+
+func TestIntegrationAbortedTask(t *testing.T) {
+	mockRelay.Script(mockrelay.Behavior{AbortReason: models.TaskAbortModelDownloadFailed})
+
+	clientTask := submitTask(t, models.TaskTypeSD, sdTaskArgs, `{"max_attempts":1}`)
+	tasks := waitClientTask(t, clientTask)
+	if clientTask.Status != models.ClientTaskStatusFailed {
+		t.Fatalf("client task status should be failed: %s", clientTask.Status)
+	}
+	if len(tasks) != 1 || tasks[0].Status != models.InferenceTaskEndAborted || tasks[0].AbortReason != models.TaskAbortModelDownloadFailed {
+		t.Errorf("task should be aborted for the model download failure: %+v", tasks)
+	}
+}
+
+func TestIntegrationResultMismatch(t *testing.T) {
+	mockRelay.Script(mockrelay.Behavior{WrongScore: true})
+
+	clientTask := submitTask(t, models.TaskTypeLLM, llmTaskArgs, `{"max_attempts":1}`)
+	tasks := waitClientTask(t, clientTask)
+	if clientTask.Status != models.ClientTaskStatusFailed {
+		t.Fatalf("client task status should be failed: %s", clientTask.Status)
+	}
+	if len(tasks) != 1 || tasks[0].Status != models.InferenceTaskResultMismatch || tasks[0].ResultMismatches != 2 {
+		t.Errorf("task should fail after the results are downloaded twice: %+v", tasks)
+	}
+}
+
+// countingBackend counts the tasks created and validated through the task backend
+type countingBackend struct {
+	tasks.TaskBackend
+	created   atomic.Int32
+	validated atomic.Int32
+}
+
+func (b *countingBackend) CreateTask(ctx context.Context, task *models.InferenceTask) error {
+	b.created.Add(1)
+	return b.TaskBackend.CreateTask(ctx, task)
+}
+
+func (b *countingBackend) ValidateTasks(ctx context.Context, tasks []*models.InferenceTask) error {
+	b.validated.Add(1)
+	return b.TaskBackend.ValidateTasks(ctx, tasks)
+}
+
+func TestIntegrationTaskBackend(t *testing.T) {
+	backend := &countingBackend{TaskBackend: tasks.GetTaskBackend()}
+	tasks.SetTaskBackend(backend)
+	defer tasks.SetTaskBackend(backend.TaskBackend)
+	mockRelay.Script(mockrelay.Behavior{})
+
+	clientTask := submitTask(t, models.TaskTypeSD, sdTaskArgs, "")
+	waitClientTask(t, clientTask)
+	if clientTask.Status != models.ClientTaskStatusSuccess {
+		t.Fatalf("client task status should be success: %s", clientTask.Status)
+	}
+	if created, validated := backend.created.Load(), backend.validated.Load(); created != 1 || validated != 1 {
+		t.Errorf("the task should be created and validated once through the task backend: %d %d", created, validated)
+	}
+}
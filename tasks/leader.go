@@ -0,0 +1,87 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sleepContext sleeps for d, and returns early with the context error if ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// runAsLeader runs loop on only one of the bridge instances sharing the database.
+// The instance holding the leader lease of name runs loop and renews the lease periodically,
+// the others keep trying to take the lease over. The context passed to loop is canceled
+// once the lease is lost, and loop should return as soon as possible then.
+func runAsLeader(ctx context.Context, name string, loop func(ctx context.Context)) {
+	owner := config.GetInstanceID()
+	duration := config.GetLeaseDuration()
+	interval := duration / 3
+
+	for {
+		acquired, err := models.AcquireLeaderLease(ctx, config.GetDB(), name, owner, duration)
+		if err != nil {
+			log.Errorf("Leader: cannot acquire lease %s: %v", name, err)
+		}
+		if acquired {
+			log.Infof("Leader: %s acquired lease %s", owner, name)
+			leaderCtx, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				loop(leaderCtx)
+			}()
+
+			keepLease(leaderCtx, name, owner, duration, done)
+			cancel()
+			<-done
+			log.Infof("Leader: %s gave up lease %s", owner, name)
+
+			releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 3*time.Second)
+			if err := models.ReleaseLeaderLease(releaseCtx, config.GetDB(), name, owner); err != nil {
+				log.Errorf("Leader: cannot release lease %s: %v", name, err)
+			}
+			releaseCancel()
+		}
+
+		if err := sleepContext(ctx, interval); err != nil {
+			return
+		}
+	}
+}
+
+// keepLease renews the lease until it is lost, ctx is done or the loop returns
+func keepLease(ctx context.Context, name, owner string, duration time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(duration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			acquired, err := models.AcquireLeaderLease(ctx, config.GetDB(), name, owner, duration)
+			if err != nil {
+				log.Errorf("Leader: cannot renew lease %s: %v", name, err)
+				continue
+			}
+			if !acquired {
+				log.Warnf("Leader: %s lost lease %s", owner, name)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package tasks
+
+import (
+	"archive/zip"
+	"context"
+	"crynux_bridge/models"
+	"crynux_bridge/storage"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// extractValidationImages stores the validation images in the finetune result under dir of the result store,
+// and returns the file names of the extracted images
+func extractValidationImages(ctx context.Context, resultFilePath, dir string) ([]string, error) {
+	zipFile, err := zip.OpenReader(resultFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zipFile.Close()
+
+	images := make([]string, 0)
+	for _, file := range zipFile.File {
+		if file.FileInfo().IsDir() || !strings.Contains(strings.ToLower(file.Name), "validation") {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(file.Name))
+		if ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".webp" {
+			continue
+		}
+
+		filename := fmt.Sprintf("%d%s", len(images), ext)
+		err := func() error {
+			src, err := file.Open()
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			return storage.GetResultStore().Put(ctx, path.Join(dir, filename), src, int64(file.UncompressedSize64))
+		}()
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, filename)
+	}
+	return images, nil
+}
+
+// registerSDFTLoraModel adds the result of a finished finetune task, fetched to the local resultFilePath,
+// to the lora model list of the client. It does nothing if the client task has already been registered.
+func registerSDFTLoraModel(ctx context.Context, db *gorm.DB, clientTask *models.ClientTask, task *models.InferenceTask, resultFilePath string) error {
+	existed, err := models.GetLoraModelByClientTaskID(ctx, db, clientTask.ID)
+	if err != nil {
+		return err
+	}
+	if existed != nil {
+		return nil
+	}
+
+	taskArgs := models.FinetuneLoraTaskArgs{}
+	if err := json.Unmarshal([]byte(task.TaskArgs), &taskArgs); err != nil {
+		return err
+	}
+	modelType, err := models.GetModelTypeOfBaseModel(ctx, db, taskArgs.Model.Name)
+	if err != nil {
+		return err
+	}
+	trainArgs, err := json.Marshal(struct {
+		TrainArgs      models.TrainArgs     `json:"train_args"`
+		Lora           models.LoraArgs      `json:"lora"`
+		Transforms     models.TransformArgs `json:"transforms"`
+		MixedPrecision string               `json:"mixed_precision"`
+		Seed           int                  `json:"seed"`
+	}{taskArgs.TrainArgs, taskArgs.Lora, taskArgs.Transforms, taskArgs.MixedPrecision, taskArgs.Seed})
+	if err != nil {
+		return err
+	}
+
+	images, err := extractValidationImages(ctx, resultFilePath, storage.TaskKey(task.TaskIDCommitment, "validation"))
+	if err != nil {
+		log.Errorf("registerSDFTLoraModel: cannot extract validation images of task %s: %v", task.TaskIDCommitment, err)
+		return err
+	}
+
+	description := ""
+	if taskArgs.Validation.Prompt != nil {
+		description = *taskArgs.Validation.Prompt
+	}
+
+	loraModel := &models.LoraModel{
+		Name:             fmt.Sprintf("finetune-%d", clientTask.ID),
+		Description:      description,
+		Type:             modelType,
+		DownloadLink:     fmt.Sprintf("/v1/images/models/%d/result", clientTask.ID),
+		ClientID:         clientTask.ClientID,
+		ClientTaskID:     clientTask.ID,
+		BaseModel:        taskArgs.Model.Name,
+		TrainArgs:        string(trainArgs),
+		ValidationImages: images,
+	}
+	if err := loraModel.Save(ctx, db); err != nil {
+		return err
+	}
+	log.Infof("registerSDFTLoraModel: client task %d is registered as lora model %d", clientTask.ID, loraModel.ID)
+	return nil
+}
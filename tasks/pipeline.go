@@ -0,0 +1,279 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how often the running pipelines are checked without being woken up
+const pipelinePollInterval = 2 * time.Second
+
+// PipelineStepRunner creates the client tasks of the pipeline steps and reads their outputs.
+// It is implemented by the api, which owns the task creation and the task results.
+type PipelineStepRunner interface {
+	// CreateTask creates the client task of the step with the rendered task args.
+	// maxTotalFee is the budget of the step out of the remaining budget of the pipeline, 0 means no limit.
+	CreateTask(ctx context.Context, pipeline *models.Pipeline, step *models.PipelineStep, taskArgs string, maxTotalFee uint64) (*models.ClientTask, error)
+	// ReadOutput returns the output of the successful client task of the step
+	ReadOutput(ctx context.Context, pipeline *models.Pipeline, step *models.PipelineStep, clientTask *models.ClientTask) (map[string]interface{}, error)
+}
+
+func stepOutputs(pipeline *models.Pipeline) (map[string]map[string]interface{}, error) {
+	outputs := make(map[string]map[string]interface{})
+	for _, step := range pipeline.Steps {
+		if step.Status != models.PipelineStepSuccess {
+			continue
+		}
+		output, err := step.GetOutput()
+		if err != nil {
+			return nil, err
+		}
+		outputs[step.Name] = output
+	}
+	return outputs, nil
+}
+
+// skipSteps skips the pending steps of the ended pipeline and cancels the running ones
+func skipSteps(ctx context.Context, pipeline *models.Pipeline) error {
+	db := config.GetDB()
+	for i := range pipeline.Steps {
+		step := &pipeline.Steps[i]
+		switch step.Status {
+		case models.PipelineStepPending:
+			if err := step.Update(ctx, db, map[string]interface{}{"status": models.PipelineStepSkipped}); err != nil {
+				return err
+			}
+		case models.PipelineStepRunning:
+			clientTask, err := models.GetClientTaskByID(ctx, db, step.ClientTaskID)
+			if err != nil {
+				return err
+			}
+			if _, err := clientTask.Cancel(ctx, db); err != nil && !errors.Is(err, models.ErrClientTaskNotRunning) {
+				return err
+			}
+			if err := step.Update(ctx, db, map[string]interface{}{"status": models.PipelineStepSkipped}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// endPipeline ends the running pipeline with status, it returns models.ErrPipelineNotRunning
+// if the pipeline has been ended by others in between
+func endPipeline(ctx context.Context, pipeline *models.Pipeline, status models.PipelineStatus, reason string) error {
+	if err := pipeline.End(ctx, config.GetDB(), status, reason); err != nil {
+		return err
+	}
+	log.Infof("Pipelines: pipeline %d ended with status %s: %s", pipeline.ID, status, reason)
+	return skipSteps(ctx, pipeline)
+}
+
+// CancelPipeline cancels the running pipeline and the client tasks of its running steps
+func CancelPipeline(ctx context.Context, pipeline *models.Pipeline) error {
+	return endPipeline(ctx, pipeline, models.PipelineStatusCancelled, "cancelled by the client")
+}
+
+// checkRunningStep updates the step by the status of its client task, and returns the budget given to the client task
+func checkRunningStep(ctx context.Context, runner PipelineStepRunner, pipeline *models.Pipeline, step *models.PipelineStep) (uint64, error) {
+	db := config.GetDB()
+	clientTask, err := models.GetClientTaskByID(ctx, db, step.ClientTaskID)
+	if err != nil {
+		return 0, err
+	}
+	taskFee, err := models.GetClientTaskTotalFee(ctx, db, clientTask.ID)
+	if err != nil {
+		return 0, err
+	}
+	values := map[string]interface{}{"task_fee": taskFee}
+
+	switch clientTask.Status {
+	case models.ClientTaskStatusRunning:
+	case models.ClientTaskStatusSuccess:
+		output, err := runner.ReadOutput(ctx, pipeline, step, clientTask)
+		if err == nil {
+			var b []byte
+			b, err = json.Marshal(output)
+			if err == nil {
+				values["status"] = models.PipelineStepSuccess
+				values["output"] = string(b)
+				break
+			}
+		}
+		values["status"] = models.PipelineStepFailed
+		values["error"] = fmt.Sprintf("cannot read the output of client task %d: %v", clientTask.ID, err)
+	default:
+		values["status"] = models.PipelineStepFailed
+		values["error"] = fmt.Sprintf("client task %d %s", clientTask.ID, clientTask.Status)
+	}
+	return clientTask.MaxTotalFee, step.Update(ctx, db, values)
+}
+
+// startStep renders the task args of the step with the outputs of its dependencies and creates its client task.
+// maxTotalFee is the budget of the step if the pipeline has a max total fee.
+func startStep(ctx context.Context, runner PipelineStepRunner, pipeline *models.Pipeline, step *models.PipelineStep, outputs map[string]map[string]interface{}, maxTotalFee uint64) error {
+	db := config.GetDB()
+
+	taskArgs, err := models.RenderTaskArgs(step.TaskArgs, outputs)
+	if err == nil {
+		if pipeline.MaxTotalFee > 0 && maxTotalFee == 0 {
+			err = errors.New("max total fee of the pipeline reached")
+		}
+		if err == nil {
+			var clientTask *models.ClientTask
+			clientTask, err = runner.CreateTask(ctx, pipeline, step, taskArgs, maxTotalFee)
+			if err == nil {
+				log.Infof("Pipelines: step %s of pipeline %d started with client task %d", step.Name, pipeline.ID, clientTask.ID)
+				if err := step.Update(ctx, db, map[string]interface{}{
+					"status":         models.PipelineStepRunning,
+					"client_task_id": clientTask.ID,
+				}); err != nil {
+					return err
+				}
+				// the pipeline may be cancelled while the task is being created
+				if err := pipeline.Sync(ctx, db); err != nil {
+					return err
+				}
+				if pipeline.Status != models.PipelineStatusRunning {
+					return skipSteps(ctx, pipeline)
+				}
+				return nil
+			}
+		}
+	}
+	log.Errorf("Pipelines: cannot start step %s of pipeline %d: %v", step.Name, pipeline.ID, err)
+	return step.Update(ctx, db, map[string]interface{}{
+		"status": models.PipelineStepFailed,
+		"error":  err.Error(),
+	})
+}
+
+// processPipeline moves the pipeline forward: it checks the running steps,
+// starts the steps whose dependencies have succeeded, and ends the pipeline
+// once all the steps have succeeded or one of them has failed
+func processPipeline(ctx context.Context, runner PipelineStepRunner, pipeline *models.Pipeline) error {
+	db := config.GetDB()
+
+	// the running steps may spend up to the budgets given to their client tasks
+	var reserved uint64
+	for i := range pipeline.Steps {
+		step := &pipeline.Steps[i]
+		if step.Status == models.PipelineStepRunning {
+			budget, err := checkRunningStep(ctx, runner, pipeline, step)
+			if err != nil {
+				return err
+			}
+			if step.Status == models.PipelineStepRunning && budget > step.TaskFee {
+				reserved += budget - step.TaskFee
+			}
+		}
+	}
+
+	var totalFee uint64
+	for _, step := range pipeline.Steps {
+		totalFee += step.TaskFee
+	}
+	if totalFee != pipeline.TotalFee {
+		if err := pipeline.Update(ctx, db, map[string]interface{}{"total_fee": totalFee}); err != nil {
+			return err
+		}
+	}
+
+	for _, step := range pipeline.Steps {
+		if step.Status == models.PipelineStepFailed {
+			return endPipeline(ctx, pipeline, models.PipelineStatusFailed, fmt.Sprintf("step %s failed: %s", step.Name, step.Error))
+		}
+	}
+
+	outputs, err := stepOutputs(pipeline)
+	if err != nil {
+		return err
+	}
+	if len(outputs) == len(pipeline.Steps) {
+		log.Infof("Pipelines: pipeline %d succeeded", pipeline.ID)
+		return pipeline.End(ctx, db, models.PipelineStatusSuccess, "")
+	}
+
+	readySteps := make([]*models.PipelineStep, 0)
+	for i := range pipeline.Steps {
+		step := &pipeline.Steps[i]
+		if step.Status != models.PipelineStepPending {
+			continue
+		}
+		ready := true
+		for _, name := range step.Dependencies() {
+			if _, ok := outputs[name]; !ok {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			readySteps = append(readySteps, step)
+		}
+	}
+
+	// the remaining budget is shared by the steps started together, so that the steps
+	// running in parallel cannot spend over the max total fee of the pipeline
+	var budget uint64
+	if pipeline.MaxTotalFee > totalFee+reserved {
+		budget = pipeline.MaxTotalFee - totalFee - reserved
+	}
+	for i, step := range readySteps {
+		var maxTotalFee uint64
+		if pipeline.MaxTotalFee > 0 {
+			maxTotalFee = budget / uint64(len(readySteps)-i)
+			budget -= maxTotalFee
+		}
+		if err := startStep(ctx, runner, pipeline, step, outputs, maxTotalFee); err != nil {
+			return err
+		}
+		// the failure of the step ends the pipeline in the next round
+		if step.Status == models.PipelineStepFailed {
+			return nil
+		}
+	}
+	return nil
+}
+
+func processPipelines(ctx context.Context, runner PipelineStepRunner) {
+	wake, unsubscribe := models.Subscribe(models.PipelineKey)
+	defer unsubscribe()
+
+	for {
+		pipelines, err := models.GetRunningPipelines(ctx, config.GetDB())
+		if err != nil {
+			log.Errorf("Pipelines: cannot get running pipelines: %v", err)
+		}
+		for i := range pipelines {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := processPipeline(ctx, runner, &pipelines[i]); err != nil && !errors.Is(err, models.ErrPipelineNotRunning) {
+				log.Errorf("Pipelines: cannot process pipeline %d: %v", pipelines[i].ID, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(pipelinePollInterval):
+		}
+	}
+}
+
+// ProcessPipelines runs the steps of the running pipelines until ctx is done
+func ProcessPipelines(runner PipelineStepRunner) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		runAsLeader(ctx, "pipelines", func(ctx context.Context) {
+			processPipelines(ctx, runner)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+)
+
+// testStepRunner creates the client tasks of the steps without inference tasks, and records their budgets
+type testStepRunner struct {
+	budgets map[string]uint64
+	tasks   map[string]*models.ClientTask
+}
+
+func (r *testStepRunner) CreateTask(ctx context.Context, pipeline *models.Pipeline, step *models.PipelineStep, taskArgs string, maxTotalFee uint64) (*models.ClientTask, error) {
+	// the client task is not polled by the scheduler of the integration tests
+	clientTask := &models.ClientTask{ClientID: pipeline.ClientID, MaxTotalFee: maxTotalFee, NextPollAt: time.Now().Add(time.Hour)}
+	if err := config.GetDB().Create(clientTask).Error; err != nil {
+		return nil, err
+	}
+	r.budgets[step.Name] = maxTotalFee
+	r.tasks[step.Name] = clientTask
+	return clientTask, nil
+}
+
+func (r *testStepRunner) ReadOutput(ctx context.Context, pipeline *models.Pipeline, step *models.PipelineStep, clientTask *models.ClientTask) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+// finishTestStep makes the client task of the step succeed with the fee spent
+func finishTestStep(t *testing.T, runner *testStepRunner, name string, taskFee uint64) {
+	t.Helper()
+	clientTask := runner.tasks[name]
+	createBudgetTestTask(t, clientTask, taskFee, models.InferenceTaskResultDownloaded, models.TaskAbortReasonNone)
+	if err := config.GetDB().Model(clientTask).UpdateColumn("status", models.ClientTaskStatusSuccess).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelineParallelStepsBudget(t *testing.T) {
+	ctx := context.Background()
+	db := config.GetDB()
+
+	client := &models.Client{ClientId: t.Name()}
+	if err := db.Where(client).FirstOrCreate(client).Error; err != nil {
+		t.Fatal(err)
+	}
+	// a and b run in parallel, and c runs after both
+	pipeline := &models.Pipeline{
+		Client:      *client,
+		MaxTotalFee: 10,
+		Steps: []models.PipelineStep{
+			{Name: "a", Type: models.PipelineStepLLM, TaskArgs: `{}`},
+			{Name: "b", Type: models.PipelineStepLLM, TaskArgs: `{}`},
+			{Name: "c", Type: models.PipelineStepLLM, TaskArgs: `{}`, DependsOn: models.StringArray{"a", "b"}},
+		},
+	}
+	if err := pipeline.Save(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	runner := &testStepRunner{budgets: make(map[string]uint64), tasks: make(map[string]*models.ClientTask)}
+	process := func() {
+		t.Helper()
+		current, err := models.GetPipelineByID(ctx, db, pipeline.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := processPipeline(ctx, runner, current); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	process()
+	if runner.budgets["a"]+runner.budgets["b"] != 10 || runner.budgets["a"] == 0 || runner.budgets["b"] == 0 {
+		t.Fatalf("the parallel steps should share the budget: %v", runner.budgets)
+	}
+
+	// the budget of b is still reserved while it is running
+	finishTestStep(t, runner, "a", 3)
+	process()
+	if _, ok := runner.budgets["c"]; ok {
+		t.Fatalf("c should not start before b: %v", runner.budgets)
+	}
+
+	finishTestStep(t, runner, "b", 4)
+	process()
+	if runner.budgets["c"] != 3 {
+		t.Errorf("c should get the budget left by a and b: %v", runner.budgets)
+	}
+}
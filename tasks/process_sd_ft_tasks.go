@@ -5,10 +5,9 @@ import (
 	"context"
 	"crynux_bridge/config"
 	"crynux_bridge/models"
-	"crypto/rand"
+	"crynux_bridge/storage"
+	"crynux_bridge/utils"
 	"errors"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -16,100 +15,6 @@ import (
 	"gorm.io/gorm"
 )
 
-func ProcessSDFTTasks(ctx context.Context) {
-	type result struct {
-		ID uint `json:"id"`
-	}
-
-	lastID := uint(0)
-	limit := 100
-
-	for {
-		tasks, err := func(ctx context.Context) ([]*models.ClientTask, error) {
-			var results []result
-
-			dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
-			err := config.GetDB().WithContext(dbCtx).Table("client_tasks").
-				Select("distinct client_tasks.id").
-				Joins("LEFT JOIN inference_tasks ON inference_tasks.client_task_id = client_tasks.id").
-				Where("client_tasks.status = ?", models.ClientTaskStatusRunning).
-				Where("inference_tasks.task_type = ?", models.TaskTypeSDFTLora).
-				Where("client_tasks.id > ?", lastID).
-				Order("client_tasks.id ASC").
-				Limit(limit).
-				Scan(&results).
-				Error
-			if err != nil {
-				return nil, err
-			}
-
-			var tasks []*models.ClientTask
-			var ids []uint
-			for _, result := range results {
-				ids = append(ids, result.ID)
-			}
-
-			if len(ids) > 0 {
-				dbCtx1, cancel1 := context.WithTimeout(ctx, 10*time.Second)
-				defer cancel1()
-				err := config.GetDB().WithContext(dbCtx1).Model(&models.ClientTask{}).
-					Where("id IN (?)", ids).
-					Order("id ASC").
-					Find(&tasks).
-					Error
-				if err != nil {
-					return nil, err
-				}
-			}
-
-			return tasks, nil
-		}(ctx)
-		if err != nil {
-			log.Errorf("GetSDFTTasks: cannot get tasks: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		if len(tasks) == 0 {
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		for _, task := range tasks {
-			go func(ctx context.Context, task *models.ClientTask) {
-				var inferenceTaskID uint
-				for task.Status == models.ClientTaskStatusRunning {
-					inferenceTask, err := getRunningSDFTInferenceTask(ctx, task.ID)
-					if err != nil {
-						log.Errorf("ProcessSDFTTasks: cannot get running inference task of client task %d: %v", task.ID, err)
-						return
-					}
-					if inferenceTask == nil {
-						log.Errorf("ProcessSDFTTasks: no running inference task of client task %d", task.ID)
-						return
-					}
-					if inferenceTaskID == inferenceTask.ID {
-						log.Errorf("ProcessSDFTTasks: get the same inference task of client task %d", task.ID)
-					}
-					inferenceTaskID = inferenceTask.ID
-					func() {
-						duration := time.Duration(inferenceTask.Timeout) * time.Second
-						ctx, cancel := context.WithTimeout(ctx, duration)
-						defer cancel()
-						err = processSDFTTaskWithRetry(ctx, task, inferenceTask)
-						if err != nil {
-							log.Errorf("ProcessSDFTTasks: cannot process task %d: %v", task.ID, err)
-						}	
-					}()
-				}
-			}(ctx, task)
-		}
-
-		lastID = tasks[len(tasks)-1].ID
-	}
-}
-
 func getRunningSDFTInferenceTask(ctx context.Context, clientTaskID uint) (*models.InferenceTask, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -138,52 +43,83 @@ func getRunningSDFTInferenceTask(ctx context.Context, clientTaskID uint) (*model
 	return &inferenceTask, nil
 }
 
-func processSDFTTaskWithRetry(ctx context.Context, clientTask *models.ClientTask, inferenceTask *models.InferenceTask) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			err := processSDFTTask(ctx, clientTask, inferenceTask)
-			if err != nil {
-				time.Sleep(3 * time.Second)
-			} else {
-				return nil
-			}
-		}
-	}
+// taskEnded reports whether the task will not change any more.
+// Tasks in InferenceTaskNeedCancel are not ended, they may still succeed before being cancelled.
+func taskEnded(task *models.InferenceTask) bool {
+	return task.Status == models.InferenceTaskEndInvalidated ||
+		task.Status == models.InferenceTaskEndGroupRefund ||
+		task.Status == models.InferenceTaskEndAborted ||
+		task.Status == models.InferenceTaskResultDownloaded ||
+		task.Status == models.InferenceTaskResultMismatch
 }
 
-func processSDFTTask(ctx context.Context, clientTask *models.ClientTask, inferenceTask *models.InferenceTask) error {
-	log.Infof("processSDFTTask: process client task %d inference task %d", clientTask.ID, inferenceTask.ID)
-	taskGroup, err := models.WaitTaskGroup(ctx, config.GetDB(), inferenceTask)
+// stepSDFTClientTask checks the latest segment of a sd finetune client task, and starts the
+// next segment, retries the segment or finishes the client task accordingly
+func stepSDFTClientTask(ctx context.Context, clientTask *models.ClientTask) (time.Duration, error) {
+	inferenceTask, err := getRunningSDFTInferenceTask(ctx, clientTask.ID)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if inferenceTask.ID == 0 {
+		return clientTaskPollInterval, nil
+	}
+	// the task group is unknown before the vrf number is generated,
+	// unless the task ends before that
+	if len(inferenceTask.VRFNumber) == 0 && !taskEnded(inferenceTask) {
+		return clientTaskPollInterval, nil
 	}
 
-	resultDownloadedTask, err := models.WaitResultTask(ctx, config.GetDB(), taskGroup)
+	taskGroup := []models.InferenceTask{*inferenceTask}
+	vrfNumber, _ := hexutil.Decode(inferenceTask.VRFNumber)
+	if len(vrfNumber) > 0 && utils.VrfNeedValidation(vrfNumber) {
+		taskGroup, err = models.GetTaskGroup(ctx, config.GetDB(), inferenceTask.TaskID)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	if err == models.ErrTaskEndWithoutResult {
-		return processFailedSDFTTask(ctx, clientTask, inferenceTask)
+	for _, task := range taskGroup {
+		if task.Success() {
+			return 0, processResultDownloadedSDFTTask(ctx, clientTask, &task)
+		}
 	}
-	if err != nil {
-		return err
+	for _, task := range taskGroup {
+		if !taskEnded(&task) {
+			return clientTaskPollInterval, nil
+		}
 	}
 
-	if resultDownloadedTask != nil {
-		return processResultDownloadedSDFTTask(ctx, clientTask, resultDownloadedTask)
+	// the validation tasks are created after the segment task, use the segment task
+	// so that the retry decision is made once for the whole group
+	segmentTask := taskGroup[0]
+	for _, task := range taskGroup[1:] {
+		if task.ID < segmentTask.ID {
+			segmentTask = task
+		}
 	}
-
-	return nil
+	log.Infof("processSDFTTasks: client task %d segment task %d failed", clientTask.ID, segmentTask.ID)
+	return processFailedTask(ctx, clientTask, &segmentTask)
 }
 
 func processResultDownloadedSDFTTask(ctx context.Context, clientTask *models.ClientTask, task *models.InferenceTask) error {
-	appConfig := config.GetConfig()
+	store := storage.GetResultStore()
 
-	resultFilePath := filepath.Join(appConfig.DataDir.InferenceTasks, task.TaskIDCommitment, "result.zip")
-	if _, err := os.Stat(resultFilePath); !os.IsNotExist(err) {
+	resultKey := storage.TaskKey(task.TaskIDCommitment, "result.zip")
+	exists, err := store.Exists(ctx, resultKey)
+	if err != nil {
+		return err
+	}
+	if exists {
 		log.Infof("processSDFTTasks: client task %d inference task %d result file already exists", clientTask.ID, task.ID)
 
+		resultFilePath, removeResultFile, err := storage.FetchFile(ctx, store, resultKey)
+		if err != nil {
+			return err
+		}
+		defer removeResultFile()
+		if err := registerSDFTLoraModel(ctx, config.GetDB(), clientTask, task, resultFilePath); err != nil {
+			return err
+		}
 		if clientTask.Status != models.ClientTaskStatusSuccess {
 			clientTask.Status = models.ClientTaskStatusSuccess
 			if err := clientTask.Update(ctx, config.GetDB(), clientTask); err != nil {
@@ -193,11 +129,16 @@ func processResultDownloadedSDFTTask(ctx context.Context, clientTask *models.Cli
 		return nil
 	}
 
-	checkpointFilePath := filepath.Join(appConfig.DataDir.InferenceTasks, task.TaskIDCommitment, "checkpoint.zip")
-	if _, err := os.Stat(checkpointFilePath); os.IsNotExist(err) {
+	checkpointKey := storage.TaskKey(task.TaskIDCommitment, "checkpoint.zip")
+	checkpointFilePath, removeCheckpointFile, err := storage.FetchFile(ctx, store, checkpointKey)
+	if errors.Is(err, storage.ErrNotFound) {
 		log.Errorf("processSDFTTasks: checkpoint file of task %s not found", task.TaskIDCommitment)
 		return errors.New("checkpoint file not found")
 	}
+	if err != nil {
+		return err
+	}
+	defer removeCheckpointFile()
 
 	// unzip checkpoint file and check if FINISH file exists
 	finished, err := func() (bool, error) {
@@ -223,48 +164,82 @@ func processResultDownloadedSDFTTask(ctx context.Context, clientTask *models.Cli
 
 	if finished {
 		log.Infof("processSDFTTasks: client task %d inference task %d is finished", clientTask.ID, task.ID)
-		// rename the checkpoint file to result.zip
-		err = os.Rename(checkpointFilePath, filepath.Join(appConfig.DataDir.InferenceTasks, task.TaskIDCommitment, "result.zip"))
-		if err != nil {
-			log.Errorf("processSDFTTasks: cannot rename checkpoint file of task %s: %v", task.TaskIDCommitment, err)
+		// the finished checkpoint is the result
+		if err := storage.PutFile(ctx, store, resultKey, checkpointFilePath); err != nil {
+			log.Errorf("processSDFTTasks: cannot save result file of task %s: %v", task.TaskIDCommitment, err)
 			return err
 		}
+		if err := store.Delete(ctx, checkpointKey); err != nil {
+			log.Errorf("processSDFTTasks: cannot remove checkpoint file of task %s: %v", task.TaskIDCommitment, err)
+		}
 		// update client task status
-		clientTask.Status = models.ClientTaskStatusSuccess
-		if err := clientTask.Update(ctx, config.GetDB(), clientTask); err != nil {
+		attempt, err := newSDFTSegmentAttempt(ctx, clientTask, task, models.AttemptDecisionFinish)
+		if err != nil {
 			return err
 		}
-		return nil
+		return config.GetDB().Transaction(func(tx *gorm.DB) error {
+			if err := attempt.Save(ctx, tx); err != nil {
+				return err
+			}
+			if err := registerSDFTLoraModel(ctx, tx, clientTask, task, checkpointFilePath); err != nil {
+				return err
+			}
+			return clientTask.Update(ctx, tx, &models.ClientTask{Status: models.ClientTaskStatusSuccess})
+		})
 	} else {
 		// sd ft task is not finished, create a new task with the same client task id and task args, except the checkpoint file
-		newTaskArgs, err := models.ChangeSDFTTaskArgsCheckpoint(task.TaskArgs, checkpointFilePath)
+		attempt, err := models.GetTaskAttemptByInferenceTaskID(ctx, config.GetDB(), task.ID)
+		if err != nil {
+			return err
+		}
+		if attempt != nil {
+			return nil
+		}
+		newTaskArgs, err := models.ChangeSDFTTaskArgsCheckpoint(task.TaskArgs, checkpointKey)
 		if err != nil {
 			log.Errorf("processSDFTTasks: cannot change task args of task %s: %v", task.TaskIDCommitment, err)
 			return err
 		}
-		taskIDBytes := make([]byte, 32)
-		rand.Read(taskIDBytes)
-		newTaskID := hexutil.Encode(taskIDBytes)
-
-		newTask := &models.InferenceTask{
-			ClientID:        task.ClientID,
-			ClientTaskID:    task.ClientTaskID,
-			TaskArgs:        newTaskArgs,
-			TaskType:        task.TaskType,
-			TaskModelIDs:    task.TaskModelIDs,
-			TaskVersion:     task.TaskVersion,
-			TaskFee:         task.TaskFee,
-			MinVram:         task.MinVram,
-			RequiredGPU:     task.RequiredGPU,
-			RequiredGPUVram: task.RequiredGPUVram,
-			TaskSize:        task.TaskSize,
-			TaskID:          newTaskID,
-			Timeout:         task.Timeout,
+		withinBudget, err := clientTask.WithinBudget(ctx, config.GetDB(), task.TaskFee)
+		if err != nil {
+			return err
+		}
+		if !withinBudget {
+			log.Infof("processSDFTTasks: client task %d exceeds its max total fee", clientTask.ID)
+			attempt, err = newSDFTSegmentAttempt(ctx, clientTask, task, models.AttemptDecisionBudgetExceeded)
+			if err != nil {
+				return err
+			}
+			return config.GetDB().Transaction(func(tx *gorm.DB) error {
+				if err := attempt.Save(ctx, tx); err != nil {
+					return err
+				}
+				return clientTask.Update(ctx, tx, &models.ClientTask{Status: models.ClientTaskStatusBudgetExceeded})
+			})
 		}
 
-		err = newTask.Save(ctx, config.GetDB())
+		newTask := copyTask(task, newTaskArgs, task.TaskFee, task.MinVram)
+		attempt, err = newSDFTSegmentAttempt(ctx, clientTask, task, models.AttemptDecisionNextSegment)
 		if err != nil {
-			log.Errorf("processSDFTTasks: cannot save new task %s: %v", newTaskID, err)
+			return err
+		}
+		maxTaskFee, err := GetMaxTaskFee(ctx, clientTask.ClientID)
+		if err != nil {
+			return err
+		}
+		err = config.GetDB().Transaction(func(tx *gorm.DB) error {
+			if err := newTask.Save(ctx, tx); err != nil {
+				return err
+			}
+			feeDecision := newResubmitFeeDecision(task, newTask, "", maxTaskFee)
+			if err := models.SaveFeeDecisions(ctx, tx, []*models.FeeDecision{feeDecision}); err != nil {
+				return err
+			}
+			attempt.NextTaskID = newTask.ID
+			return attempt.Save(ctx, tx)
+		})
+		if err != nil {
+			log.Errorf("processSDFTTasks: cannot save new task %s: %v", newTask.TaskID, err)
 			return err
 		}
 
@@ -272,42 +247,18 @@ func processResultDownloadedSDFTTask(ctx context.Context, clientTask *models.Cli
 	}
 }
 
-func processFailedSDFTTask(ctx context.Context, clientTask *models.ClientTask, task *models.InferenceTask) error {
-	clientTask.FailedCount += 1
-	if clientTask.FailedCount > 3 {
-		clientTask.Status = models.ClientTaskStatusFailed
-	}
-	if err := clientTask.Update(ctx, config.GetDB(), clientTask); err != nil {
-		return err
-	}
-	if clientTask.Status == models.ClientTaskStatusFailed {
-		return nil
+func newSDFTSegmentAttempt(ctx context.Context, clientTask *models.ClientTask, task *models.InferenceTask, decision models.AttemptDecision) (*models.TaskAttempt, error) {
+	attemptCount, err := models.CountTaskAttempts(ctx, config.GetDB(), clientTask.ID)
+	if err != nil {
+		return nil, err
 	}
-
-	taskIDBytes := make([]byte, 32)
-	rand.Read(taskIDBytes)
-	newTaskID := hexutil.Encode(taskIDBytes)
-
-	newTask := &models.InferenceTask{
-		ClientID:        task.ClientID,
-		ClientTaskID:    task.ClientTaskID,
-		TaskArgs:        task.TaskArgs,
-		TaskType:        task.TaskType,
-		TaskModelIDs:    task.TaskModelIDs,
-		TaskVersion:     task.TaskVersion,
+	return &models.TaskAttempt{
+		ClientTaskID:    clientTask.ID,
+		InferenceTaskID: task.ID,
+		Attempt:         attemptCount + 1,
+		TaskStatus:      task.Status,
+		Decision:        decision,
 		TaskFee:         task.TaskFee,
 		MinVram:         task.MinVram,
-		RequiredGPU:     task.RequiredGPU,
-		RequiredGPUVram: task.RequiredGPUVram,
-		TaskSize:        task.TaskSize,
-		TaskID:          newTaskID,
-		Timeout:         task.Timeout,
-	}
-
-	err := newTask.Save(ctx, config.GetDB())
-	if err != nil {
-		return err
-	}
-
-	return nil
+	}, nil
 }
@@ -5,14 +5,13 @@ import (
 	"crynux_bridge/config"
 	"crynux_bridge/models"
 	"crynux_bridge/relay"
+	"crynux_bridge/storage"
 	"crynux_bridge/utils"
 	"crypto/rand"
 	"errors"
 	"fmt"
-	mrand "math/rand"
+	"io"
 	"os"
-	"path"
-	"strings"
 	"sync"
 	"time"
 
@@ -29,7 +28,7 @@ func getTask(ctx context.Context, taskIDCommitment string) (*models.RelayTask, e
 	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	return relay.GetTaskByCommitment(callCtx, taskIDCommitment)
+	return GetTaskBackend().GetTask(callCtx, taskIDCommitment)
 }
 
 func vrfProve(privateKey, samplingSeed []byte) ([]byte, []byte, error) {
@@ -53,10 +52,9 @@ func generateTaskIDCommitment(taskID string) (string, string) {
 	return nonce, taskIDCommitment
 }
 
+// the backends set the timeouts of their calls, the transactions of the chain backend take longer than the relay requests
 func createTask(ctx context.Context, task *models.InferenceTask) error {
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := relay.CreateTask(callCtx, task); err != nil {
+	if err := GetTaskBackend().CreateTask(ctx, task); err != nil {
 		log.Errorf("ProcessTasks: %d createTask failed: err: %v", task.ID, err)
 		return err
 	}
@@ -67,13 +65,11 @@ func getNode(ctx context.Context, address string) (*models.RelayNode, error) {
 	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	return relay.GetNodeByAddress(callCtx, address)
+	return GetTaskBackend().GetNode(callCtx, address)
 }
 
 func validateSingleTask(ctx context.Context, task *models.InferenceTask) error {
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := relay.ValidateTask(callCtx, []*models.InferenceTask{task}); err != nil {
+	if err := GetTaskBackend().ValidateTasks(ctx, []*models.InferenceTask{task}); err != nil {
 		log.Errorf("ProcessTasks: %d validateSingleTask failed: err: %v", task.ID, err)
 		return err
 	}
@@ -81,9 +77,7 @@ func validateSingleTask(ctx context.Context, task *models.InferenceTask) error {
 }
 
 func validateTaskGroup(ctx context.Context, task1, task2, task3 *models.InferenceTask) error {
-	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	if err := relay.ValidateTask(callCtx, []*models.InferenceTask{task1, task2, task3}); err != nil {
+	if err := GetTaskBackend().ValidateTasks(ctx, []*models.InferenceTask{task1, task2, task3}); err != nil {
 		log.Errorf("ProcessTasks: %d validateTaskGroup failed: err: %v", task1.ID, err)
 		return err
 	}
@@ -97,12 +91,8 @@ func syncTask(ctx context.Context, task *models.InferenceTask) (*models.RelayTas
 
 	chainTask, err := getTask(ctx, task.TaskIDCommitment)
 	if err != nil {
-		if task.Status == models.InferenceTaskPending {
-			var relayErr relay.RelayError
-			if errors.As(err, &relayErr) && strings.Contains(relayErr.ErrorMessage, "Task not found") {
-				return nil, nil
-			}
-			return nil, err
+		if task.Status == models.InferenceTaskPending && isTaskNotFound(err) {
+			return nil, nil
 		}
 		return nil, err
 	}
@@ -127,6 +117,45 @@ func syncTask(ctx context.Context, task *models.InferenceTask) (*models.RelayTas
 		changed = true
 	}
 
+	if chainTask.StartTime != nil && task.StartTime == nil {
+		newTask.StartTime = chainTask.StartTime
+		changed = true
+	}
+	if chainTask.ScoreReadyTime != nil && task.ScoreReadyTime == nil {
+		newTask.ScoreReadyTime = chainTask.ScoreReadyTime
+		changed = true
+	}
+	if chainTask.ValidatedTime != nil && task.ValidatedTime == nil {
+		newTask.ValidatedTime = chainTask.ValidatedTime
+		changed = true
+	}
+	if chainTask.ResultUploadedTime != nil && task.ResultUploadedTime == nil {
+		newTask.ResultUploadedTime = chainTask.ResultUploadedTime
+		changed = true
+	}
+
+	if chainTask.SelectedNode != task.SelectedNode {
+		newTask.SelectedNode = chainTask.SelectedNode
+		changed = true
+		// the benchmark report of the auto tasks is broken down by the gpus of the nodes
+		if task.Priority == models.PriorityAuto && len(chainTask.SelectedNode) > 0 {
+			node, err := getNode(ctx, chainTask.SelectedNode)
+			if err != nil {
+				return nil, err
+			}
+			newTask.SelectedGPU = node.GPUName
+			newTask.SelectedGPUVram = node.GPUVram
+		}
+	}
+	if chainTask.QOSScore != task.QOSScore {
+		newTask.QOSScore = chainTask.QOSScore
+		changed = true
+	}
+	if chainTask.Score != task.Score {
+		newTask.Score = chainTask.Score
+		changed = true
+	}
+
 	if chainTaskStatus == models.ChainTaskStarted {
 		if task.Status != models.InferenceTaskStarted {
 			newTask.Status = models.InferenceTaskStarted
@@ -182,20 +211,33 @@ func syncTask(ctx context.Context, task *models.InferenceTask) (*models.RelayTas
 	return chainTask, nil
 }
 
-func doDownloadTaskResult(ctx context.Context, taskIDCommitment string, index uint64, filename string) error {
-	for {
-		err := func() error {
-			file, err := os.Create(filename)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
+// downloadToStore downloads a result file of a task to a temporary file with download, and stores it as key
+func downloadToStore(ctx context.Context, key string, download func(dst io.Writer) error) error {
+	file, err := os.CreateTemp("", "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
 
-			if err := relay.DownloadTaskResult(ctx, taskIDCommitment, index, file); err != nil {
-				return err
-			}
-			return nil
-		}()
+	if err := download(file); err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return storage.GetResultStore().Put(ctx, key, file, size)
+}
+
+func doDownloadTaskResult(ctx context.Context, taskIDCommitment string, index uint64, key string) error {
+	for {
+		err := downloadToStore(ctx, key, func(dst io.Writer) error {
+			return GetTaskBackend().DownloadTaskResult(ctx, taskIDCommitment, index, dst)
+		})
 		if err != nil {
 			var relayErr relay.RelayError
 			if errors.As(err, &relayErr) && relayErr.StatusCode == 400 {
@@ -211,20 +253,11 @@ func doDownloadTaskResult(ctx context.Context, taskIDCommitment string, index ui
 	}
 }
 
-func doDownloadTaskResultCheckpoint(ctx context.Context, taskIDCommitment string, filename string) error {
+func doDownloadTaskResultCheckpoint(ctx context.Context, taskIDCommitment string, key string) error {
 	for {
-		err := func() error {
-			file, err := os.Create(filename)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			if err := relay.DownloadTaskResultCheckpoint(ctx, taskIDCommitment, file); err != nil {
-				return err
-			}
-			return nil
-		}()
+		err := downloadToStore(ctx, key, func(dst io.Writer) error {
+			return GetTaskBackend().DownloadTaskResultCheckpoint(ctx, taskIDCommitment, dst)
+		})
 		if err != nil {
 			var relayErr relay.RelayError
 			if errors.As(err, &relayErr) && relayErr.StatusCode == 400 {
@@ -242,24 +275,12 @@ func doDownloadTaskResultCheckpoint(ctx context.Context, taskIDCommitment string
 }
 
 func downloadTaskResult(ctx context.Context, task *models.InferenceTask) error {
-	appConfig := config.GetConfig()
-
-	taskFolder := path.Join(
-		appConfig.DataDir.InferenceTasks,
-		task.TaskIDCommitment,
-	)
-
-	if err := os.MkdirAll(taskFolder, 0700); err != nil {
-		log.Errorf("ProcessTasks: cannot create task result dir of %d", task.ID)
-		return err
-	}
-
 	ctx1, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	if task.TaskType == models.TaskTypeSDFTLora {
-		filename := path.Join(taskFolder, "checkpoint.zip")
-		if err := doDownloadTaskResultCheckpoint(ctx1, task.TaskIDCommitment, filename); err != nil {
+		key := storage.TaskKey(task.TaskIDCommitment, "checkpoint.zip")
+		if err := doDownloadTaskResultCheckpoint(ctx1, task.TaskIDCommitment, key); err != nil {
 			return err
 		}
 		return nil
@@ -272,12 +293,12 @@ func downloadTaskResult(ctx context.Context, task *models.InferenceTask) error {
 		var wg sync.WaitGroup
 		errCh := make(chan error, int(task.TaskSize))
 		for i := uint64(0); i < task.TaskSize; i++ {
-			filename := path.Join(taskFolder, fmt.Sprintf("%d.%s", i, ext))
+			key := storage.TaskKey(task.TaskIDCommitment, fmt.Sprintf("%d.%s", i, ext))
 			wg.Add(1)
-			go func(ctx context.Context, taskIDCommitment string, index uint64, filename string) {
+			go func(ctx context.Context, taskIDCommitment string, index uint64, key string) {
 				defer wg.Done()
-				errCh <- doDownloadTaskResult(ctx, taskIDCommitment, index, filename)
-			}(ctx1, task.TaskIDCommitment, i, filename)
+				errCh <- doDownloadTaskResult(ctx, taskIDCommitment, index, key)
+			}(ctx1, task.TaskIDCommitment, i, key)
 		}
 		wg.Wait()
 		for i := 0; i < int(task.TaskSize); i++ {
@@ -291,435 +312,330 @@ func downloadTaskResult(ctx context.Context, task *models.InferenceTask) error {
 
 }
 
-func processOneTask(ctx context.Context, task *models.InferenceTask) error {
+// taskDeadline is the time the task should be cancelled if it is still not finished
+func taskDeadline(task *models.InferenceTask) time.Time {
+	// if timeout is 0, use default timeout
+	timeout := task.Timeout
+	if timeout == 0 {
+		appConfig := config.GetConfig()
+		timeout = appConfig.Task.DefaultTimeout
+		if task.TaskType == models.TaskTypeSDFTLora {
+			timeout = appConfig.Task.SDFinetuneTimeout
+		}
+		timeout *= 60
+	}
+	duration := time.Duration(timeout)*time.Second + 3*time.Minute // additional 3 minutes for waiting task to start
+	return task.CreatedAt.Add(duration)
+}
+
+// stepInferenceTask handles the current state of the task once, and returns the time
+// to wait before the task should be processed again
+func stepInferenceTask(ctx context.Context, task *models.InferenceTask) (time.Duration, error) {
 	// sync task from database
 	if err := task.Sync(ctx, config.GetDB()); err != nil {
-		return err
+		return 0, err
 	}
-
-	// sync task from relay
-	_, err := syncTask(ctx, task)
-	if err != nil {
-		return err
+	if task.Finished() {
+		return 0, nil
 	}
-	log.Infof("ProcessTasks: task %d status %d", task.ID, task.Status)
 
-	// 1. Generate taskIDCommitment if not exist
-	// 2. Create task
-	// 3. Update task status to InferenceTaskCreated
-	if task.Status == models.InferenceTaskPending {
-		if len(task.TaskIDCommitment) == 0 {
-			nonce, taskIDCommitment := generateTaskIDCommitment(task.TaskID)
-			newTask := &models.InferenceTask{
-				Nonce:            nonce,
-				TaskIDCommitment: taskIDCommitment,
-			}
-			if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
-				return err
-			}
-		}
+	// the result of a successful task can still be downloaded after the deadline
+	if task.Status != models.InferenceTaskEndSuccess && time.Now().After(taskDeadline(task)) {
+		log.Errorf("ProcessTasks: task %d timeout, need cancel", task.ID)
+		return 0, task.Update(ctx, config.GetDB(), &models.InferenceTask{Status: models.InferenceTaskNeedCancel})
+	}
 
-		if err := createTask(ctx, task); err != nil {
-			return err
-		}
+	// sync task from the task backend
+	chainTask, err := syncTask(ctx, task)
+	if err != nil {
+		return 0, err
+	}
+	log.Debugf("ProcessTasks: task %d status %d", task.ID, task.Status)
+
+	switch task.Status {
+	case models.InferenceTaskPending:
+		return 0, stepPendingTask(ctx, task)
+	case models.InferenceTaskCreated, models.InferenceTaskStarted, models.InferenceTaskParamsUploaded:
+		return stepRunningTask(ctx, task, chainTask)
+	case models.InferenceTaskScoreReady, models.InferenceTaskErrorReported:
+		return stepScoreReadyTask(ctx, task)
+	case models.InferenceTaskEndSuccess:
+		return 0, stepSuccessTask(ctx, task)
+	}
+	// wait task status to be success, aborted, invalidated or group refund
+	return relayPollInterval, nil
+}
 
+// 1. Generate taskIDCommitment if not exist
+// 2. Create task
+// 3. Update task status to InferenceTaskCreated
+func stepPendingTask(ctx context.Context, task *models.InferenceTask) error {
+	if len(task.TaskIDCommitment) == 0 {
+		nonce, taskIDCommitment := generateTaskIDCommitment(task.TaskID)
 		newTask := &models.InferenceTask{
-			Status: models.InferenceTaskCreated,
+			Nonce:            nonce,
+			TaskIDCommitment: taskIDCommitment,
 		}
 		if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
 			return err
 		}
-		log.Infof("ProcessTasks: create task %d ", task.ID)
 	}
 
-	// 1. Sync sequence and sampling seed, update local database
-	// 2. If needs two more sub-tasks, generate them and store into database
-	// 3. Wait for task result hash to be submitted to relay(Status: InferenceTaskTaskScoreReady)
-	if task.Status == models.InferenceTaskCreated || task.Status == models.InferenceTaskStarted || task.Status == models.InferenceTaskParamsUploaded {
-		// get task sequence and sampling number
-		chainTask, err := getTask(ctx, task.TaskIDCommitment)
-		if err != nil {
-			return err
-		}
-		newTask := &models.InferenceTask{}
-		newTask.Sequence = chainTask.Sequence
+	if err := createTask(ctx, task); err != nil {
+		return err
+	}
 
-		subTasks := make([]*models.InferenceTask, 0)
+	newTask := &models.InferenceTask{
+		Status: models.InferenceTaskCreated,
+	}
+	if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
+		return err
+	}
+	log.Infof("ProcessTasks: create task %d ", task.ID)
+	return nil
+}
 
-		// validation tasks' sampling seed is not empty
-		// avoid generating validation tasks for validation tasks
-		if len(task.SamplingSeed) == 0 {
-			newTask.SamplingSeed = chainTask.SamplingSeed
-			samplingSeedBytes, err := hexutil.Decode(chainTask.SamplingSeed)
-			if err != nil {
-				log.Errorf("ProcessTasks: %d decode sampling seed failed: %v", task.ID, err)
-				return err
-			}
-			// generate vrf proof
-			appConfig := config.GetConfig()
-			pk := appConfig.Blockchain.Account.PrivateKey
-			privateKey, err := hexutil.Decode("0x" + pk)
-			if err != nil {
-				log.Errorf("ProcessTasks: %d decode private key failed: %v", task.ID, err)
-				return err
-			}
-			vrfNum, vrfProof, err := vrfProve(privateKey, samplingSeedBytes)
-			if err != nil {
-				log.Errorf("ProcessTasks: %d vrf prove failed: %v", task.ID, err)
-				return err
-			}
-			newTask.VRFProof = hexutil.Encode(vrfProof)
-			newTask.VRFNumber = hexutil.Encode(vrfNum)
-
-			if utils.VrfNeedValidation(vrfNum) {
-				requiredGPU := task.RequiredGPU
-				requiredGPUVram := task.RequiredGPUVram
-				if task.TaskType == models.TaskTypeLLM {
-					// for LLM type task, need to wait the task is started to determine required gpu for sub tasks
-					for len(chainTask.SelectedNode) == 0 {
-						chainTask, err = getTask(ctx, task.TaskIDCommitment)
-						if err != nil {
-							return err
-						}
-						if len(chainTask.SelectedNode) > 0 {
-							break
-						}
-						time.Sleep(time.Second)
-					}
-					node, err := getNode(ctx, chainTask.SelectedNode)
-					if err != nil {
-						return err
-					}
-					requiredGPU = node.GPUName
-					requiredGPUVram = node.GPUVram
-				}
-				for i := 0; i < 2; i++ {
-					subTask := &models.InferenceTask{
-						ClientID:        task.ClientID,
-						ClientTaskID:    task.ClientTaskID,
-						TaskArgs:        task.TaskArgs,
-						TaskType:        task.TaskType,
-						TaskModelIDs:    task.TaskModelIDs,
-						TaskVersion:     task.TaskVersion,
-						TaskFee:         task.TaskFee,
-						MinVram:         task.MinVram,
-						RequiredGPU:     requiredGPU,
-						RequiredGPUVram: requiredGPUVram,
-						TaskSize:        task.TaskSize,
-						TaskID:          task.TaskID,
-						SamplingSeed:    newTask.SamplingSeed,
-						VRFProof:        newTask.VRFProof,
-						VRFNumber:       newTask.VRFNumber,
-					}
-					subTasks = append(subTasks, subTask)
-				}
-			}
-		}
+// 1. Sync sequence and sampling seed, update local database
+// 2. If needs two more sub-tasks, generate them and store into database
+// 3. Wait for task result hash to be submitted to relay(Status: InferenceTaskTaskScoreReady)
+func stepRunningTask(ctx context.Context, task *models.InferenceTask, chainTask *models.RelayTask) (time.Duration, error) {
+	if chainTask == nil {
+		return relayPollInterval, nil
+	}
+	// validation tasks' sampling seed is not empty
+	// avoid generating validation tasks for validation tasks
+	if task.Sequence == chainTask.Sequence && len(task.SamplingSeed) > 0 {
+		return relayPollInterval, nil
+	}
 
-		err = config.GetDB().Transaction(func(tx *gorm.DB) error {
-			if err := task.Update(ctx, tx, newTask); err != nil {
-				return err
-			}
-			if len(subTasks) > 0 {
-				if err := models.SaveTasks(ctx, tx, subTasks); err != nil {
-					return err
-				}
-			}
-			return nil
-		})
-		if err != nil {
-			return err
-		}
+	newTask := &models.InferenceTask{}
+	newTask.Sequence = chainTask.Sequence
 
-		// wait task status to be score-ready or error reported
-		for {
-			_, err := syncTask(ctx, task)
-			if err != nil {
-				return err
-			}
-			if task.Status == models.InferenceTaskScoreReady || task.Status == models.InferenceTaskErrorReported || task.Status == models.InferenceTaskEndAborted{
-				break
-			}
-			time.Sleep(time.Second)
-		}
-		log.Infof("ProcessTasks: task %d status %d", task.ID, task.Status)
-	}
+	subTasks := make([]*models.InferenceTask, 0)
 
-	// 1. If single task, validate
-	// 2. If task group, wait until all sub-tasks are ready, then validate
-	// 3. Wait for validate result(Status: InferenceTaskEndInvalidated, InferenceTaskEndSuccess, InferenceTaskEndGroupRefund, InferenceTaskEndAborted)
-	if task.Status == models.InferenceTaskScoreReady || task.Status == models.InferenceTaskErrorReported {
-		needValidate := false
-		taskGroup, err := models.GetTaskGroup(ctx, config.GetDB(), task.TaskID)
+	if len(task.SamplingSeed) == 0 {
+		newTask.SamplingSeed = chainTask.SamplingSeed
+		samplingSeedBytes, err := hexutil.Decode(chainTask.SamplingSeed)
 		if err != nil {
-			log.Errorf("ProcessTasks: get tasks of task id %s error: %v", task.TaskID, err)
-			return err
+			log.Errorf("ProcessTasks: %d decode sampling seed failed: %v", task.ID, err)
+			return 0, err
 		}
-		if len(taskGroup) == 1 {
-			needValidate = true
-		} else if len(taskGroup) == 3 {
-			// wait all tasks in group be in status score ready, error reported or aborted
-			for {
-				readyCount := 0
-				for _, subTask := range taskGroup {
-					if subTask.Status >= models.InferenceTaskScoreReady {
-						readyCount += 1
-					}
-				}
-				if readyCount == 3 {
-					break
+		// generate vrf proof
+		appConfig := config.GetConfig()
+		pk := appConfig.Blockchain.Account.PrivateKey
+		privateKey, err := hexutil.Decode("0x" + pk)
+		if err != nil {
+			log.Errorf("ProcessTasks: %d decode private key failed: %v", task.ID, err)
+			return 0, err
+		}
+		vrfNum, vrfProof, err := vrfProve(privateKey, samplingSeedBytes)
+		if err != nil {
+			log.Errorf("ProcessTasks: %d vrf prove failed: %v", task.ID, err)
+			return 0, err
+		}
+		newTask.VRFProof = hexutil.Encode(vrfProof)
+		newTask.VRFNumber = hexutil.Encode(vrfNum)
+
+		if utils.VrfNeedValidation(vrfNum) {
+			requiredGPU := task.RequiredGPU
+			requiredGPUVram := task.RequiredGPUVram
+			if task.TaskType == models.TaskTypeLLM {
+				// for LLM type task, need to wait the task is started to determine required gpu for sub tasks
+				if len(chainTask.SelectedNode) == 0 {
+					return relayPollInterval, nil
 				}
-				time.Sleep(time.Second)
-				taskGroup, err = models.GetTaskGroup(ctx, config.GetDB(), task.TaskID)
+				node, err := getNode(ctx, chainTask.SelectedNode)
 				if err != nil {
-					log.Errorf("ProcessTasks: get tasks of %s error: %v", task.TaskID, err)
-					return err
+					return 0, err
 				}
+				requiredGPU = node.GPUName
+				requiredGPUVram = node.GPUVram
 			}
-			validateTaskIDCommitment := ""
-			for _, subTask := range taskGroup {
-				if subTask.Status == models.InferenceTaskScoreReady || subTask.Status == models.InferenceTaskErrorReported {
-					validateTaskIDCommitment = subTask.TaskIDCommitment
-					break
+			for i := 0; i < 2; i++ {
+				subTask := &models.InferenceTask{
+					ClientID:         task.ClientID,
+					ClientTaskID:     task.ClientTaskID,
+					TaskArgs:         task.TaskArgs,
+					TaskType:         task.TaskType,
+					TaskModelIDs:     task.TaskModelIDs,
+					TaskVersion:      task.TaskVersion,
+					TaskFee:          task.TaskFee,
+					MinVram:          task.MinVram,
+					RequiredGPU:      requiredGPU,
+					RequiredGPUVram:  requiredGPUVram,
+					TaskSize:         task.TaskSize,
+					Priority:         task.Priority,
+					TaskID:           task.TaskID,
+					SamplingSeed:     newTask.SamplingSeed,
+					VRFProof:         newTask.VRFProof,
+					VRFNumber:        newTask.VRFNumber,
+					Replica:          task.Replica,
+					AutoTaskTemplate: task.AutoTaskTemplate,
 				}
-			}
-			if validateTaskIDCommitment == task.TaskIDCommitment {
-				needValidate = true
+				subTasks = append(subTasks, subTask)
 			}
 		}
+	}
 
-		// validate task
-		if needValidate {
-			if len(taskGroup) == 1 {
-				if err := validateSingleTask(ctx, task); err != nil {
-					return err
-				}
-				log.Infof("ProcessTasks: validate single task %d", task.ID)
-			} else if len(taskGroup) == 3 {
-				if err := validateTaskGroup(ctx, &taskGroup[0], &taskGroup[1], &taskGroup[2]); err != nil {
-					return err
-				}
-				log.Infof("ProcessTasks: %d validate task group task %d, %d, %d", task.ID, taskGroup[0].ID, taskGroup[1].ID, taskGroup[2].ID)
-			}
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := task.Update(ctx, tx, newTask); err != nil {
+			return err
 		}
-
-		// wait task status to be validated, invalidated, success, group refund or aborted
-		for {
-			_, err := syncTask(ctx, task)
-			if err != nil {
+		if len(subTasks) > 0 {
+			if err := models.SaveTasks(ctx, tx, subTasks); err != nil {
 				return err
 			}
-			if task.Status >= models.InferenceTaskValidated {
-				break
-			}
-			time.Sleep(time.Second)
 		}
-		log.Infof("ProcessTasks: task %d status %d", task.ID, task.Status)
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
+	// the notifications inside the transaction may be handled before the commit
+	models.Publish(models.InferenceTaskKey(task.ID))
+	models.Publish(models.SchedulerKey)
+	return relayPollInterval, nil
+}
 
-	if task.Status == models.InferenceTaskValidated {
-		for {
-			_, err := syncTask(ctx, task)
-			if err != nil {
-				return err
+// 1. If single task, validate
+// 2. If task group, wait until all sub-tasks are ready, then validate
+// 3. Wait for validate result(Status: InferenceTaskEndInvalidated, InferenceTaskEndSuccess, InferenceTaskEndGroupRefund, InferenceTaskEndAborted)
+func stepScoreReadyTask(ctx context.Context, task *models.InferenceTask) (time.Duration, error) {
+	if task.ValidationSent {
+		return relayPollInterval, nil
+	}
+
+	taskGroup, err := models.GetTaskGroup(ctx, config.GetDB(), task.TaskID)
+	if err != nil {
+		log.Errorf("ProcessTasks: get tasks of task id %s error: %v", task.TaskID, err)
+		return 0, err
+	}
+
+	if len(taskGroup) == 1 {
+		if err := validateSingleTask(ctx, task); err != nil {
+			return 0, err
+		}
+		log.Infof("ProcessTasks: validate single task %d", task.ID)
+	} else if len(taskGroup) == 3 {
+		// wait all tasks in group be in status score ready, error reported or aborted
+		for _, subTask := range taskGroup {
+			if subTask.Status < models.InferenceTaskScoreReady {
+				return relayPollInterval, nil
 			}
-			if task.Status == models.InferenceTaskEndSuccess || task.Status == models.InferenceTaskEndAborted {
+		}
+		validateTaskIDCommitment := ""
+		for _, subTask := range taskGroup {
+			if subTask.Status == models.InferenceTaskScoreReady || subTask.Status == models.InferenceTaskErrorReported {
+				validateTaskIDCommitment = subTask.TaskIDCommitment
 				break
 			}
-			time.Sleep(time.Second)
 		}
-		log.Infof("ProcessTasks: task %d status %d", task.ID, task.Status)
+		if validateTaskIDCommitment != task.TaskIDCommitment {
+			return relayPollInterval, nil
+		}
+		if err := validateTaskGroup(ctx, &taskGroup[0], &taskGroup[1], &taskGroup[2]); err != nil {
+			return 0, err
+		}
+		log.Infof("ProcessTasks: %d validate task group task %d, %d, %d", task.ID, taskGroup[0].ID, taskGroup[1].ID, taskGroup[2].ID)
+	} else {
+		return relayPollInterval, nil
 	}
 
-	// download task result
-	if task.Status == models.InferenceTaskEndSuccess {
-		err := downloadTaskResult(ctx, task)
-		if err != nil {
-			return err
-		}
+	if err := task.Update(ctx, config.GetDB(), &models.InferenceTask{ValidationSent: true}); err != nil {
+		return 0, err
+	}
+	return relayPollInterval, nil
+}
+
+// download task result and verify it against the task score.
+// A mismatched result is downloaded again, and the task fails after too many mismatches.
+func stepSuccessTask(ctx context.Context, task *models.InferenceTask) error {
+	err := downloadTaskResult(ctx, task)
+	if err != nil {
+		return err
+	}
+	verification, err := verifyTaskResult(ctx, task)
+	if err != nil {
+		return err
+	}
+	if verification == models.ResultVerificationMismatch {
 		newTask := &models.InferenceTask{
-			Status: models.InferenceTaskResultDownloaded,
+			ResultVerification: verification,
+			ResultMismatches:   task.ResultMismatches + 1,
+		}
+		if newTask.ResultMismatches >= getMaxResultDownloads() {
+			newTask.Status = models.InferenceTaskResultMismatch
 		}
 		if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
 			return err
 		}
-		log.Infof("ProcessTasks: download results of task %d", task.ID)
+		log.Errorf("ProcessTasks: results of task %d mismatch its score %d times", task.ID, task.ResultMismatches)
+		return nil
 	}
-
-	// update client task status
-	if err := processClientTask(ctx, task); err != nil {
+	newTask := &models.InferenceTask{
+		Status:             models.InferenceTaskResultDownloaded,
+		ResultVerification: verification,
+	}
+	if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
 		return err
 	}
-
+	log.Infof("ProcessTasks: download results of task %d", task.ID)
+	// nobody reads the results of the auto tasks
+	if task.Priority == models.PriorityAuto {
+		if err := deleteTaskResults(ctx, config.GetDB(), task); err != nil {
+			log.Errorf("ProcessTasks: cannot delete results of auto task %d: %v", task.ID, err)
+		}
+	}
 	return nil
 }
 
-func processClientTask(ctx context.Context, task *models.InferenceTask) error {
-	if task.TaskType == models.TaskTypeSDFTLora {
-		return nil
+// stepClientTask updates the client task status from its inference tasks, and returns
+// the time to wait before the client task should be processed again
+func stepClientTask(ctx context.Context, clientTask *models.ClientTask) (time.Duration, error) {
+	var tasks []models.InferenceTask
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := config.GetDB().WithContext(dbCtx).Model(&models.InferenceTask{}).
+		Where("client_task_id = ?", clientTask.ID).
+		Order("id ASC").
+		Find(&tasks).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(tasks) == 0 {
+		return clientTaskPollInterval, nil
 	}
 
-	clientTask, err := models.GetClientTaskByID(ctx, config.GetDB(), task.ClientTaskID)
-	if err != nil {
-		return err
+	if tasks[0].TaskType == models.TaskTypeSDFTLora {
+		return stepSDFTClientTask(ctx, clientTask)
+	}
+	if clientTask.VerificationReplicas > 0 {
+		return stepReplicatedClientTask(ctx, clientTask, tasks)
 	}
-	if clientTask.Status == models.ClientTaskStatusRunning && task.Finished() {
+
+	allFinished := true
+	success := false
+	for _, task := range tasks {
+		if !task.Finished() {
+			allFinished = false
+		}
 		if task.Success() {
-			clientTask.Status = models.ClientTaskStatusSuccess
-			if err := clientTask.Update(ctx, config.GetDB(), clientTask); err != nil {
-				return err
-			}
-		} else {
-			taskGroup, err := models.GetTaskGroup(ctx, config.GetDB(), task.TaskID)
-			if err != nil {
-				return err
-			}
-			if len(taskGroup) == 1 {
-				clientTask.FailedCount += 1
-				clientTask.Status = models.ClientTaskStatusFailed
-				if err := clientTask.Update(ctx, config.GetDB(), clientTask); err != nil {
-					return err
-				}
-			} else {
-				allFinished := true
-				success := false
-				for _, subTask := range taskGroup {
-					if !subTask.Finished() {
-						allFinished = false
-					}
-					if subTask.Success() {
-						success = true
-					}
-				}
-				if allFinished {
-					if success {
-						clientTask.Status = models.ClientTaskStatusSuccess
-					} else {
-						clientTask.FailedCount += 1
-						clientTask.Status = models.ClientTaskStatusFailed
-					}
-					if err := clientTask.Update(ctx, config.GetDB(), clientTask); err != nil {
-						return err
-					}
-				}
-			}
+			success = true
 		}
 	}
-	return nil
-}
-
-// Get unprocessed tasks from database and process them, each task is processed in a goroutine
-func ProcessTasks(ctx context.Context) {
-	limit := 100
-	lastID := uint(0)
-
-	for {
-		// get unprocessed tasks from database
-		tasks, err := func(ctx context.Context) ([]models.InferenceTask, error) {
-			var tasks []models.InferenceTask
-
-			dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
-			defer cancel()
-			err := config.GetDB().WithContext(dbCtx).Model(&models.InferenceTask{}).
-				Where("status != ?", models.InferenceTaskEndAborted).
-				Where("status != ?", models.InferenceTaskEndInvalidated).
-				Where("status != ?", models.InferenceTaskEndGroupRefund).
-				Where("status != ?", models.InferenceTaskResultDownloaded).
-				Where("status != ?", models.InferenceTaskNeedCancel).
-				Where("id > ?", lastID).
-				Find(&tasks).
-				Order("id ASC").
-				Limit(limit).
-				Error
-			if err != nil {
-				return nil, err
-			}
-			return tasks, nil
-		}(ctx)
-		if err != nil {
-			log.Errorf("ProcessTasks: cannot get unprocessed tasks: %v", err)
-			time.Sleep(time.Duration(mrand.Float64()*1000) * time.Millisecond)
-			continue
-		}
-
-		// process tasks one by one
-		if len(tasks) > 0 {
-			lastID = tasks[len(tasks)-1].ID
-
-			for _, task := range tasks {
-				go func(ctx context.Context, task models.InferenceTask) {
-					log.Infof("ProcessTasks: start processing task %d", task.ID)
-					var ctx1 context.Context
-					var cancel context.CancelFunc
-					// if timeout is 0, use default timeout
-					timeout := task.Timeout
-					if timeout == 0 {
-						appConfig := config.GetConfig()
-						timeout = appConfig.Task.DefaultTimeout
-						if task.TaskType == models.TaskTypeSDFTLora {
-							timeout = appConfig.Task.SDFinetuneTimeout
-						}
-						timeout *= 60
-					}
-					duration := time.Duration(timeout) * time.Second + 3 * time.Minute // additional 3 minutes for waiting task to start
-					deadline := task.CreatedAt.Add(duration)
-					ctx1, cancel = context.WithDeadline(ctx, deadline)
-					defer cancel()
-
-					for {
-						c := make(chan error, 1)
-						go func() {
-							c <- processOneTask(ctx1, &task)
-						}()
-
-						select {
-						// process task successfully or failed
-						case err := <-c:
-							if err != nil {
-								log.Errorf("ProcessTasks: process task %d error %v, retry", task.ID, err)
-								duration := time.Duration((mrand.Float64()*3 + 2) * 1000)
-								time.Sleep(duration * time.Millisecond)
-							} else {
-								log.Infof("ProcessTasks: process task %d successfully", task.ID)
-								return
-							}
-						// process task timeout
-						case <-ctx1.Done():
-							err := ctx1.Err()
-							log.Errorf("ProcessTasks: process task %d timeout %v, finish", task.ID, err)
-							if err == context.DeadlineExceeded {
-								newTask := &models.InferenceTask{}
-								if task.Status != models.InferenceTaskEndAborted &&
-									task.Status != models.InferenceTaskEndInvalidated &&
-									task.Status != models.InferenceTaskEndGroupRefund &&
-									task.Status != models.InferenceTaskEndSuccess &&
-									task.Status != models.InferenceTaskResultDownloaded {
-									newTask.Status = models.InferenceTaskNeedCancel
-								}
-								if newTask.Status != models.InferenceTaskPending {
-									for {
-										if err := task.Update(ctx, config.GetDB(), newTask); err != nil {
-											log.Errorf("ProcessTasks: save task %d error %v", task.ID, err)
-											time.Sleep(2 * time.Second)
-										} else {
-											break
-										}
-										if err := processClientTask(ctx, &task); err != nil {
-											log.Errorf("ProcessTasks: process client task %d error %v", task.ID, err)
-											time.Sleep(2 * time.Second)
-										} else {
-											break
-										}
-									}
-								}
-							}
-							return
-						}
-					}
-				}(ctx, task)
+	if success {
+		return 0, clientTask.Update(ctx, config.GetDB(), &models.ClientTask{Status: models.ClientTaskStatusSuccess})
+	}
+	if allFinished {
+		// the retry policy resubmits the first task of the latest attempt, the repeated tasks
+		// and the validation tasks of the attempt share its task id and are created after it
+		latestTaskID := tasks[len(tasks)-1].TaskID
+		for _, task := range tasks {
+			if task.TaskID == latestTaskID {
+				log.Infof("ProcessTasks: client task %d task %d failed", clientTask.ID, task.ID)
+				return processFailedTask(ctx, clientTask, &task)
 			}
 		}
-
-		time.Sleep(time.Second)
 	}
+	return clientTaskPollInterval, nil
 }
@@ -0,0 +1,114 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"crynux_bridge/blockchain"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/storage"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// size of the pHash of an image and of the hash of an llm response in the score
+const (
+	pHashSize       = 8
+	gptResponseSize = 32
+)
+
+func getMaxResultDownloads() int {
+	maxDownloads := config.GetConfig().ResultVerification.MaxDownloads
+	if maxDownloads <= 0 {
+		maxDownloads = 3
+	}
+	return maxDownloads
+}
+
+// hammingDistance returns the number of the different bits of a and b, which have the same length
+func hammingDistance(a, b []byte) int {
+	distance := 0
+	for i := range a {
+		distance += bits.OnesCount8(a[i] ^ b[i])
+	}
+	return distance
+}
+
+// resultHash computes the hash of a downloaded result the way the node computes its score
+func resultHash(taskType models.ChainTaskType, result io.Reader) ([]byte, error) {
+	if taskType == models.TaskTypeSD {
+		return blockchain.GetPHashForImageReader(result)
+	}
+	data, err := io.ReadAll(result)
+	if err != nil {
+		return nil, err
+	}
+	return blockchain.GetHashForGPTResponse(string(data)), nil
+}
+
+// resultKey returns the key of the index-th result file of the task in the result store
+func resultKey(task *models.InferenceTask, index int) string {
+	ext := "png"
+	if task.TaskType == models.TaskTypeLLM {
+		ext = "json"
+	}
+	return storage.TaskKey(task.TaskIDCommitment, fmt.Sprintf("%d.%s", index, ext))
+}
+
+// verifyTaskResult checks the downloaded results of the task against the score submitted by the node:
+// the pHashes of the images should be within the configured hamming distance of the submitted ones,
+// and the hashes of the llm responses should equal the submitted ones.
+func verifyTaskResult(ctx context.Context, task *models.InferenceTask) (models.ResultVerification, error) {
+	conf := config.GetConfig().ResultVerification
+	if !conf.Enabled || task.TaskType == models.TaskTypeSDFTLora || len(task.Score) == 0 {
+		return models.ResultVerificationSkipped, nil
+	}
+
+	score, err := hex.DecodeString(strings.TrimPrefix(task.Score, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("invalid score %s of task %d: %w", task.Score, task.ID, err)
+	}
+
+	hashSize := pHashSize
+	if task.TaskType == models.TaskTypeLLM {
+		hashSize = gptResponseSize
+	}
+	if len(score) != hashSize*int(task.TaskSize) {
+		log.Errorf("ProcessTasks: score of task %d has %d bytes, expected %d", task.ID, len(score), hashSize*int(task.TaskSize))
+		return models.ResultVerificationMismatch, nil
+	}
+
+	for i := 0; i < int(task.TaskSize); i++ {
+		key := resultKey(task, i)
+		file, _, err := storage.GetResultStore().Get(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		hash, err := resultHash(task.TaskType, file)
+		file.Close()
+		if err != nil && task.TaskType == models.TaskTypeLLM {
+			return "", err
+		}
+		if err != nil {
+			// an image which cannot be decoded does not match its pHash
+			log.Errorf("ProcessTasks: cannot hash result %s of task %d: %v", key, task.ID, err)
+			return models.ResultVerificationMismatch, nil
+		}
+		submitted := score[i*hashSize : (i+1)*hashSize]
+		if task.TaskType == models.TaskTypeSD {
+			if len(hash) != pHashSize || hammingDistance(hash, submitted) > conf.PHashDistance {
+				log.Errorf("ProcessTasks: pHash %x of result %s of task %d mismatches score %x", hash, key, task.ID, submitted)
+				return models.ResultVerificationMismatch, nil
+			}
+		} else if !bytes.Equal(hash, submitted) {
+			log.Errorf("ProcessTasks: hash %x of result %s of task %d mismatches score %x", hash, key, task.ID, submitted)
+			return models.ResultVerificationMismatch, nil
+		}
+	}
+	return models.ResultVerificationVerified, nil
+}
@@ -0,0 +1,71 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ResumeTasks reconciles every unfinished task with the relay before processing starts,
+// so that the tasks interrupted by a restart continue from their real state.
+// The tasks being processed by other bridge instances are skipped.
+func ResumeTasks(ctx context.Context) error {
+	db := config.GetDB()
+	owner := config.GetInstanceID()
+	duration := config.GetLeaseDuration()
+
+	// the leases held by the previous run of this instance will never be released otherwise
+	if err := models.ReleaseTaskLeases(ctx, db, owner); err != nil {
+		return err
+	}
+
+	ids, err := models.GetUnfinishedTaskIDs(ctx, db)
+	if err != nil {
+		return err
+	}
+	log.Infof("ResumeTasks: %d unfinished tasks to resume", len(ids))
+
+	resumed := 0
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		claimed, err := models.ClaimInferenceTask(ctx, db, id, owner, duration)
+		if err != nil {
+			log.Errorf("ResumeTasks: cannot claim task %d: %v", id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := resumeTask(ctx, id); err != nil {
+			log.Errorf("ResumeTasks: cannot reconcile task %d with relay: %v", id, err)
+		} else {
+			resumed += 1
+		}
+		if err := models.ReleaseLease(context.WithoutCancel(ctx), db, &models.InferenceTask{}, id, owner, time.Now()); err != nil {
+			log.Errorf("ResumeTasks: cannot release task %d: %v", id, err)
+		}
+	}
+	log.Infof("ResumeTasks: %d tasks resumed", resumed)
+	return nil
+}
+
+func resumeTask(ctx context.Context, id uint) error {
+	task := &models.InferenceTask{RootModel: models.RootModel{ID: id}}
+	if err := task.Sync(ctx, config.GetDB()); err != nil {
+		return err
+	}
+	status := task.Status
+	if _, err := syncTask(ctx, task); err != nil {
+		return err
+	}
+	if task.Status != status {
+		log.Infof("ResumeTasks: task %d status changed from %d to %d", task.ID, status, task.Status)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/storage"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const retentionSweepBatchSize = 100
+
+// retentionRule selects the ended tasks whose results are deleted once they have been kept for ttl
+type retentionRule struct {
+	name  string
+	scope func(*gorm.DB) *gorm.DB
+	ttl   time.Duration
+}
+
+func notAutoTask(db *gorm.DB) *gorm.DB {
+	return db.Where("priority IS NULL OR priority <> ?", models.PriorityAuto)
+}
+
+// finetuneResultsNotInUse excludes the finetune tasks whose files are still used. The checkpoints of the ended
+// segments are read by the next segments while the client task is running, and the result and the validation
+// images of a finished finetune are the files of its lora model, which are kept until the model is deleted.
+func finetuneResultsNotInUse(db *gorm.DB) *gorm.DB {
+	newDB := db.Session(&gorm.Session{NewDB: true})
+	running := newDB.Model(&models.ClientTask{}).Select("id").Where("status = ?", models.ClientTaskStatusRunning)
+	registered := newDB.Model(&models.LoraModel{}).Select("client_task_id")
+	return db.Where("task_type <> ? OR (client_task_id NOT IN (?) AND client_task_id NOT IN (?))", models.TaskTypeSDFTLora, running, registered)
+}
+
+// getRetentionRules builds the rules from the retention config. The ttl of a client overrides
+// the ttls of the task types, and the results of the auto tasks are not kept at all.
+func getRetentionRules(ctx context.Context, db *gorm.DB) []retentionRule {
+	conf := config.GetConfig().Retention
+
+	rules := []retentionRule{{
+		name: "auto tasks",
+		scope: func(db *gorm.DB) *gorm.DB {
+			return db.Where("priority = ?", models.PriorityAuto)
+		},
+	}}
+
+	clientIDs := make([]uint, 0, len(conf.Clients))
+	for _, clientRetention := range conf.Clients {
+		client := models.Client{}
+		err := func() error {
+			dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			return db.WithContext(dbCtx).Where(&models.Client{ClientId: clientRetention.ClientID}).First(&client).Error
+		}()
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Errorf("Retention: cannot get client %s: %v", clientRetention.ClientID, err)
+			}
+			continue
+		}
+		clientIDs = append(clientIDs, client.ID)
+		if clientRetention.TTL == 0 {
+			continue
+		}
+		clientID := client.ID
+		rules = append(rules, retentionRule{
+			name: "client " + clientRetention.ClientID,
+			scope: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(notAutoTask).Where("client_id = ?", clientID)
+			},
+			ttl: time.Duration(clientRetention.TTL) * time.Hour,
+		})
+	}
+
+	taskTypeTTLs := []struct {
+		name     string
+		taskType models.ChainTaskType
+		ttl      uint64
+	}{
+		{"sd tasks", models.TaskTypeSD, conf.SDTTL},
+		{"llm tasks", models.TaskTypeLLM, conf.LLMTTL},
+		{"sd finetune tasks", models.TaskTypeSDFTLora, conf.SDFinetuneTTL},
+	}
+	for _, taskTypeTTL := range taskTypeTTLs {
+		if taskTypeTTL.ttl == 0 {
+			continue
+		}
+		taskType := taskTypeTTL.taskType
+		rules = append(rules, retentionRule{
+			name: taskTypeTTL.name,
+			scope: func(db *gorm.DB) *gorm.DB {
+				db = db.Scopes(notAutoTask).Where("task_type = ?", taskType)
+				if len(clientIDs) > 0 {
+					db = db.Where("client_id NOT IN ?", clientIDs)
+				}
+				return db
+			},
+			ttl: time.Duration(taskTypeTTL.ttl) * time.Hour,
+		})
+	}
+	return rules
+}
+
+// deleteTaskResults deletes the result files of the task and records the deletion on the task
+func deleteTaskResults(ctx context.Context, db *gorm.DB, task *models.InferenceTask) error {
+	store := storage.GetResultStore()
+	if len(task.TaskIDCommitment) > 0 {
+		if err := store.DeleteDir(ctx, task.TaskIDCommitment); err != nil {
+			return err
+		}
+	}
+
+	// the checkpoint uploaded by the client is shared by the retries of the first segment,
+	// and is deleted once the finetune task has ended, see finetuneResultsNotInUse
+	if task.TaskType == models.TaskTypeSDFTLora {
+		checkpoint, err := models.GetSDFTTaskConfigCheckpoint(task.TaskArgs)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path.Base(checkpoint), "_checkpoint.zip") {
+			if err := store.Delete(ctx, checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+
+	return task.MarkResultDeleted(ctx, db)
+}
+
+// sweepRetentionRule deletes the expired results of the tasks of rule, and returns the number of tasks swept
+func sweepRetentionRule(ctx context.Context, db *gorm.DB, rule retentionRule) (int, error) {
+	swept := 0
+	for {
+		scope := func(db *gorm.DB) *gorm.DB {
+			return db.Scopes(rule.scope, finetuneResultsNotInUse)
+		}
+		tasks, err := models.GetExpiredResultTasks(ctx, db, scope, time.Now().Add(-rule.ttl), retentionSweepBatchSize)
+		if err != nil {
+			return swept, err
+		}
+		deleted := 0
+		for i := range tasks {
+			if err := deleteTaskResults(ctx, db, &tasks[i]); err != nil {
+				log.Errorf("Retention: cannot delete results of task %d: %v", tasks[i].ID, err)
+				continue
+			}
+			deleted++
+		}
+		swept += deleted
+		// stop at a batch of failing tasks, they are tried again in the next sweep
+		if len(tasks) < retentionSweepBatchSize || deleted == 0 {
+			return swept, nil
+		}
+	}
+}
+
+func getRetentionSweepInterval() time.Duration {
+	interval := config.GetConfig().Retention.SweepInterval
+	if interval == 0 {
+		interval = 600
+	}
+	return time.Duration(interval) * time.Second
+}
+
+func sweepResults(ctx context.Context) {
+	db := config.GetDB()
+	for {
+		for _, rule := range getRetentionRules(ctx, db) {
+			swept, err := sweepRetentionRule(ctx, db, rule)
+			if err != nil {
+				log.Errorf("Retention: cannot sweep results of %s: %v", rule.name, err)
+			}
+			if swept > 0 {
+				log.Infof("Retention: results of %d %s deleted", swept, rule.name)
+			}
+		}
+
+		if err := sleepContext(ctx, getRetentionSweepInterval()); err != nil {
+			return
+		}
+	}
+}
+
+// SweepResults deletes the expired results of the ended tasks until ctx is done
+func SweepResults(ctx context.Context) {
+	runAsLeader(ctx, "result_retention", sweepResults)
+}
@@ -0,0 +1,105 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func createRetentionTestClientTask(t *testing.T, status models.ClientTaskStatus) *models.ClientTask {
+	t.Helper()
+	// the client task is not polled by the scheduler of the integration tests
+	clientTask := &models.ClientTask{
+		ClientID:   1,
+		NextPollAt: time.Now().Add(time.Hour),
+	}
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(clientTask).Error; err != nil {
+			return err
+		}
+		return tx.Model(clientTask).UpdateColumn("status", status).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientTask
+}
+
+func createRetentionTestTask(t *testing.T, clientTask *models.ClientTask, taskType models.ChainTaskType) *models.InferenceTask {
+	t.Helper()
+	task := &models.InferenceTask{
+		ClientID:     clientTask.ClientID,
+		ClientTaskID: clientTask.ID,
+		TaskType:     taskType,
+		TaskArgs:     `{"checkpoint":null}`,
+		TaskID:       "0x01",
+	}
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		return tx.Model(task).UpdateColumn("status", models.InferenceTaskResultDownloaded).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestSweepFinetuneResultsInUse(t *testing.T) {
+	ctx := context.Background()
+	db := config.GetDB()
+
+	running := createRetentionTestClientTask(t, models.ClientTaskStatusRunning)
+	registered := createRetentionTestClientTask(t, models.ClientTaskStatusSuccess)
+	if err := db.Create(&models.LoraModel{ClientTaskID: registered.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	ended := createRetentionTestClientTask(t, models.ClientTaskStatusSuccess)
+
+	kept := []*models.InferenceTask{
+		// the checkpoint of the segment is read by the next segment
+		createRetentionTestTask(t, running, models.TaskTypeSDFTLora),
+		// the result is the file of the lora model
+		createRetentionTestTask(t, registered, models.TaskTypeSDFTLora),
+	}
+	swept := []*models.InferenceTask{
+		createRetentionTestTask(t, running, models.TaskTypeSD),
+		createRetentionTestTask(t, ended, models.TaskTypeSDFTLora),
+	}
+
+	clientTaskIDs := []uint{running.ID, registered.ID, ended.ID}
+	rule := retentionRule{
+		name: "test tasks",
+		scope: func(db *gorm.DB) *gorm.DB {
+			return db.Where("client_task_id IN ?", clientTaskIDs)
+		},
+	}
+	n, err := sweepRetentionRule(ctx, db, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(swept) {
+		t.Errorf("%d tasks swept, want %d", n, len(swept))
+	}
+	for _, task := range kept {
+		if err := task.Sync(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		if task.ResultDeletedAt != nil {
+			t.Errorf("results of task %d should be kept", task.ID)
+		}
+	}
+	for _, task := range swept {
+		if err := task.Sync(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		if task.ResultDeletedAt == nil {
+			t.Errorf("results of task %d should be deleted", task.ID)
+		}
+	}
+}
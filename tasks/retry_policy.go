@@ -0,0 +1,251 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crypto/rand"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// DefaultRetryPolicy returns the configured retry policy of the tasks of taskType,
+// which applies unless the client task overrides it
+func DefaultRetryPolicy(taskType models.ChainTaskType) config.RetryPolicy {
+	appConfig := config.GetConfig()
+	switch taskType {
+	case models.TaskTypeSD:
+		return appConfig.Task.SDRetryPolicy
+	case models.TaskTypeLLM:
+		return appConfig.Task.LLMRetryPolicy
+	}
+	return appConfig.Task.SDFinetuneRetryPolicy
+}
+
+// clientTaskRetryPolicy returns the retry policy of the client task for the type of its inference tasks
+func clientTaskRetryPolicy(ctx context.Context, clientTask *models.ClientTask) config.RetryPolicy {
+	task := models.InferenceTask{TaskType: models.TaskTypeSDFTLora}
+	err := func() error {
+		dbCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		return config.GetDB().WithContext(dbCtx).Model(&models.InferenceTask{}).
+			Select("task_type").
+			Where("client_task_id = ?", clientTask.ID).
+			Order("id ASC").
+			First(&task).Error
+	}()
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("ProcessTasks: cannot get the task type of client task %d: %v", clientTask.ID, err)
+	}
+	policy, _ := clientTask.GetRetryPolicy(DefaultRetryPolicy(task.TaskType))
+	return policy
+}
+
+// failureReason maps the end state of an inference task to the key used in RetryPolicy.Actions
+func failureReason(task *models.InferenceTask) string {
+	if task.TaskError == models.TaskErrorParametersValidationFailed {
+		return "task_error"
+	}
+	switch task.AbortReason {
+	case models.TaskAbortTimeout:
+		return "timeout"
+	case models.TaskAbortModelDownloadFailed:
+		return "model_download_failed"
+	case models.TaskAbortIncorrectResult:
+		return "incorrect_result"
+	case models.TaskAbortTaskFeeTooLow:
+		return "task_fee_too_low"
+	}
+	switch task.Status {
+	case models.InferenceTaskEndInvalidated:
+		return "invalidated"
+	case models.InferenceTaskEndGroupRefund:
+		return "group_refund"
+	case models.InferenceTaskResultMismatch:
+		return "result_mismatch"
+	}
+	return "timeout"
+}
+
+// retryDecision decides what to do with a failed task, and returns the decision
+// together with the fee and vram of the next task. The fee is raised within maxTaskFee
+// and the max task fee of the policy, 0 means no limit.
+func retryDecision(policy config.RetryPolicy, failedCount int, task *models.InferenceTask, maxTaskFee uint64) (models.AttemptDecision, uint64, uint64) {
+	if failedCount >= policy.MaxAttempts {
+		return models.AttemptDecisionFail, task.TaskFee, task.MinVram
+	}
+
+	reason := failureReason(task)
+	decision := models.AttemptDecision(policy.Actions[reason])
+	taskFee, minVram := task.TaskFee, task.MinVram
+
+	switch decision {
+	case models.AttemptDecisionRaiseFee:
+		if policy.MaxTaskFee > 0 && (maxTaskFee == 0 || policy.MaxTaskFee < maxTaskFee) {
+			maxTaskFee = policy.MaxTaskFee
+		}
+		newFee := raiseTaskFee(taskFee, policy.FeeIncreaseRatio, maxTaskFee)
+		if newFee <= taskFee {
+			// a timed out task may still be run at the max fee
+			if reason == "timeout" {
+				return models.AttemptDecisionRetry, taskFee, minVram
+			}
+			return models.AttemptDecisionFail, taskFee, minVram
+		}
+		taskFee = newFee
+	case models.AttemptDecisionRaiseVram:
+		newVram := minVram + policy.VramIncrease
+		if newVram > policy.MaxVram {
+			newVram = policy.MaxVram
+		}
+		if newVram <= minVram {
+			return models.AttemptDecisionFail, taskFee, minVram
+		}
+		minVram = newVram
+	case models.AttemptDecisionRetry, models.AttemptDecisionFail:
+	default:
+		decision = models.AttemptDecisionRetry
+	}
+	return decision, taskFee, minVram
+}
+
+// retryBackoff returns the seconds to wait before the n-th retry (n starts from 1)
+func retryBackoff(policy config.RetryPolicy, n int) uint64 {
+	backoff := float64(policy.InitialBackoff) * math.Pow(policy.BackoffMultiplier, float64(n-1))
+	if backoff > float64(policy.MaxBackoff) {
+		return policy.MaxBackoff
+	}
+	return uint64(backoff)
+}
+
+// processFailedTask decides how to retry the failed task of the client task, which is the sd finetune
+// segment task or the task of the other task types, and returns the time to wait before the next task can be created
+func processFailedTask(ctx context.Context, clientTask *models.ClientTask, task *models.InferenceTask) (time.Duration, error) {
+	db := config.GetDB()
+
+	// the decision is recorded once per inference task, so that a retry of this function
+	// after an error or a restart continues with the same decision
+	attempt, err := models.GetTaskAttemptByInferenceTaskID(ctx, db, task.ID)
+	if err != nil {
+		return 0, err
+	}
+	if attempt == nil {
+		policy, err := clientTask.GetRetryPolicy(DefaultRetryPolicy(task.TaskType))
+		if err != nil {
+			log.Errorf("ProcessTasks: invalid retry policy of client task %d: %v", clientTask.ID, err)
+		}
+		attemptCount, err := models.CountTaskAttempts(ctx, db, clientTask.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		maxTaskFee, err := GetMaxTaskFee(ctx, clientTask.ClientID)
+		if err != nil {
+			return 0, err
+		}
+		failedCount := clientTask.FailedCount + 1
+		decision, taskFee, minVram := retryDecision(policy, failedCount, task, maxTaskFee)
+		if decision != models.AttemptDecisionFail {
+			withinBudget, err := clientTask.WithinBudget(ctx, db, taskFee)
+			if err != nil {
+				return 0, err
+			}
+			if !withinBudget {
+				decision = models.AttemptDecisionBudgetExceeded
+			}
+		}
+		attempt = &models.TaskAttempt{
+			ClientTaskID:    clientTask.ID,
+			InferenceTaskID: task.ID,
+			Attempt:         attemptCount + 1,
+			TaskStatus:      task.Status,
+			AbortReason:     task.AbortReason,
+			TaskError:       task.TaskError,
+			Reason:          failureReason(task),
+			Decision:        decision,
+			TaskFee:         taskFee,
+			MinVram:         minVram,
+		}
+		newClientTask := &models.ClientTask{FailedCount: failedCount}
+		if decision == models.AttemptDecisionFail {
+			newClientTask.Status = models.ClientTaskStatusFailed
+		} else if decision == models.AttemptDecisionBudgetExceeded {
+			newClientTask.Status = models.ClientTaskStatusBudgetExceeded
+		} else {
+			attempt.Backoff = retryBackoff(policy, failedCount)
+		}
+		log.Infof("ProcessTasks: client task %d inference task %d failed with %s, decision: %s", clientTask.ID, task.ID, attempt.Reason, decision)
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := attempt.Save(ctx, tx); err != nil {
+				return err
+			}
+			return clientTask.Update(ctx, tx, newClientTask)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if attempt.Decision == models.AttemptDecisionFail || attempt.Decision == models.AttemptDecisionBudgetExceeded || attempt.NextTaskID != 0 {
+		return 0, nil
+	}
+
+	if wait := time.Until(attempt.NextAttemptAt()); wait > 0 {
+		return wait, nil
+	}
+
+	maxTaskFee, err := GetMaxTaskFee(ctx, clientTask.ClientID)
+	if err != nil {
+		return 0, err
+	}
+	newTask := copyTask(task, task.TaskArgs, attempt.TaskFee, attempt.MinVram)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := newTask.Save(ctx, tx); err != nil {
+			return err
+		}
+		feeDecision := newResubmitFeeDecision(task, newTask, attempt.Reason, maxTaskFee)
+		if err := models.SaveFeeDecisions(ctx, tx, []*models.FeeDecision{feeDecision}); err != nil {
+			return err
+		}
+		return attempt.Update(ctx, tx, &models.TaskAttempt{NextTaskID: newTask.ID})
+	})
+	if err != nil {
+		return 0, err
+	}
+	// the requests waiting for the client task follow the new task
+	models.Publish(models.ClientTaskKey(clientTask.ID))
+	return clientTaskPollInterval, nil
+}
+
+// copyTask returns a new inference task of the client task of task with a new task id,
+// which runs taskArgs at taskFee and minVram
+func copyTask(task *models.InferenceTask, taskArgs string, taskFee, minVram uint64) *models.InferenceTask {
+	taskIDBytes := make([]byte, 32)
+	rand.Read(taskIDBytes)
+	newTaskID := hexutil.Encode(taskIDBytes)
+
+	return &models.InferenceTask{
+		ClientID:         task.ClientID,
+		ClientTaskID:     task.ClientTaskID,
+		TaskArgs:         taskArgs,
+		TaskType:         task.TaskType,
+		TaskModelIDs:     task.TaskModelIDs,
+		TaskVersion:      task.TaskVersion,
+		TaskFee:          taskFee,
+		MinVram:          minVram,
+		RequiredGPU:      task.RequiredGPU,
+		RequiredGPUVram:  task.RequiredGPUVram,
+		TaskSize:         task.TaskSize,
+		TaskID:           newTaskID,
+		Timeout:          task.Timeout,
+		Priority:         task.Priority,
+		Replica:          task.Replica,
+		AutoTaskTemplate: task.AutoTaskTemplate,
+	}
+}
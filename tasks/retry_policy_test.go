@@ -0,0 +1,256 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	policy := config.RetryPolicy{InitialBackoff: 10, MaxBackoff: 300, BackoffMultiplier: 2}
+	cases := []struct {
+		n       int
+		backoff uint64
+	}{
+		{1, 10},
+		{2, 20},
+		{3, 40},
+		{5, 160},
+		{6, 300},
+		{20, 300},
+	}
+	for _, c := range cases {
+		if backoff := retryBackoff(policy, c.n); backoff != c.backoff {
+			t.Errorf("backoff of retry %d should be %d: %d", c.n, c.backoff, backoff)
+		}
+	}
+
+	constant := config.RetryPolicy{InitialBackoff: 5, MaxBackoff: 60, BackoffMultiplier: 1}
+	if backoff := retryBackoff(constant, 10); backoff != 5 {
+		t.Errorf("backoff of multiplier 1 should not grow: %d", backoff)
+	}
+}
+
+func TestRetryDecision(t *testing.T) {
+	policy := config.RetryPolicy{MaxAttempts: 3}.WithDefaults()
+
+	cases := []struct {
+		name        string
+		policy      config.RetryPolicy
+		failedCount int
+		task        *models.InferenceTask
+		maxTaskFee  uint64
+		decision    models.AttemptDecision
+		taskFee     uint64
+		minVram     uint64
+	}{
+		{
+			name:        "max attempts",
+			policy:      policy,
+			failedCount: 3,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortTimeout, TaskFee: 100, MinVram: 8},
+			decision:    models.AttemptDecisionFail,
+			taskFee:     100,
+			minVram:     8,
+		},
+		{
+			name:        "timeout raises fee",
+			policy:      policy,
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortTimeout, TaskFee: 100, MinVram: 8},
+			decision:    models.AttemptDecisionRaiseFee,
+			taskFee:     120,
+			minVram:     8,
+		},
+		{
+			name:        "fee raised within the max task fee",
+			policy:      policy,
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortTaskFeeTooLow, TaskFee: 100, MinVram: 8},
+			maxTaskFee:  110,
+			decision:    models.AttemptDecisionRaiseFee,
+			taskFee:     110,
+			minVram:     8,
+		},
+		{
+			name:        "fee raised within the max task fee of the policy",
+			policy:      config.RetryPolicy{MaxAttempts: 3, MaxTaskFee: 105}.WithDefaults(),
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortTaskFeeTooLow, TaskFee: 100, MinVram: 8},
+			maxTaskFee:  110,
+			decision:    models.AttemptDecisionRaiseFee,
+			taskFee:     105,
+			minVram:     8,
+		},
+		{
+			name:        "timeout at the max fee retries",
+			policy:      policy,
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortTimeout, TaskFee: 100, MinVram: 8},
+			maxTaskFee:  100,
+			decision:    models.AttemptDecisionRetry,
+			taskFee:     100,
+			minVram:     8,
+		},
+		{
+			name:        "fee too low at the max fee fails",
+			policy:      policy,
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortTaskFeeTooLow, TaskFee: 100, MinVram: 8},
+			maxTaskFee:  100,
+			decision:    models.AttemptDecisionFail,
+			taskFee:     100,
+			minVram:     8,
+		},
+		{
+			name:        "task error fails by default",
+			policy:      policy,
+			failedCount: 1,
+			task:        &models.InferenceTask{TaskError: models.TaskErrorParametersValidationFailed, TaskFee: 100, MinVram: 8},
+			decision:    models.AttemptDecisionFail,
+			taskFee:     100,
+			minVram:     8,
+		},
+		{
+			name:        "invalidated retries",
+			policy:      policy,
+			failedCount: 2,
+			task:        &models.InferenceTask{Status: models.InferenceTaskEndInvalidated, TaskFee: 100, MinVram: 8},
+			decision:    models.AttemptDecisionRetry,
+			taskFee:     100,
+			minVram:     8,
+		},
+		{
+			name:        "configured raise vram",
+			policy:      config.RetryPolicy{MaxAttempts: 3, MaxVram: 20, Actions: map[string]string{"incorrect_result": "raise_vram"}}.WithDefaults(),
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortIncorrectResult, TaskFee: 100, MinVram: 8},
+			decision:    models.AttemptDecisionRaiseVram,
+			taskFee:     100,
+			minVram:     16,
+		},
+		{
+			name:        "vram raised within the max vram",
+			policy:      config.RetryPolicy{MaxAttempts: 3, MaxVram: 20, Actions: map[string]string{"incorrect_result": "raise_vram"}}.WithDefaults(),
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortIncorrectResult, TaskFee: 100, MinVram: 16},
+			decision:    models.AttemptDecisionRaiseVram,
+			taskFee:     100,
+			minVram:     20,
+		},
+		{
+			name:        "vram at the max vram fails",
+			policy:      config.RetryPolicy{MaxAttempts: 3, MaxVram: 20, Actions: map[string]string{"incorrect_result": "raise_vram"}}.WithDefaults(),
+			failedCount: 1,
+			task:        &models.InferenceTask{AbortReason: models.TaskAbortIncorrectResult, TaskFee: 100, MinVram: 20},
+			decision:    models.AttemptDecisionFail,
+			taskFee:     100,
+			minVram:     20,
+		},
+		{
+			name:        "unknown action retries",
+			policy:      config.RetryPolicy{MaxAttempts: 3, Actions: map[string]string{"group_refund": "unknown"}}.WithDefaults(),
+			failedCount: 1,
+			task:        &models.InferenceTask{Status: models.InferenceTaskEndGroupRefund, TaskFee: 100, MinVram: 8},
+			decision:    models.AttemptDecisionRetry,
+			taskFee:     100,
+			minVram:     8,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			decision, taskFee, minVram := retryDecision(c.policy, c.failedCount, c.task, c.maxTaskFee)
+			if decision != c.decision || taskFee != c.taskFee || minVram != c.minVram {
+				t.Errorf("decision %s %d %d, want %s %d %d", decision, taskFee, minVram, c.decision, c.taskFee, c.minVram)
+			}
+		})
+	}
+}
+
+// createBudgetTestTask creates a sd finetune segment task of the client task in status.
+// The task is created and moved to status in a transaction, so that the scheduler never sees it pending.
+func createBudgetTestTask(t *testing.T, clientTask *models.ClientTask, taskFee uint64, status models.TaskStatus, abortReason models.TaskAbortReason) *models.InferenceTask {
+	t.Helper()
+	task := &models.InferenceTask{
+		ClientID:     clientTask.ClientID,
+		ClientTaskID: clientTask.ID,
+		TaskType:     models.TaskTypeSDFTLora,
+		TaskFee:      taskFee,
+		MinVram:      24,
+		TaskID:       "0x01",
+	}
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		return tx.Model(task).UpdateColumns(map[string]interface{}{"status": status, "abort_reason": abortReason}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Status = status
+	task.AbortReason = abortReason
+	return task
+}
+
+func TestProcessFailedTaskBudget(t *testing.T) {
+	ctx := context.Background()
+	db := config.GetDB()
+
+	cases := []struct {
+		name        string
+		maxTotalFee uint64
+		decision    models.AttemptDecision
+		status      models.ClientTaskStatus
+	}{
+		// 150 spent and 120 for the raised fee of the next segment
+		{"within budget", 270, models.AttemptDecisionRaiseFee, models.ClientTaskStatusRunning},
+		{"budget exceeded", 269, models.AttemptDecisionBudgetExceeded, models.ClientTaskStatusBudgetExceeded},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// the client task is not polled by the scheduler of the integration tests
+			clientTask := &models.ClientTask{
+				ClientID:    1,
+				Status:      models.ClientTaskStatusRunning,
+				MaxTotalFee: c.maxTotalFee,
+				RetryPolicy: `{"max_attempts":5,"fee_increase_ratio":1.2,"actions":{"timeout":"raise_fee"}}`,
+				NextPollAt:  time.Now().Add(time.Hour),
+			}
+			if err := db.Create(clientTask).Error; err != nil {
+				t.Fatal(err)
+			}
+			createBudgetTestTask(t, clientTask, 150, models.InferenceTaskResultDownloaded, models.TaskAbortReasonNone)
+			task := createBudgetTestTask(t, clientTask, 100, models.InferenceTaskEndAborted, models.TaskAbortTimeout)
+
+			wait, err := processFailedTask(ctx, clientTask, task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attempt, err := models.GetTaskAttemptByInferenceTaskID(ctx, db, task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempt == nil || attempt.Decision != c.decision || attempt.TaskFee != 120 || attempt.NextTaskID != 0 {
+				t.Fatalf("attempt should be decided as %s at fee 120: %+v", c.decision, attempt)
+			}
+			saved, err := models.GetClientTaskByID(ctx, db, clientTask.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Status != c.status || saved.FailedCount != 1 {
+				t.Errorf("client task should be %s after 1 failure: %s %d", c.status, saved.Status, saved.FailedCount)
+			}
+			if c.status == models.ClientTaskStatusRunning && wait <= 0 {
+				t.Errorf("the next segment should wait for the backoff: %v", wait)
+			}
+			if c.status == models.ClientTaskStatusBudgetExceeded && wait != 0 {
+				t.Errorf("the client task exceeding its budget should not wait: %v", wait)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// how often a task waiting for the relay is synced
+	relayPollInterval = 2 * time.Second
+	// how often a running client task is checked without being woken up
+	clientTaskPollInterval = 30 * time.Second
+	// how often the scheduler looks for due tasks without being woken up
+	schedulerInterval = time.Second
+	// max time of a single step, downloading the results of large tasks takes a while
+	stepTimeout = 10 * time.Minute
+)
+
+// Scheduler processes the inference tasks and client tasks whose NextPollAt is due
+// with a bounded number of workers. A task is handled by at most one worker at a time,
+// across all the bridge instances sharing the database, by leasing the task row to the instance.
+type Scheduler struct {
+	owner         string
+	leaseDuration time.Duration
+	slots         chan struct{}
+
+	// tracks the running workers to drain them on shutdown
+	workers sync.WaitGroup
+
+	// chooses the pending tasks to submit to the relay, only used by the dispatch loop
+	queue *fairQueue
+
+	mu      sync.Mutex
+	running map[string]struct{}
+	// consecutive errors of client tasks, used for backoff
+	clientErrors map[uint]int
+}
+
+func NewScheduler(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = 32
+	}
+	return &Scheduler{
+		owner:         config.GetInstanceID(),
+		leaseDuration: config.GetLeaseDuration(),
+		slots:         make(chan struct{}, workers),
+		queue:         newFairQueue(),
+		running:       make(map[string]struct{}),
+		clientErrors:  make(map[uint]int),
+	}
+}
+
+// acquire marks the task as running and takes a worker slot, it returns false
+// if the task is already running or there is no free worker
+func (s *Scheduler) acquire(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.running[key]; ok {
+		return false
+	}
+	select {
+	case s.slots <- struct{}{}:
+		s.running[key] = struct{}{}
+		s.workers.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Scheduler) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, key)
+	<-s.slots
+	s.workers.Done()
+}
+
+func (s *Scheduler) freeSlots() int {
+	return cap(s.slots) - len(s.slots)
+}
+
+func (s *Scheduler) runInferenceTask(ctx context.Context, task models.InferenceTask) {
+	key := models.InferenceTaskKey(task.ID)
+
+	delay, err := func() (time.Duration, error) {
+		stepCtx, cancel := context.WithTimeout(ctx, stepTimeout)
+		defer cancel()
+		return stepInferenceTask(stepCtx, &task)
+	}()
+	if err != nil {
+		log.Errorf("ProcessTasks: process task %d error %v, retry", task.ID, err)
+		delay = time.Duration((mrand.Float64()*3 + 2) * float64(time.Second))
+	}
+
+	s.release(key)
+	// the lease is released even if the worker is interrupted by shutdown
+	ctx = context.WithoutCancel(ctx)
+	var nextPollAt time.Time
+	if !task.Finished() {
+		nextPollAt = time.Now().Add(delay)
+	}
+	if err := models.ReleaseLease(ctx, config.GetDB(), &models.InferenceTask{}, task.ID, s.owner, nextPollAt); err != nil {
+		log.Errorf("ProcessTasks: cannot release task %d: %v", task.ID, err)
+	}
+
+	if task.Finished() {
+		log.Infof("ProcessTasks: task %d finished with status %d", task.ID, task.Status)
+		if err := models.WakeClientTask(ctx, config.GetDB(), task.ClientTaskID); err != nil {
+			log.Errorf("ProcessTasks: cannot wake client task %d: %v", task.ClientTaskID, err)
+		}
+		return
+	}
+	if delay == 0 {
+		models.Publish(models.SchedulerKey)
+	}
+}
+
+func (s *Scheduler) runClientTask(ctx context.Context, clientTask models.ClientTask) {
+	key := models.ClientTaskKey(clientTask.ID)
+
+	delay, err := func() (time.Duration, error) {
+		stepCtx, cancel := context.WithTimeout(ctx, stepTimeout)
+		defer cancel()
+		return stepClientTask(stepCtx, &clientTask)
+	}()
+
+	s.mu.Lock()
+	if err != nil {
+		s.clientErrors[clientTask.ID] += 1
+	} else {
+		delete(s.clientErrors, clientTask.ID)
+	}
+	errorCount := s.clientErrors[clientTask.ID]
+	s.mu.Unlock()
+
+	if err != nil {
+		policy := clientTaskRetryPolicy(ctx, &clientTask)
+		delay = time.Duration(retryBackoff(policy, errorCount)) * time.Second
+		log.Errorf("ProcessTasks: process client task %d error %v, retry after %v", clientTask.ID, err, delay)
+	}
+
+	s.release(key)
+	ctx = context.WithoutCancel(ctx)
+	var nextPollAt time.Time
+	if clientTask.Status == models.ClientTaskStatusRunning {
+		nextPollAt = time.Now().Add(delay)
+	}
+	if err := models.ReleaseLease(ctx, config.GetDB(), &models.ClientTask{}, clientTask.ID, s.owner, nextPollAt); err != nil {
+		log.Errorf("ProcessTasks: cannot release client task %d: %v", clientTask.ID, err)
+	}
+
+	if clientTask.Status != models.ClientTaskStatusRunning {
+		log.Infof("ProcessTasks: client task %d finished with status %s", clientTask.ID, clientTask.Status)
+		return
+	}
+	if delay == 0 {
+		models.Publish(models.SchedulerKey)
+	}
+}
+
+// start runs the claimed inference tasks, the tasks without a free worker are released
+func (s *Scheduler) start(ctx context.Context, tasks []models.InferenceTask) {
+	for _, task := range tasks {
+		if !s.acquire(models.InferenceTaskKey(task.ID)) {
+			if err := models.ReleaseLease(ctx, config.GetDB(), &models.InferenceTask{}, task.ID, s.owner, time.Time{}); err != nil {
+				log.Errorf("ProcessTasks: cannot release task %d: %v", task.ID, err)
+			}
+			continue
+		}
+		go s.runInferenceTask(ctx, task)
+	}
+}
+
+func (s *Scheduler) dispatch(ctx context.Context) {
+	db := config.GetDB()
+
+	if free := s.freeSlots(); free > 0 {
+		tasks, err := models.ClaimDueInferenceTasks(ctx, db, s.owner, free, s.leaseDuration)
+		if err != nil {
+			log.Errorf("ProcessTasks: cannot claim unprocessed tasks: %v", err)
+		}
+		s.start(ctx, tasks)
+	}
+
+	if free := s.freeSlots(); free > 0 {
+		s.dispatchSubmissions(ctx, free)
+	}
+
+	if free := s.freeSlots(); free > 0 {
+		clientTasks, err := models.ClaimDueClientTasks(ctx, db, s.owner, free, s.leaseDuration)
+		if err != nil {
+			log.Errorf("ProcessTasks: cannot claim running client tasks: %v", err)
+		}
+		for _, clientTask := range clientTasks {
+			if !s.acquire(models.ClientTaskKey(clientTask.ID)) {
+				if err := models.ReleaseLease(ctx, db, &models.ClientTask{}, clientTask.ID, s.owner, time.Time{}); err != nil {
+					log.Errorf("ProcessTasks: cannot release client task %d: %v", clientTask.ID, err)
+				}
+				continue
+			}
+			go s.runClientTask(ctx, clientTask)
+		}
+	}
+}
+
+// renewLeases keeps the leases of the running tasks until they are released by the workers
+func (s *Scheduler) renewLeases(ctx context.Context) {
+	s.mu.Lock()
+	var taskIDs, clientTaskIDs []uint
+	for key := range s.running {
+		var id uint
+		if _, err := fmt.Sscanf(key, "inference_task:%d", &id); err == nil {
+			taskIDs = append(taskIDs, id)
+		} else if _, err := fmt.Sscanf(key, "client_task:%d", &id); err == nil {
+			clientTaskIDs = append(clientTaskIDs, id)
+		}
+	}
+	s.mu.Unlock()
+
+	db := config.GetDB()
+	if err := models.RenewLeases(ctx, db, &models.InferenceTask{}, taskIDs, s.owner, s.leaseDuration); err != nil {
+		log.Errorf("ProcessTasks: cannot renew task leases: %v", err)
+	}
+	if err := models.RenewLeases(ctx, db, &models.ClientTask{}, clientTaskIDs, s.owner, s.leaseDuration); err != nil {
+		log.Errorf("ProcessTasks: cannot renew client task leases: %v", err)
+	}
+}
+
+// Run dispatches the due tasks every second, or as soon as it is woken up by a change of the tasks.
+// Once ctx is done, Run stops taking new tasks and waits for the running workers to finish.
+// The workers still running after the shutdown timeout are interrupted, and their tasks are
+// released so that they can be resumed by this or other instances.
+func (s *Scheduler) Run(ctx context.Context) {
+	wake, unsubscribe := models.Subscribe(models.SchedulerKey)
+	defer unsubscribe()
+
+	renewTicker := time.NewTicker(s.leaseDuration / 3)
+	defer renewTicker.Stop()
+
+	// workers are not interrupted by ctx to let them finish their current steps on shutdown
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	for {
+		s.dispatch(workCtx)
+
+		select {
+		case <-ctx.Done():
+			s.shutdown(cancelWork)
+			return
+		case <-renewTicker.C:
+			s.renewLeases(workCtx)
+		case <-wake:
+		case <-time.After(schedulerInterval):
+		}
+	}
+}
+
+func (s *Scheduler) shutdown(cancelWork context.CancelFunc) {
+	s.mu.Lock()
+	running := len(s.running)
+	s.mu.Unlock()
+	log.Infof("ProcessTasks: stopping, waiting for %d running workers", running)
+
+	drained := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(drained)
+	}()
+
+	timeout := config.GetShutdownTimeout()
+	renewTicker := time.NewTicker(s.leaseDuration / 3)
+	defer renewTicker.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-drained:
+			log.Infoln("ProcessTasks: all workers finished")
+			s.releaseAll()
+			return
+		case <-renewTicker.C:
+			s.renewLeases(context.Background())
+		case <-deadline:
+			log.Warnf("ProcessTasks: workers not finished in %v, interrupt them", timeout)
+			cancelWork()
+			<-drained
+			s.releaseAll()
+			return
+		}
+	}
+}
+
+// releaseAll gives up the task leases of this instance so that other instances can take the tasks immediately
+func (s *Scheduler) releaseAll() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := models.ReleaseTaskLeases(ctx, config.GetDB(), s.owner); err != nil {
+		log.Errorf("ProcessTasks: cannot release leases: %v", err)
+	}
+}
+
+// ProcessTasks resumes the unfinished tasks and then processes the inference tasks
+// and client tasks in database until ctx is done
+func ProcessTasks(ctx context.Context) {
+	if err := ResumeTasks(ctx); err != nil {
+		log.Errorf("ProcessTasks: cannot resume tasks: %v", err)
+	}
+	NewScheduler(config.GetConfig().Task.SchedulerWorkers).Run(ctx)
+}
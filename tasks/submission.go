@@ -0,0 +1,180 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type submissionSettings struct {
+	maxInFlight          int
+	maxInFlightPerClient int
+	realtimeReserved     int
+	weights              map[models.Priority]float64
+}
+
+func getSubmissionSettings() submissionSettings {
+	conf := config.GetConfig().Submission
+	settings := submissionSettings{
+		maxInFlight:          conf.MaxInFlight,
+		maxInFlightPerClient: conf.MaxInFlightPerClient,
+		realtimeReserved:     conf.RealtimeReserved,
+		weights: map[models.Priority]float64{
+			models.PriorityRealtime: 8,
+			models.PriorityStandard: 4,
+			models.PriorityBatch:    2,
+			models.PriorityAuto:     1,
+		},
+	}
+	for priority, weight := range conf.Weights {
+		if models.IsPriority(models.Priority(priority)) && weight > 0 {
+			settings.weights[models.Priority(priority)] = weight
+		}
+	}
+	return settings
+}
+
+// fairQueue chooses the next client to submit a task for by weighted fair queuing.
+// Every submission advances the virtual finish time of the client by 1/weight of its
+// priority class, and the client with the earliest finish time goes first, so that
+// clients of the same class share the submissions evenly and a heavier class gets
+// proportionally more of them.
+type fairQueue struct {
+	virtualTime float64
+	finish      map[uint]float64
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{finish: make(map[uint]float64)}
+}
+
+// tag returns the virtual start and finish time of the next submission of the client
+func (q *fairQueue) tag(clientID uint, weight float64) (float64, float64) {
+	start := q.finish[clientID]
+	return start, start + 1/weight
+}
+
+// update drops the state of the idle clients, and lets the clients coming back
+// start at the current virtual time instead of catching up on their idle time
+func (q *fairQueue) update(active map[uint]bool) {
+	for clientID, finish := range q.finish {
+		if !active[clientID] && finish <= q.virtualTime {
+			delete(q.finish, clientID)
+		}
+	}
+	for clientID := range active {
+		if _, ok := q.finish[clientID]; !ok {
+			q.finish[clientID] = q.virtualTime
+		}
+	}
+}
+
+// pickSubmissions chooses at most limit tasks among the pending ones to submit to the relay.
+// The validation tasks of submitted tasks go first and are not capped, since the tasks
+// they validate cannot finish without them. The other tasks are chosen by weighted fair
+// queuing between the clients, within the global and per client in-flight caps.
+func (q *fairQueue) pickSubmissions(pending []models.InferenceTask, inFlight map[uint]int, limit int, settings submissionSettings) []uint {
+	picked := make([]uint, 0)
+	total := 0
+	for _, count := range inFlight {
+		total += count
+	}
+
+	queues := make(map[uint][]models.InferenceTask)
+	active := make(map[uint]bool)
+	for _, task := range pending {
+		if len(task.VRFProof) > 0 {
+			if len(picked) < limit {
+				picked = append(picked, task.ID)
+				inFlight[task.ClientID] += 1
+				total += 1
+			}
+			continue
+		}
+		queues[task.ClientID] = append(queues[task.ClientID], task)
+		active[task.ClientID] = true
+	}
+	q.update(active)
+
+	for len(picked) < limit {
+		if settings.maxInFlight > 0 && total >= settings.maxInFlight {
+			break
+		}
+
+		var next *models.InferenceTask
+		var nextStart, nextFinish float64
+		for clientID, queue := range queues {
+			if len(queue) == 0 {
+				continue
+			}
+			if settings.maxInFlightPerClient > 0 && inFlight[clientID] >= settings.maxInFlightPerClient {
+				continue
+			}
+			head := &queue[0]
+			priority := head.Priority
+			if len(priority) == 0 {
+				priority = models.PriorityStandard
+			}
+			// the last slots are kept for the realtime tasks
+			if priority != models.PriorityRealtime && settings.maxInFlight > 0 &&
+				total >= settings.maxInFlight-settings.realtimeReserved {
+				continue
+			}
+			weight, ok := settings.weights[priority]
+			if !ok {
+				weight = settings.weights[models.PriorityStandard]
+			}
+			start, finish := q.tag(clientID, weight)
+			if next == nil || finish < nextFinish || (finish == nextFinish && head.ID < next.ID) {
+				next, nextStart, nextFinish = head, start, finish
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		clientID := next.ClientID
+		picked = append(picked, next.ID)
+		queues[clientID] = queues[clientID][1:]
+		inFlight[clientID] += 1
+		total += 1
+		q.finish[clientID] = nextFinish
+		q.virtualTime = nextStart
+	}
+	return picked
+}
+
+// dispatchSubmissions claims at most limit pending tasks chosen by the fair queue,
+// and starts the workers submitting them to the relay
+func (s *Scheduler) dispatchSubmissions(ctx context.Context, limit int) {
+	db := config.GetDB()
+
+	// a client cannot get more than limit tasks submitted in a round, nor more than its in-flight cap
+	settings := getSubmissionSettings()
+	perClient := limit
+	if settings.maxInFlightPerClient > 0 && settings.maxInFlightPerClient < perClient {
+		perClient = settings.maxInFlightPerClient
+	}
+	pending, err := models.GetPendingSubmissions(ctx, db, limit, perClient)
+	if err != nil {
+		log.Errorf("ProcessTasks: cannot get pending tasks: %v", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+	inFlight, err := models.CountInFlightTasks(ctx, db)
+	if err != nil {
+		log.Errorf("ProcessTasks: cannot count in-flight tasks: %v", err)
+		return
+	}
+
+	ids := s.queue.pickSubmissions(pending, inFlight, limit, settings)
+	tasks, err := models.ClaimPendingSubmissions(ctx, db, ids, s.owner, s.leaseDuration)
+	if err != nil {
+		log.Errorf("ProcessTasks: cannot claim pending tasks: %v", err)
+	}
+	s.start(ctx, tasks)
+}
@@ -0,0 +1,120 @@
+package tasks
+
+import (
+	"crynux_bridge/models"
+	"testing"
+)
+
+func TestPickSubmissions(t *testing.T) {
+	weights := map[models.Priority]float64{
+		models.PriorityRealtime: 8,
+		models.PriorityStandard: 4,
+		models.PriorityBatch:    2,
+		models.PriorityAuto:     1,
+	}
+	type queue struct {
+		clientID   uint
+		priority   models.Priority
+		n          int
+		validation bool
+	}
+	cases := []struct {
+		name     string
+		queues   []queue
+		inFlight map[uint]int
+		limit    int
+		settings submissionSettings
+		want     map[uint]int
+	}{
+		{
+			name:     "weight ratio",
+			queues:   []queue{{1, models.PriorityRealtime, 20, false}, {2, models.PriorityBatch, 20, false}},
+			limit:    10,
+			settings: submissionSettings{weights: weights},
+			want:     map[uint]int{1: 8, 2: 2},
+		},
+		{
+			name:     "same class",
+			queues:   []queue{{1, models.PriorityStandard, 20, false}, {2, models.PriorityStandard, 20, false}},
+			limit:    10,
+			settings: submissionSettings{weights: weights},
+			want:     map[uint]int{1: 5, 2: 5},
+		},
+		{
+			name:     "per client cap",
+			queues:   []queue{{1, models.PriorityRealtime, 20, false}, {2, models.PriorityBatch, 20, false}},
+			inFlight: map[uint]int{1: 2},
+			limit:    10,
+			settings: submissionSettings{maxInFlightPerClient: 3, weights: weights},
+			want:     map[uint]int{1: 1, 2: 3},
+		},
+		{
+			name:     "global cap",
+			queues:   []queue{{1, models.PriorityStandard, 20, false}, {2, models.PriorityStandard, 20, false}},
+			inFlight: map[uint]int{3: 6},
+			limit:    10,
+			settings: submissionSettings{maxInFlight: 10, weights: weights},
+			want:     map[uint]int{1: 2, 2: 2},
+		},
+		{
+			name:     "realtime reservation",
+			queues:   []queue{{1, models.PriorityBatch, 20, false}},
+			inFlight: map[uint]int{3: 6},
+			limit:    10,
+			settings: submissionSettings{maxInFlight: 10, realtimeReserved: 2, weights: weights},
+			want:     map[uint]int{1: 2},
+		},
+		{
+			name:     "realtime takes the reserved slots",
+			queues:   []queue{{1, models.PriorityBatch, 20, false}, {2, models.PriorityRealtime, 20, false}},
+			inFlight: map[uint]int{3: 8},
+			limit:    10,
+			settings: submissionSettings{maxInFlight: 10, realtimeReserved: 2, weights: weights},
+			want:     map[uint]int{2: 2},
+		},
+		{
+			name:     "validation tasks are not capped",
+			queues:   []queue{{1, models.PriorityBatch, 2, true}, {2, models.PriorityRealtime, 2, false}},
+			inFlight: map[uint]int{1: 10},
+			limit:    10,
+			settings: submissionSettings{maxInFlight: 10, maxInFlightPerClient: 10, weights: weights},
+			want:     map[uint]int{1: 2},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pending := make([]models.InferenceTask, 0)
+			clients := make(map[uint]uint)
+			for _, q := range c.queues {
+				for i := 0; i < q.n; i++ {
+					task := models.InferenceTask{ClientID: q.clientID, Priority: q.priority}
+					task.ID = uint(len(pending) + 1)
+					if q.validation {
+						task.VRFProof = "0x01"
+					}
+					pending = append(pending, task)
+					clients[task.ID] = q.clientID
+				}
+			}
+			inFlight := make(map[uint]int)
+			for clientID, count := range c.inFlight {
+				inFlight[clientID] = count
+			}
+
+			picked := newFairQueue().pickSubmissions(pending, inFlight, c.limit, c.settings)
+			got := make(map[uint]int)
+			for _, id := range picked {
+				got[clients[id]]++
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("picked %v, want %v", got, c.want)
+			}
+			for clientID, count := range c.want {
+				if got[clientID] != count {
+					t.Errorf("picked %v, want %v", got, c.want)
+					break
+				}
+			}
+		})
+	}
+}
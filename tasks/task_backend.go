@@ -0,0 +1,207 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/blockchain"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/relay"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TaskBackend creates, validates and cancels the tasks on the network, and reads their states
+type TaskBackend interface {
+	// GetTask returns the task of the commitment, or an error that isTaskNotFound reports
+	GetTask(ctx context.Context, taskIDCommitment string) (*models.RelayTask, error)
+	CreateTask(ctx context.Context, task *models.InferenceTask) error
+	// ValidateTasks validates the single task, or the group of 3 tasks in the order of their sequences
+	ValidateTasks(ctx context.Context, tasks []*models.InferenceTask) error
+	CancelTask(ctx context.Context, task *models.InferenceTask, abortReason models.TaskAbortReason) error
+	GetNode(ctx context.Context, address string) (*models.RelayNode, error)
+	// GetQueuedTasks returns the count of the tasks waiting for nodes on the network
+	GetQueuedTasks(ctx context.Context) (int64, error)
+	DownloadTaskResult(ctx context.Context, taskIDCommitment string, index uint64, dst io.Writer) error
+	DownloadTaskResultCheckpoint(ctx context.Context, taskIDCommitment string, dst io.Writer) error
+	// CheckQuota checks the account of the bridge can pay for the tasks
+	CheckQuota(ctx context.Context) error
+}
+
+var taskBackend TaskBackend
+
+func InitTaskBackend(appConfig *config.AppConfig) error {
+	switch appConfig.TaskBackend.Type {
+	case "", "relay":
+		taskBackend = relayBackend{}
+	case "chain":
+		taskBackend = chainBackend{}
+	default:
+		return fmt.Errorf("unknown task backend type %s", appConfig.TaskBackend.Type)
+	}
+	return nil
+}
+
+func GetTaskBackend() TaskBackend {
+	return taskBackend
+}
+
+// SetTaskBackend replaces the task backend, it is used by the tests
+func SetTaskBackend(backend TaskBackend) {
+	taskBackend = backend
+}
+
+// isTaskNotFound reports whether the error of GetTask means the task is not created yet
+func isTaskNotFound(err error) bool {
+	if errors.Is(err, blockchain.ErrTaskNotFound) {
+		return true
+	}
+	var relayErr relay.RelayError
+	return errors.As(err, &relayErr) && strings.Contains(relayErr.ErrorMessage, "Task not found")
+}
+
+// relayBackend sends the tasks to the relay, which creates and validates them on chain for the bridge
+type relayBackend struct{}
+
+func (relayBackend) GetTask(ctx context.Context, taskIDCommitment string) (*models.RelayTask, error) {
+	return relay.GetTaskByCommitment(ctx, taskIDCommitment)
+}
+
+func (relayBackend) CreateTask(ctx context.Context, task *models.InferenceTask) error {
+	return relay.CreateTask(ctx, task)
+}
+
+func (relayBackend) ValidateTasks(ctx context.Context, tasks []*models.InferenceTask) error {
+	return relay.ValidateTask(ctx, tasks)
+}
+
+func (relayBackend) CancelTask(ctx context.Context, task *models.InferenceTask, abortReason models.TaskAbortReason) error {
+	return relay.CancelTask(ctx, task, abortReason)
+}
+
+func (relayBackend) GetNode(ctx context.Context, address string) (*models.RelayNode, error) {
+	return relay.GetNodeByAddress(ctx, address)
+}
+
+func (relayBackend) GetQueuedTasks(ctx context.Context) (int64, error) {
+	return relay.GetQueuedTasks(ctx)
+}
+
+func (relayBackend) DownloadTaskResult(ctx context.Context, taskIDCommitment string, index uint64, dst io.Writer) error {
+	return relay.DownloadTaskResult(ctx, taskIDCommitment, index, dst)
+}
+
+func (relayBackend) DownloadTaskResultCheckpoint(ctx context.Context, taskIDCommitment string, dst io.Writer) error {
+	return relay.DownloadTaskResultCheckpoint(ctx, taskIDCommitment, dst)
+}
+
+func (relayBackend) CheckQuota(ctx context.Context) error {
+	return relay.CheckQuotaForTaskCreator(ctx)
+}
+
+// chainBackend sends the transactions of the tasks from the account of the bridge, and reads the task
+// states from the contracts. The nodes still get the task args from and upload the results to the relay.
+type chainBackend struct{}
+
+// chainTxTimeout is how long to wait for a transaction of the tasks to be mined
+const chainTxTimeout = 3 * time.Minute
+
+// waitTx waits for the transaction to be mined, and returns the revert reason as the error if it fails
+func waitTx(ctx context.Context, txHash string) error {
+	waitCtx, cancel := context.WithTimeout(ctx, chainTxTimeout)
+	defer cancel()
+	receipt, err := blockchain.WaitTxReceipt(waitCtx, common.HexToHash(txHash))
+	if err != nil {
+		return err
+	}
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		return nil
+	}
+	errMsg, err := blockchain.GetErrorMessageFromReceipt(ctx, receipt)
+	if err != nil {
+		return fmt.Errorf("tx %s failed: %w", txHash, err)
+	}
+	return fmt.Errorf("tx %s failed: %s", txHash, errMsg)
+}
+
+func (chainBackend) GetTask(ctx context.Context, taskIDCommitment string) (*models.RelayTask, error) {
+	return blockchain.GetRelayTask(ctx, taskIDCommitment)
+}
+
+// CreateTask creates the task on chain and uploads its args to the relay. The task created before is
+// not created again, so that the args can be uploaded again after a failure.
+func (chainBackend) CreateTask(ctx context.Context, task *models.InferenceTask) error {
+	// the args are checked before the task fee is paid on chain
+	if err := relay.CheckUploadTaskParams(task); err != nil {
+		return err
+	}
+	_, err := blockchain.GetRelayTask(ctx, task.TaskIDCommitment)
+	if errors.Is(err, blockchain.ErrTaskNotFound) {
+		txHash, err := blockchain.CreateTaskOnChain(ctx, task)
+		if err != nil {
+			return err
+		}
+		if err := waitTx(ctx, txHash); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return relay.UploadTaskParams(ctx, task)
+}
+
+func (chainBackend) ValidateTasks(ctx context.Context, tasks []*models.InferenceTask) error {
+	var txHash string
+	var err error
+	if len(tasks) == 1 {
+		txHash, err = blockchain.ValidateSingleTask(ctx, tasks[0])
+	} else if len(tasks) == 3 {
+		txHash, err = blockchain.ValidateTaskGroup(ctx, tasks[0], tasks[1], tasks[2])
+	} else {
+		return fmt.Errorf("cannot validate %d tasks", len(tasks))
+	}
+	if err != nil {
+		return err
+	}
+	return waitTx(ctx, txHash)
+}
+
+func (chainBackend) CancelTask(ctx context.Context, task *models.InferenceTask, abortReason models.TaskAbortReason) error {
+	if abortReason != models.TaskAbortTimeout {
+		return errors.New("tasks can only be cancelled on chain for timeout")
+	}
+	txHash, err := blockchain.CancelTask(ctx, task)
+	if err != nil {
+		return err
+	}
+	return waitTx(ctx, txHash)
+}
+
+func (chainBackend) GetNode(ctx context.Context, address string) (*models.RelayNode, error) {
+	return blockchain.GetNodeByAddress(ctx, address)
+}
+
+func (chainBackend) GetQueuedTasks(ctx context.Context) (int64, error) {
+	count, err := blockchain.GetQueuedTasks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return count.Int64(), nil
+}
+
+func (chainBackend) DownloadTaskResult(ctx context.Context, taskIDCommitment string, index uint64, dst io.Writer) error {
+	return relay.DownloadTaskResult(ctx, taskIDCommitment, index, dst)
+}
+
+func (chainBackend) DownloadTaskResultCheckpoint(ctx context.Context, taskIDCommitment string, dst io.Writer) error {
+	return relay.DownloadTaskResultCheckpoint(ctx, taskIDCommitment, dst)
+}
+
+func (chainBackend) CheckQuota(ctx context.Context) error {
+	return blockchain.CheckBalanceForTaskCreator()
+}
@@ -0,0 +1,21 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/models"
+	"strings"
+	"testing"
+)
+
+func TestChainBackendCheckpoint(t *testing.T) {
+	// the task is rejected before it is created on chain, the chain is not set up in the tests
+	task := &models.InferenceTask{
+		TaskType:         models.TaskTypeSDFTLora,
+		TaskArgs:         `{"model":{"name":"crynux-network/sdxl-turbo"},"checkpoint":"checkpoint.zip"}`,
+		TaskIDCommitment: "0x01",
+	}
+	err := chainBackend{}.CreateTask(context.Background(), task)
+	if err == nil || !strings.Contains(err.Error(), "checkpoints can only be uploaded") {
+		t.Errorf("finetune task with a checkpoint should be rejected: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package tasks
+
+import (
+	"context"
+	"crynux_bridge/blockchain"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const trackTransactionsInterval = 5 * time.Second
+
+// TrackTransactions follows the transactions sent by the bridge until they are mined,
+// and replaces the ones stuck in the mempool with a higher gas price
+func TrackTransactions(ctx context.Context) {
+	runAsLeader(ctx, "blockchain_transactions", trackTransactions)
+}
+
+func trackTransactions(ctx context.Context) {
+	for {
+		if err := blockchain.ProcessPendingTransactions(ctx); err != nil {
+			log.Errorf("Transactions: cannot process the pending transactions: %v", err)
+		}
+
+		if err := sleepContext(ctx, trackTransactionsInterval); err != nil {
+			return
+		}
+	}
+}
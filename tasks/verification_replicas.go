@@ -0,0 +1,165 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"crynux_bridge/storage"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stepReplicatedClientTask updates the client task whose task runs with verification replicas.
+// Each replica is retried by the retry policy of the client task on its own, and once all of them
+// have results, the results are compared: the client task succeeds if most of the replicas agree,
+// and the replicas disagreeing with them are marked as mismatched, so that their results are not returned.
+func stepReplicatedClientTask(ctx context.Context, clientTask *models.ClientTask, tasks []models.InferenceTask) (time.Duration, error) {
+	replicas := make([][]models.InferenceTask, clientTask.VerificationReplicas+1)
+	for _, task := range tasks {
+		if task.Replica < len(replicas) {
+			replicas[task.Replica] = append(replicas[task.Replica], task)
+		}
+	}
+
+	results := make([]*models.InferenceTask, len(replicas))
+	pending := false
+	wait := clientTaskPollInterval
+	for replica, replicaTasks := range replicas {
+		if len(replicaTasks) == 0 {
+			pending = true
+			continue
+		}
+		allFinished := true
+		for i := range replicaTasks {
+			if replicaTasks[i].Success() && results[replica] == nil {
+				results[replica] = &replicaTasks[i]
+			}
+			if !replicaTasks[i].Finished() {
+				allFinished = false
+			}
+		}
+		if results[replica] != nil {
+			continue
+		}
+		pending = true
+		if !allFinished {
+			continue
+		}
+
+		// the retry policy resubmits the first task of the latest attempt of the replica
+		latestTaskID := replicaTasks[len(replicaTasks)-1].TaskID
+		for i := range replicaTasks {
+			if replicaTasks[i].TaskID != latestTaskID {
+				continue
+			}
+			log.Infof("ProcessTasks: client task %d replica %d task %d failed", clientTask.ID, replica, replicaTasks[i].ID)
+			replicaWait, err := processFailedTask(ctx, clientTask, &replicaTasks[i])
+			if err != nil {
+				return 0, err
+			}
+			if clientTask.Status != models.ClientTaskStatusRunning {
+				return 0, nil
+			}
+			if replicaWait < wait {
+				wait = replicaWait
+			}
+			break
+		}
+	}
+	if pending {
+		return wait, nil
+	}
+
+	return 0, compareReplicaResults(ctx, clientTask, results)
+}
+
+// compareReplicaResults ends the client task by comparing the results of its replicas
+func compareReplicaResults(ctx context.Context, clientTask *models.ClientTask, results []*models.InferenceTask) error {
+	hashes := make([][][]byte, len(results))
+	for i, task := range results {
+		taskHashes, err := replicaResultHashes(ctx, task)
+		if err != nil {
+			return err
+		}
+		hashes[i] = taskHashes
+	}
+
+	// the replica agreed by the most replicas, including itself
+	best, bestAgreed := 0, 0
+	for i := range results {
+		agreed := 0
+		for j := range results {
+			if replicaResultsAgree(results[i].TaskType, hashes[i], hashes[j]) {
+				agreed++
+			}
+		}
+		if agreed > bestAgreed {
+			best, bestAgreed = i, agreed
+		}
+	}
+	majority := bestAgreed*2 > len(results)
+
+	db := config.GetDB()
+	for i, task := range results {
+		if majority && replicaResultsAgree(task.TaskType, hashes[best], hashes[i]) {
+			continue
+		}
+		if err := task.Update(ctx, db, &models.InferenceTask{ResultVerification: models.ResultVerificationMismatch}); err != nil {
+			return err
+		}
+	}
+
+	if !majority {
+		log.Errorf("ProcessTasks: results of the %d replicas of client task %d disagree", len(results), clientTask.ID)
+		return clientTask.Update(ctx, db, &models.ClientTask{Status: models.ClientTaskStatusFailed})
+	}
+	log.Infof("ProcessTasks: results of %d of the %d replicas of client task %d agree", bestAgreed, len(results), clientTask.ID)
+	return clientTask.Update(ctx, db, &models.ClientTask{Status: models.ClientTaskStatusSuccess})
+}
+
+// replicaResultHashes returns the hashes of the result files of the task.
+// The hash of an image which cannot be decoded is nil, and agrees with no other hash.
+func replicaResultHashes(ctx context.Context, task *models.InferenceTask) ([][]byte, error) {
+	hashes := make([][]byte, task.TaskSize)
+	for i := range hashes {
+		key := resultKey(task, i)
+		file, _, err := storage.GetResultStore().Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := resultHash(task.TaskType, file)
+		file.Close()
+		if err != nil && task.TaskType == models.TaskTypeLLM {
+			return nil, err
+		}
+		if err != nil {
+			log.Errorf("ProcessTasks: cannot hash result %s of task %d: %v", key, task.ID, err)
+			continue
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+// replicaResultsAgree reports whether the results of two replicas are the same: the pHashes of the images
+// should be within the configured hamming distance, and the hashes of the llm responses should be equal
+func replicaResultsAgree(taskType models.ChainTaskType, a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == nil || b[i] == nil {
+			return false
+		}
+		if taskType == models.TaskTypeSD {
+			if len(a[i]) != len(b[i]) || hammingDistance(a[i], b[i]) > config.GetConfig().ResultVerification.PHashDistance {
+				return false
+			}
+		} else if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"crynux_bridge/config"
+	"crynux_bridge/models"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const webhookBatchSize = 100
+
+type webhookSettings struct {
+	timeout        time.Duration
+	maxAttempts    int
+	initialBackoff float64
+	maxBackoff     float64
+	multiplier     float64
+	workers        int
+}
+
+func getWebhookSettings() webhookSettings {
+	conf := config.GetConfig().Webhook
+	settings := webhookSettings{
+		timeout:        10 * time.Second,
+		maxAttempts:    8,
+		initialBackoff: 10,
+		maxBackoff:     3600,
+		multiplier:     2,
+		workers:        8,
+	}
+	if conf.Timeout > 0 {
+		settings.timeout = time.Duration(conf.Timeout) * time.Second
+	}
+	if conf.MaxAttempts > 0 {
+		settings.maxAttempts = conf.MaxAttempts
+	}
+	if conf.InitialBackoff > 0 {
+		settings.initialBackoff = float64(conf.InitialBackoff)
+	}
+	if conf.MaxBackoff > 0 {
+		settings.maxBackoff = float64(conf.MaxBackoff)
+	}
+	if conf.Multiplier > 0 {
+		settings.multiplier = conf.Multiplier
+	}
+	if conf.Workers > 0 {
+		settings.workers = conf.Workers
+	}
+	return settings
+}
+
+// backoff returns the time to wait before the next attempt after attempts failed attempts
+func (settings webhookSettings) backoff(attempts int) time.Duration {
+	seconds := settings.initialBackoff * math.Pow(settings.multiplier, float64(attempts-1))
+	seconds = math.Min(seconds, settings.maxBackoff)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// postWebhook sends the payload to the webhook and returns the response status code
+func postWebhook(ctx context.Context, client *http.Client, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	payload := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Crynux-Event", string(delivery.Event))
+	req.Header.Set("X-Crynux-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Crynux-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Crynux-Signature", "sha256="+models.SignWebhookPayload(webhook.Secret, timestamp, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func deliverWebhook(ctx context.Context, client *http.Client, settings webhookSettings, delivery *models.WebhookDelivery) error {
+	db := config.GetDB()
+	webhook, err := models.GetWebhookByID(ctx, db, delivery.WebhookID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return delivery.Update(ctx, db, map[string]interface{}{
+				"status":     models.WebhookDeliveryDead,
+				"last_error": "webhook deleted",
+			})
+		}
+		return err
+	}
+
+	statusCode, err := postWebhook(ctx, client, webhook, delivery)
+	attempts := delivery.Attempts + 1
+	if err == nil {
+		log.Infof("Webhook: delivery %d of event %s delivered to webhook %d", delivery.ID, delivery.Event, webhook.ID)
+		return delivery.Update(ctx, db, map[string]interface{}{
+			"status":           models.WebhookDeliveryDelivered,
+			"attempts":         attempts,
+			"last_status_code": statusCode,
+			"last_error":       "",
+			"delivered_at":     time.Now(),
+		})
+	}
+
+	values := map[string]interface{}{
+		"attempts":         attempts,
+		"last_status_code": statusCode,
+		"last_error":       err.Error(),
+	}
+	if attempts >= settings.maxAttempts {
+		log.Errorf("Webhook: delivery %d to webhook %d failed %d times, dead-lettered: %v", delivery.ID, webhook.ID, attempts, err)
+		values["status"] = models.WebhookDeliveryDead
+	} else {
+		backoff := settings.backoff(attempts)
+		log.Warnf("Webhook: delivery %d to webhook %d failed, retry after %v: %v", delivery.ID, webhook.ID, backoff, err)
+		values["next_attempt_at"] = time.Now().Add(backoff)
+	}
+	return delivery.Update(ctx, db, values)
+}
+
+func deliverWebhooks(ctx context.Context) {
+	wake, unsubscribe := models.Subscribe(models.WebhookKey)
+	defer unsubscribe()
+
+	settings := getWebhookSettings()
+	client := &http.Client{Timeout: settings.timeout}
+
+	for {
+		deliveries, err := models.GetDueWebhookDeliveries(ctx, config.GetDB(), webhookBatchSize)
+		if err != nil {
+			log.Errorf("Webhook: cannot get due deliveries: %v", err)
+		}
+
+		slots := make(chan struct{}, settings.workers)
+		var wg sync.WaitGroup
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				if err := deliverWebhook(ctx, client, settings, delivery); err != nil {
+					log.Errorf("Webhook: cannot deliver %d: %v", delivery.ID, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) == webhookBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// DeliverWebhooks sends the pending webhook deliveries until ctx is done
+func DeliverWebhooks(ctx context.Context) {
+	runAsLeader(ctx, "webhooks", deliverWebhooks)
+}